@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxSyncPause caps the write pause that POST /admin/sync can request.
+const maxSyncPause = 60 * time.Second
+
+// defaultSyncTimeout is how long POST /admin/sync waits for in-flight writes
+// unless ?timeout= says otherwise; maxSyncPause caps that too.
+const defaultSyncTimeout = 10 * time.Second
+
+// writeGate fences writes for POST /admin/sync. Writers enter and leave it
+// around their work; close holds off new writers and waits for the ones
+// inside. Unlike a sync.RWMutex, a close that waits too long can give up and
+// let held-off writers through again, so one stalled upload cannot turn a
+// sync into a server-wide write stall.
+type writeGate struct {
+	mu      sync.Mutex
+	active  int           // writers inside the gate
+	closed  chan struct{} // non-nil while closed; closed itself on open
+	drained chan struct{} // closed when the last writer leaves a closed gate
+}
+
+// enter waits until the gate is open and counts the caller as a writer.
+func (g *writeGate) enter() {
+	for {
+		g.mu.Lock()
+		if g.closed == nil {
+			g.active++
+			g.mu.Unlock()
+			return
+		}
+		wait := g.closed
+		g.mu.Unlock()
+		<-wait
+	}
+}
+
+// leave undoes enter.
+func (g *writeGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.active == 0 && g.drained != nil {
+		close(g.drained)
+		g.drained = nil
+	}
+}
+
+// close holds off new writers and waits for those inside to leave. It
+// returns false, with the gate open again, if that takes longer than
+// timeout. On success the caller must call open.
+func (g *writeGate) close(timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		g.mu.Lock()
+		if g.closed == nil {
+			break
+		}
+		// Another sync holds the gate, e.g. during its pause.
+		wait := g.closed
+		g.mu.Unlock()
+		select {
+		case <-wait:
+		case <-deadline.C:
+			return false
+		}
+	}
+	g.closed = make(chan struct{})
+	if g.active == 0 {
+		g.mu.Unlock()
+		return true
+	}
+	drained := make(chan struct{})
+	g.drained = drained
+	g.mu.Unlock()
+
+	select {
+	case <-drained:
+		return true
+	case <-deadline.C:
+		g.open()
+		return false
+	}
+}
+
+// open lets writers in again after a successful close.
+func (g *writeGate) open() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	close(g.closed)
+	g.closed = nil
+	g.drained = nil
+}
+
+// SyncResult is the JSON body of a successful POST /admin/sync.
+type SyncResult struct {
+	// DrainMS is how long the sync waited for in-flight writes to finish.
+	DrainMS int64 `json:"drain_ms"`
+	// SyncMS is how long flushing the data directory took.
+	SyncMS int64 `json:"sync_ms"`
+	// PausedUntil is when writes resume, if a pause was requested.
+	PausedUntil string `json:"paused_until,omitempty"`
+}
+
+// AdminHandler returns the handler served on the admin listener. Admin
+// endpoints are not authenticated, so the listener must only be reachable
+// from trusted hosts.
+func (h *S3Handler) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/sync", h.handleAdminSync)
+	mux.HandleFunc("GET /admin/maintenance", h.handleAdminMaintenance)
+	mux.HandleFunc("POST /admin/maintenance", h.handleAdminMaintenance)
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	return mux
+}
+
+// handleAdminSync quiesces writes, fsyncs the whole data directory and, with
+// ?pause=<duration>, keeps writes blocked for that long after responding so a
+// backup can copy a consistent tree. Reads are never blocked. If in-flight
+// writes do not finish within ?timeout=<duration>, it gives up with 503.
+func (h *S3Handler) handleAdminSync(w http.ResponseWriter, r *http.Request) {
+	var pause time.Duration
+	if p := r.URL.Query().Get("pause"); p != "" {
+		d, err := time.ParseDuration(p)
+		if err != nil || d < 0 || d > maxSyncPause {
+			writeAdminError(w, http.StatusBadRequest, "pause must be a duration between 0 and "+maxSyncPause.String())
+			return
+		}
+		pause = d
+	}
+	timeout := defaultSyncTimeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 || d > maxSyncPause {
+			writeAdminError(w, http.StatusBadRequest, "timeout must be a duration above 0 and at most "+maxSyncPause.String())
+			return
+		}
+		timeout = d
+	}
+
+	start := time.Now()
+	if !h.writeBarrier.close(timeout) {
+		writeAdminError(w, http.StatusServiceUnavailable, "in-flight writes did not finish within "+timeout.String()+"; nothing was synced")
+		return
+	}
+	drained := time.Now()
+	if err := h.storage.SyncAll(); err != nil {
+		h.writeBarrier.open()
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	synced := time.Now()
+
+	result := SyncResult{
+		DrainMS: drained.Sub(start).Milliseconds(),
+		SyncMS:  synced.Sub(drained).Milliseconds(),
+	}
+	if pause > 0 {
+		result.PausedUntil = synced.Add(pause).UTC().Format(time.RFC3339Nano)
+		time.AfterFunc(pause, h.writeBarrier.open)
+	} else {
+		h.writeBarrier.open()
+	}
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+// MaintenanceStatus is the JSON body of /admin/maintenance.
+type MaintenanceStatus struct {
+	Maintenance bool `json:"maintenance"`
+}
+
+// handleAdminMaintenance reports maintenance mode on GET and sets it on POST
+// with ?enabled=true or ?enabled=false.
+func (h *S3Handler) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		on, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, "enabled must be true or false")
+			return
+		}
+		if on != h.Maintenance() {
+			log.Printf("Maintenance mode %s via admin API", onOff(on))
+		}
+		h.SetMaintenance(on)
+	}
+	writeAdminJSON(w, http.StatusOK, MaintenanceStatus{Maintenance: h.Maintenance()})
+}
+
+// onOff renders b for log messages.
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	data, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	writeAdminJSON(w, status, map[string]string{"error": msg})
+}
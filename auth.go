@@ -18,7 +18,15 @@ type Authenticator interface {
 	Authenticate(r *http.Request) error
 }
 
+// SigV4Authenticator verifies AWS Signature Version 4 requests (Authorization
+// header and presigned URLs) against a single credential pair.
 type SigV4Authenticator struct {
+	sigV4Signer
+}
+
+// sigV4Signer holds the SigV4 key derivation and signing steps shared by
+// request verification and presigned URL generation.
+type sigV4Signer struct {
 	accessKey string
 	secretKey string
 }
@@ -26,10 +34,10 @@ type SigV4Authenticator struct {
 type NoOpAuthenticator struct{}
 
 func NewSigV4Authenticator(accessKey, secretKey string) *SigV4Authenticator {
-	return &SigV4Authenticator{
+	return &SigV4Authenticator{sigV4Signer{
 		accessKey: accessKey,
 		secretKey: secretKey,
-	}
+	}}
 }
 
 func (a *NoOpAuthenticator) Authenticate(r *http.Request) error {
@@ -100,9 +108,9 @@ func (a *SigV4Authenticator) authenticatePresigned(r *http.Request) error {
 	}
 
 	// Calculate expected signature
-	canonicalRequest := a.buildCanonicalRequestPresigned(r, signedHeaders)
-	stringToSign := a.buildStringToSign(date, dateStamp, region, service, canonicalRequest)
-	expectedSignature := a.calculateSignature(a.secretKey, dateStamp, region, service, stringToSign)
+	canonicalRequest := buildCanonicalRequestPresigned(r, signedHeaders)
+	stringToSign := a.StringToSign(date, dateStamp, region, service, canonicalRequest)
+	expectedSignature := a.Signature(dateStamp, region, service, stringToSign)
 
 	if subtle.ConstantTimeCompare([]byte(signature), []byte(expectedSignature)) != 1 {
 		return fmt.Errorf("the request signature we calculated does not match the signature you provided")
@@ -163,9 +171,9 @@ func (a *SigV4Authenticator) authenticateHeader(r *http.Request, authHeader stri
 	}
 
 	// Calculate expected signature
-	canonicalRequest := a.buildCanonicalRequest(r, signedHeaders)
-	stringToSign := a.buildStringToSign(date, dateStamp, region, service, canonicalRequest)
-	expectedSignature := a.calculateSignature(a.secretKey, dateStamp, region, service, stringToSign)
+	canonicalRequest := buildCanonicalRequest(r, signedHeaders)
+	stringToSign := a.StringToSign(date, dateStamp, region, service, canonicalRequest)
+	expectedSignature := a.Signature(dateStamp, region, service, stringToSign)
 
 	if subtle.ConstantTimeCompare([]byte(signature), []byte(expectedSignature)) != 1 {
 		return fmt.Errorf("the request signature we calculated does not match the signature you provided")
@@ -174,14 +182,53 @@ func (a *SigV4Authenticator) authenticateHeader(r *http.Request, authHeader stri
 	return nil
 }
 
-func (a *SigV4Authenticator) buildCanonicalRequest(r *http.Request, signedHeaders string) string {
+// PresignURL returns rawURL signed as a SigV4 presigned URL for method, valid
+// for expires from now. Only the host header is signed, so the URL works from
+// any client. The signature is computed with the same canonicalization the
+// server uses to verify presigned requests.
+func (s sigV4Signer) PresignURL(method, rawURL, region string, expires time.Duration, now time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("URL %q has no host", rawURL)
+	}
+	expiresSec := int(expires / time.Second)
+	if expiresSec < 1 || expiresSec > 604800 {
+		return "", fmt.Errorf("expiry must be between 1 and 604800 seconds")
+	}
+
+	now = now.UTC()
+	dateStamp := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	service := "s3"
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", fmt.Sprintf("%s/%s/%s/%s/aws4_request", s.accessKey, dateStamp, region, service))
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(expiresSec))
+	query.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = buildCanonicalQueryString(query, true)
+
+	r := &http.Request{Method: method, URL: u, Host: u.Host, Header: http.Header{}}
+	canonicalRequest := buildCanonicalRequestPresigned(r, "host")
+	stringToSign := s.StringToSign(amzDate, dateStamp, region, service, canonicalRequest)
+	signature := s.Signature(dateStamp, region, service, stringToSign)
+
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+func buildCanonicalRequest(r *http.Request, signedHeaders string) string {
 	// HTTPMethod + '\n' + CanonicalURI + '\n' + CanonicalQueryString + '\n' + CanonicalHeaders + '\n' + SignedHeaders + '\n' + HashedPayload
 
 	method := r.Method
 	uri := canonicalURI(r.URL.Path)
 
 	// Canonical query string
-	queryString := a.buildCanonicalQueryString(r.URL.Query(), false)
+	queryString := buildCanonicalQueryString(r.URL.Query(), false)
 
 	// Canonical headers (collapse sequential whitespace per SigV4 spec)
 	headers := strings.Split(signedHeaders, ";")
@@ -207,12 +254,12 @@ func (a *SigV4Authenticator) buildCanonicalRequest(r *http.Request, signedHeader
 		method, uri, queryString, canonicalHeaders.String(), signedHeaders, hashedPayload)
 }
 
-func (a *SigV4Authenticator) buildCanonicalRequestPresigned(r *http.Request, signedHeaders string) string {
+func buildCanonicalRequestPresigned(r *http.Request, signedHeaders string) string {
 	method := r.Method
 	uri := canonicalURI(r.URL.Path)
 
 	// Canonical query string (exclude signature)
-	queryString := a.buildCanonicalQueryString(r.URL.Query(), true)
+	queryString := buildCanonicalQueryString(r.URL.Query(), true)
 
 	// Canonical headers (collapse sequential whitespace per SigV4 spec)
 	headers := strings.Split(signedHeaders, ";")
@@ -234,7 +281,7 @@ func (a *SigV4Authenticator) buildCanonicalRequestPresigned(r *http.Request, sig
 		method, uri, queryString, canonicalHeaders.String(), signedHeaders, hashedPayload)
 }
 
-func (a *SigV4Authenticator) buildCanonicalQueryString(query url.Values, excludeSignature bool) string {
+func buildCanonicalQueryString(query url.Values, excludeSignature bool) string {
 	keys := make([]string, 0, len(query))
 	for k := range query {
 		if excludeSignature && k == "X-Amz-Signature" {
@@ -254,23 +301,27 @@ func (a *SigV4Authenticator) buildCanonicalQueryString(query url.Values, exclude
 	return strings.Join(parts, "&")
 }
 
-func (a *SigV4Authenticator) buildStringToSign(date, dateStamp, region, service, canonicalRequest string) string {
+// StringToSign builds the SigV4 string to sign for a canonical request.
+func (s sigV4Signer) StringToSign(amzDate, dateStamp, region, service, canonicalRequest string) string {
 	algorithm := "AWS4-HMAC-SHA256"
 	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", dateStamp, region, service)
 	hashedCanonicalRequest := sha256Hash(canonicalRequest)
 
 	return fmt.Sprintf("%s\n%s\n%s\n%s",
-		algorithm, date, credentialScope, hashedCanonicalRequest)
+		algorithm, amzDate, credentialScope, hashedCanonicalRequest)
 }
 
-func (a *SigV4Authenticator) calculateSignature(secretKey, dateStamp, region, service, stringToSign string) string {
-	kDate := hmacSHA256([]byte("AWS4"+secretKey), []byte(dateStamp))
+// SigningKey derives the SigV4 signing key for a date, region and service.
+func (s sigV4Signer) SigningKey(dateStamp, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+s.secretKey), []byte(dateStamp))
 	kRegion := hmacSHA256(kDate, []byte(region))
 	kService := hmacSHA256(kRegion, []byte(service))
-	kSigning := hmacSHA256(kService, []byte("aws4_request"))
-	signature := hmacSHA256(kSigning, []byte(stringToSign))
+	return hmacSHA256(kService, []byte("aws4_request"))
+}
 
-	return hex.EncodeToString(signature)
+// Signature returns the hex SigV4 signature of stringToSign.
+func (s sigV4Signer) Signature(dateStamp, region, service, stringToSign string) string {
+	return hex.EncodeToString(hmacSHA256(s.SigningKey(dateStamp, region, service), []byte(stringToSign)))
 }
 
 func hmacSHA256(key, data []byte) []byte {
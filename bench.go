@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchKeysPerWorker bounds how many distinct keys each bench worker
+// overwrites, so a long run does not fill the disk.
+const benchKeysPerWorker = 16
+
+// runBench implements the "geckos3 bench" subcommand: it drives PUT and GET
+// load against a running server for a fixed time and reports throughput and
+// latency percentiles. It returns the process exit code.
+func runBench(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	target := fs.String("target", defaultEndpoint(getEnv("GECKOS3_LISTEN", ":9000")), "Server base URL")
+	accessKey := fs.String("access-key", getEnv("GECKOS3_ACCESS_KEY", "geckoadmin"), "AWS access key")
+	secretKey := fs.String("secret-key", getEnv("GECKOS3_SECRET_KEY", "geckoadmin"), "AWS secret key")
+	region := fs.String("region", "us-east-1", "Region in the credential scope")
+	bucket := fs.String("bucket", "geckos3-bench", "Bucket to write to (created if missing)")
+	concurrency := fs.Int("concurrency", 8, "Number of concurrent workers")
+	duration := fs.Duration("duration", 30*time.Second, "How long to generate load")
+	objectSize := fs.String("object-size", "1MiB", "Size of each object (e.g. 4KiB, 1MB, 16MiB)")
+	jsonOut := fs.Bool("json", false, "Print the report as JSON")
+	cleanup := fs.Bool("cleanup", true, "Delete the objects written once the run ends")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	size, err := parseByteSize(*objectSize)
+	if err != nil {
+		fmt.Fprintf(stderr, "bench: invalid -object-size: %v\n", err)
+		return 2
+	}
+	if *concurrency < 1 || *duration <= 0 {
+		fmt.Fprintln(stderr, "bench: -concurrency and -duration must be positive")
+		return 2
+	}
+	base, err := url.Parse(*target)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		fmt.Fprintf(stderr, "bench: invalid -target %q\n", *target)
+		return 2
+	}
+
+	b := &benchClient{
+		base:   strings.TrimSuffix(base.String(), "/"),
+		region: *region,
+		signer: sigV4Signer{accessKey: *accessKey, secretKey: *secretKey},
+		client: &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}},
+	}
+	status, err := b.do("PUT", "/"+*bucket, nil)
+	if err != nil || (status != http.StatusOK && status != http.StatusConflict) {
+		fmt.Fprintf(stderr, "bench: cannot create bucket %s: status %d %v\n", *bucket, status, err)
+		return 1
+	}
+
+	payload := bytes.Repeat([]byte("g"), int(size))
+	var mu sync.Mutex
+	var puts, gets benchSamples
+	deadline := time.Now().Add(*duration)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var put, get benchSamples
+			for i := 0; time.Now().Before(deadline); i++ {
+				path := fmt.Sprintf("/%s/bench/w%d/obj-%d", *bucket, w, i%benchKeysPerWorker)
+				put.record(b.timed("PUT", path, payload))
+				get.record(b.timed("GET", path, nil))
+			}
+			mu.Lock()
+			puts.merge(put)
+			gets.merge(get)
+			mu.Unlock()
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if *cleanup {
+		for w := 0; w < *concurrency; w++ {
+			for i := 0; i < benchKeysPerWorker; i++ {
+				b.do("DELETE", fmt.Sprintf("/%s/bench/w%d/obj-%d", *bucket, w, i), nil)
+			}
+		}
+	}
+
+	report := benchReport{
+		Target:          b.base,
+		Concurrency:     *concurrency,
+		DurationSeconds: elapsed.Seconds(),
+		ObjectSize:      size,
+		Put:             puts.stats(elapsed, size),
+		Get:             gets.stats(elapsed, size),
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		report.writeText(stdout)
+	}
+	if report.Put.Ops == 0 || report.Get.Ops == 0 {
+		fmt.Fprintln(stderr, "bench: no request succeeded")
+		return 1
+	}
+	return 0
+}
+
+// benchClient sends presigned requests to the bench target.
+type benchClient struct {
+	base   string
+	region string
+	signer sigV4Signer
+	client *http.Client
+}
+
+// do sends one request and returns its status, discarding the body.
+func (b *benchClient) do(method, path string, body []byte) (int, error) {
+	signed, err := b.signer.PresignURL(method, b.base+path, b.region, time.Hour, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(method, signed, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, err
+	}
+	return resp.StatusCode, nil
+}
+
+// timed sends one request and returns its latency, or ok=false if it did not
+// get a 2xx response.
+func (b *benchClient) timed(method, path string, body []byte) (latency time.Duration, ok bool) {
+	start := time.Now()
+	status, err := b.do(method, path, body)
+	return time.Since(start), err == nil && status/100 == 2
+}
+
+// benchSamples collects the latencies of one operation type.
+type benchSamples struct {
+	latencies []time.Duration
+	errors    int
+}
+
+func (s *benchSamples) record(latency time.Duration, ok bool) {
+	if !ok {
+		s.errors++
+		return
+	}
+	s.latencies = append(s.latencies, latency)
+}
+
+func (s *benchSamples) merge(other benchSamples) {
+	s.latencies = append(s.latencies, other.latencies...)
+	s.errors += other.errors
+}
+
+// stats summarizes the samples of a run that took elapsed.
+func (s *benchSamples) stats(elapsed time.Duration, objectSize int64) benchOpStats {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	n := len(s.latencies)
+	st := benchOpStats{
+		Ops:       n,
+		Errors:    s.errors,
+		OpsPerSec: float64(n) / elapsed.Seconds(),
+		MBPerSec:  float64(n) * float64(objectSize) / (1 << 20) / elapsed.Seconds(),
+	}
+	if n == 0 {
+		return st
+	}
+	pct := func(p float64) float64 {
+		return float64(s.latencies[int(p*float64(n-1))]) / float64(time.Millisecond)
+	}
+	st.P50Ms, st.P90Ms, st.P99Ms, st.MaxMs = pct(0.50), pct(0.90), pct(0.99), pct(1)
+	return st
+}
+
+// benchOpStats is the result for one operation type; MBPerSec is in MiB/s.
+type benchOpStats struct {
+	Ops       int     `json:"ops"`
+	Errors    int     `json:"errors"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+	MBPerSec  float64 `json:"mib_per_sec"`
+	P50Ms     float64 `json:"p50_ms"`
+	P90Ms     float64 `json:"p90_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	MaxMs     float64 `json:"max_ms"`
+}
+
+// benchReport is what "geckos3 bench -json" prints.
+type benchReport struct {
+	Target          string       `json:"target"`
+	Concurrency     int          `json:"concurrency"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	ObjectSize      int64        `json:"object_size"`
+	Put             benchOpStats `json:"put"`
+	Get             benchOpStats `json:"get"`
+}
+
+func (r benchReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "target %s, %d workers, %.1fs, %d-byte objects\n", r.Target, r.Concurrency, r.DurationSeconds, r.ObjectSize)
+	fmt.Fprintf(w, "%-4s %8s %7s %9s %9s %9s %9s %9s %9s\n", "op", "ops", "errors", "ops/s", "MiB/s", "p50 ms", "p90 ms", "p99 ms", "max ms")
+	for _, op := range []struct {
+		name string
+		st   benchOpStats
+	}{{"PUT", r.Put}, {"GET", r.Get}} {
+		fmt.Fprintf(w, "%-4s %8d %7d %9.1f %9.1f %9.2f %9.2f %9.2f %9.2f\n", op.name, op.st.Ops, op.st.Errors,
+			op.st.OpsPerSec, op.st.MBPerSec, op.st.P50Ms, op.st.P90Ms, op.st.P99Ms, op.st.MaxMs)
+	}
+}
+
+// parseByteSize parses sizes like "4096", "4KB", "16MiB" or "1G". All
+// suffixes are binary: KB and KiB both mean 1024 bytes.
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GIB", 1 << 30}, {"GB", 1 << 30}, {"G", 1 << 30},
+		{"MIB", 1 << 20}, {"MB", 1 << 20}, {"M", 1 << 20},
+		{"KIB", 1 << 10}, {"KB", 1 << 10}, {"K", 1 << 10},
+		{"B", 1},
+	}
+	num, mult := strings.ToUpper(strings.TrimSpace(s)), int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 || n > (1<<40)/mult {
+		return 0, fmt.Errorf("%q is not a positive size", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cacheSubdir is the directory CachingStorage creates under its cache
+// directory. It is emptied on startup, so the operator's directory itself is
+// never wiped.
+const cacheSubdir = "geckos3-cache"
+
+// CachingStorage is a read-through cache in front of another Storage, such as
+// a slow network mount or a MigratingStorage. Object bodies are kept as files
+// in a local directory and their metadata in memory, up to a byte budget,
+// evicting the least recently used objects first. PutObject writes through:
+// the origin stores the body and the cache keeps a copy. Every write, copy,
+// rename or delete made through the wrapper invalidates the keys it touches;
+// changes made to the origin behind its back are not seen until the object is
+// evicted. Listings and everything else go straight to the origin.
+//
+// The cache starts empty on every start. On Windows an evicted object that is
+// still being read cannot be removed, and its file stays behind until the
+// next start.
+type CachingStorage struct {
+	Storage
+	dir       string
+	budget    int64 // total bytes of cached bodies
+	maxObject int64 // larger objects are passed through uncached
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[cacheKey]*list.Element
+	used    int64
+	fills   map[*cacheFill]struct{} // fills in progress
+	seq     uint64                  // names cache files
+}
+
+type cacheKey struct{ bucket, key string }
+
+type cacheEntry struct {
+	key  cacheKey
+	meta ObjectMetadata
+	path string
+	size int64
+}
+
+// cacheFill is a copy of an object on its way into the cache. A write to the
+// key while it is in progress marks it stale, and it is then discarded
+// instead of caching what may be the previous content.
+type cacheFill struct {
+	key   cacheKey
+	stale bool
+}
+
+// NewCachingStorage caches origin's objects in dir, using at most budget
+// bytes. Objects larger than a quarter of the budget are not cached, so one
+// huge object cannot flush everything else.
+func NewCachingStorage(origin Storage, dir string, budget int64) (*CachingStorage, error) {
+	if budget <= 0 {
+		return nil, fmt.Errorf("cache size must be positive")
+	}
+	dir = filepath.Join(dir, cacheSubdir)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &CachingStorage{
+		Storage:   origin,
+		dir:       dir,
+		budget:    budget,
+		maxObject: budget / 4,
+		lru:       list.New(),
+		entries:   make(map[cacheKey]*list.Element),
+		fills:     make(map[*cacheFill]struct{}),
+	}, nil
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Cache bookkeeping
+// ═══════════════════════════════════════════════════════════════════════════════
+
+// lookup returns the cached entry for k, marking it recently used.
+func (c *CachingStorage) lookup(k cacheKey) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[k]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return *elem.Value.(*cacheEntry), true
+}
+
+func (c *CachingStorage) startFill(k cacheKey) *cacheFill {
+	f := &cacheFill{key: k}
+	c.mu.Lock()
+	c.fills[f] = struct{}{}
+	c.mu.Unlock()
+	return f
+}
+
+// abandonFill forgets f without caching anything.
+func (c *CachingStorage) abandonFill(f *cacheFill) {
+	c.mu.Lock()
+	delete(c.fills, f)
+	c.mu.Unlock()
+}
+
+// finishFill caches the body at path as f's object unless f went stale, and
+// reports whether it did. A body that is not cached is left to the caller.
+// With invalidate set, entries and other fills of the key are dropped first,
+// as by a write that has just completed.
+func (c *CachingStorage) finishFill(f *cacheFill, meta *ObjectMetadata, path string, size int64, invalidate bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.fills, f)
+	if invalidate {
+		c.invalidateLocked(func(k cacheKey) bool { return k == f.key })
+	}
+	if f.stale || size > c.maxObject {
+		return false
+	}
+	entry := &cacheEntry{key: f.key, meta: *meta, path: path, size: size}
+	c.entries[f.key] = c.lru.PushFront(entry)
+	c.used += size
+	for c.used > c.budget {
+		c.removeLocked(c.lru.Back())
+		cacheEvictions.Add(1)
+	}
+	cacheBytes.Set(c.used)
+	return true
+}
+
+// invalidate drops the cached copies of the keys match selects and marks
+// their fills in progress stale.
+func (c *CachingStorage) invalidate(match func(cacheKey) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidateLocked(match)
+}
+
+func (c *CachingStorage) invalidateLocked(match func(cacheKey) bool) {
+	for f := range c.fills {
+		if match(f.key) {
+			f.stale = true
+		}
+	}
+	for k, elem := range c.entries {
+		if match(k) {
+			c.removeLocked(elem)
+		}
+	}
+	cacheBytes.Set(c.used)
+}
+
+func (c *CachingStorage) invalidateKey(bucket, key string) {
+	k := cacheKey{bucket, key}
+	c.invalidate(func(other cacheKey) bool { return other == k })
+}
+
+func (c *CachingStorage) invalidateBucket(bucket string) {
+	c.invalidate(func(k cacheKey) bool { return k.bucket == bucket })
+}
+
+func (c *CachingStorage) removeLocked(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.used -= entry.size
+	os.Remove(entry.path)
+}
+
+// createFile creates a new, uniquely named cache file.
+func (c *CachingStorage) createFile() (*os.File, error) {
+	c.mu.Lock()
+	c.seq++
+	name := fmt.Sprintf("%016x", c.seq)
+	c.mu.Unlock()
+	return os.Create(filepath.Join(c.dir, name))
+}
+
+// removeOnClose is a cache file that never made it into the cache; it is
+// removed once the reader it was handed to is done with it.
+type removeOnClose struct{ *os.File }
+
+func (f removeOnClose) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Reads
+// ═══════════════════════════════════════════════════════════════════════════════
+
+// GetObject serves cached objects from their local copy. On a miss an object
+// small enough to cache is copied in full before it is served, so the reader
+// returned is always a seekable file and Range requests keep working.
+func (c *CachingStorage) GetObject(bucket, key string) (io.ReadCloser, *ObjectMetadata, error) {
+	k := cacheKey{bucket, key}
+	if entry, ok := c.lookup(k); ok {
+		if f, err := os.Open(entry.path); err == nil {
+			cacheHits.Add(1)
+			meta := entry.meta
+			return f, &meta, nil
+		}
+		// Evicted since the lookup.
+	}
+	cacheMisses.Add(1)
+
+	fill := c.startFill(k)
+	reader, meta, err := c.Storage.GetObject(bucket, key)
+	if err != nil || meta.Size > c.maxObject {
+		c.abandonFill(fill)
+		return reader, meta, err
+	}
+	defer reader.Close()
+
+	file, err := c.createFile()
+	if err != nil {
+		c.abandonFill(fill)
+		return c.Storage.GetObject(bucket, key)
+	}
+	size, err := io.Copy(file, reader)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		c.abandonFill(fill)
+		file.Close()
+		os.Remove(file.Name())
+		return nil, nil, err
+	}
+	if !c.finishFill(fill, meta, file.Name(), size, false) {
+		return removeOnClose{file}, meta, nil
+	}
+	return file, meta, nil
+}
+
+func (c *CachingStorage) HeadObject(bucket, key string) (*ObjectMetadata, error) {
+	if entry, ok := c.lookup(cacheKey{bucket, key}); ok {
+		cacheHits.Add(1)
+		return &entry.meta, nil
+	}
+	cacheMisses.Add(1)
+	return c.Storage.HeadObject(bucket, key)
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Writes
+// ═══════════════════════════════════════════════════════════════════════════════
+
+// PutObject writes through: the body is copied to a cache file as the origin
+// reads it, and cached once the origin has stored it.
+func (c *CachingStorage) PutObject(bucket, key string, reader io.Reader, input *PutObjectInput) (*ObjectMetadata, error) {
+	k := cacheKey{bucket, key}
+	c.invalidateKey(bucket, key)
+	fill := c.startFill(k)
+	file, err := c.createFile()
+	if err != nil {
+		c.abandonFill(fill)
+		meta, err := c.Storage.PutObject(bucket, key, reader, input)
+		c.invalidateKey(bucket, key)
+		return meta, err
+	}
+	tee := &cacheTee{r: reader, f: file, limit: c.maxObject}
+	meta, err := c.Storage.PutObject(bucket, key, tee, input)
+	file.Close()
+	if err != nil || tee.failed || tee.n != meta.Size {
+		c.abandonFill(fill)
+		c.invalidateKey(bucket, key)
+		os.Remove(file.Name())
+		return meta, err
+	}
+	if !c.finishFill(fill, meta, file.Name(), tee.n, true) {
+		os.Remove(file.Name())
+	}
+	return meta, nil
+}
+
+// cacheTee copies what the origin reads to the cache file, giving up on the
+// copy (but not the read) past limit bytes or on a write error.
+type cacheTee struct {
+	r      io.Reader
+	f      *os.File
+	limit  int64
+	n      int64
+	failed bool
+}
+
+func (t *cacheTee) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 && !t.failed {
+		t.n += int64(n)
+		if t.n > t.limit {
+			t.failed = true
+		} else if _, werr := t.f.Write(p[:n]); werr != nil {
+			t.failed = true
+		}
+	}
+	return n, err
+}
+
+func (c *CachingStorage) CompleteMultipartUpload(bucket, key, uploadID string, parts []CompletedPart) (*ObjectMetadata, error) {
+	defer c.invalidateKey(bucket, key)
+	return c.Storage.CompleteMultipartUpload(bucket, key, uploadID, parts)
+}
+
+func (c *CachingStorage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, overrideMeta *PutObjectInput, overrideTags map[string]string) (*ObjectMetadata, error) {
+	defer c.invalidateKey(dstBucket, dstKey)
+	return c.Storage.CopyObject(srcBucket, srcKey, dstBucket, dstKey, overrideMeta, overrideTags)
+}
+
+func (c *CachingStorage) RenameObject(bucket, srcKey, dstKey string, overwrite bool) (*ObjectMetadata, error) {
+	defer c.invalidateKey(bucket, dstKey)
+	defer c.invalidateKey(bucket, srcKey)
+	return c.Storage.RenameObject(bucket, srcKey, dstKey, overwrite)
+}
+
+func (c *CachingStorage) PutObjectTagging(bucket, key string, tags map[string]string) error {
+	defer c.invalidateKey(bucket, key)
+	return c.Storage.PutObjectTagging(bucket, key, tags)
+}
+
+func (c *CachingStorage) DeleteObjectTagging(bucket, key string) error {
+	defer c.invalidateKey(bucket, key)
+	return c.Storage.DeleteObjectTagging(bucket, key)
+}
+
+func (c *CachingStorage) DeleteObject(bucket, key string) (*DeleteObjectResult, error) {
+	defer c.invalidateKey(bucket, key)
+	return c.Storage.DeleteObject(bucket, key)
+}
+
+func (c *CachingStorage) DeleteObjectIfMatch(bucket, key, etag string) (*DeleteObjectResult, error) {
+	defer c.invalidateKey(bucket, key)
+	return c.Storage.DeleteObjectIfMatch(bucket, key, etag)
+}
+
+func (c *CachingStorage) DeleteBucket(bucket string) error {
+	defer c.invalidateBucket(bucket)
+	return c.Storage.DeleteBucket(bucket)
+}
+
+func (c *CachingStorage) RenameBucket(bucket, newName string) error {
+	defer c.invalidateBucket(newName)
+	defer c.invalidateBucket(bucket)
+	return c.Storage.RenameBucket(bucket, newName)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+)
+
+// checksumAlgorithms maps the x-amz-checksum algorithms geckos3 accepts, by
+// their upper-case S3 names, to their hash constructors.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"CRC32":  func() hash.Hash { return crc32.NewIEEE() },
+	"CRC32C": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+}
+
+// checksumHeader returns the x-amz-checksum-* header that carries digests of
+// algorithm, e.g. "x-amz-checksum-crc32c".
+func checksumHeader(algorithm string) string {
+	return "x-amz-checksum-" + strings.ToLower(algorithm)
+}
+
+// ErrBadChecksum is the ErrBadDigest PutObject and UploadPartWithChecksum
+// return when the body does not match ChecksumInput.Expected.
+var ErrBadChecksum = fmt.Errorf("%w (x-amz-checksum)", ErrBadDigest)
+
+// ChecksumInput asks an upload to compute an additional x-amz-checksum digest
+// of its body alongside the MD5 and, if Expected is set, to verify it before
+// anything is committed.
+type ChecksumInput struct {
+	Algorithm string // A key of checksumAlgorithms, e.g. "CRC32C"
+
+	// Expected is the base64 digest the body must have; empty only computes
+	// it. It is read after the whole body has been consumed, so a reader
+	// parsing aws-chunked trailers may fill it in while the body streams.
+	Expected string
+}
+
+// newHash returns a hasher for c's algorithm, or nil if c is nil.
+func (c *ChecksumInput) newHash() hash.Hash {
+	if c == nil {
+		return nil
+	}
+	return checksumAlgorithms[c.Algorithm]()
+}
+
+// verify returns the base64 digest h computed, or ErrBadChecksum if it
+// differs from c.Expected.
+func (c *ChecksumInput) verify(h hash.Hash) (string, error) {
+	sum := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	if c.Expected != "" && c.Expected != sum {
+		return "", ErrBadChecksum
+	}
+	return sum, nil
+}
@@ -1,6 +1,13 @@
 package main
 
-import "net/http"
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
 
 // CORSMiddleware adds permissive CORS headers to every response and handles
 // OPTIONS preflight requests. This allows browser-based S3 clients (e.g.
@@ -31,3 +38,190 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// CORSMiddleware is CORSMiddleware with per-bucket rules: cross-origin
+// requests to a bucket that has a CORS configuration are answered from its
+// rules, as S3 does, and everything else gets the permissive defaults.
+func (h *S3Handler) CORSMiddleware(next http.Handler) http.Handler {
+	permissive := CORSMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		bucket, _ := h.parsePath(r.URL.Path)
+		if origin == "" || bucket == "" {
+			permissive.ServeHTTP(w, r)
+			return
+		}
+		config := h.bucketCORS(bucket)
+		if config == nil {
+			permissive.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions {
+			method := r.Header.Get("Access-Control-Request-Method")
+			requested := splitHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+			rule := config.match(origin, method, requested)
+			if rule == nil {
+				h.writeError(w, r, "AccessForbidden", "CORSResponse: This CORS request is not allowed by the bucket's CORS configuration", http.StatusForbidden)
+				return
+			}
+			rule.setHeaders(w.Header(), origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+			if len(requested) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+			}
+			if rule.MaxAgeSeconds > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(rule.MaxAgeSeconds))
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// A request no rule allows gets no CORS headers, so the browser
+		// withholds the response from the page.
+		if rule := config.match(origin, r.Method, nil); rule != nil {
+			rule.setHeaders(w.Header(), origin)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bucketCORS returns the bucket's parsed CORS configuration, or nil if it has
+// none or it cannot be read.
+func (h *S3Handler) bucketCORS(bucket string) *CORSConfiguration {
+	data, err := h.storage.GetBucketCORS(bucket)
+	if err != nil || data == nil {
+		return nil
+	}
+	var config CORSConfiguration
+	if err := xml.Unmarshal(data, &config); err != nil {
+		log.Printf("Ignoring unreadable CORS configuration of bucket %s: %v", bucket, err)
+		return nil
+	}
+	return &config
+}
+
+// maxCORSRules is S3's limit on rules per CORS configuration.
+const maxCORSRules = 100
+
+// validate checks the configuration against S3's rules, plus the geckos3 one
+// that credentialed rules name their origins.
+func (c *CORSConfiguration) validate() error {
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("the CORS configuration must contain at least one CORSRule")
+	}
+	if len(c.Rules) > maxCORSRules {
+		return fmt.Errorf("the CORS configuration cannot have more than %d rules", maxCORSRules)
+	}
+	for _, rule := range c.Rules {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return fmt.Errorf("each CORSRule needs at least one AllowedOrigin and one AllowedMethod")
+		}
+		for _, m := range rule.AllowedMethods {
+			switch m {
+			case "GET", "PUT", "POST", "DELETE", "HEAD":
+			default:
+				return fmt.Errorf("found unsupported HTTP method in CORS config. Unsupported method is %s", m)
+			}
+		}
+		for _, o := range rule.AllowedOrigins {
+			if strings.Count(o, "*") > 1 {
+				return fmt.Errorf("AllowedOrigin %q can not have more than one wildcard", o)
+			}
+			if rule.AllowCredentials && o == "*" {
+				return fmt.Errorf("a CORSRule with AllowCredentials must list its origins; AllowedOrigin \"*\" would let any site send credentialed requests")
+			}
+		}
+		for _, hdr := range rule.AllowedHeaders {
+			if strings.Count(hdr, "*") > 1 {
+				return fmt.Errorf("AllowedHeader %q can not have more than one wildcard", hdr)
+			}
+		}
+	}
+	return nil
+}
+
+// match returns the first rule allowing origin, method and every requested
+// header, or nil.
+func (c *CORSConfiguration) match(origin, method string, headers []string) *CORSRule {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if !matchAny(rule.AllowedOrigins, origin, false) || !matchAny(rule.AllowedMethods, method, false) {
+			continue
+		}
+		allowed := true
+		for _, hdr := range headers {
+			if !matchAny(rule.AllowedHeaders, hdr, true) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return rule
+		}
+	}
+	return nil
+}
+
+// setHeaders adds the actual-response CORS headers of a matched rule. A
+// credentialed rule always echoes the origin, since browsers reject
+// Access-Control-Allow-Credentials alongside a "*" origin.
+func (rule *CORSRule) setHeaders(header http.Header, origin string) {
+	if !rule.AllowCredentials && containsString(rule.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+	}
+	if rule.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(rule.ExposeHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+}
+
+// matchAny reports whether value matches one of patterns, each of which may
+// contain a single "*" wildcard.
+func matchAny(patterns []string, value string, foldCase bool) bool {
+	if foldCase {
+		value = strings.ToLower(value)
+	}
+	for _, p := range patterns {
+		if foldCase {
+			p = strings.ToLower(p)
+		}
+		before, after, wild := strings.Cut(p, "*")
+		if !wild {
+			if p == value {
+				return true
+			}
+			continue
+		}
+		if len(value) >= len(before)+len(after) && strings.HasPrefix(value, before) && strings.HasSuffix(value, after) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHeaderList splits a comma-separated header value into trimmed,
+// non-empty items.
+func splitHeaderList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// casDir is the hidden directory under the data root holding the
+// content-addressed blobs of -dedup mode, shared by all buckets.
+const casDir = ".geckos3-cas"
+
+// SetDedup enables content-addressed storage: each PutObject body is stored
+// once under its SHA-256 in casDir and every key with that content is a hard
+// link to the blob. The blob's link count is its reference count; when the
+// last key is deleted or overwritten only the blob's own link is left and it
+// is removed. The count is kept by the filesystem with the links themselves,
+// so unlike a separate counter file it cannot drift from them in a crash.
+// Every link and unlink of a blob happens under the blob's stripe lock. Turning
+// dedup off later is safe: linked objects stay readable and are simply no
+// longer shared by new writes.
+func (fs *FilesystemStorage) SetDedup(enabled bool) error {
+	if enabled && !hardLinksSupported {
+		return fmt.Errorf("deduplication needs hard link counts, which are not available on this platform")
+	}
+	fs.dedup = enabled
+	return nil
+}
+
+// blobPath returns where the blob with hex SHA-256 sum is stored.
+func (fs *FilesystemStorage) blobPath(sum string) string {
+	return filepath.Join(fs.dataDir, casDir, sum[:2], sum)
+}
+
+// storeBlob moves the fully written temp file with SHA-256 sum into the blob
+// store, or discards it if that content is already stored, and returns a new
+// hard link to the blob in stagingDir. The caller renames the link over the
+// object path, which replaces the object as atomically as a plain write. The
+// blob's stripe lock keeps releaseBlob from removing it in between.
+func (fs *FilesystemStorage) storeBlob(stagingDir, tempPath, sum string) (string, error) {
+	blobPath := fs.blobPath(sum)
+	mu := fs.stripe(blobPath)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := os.Stat(blobPath); err == nil {
+		os.Remove(tempPath)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tempPath, blobPath); err != nil {
+			return "", err
+		}
+		if fs.enableFsync {
+			syncParentDir(blobPath)
+		}
+	}
+	return fs.linkBlobLocked(stagingDir, blobPath)
+}
+
+// linkBlob returns a new hard link in stagingDir to the stored blob with
+// SHA-256 sum, for copies of deduplicated objects.
+func (fs *FilesystemStorage) linkBlob(stagingDir, sum string) (string, error) {
+	blobPath := fs.blobPath(sum)
+	mu := fs.stripe(blobPath)
+	mu.Lock()
+	defer mu.Unlock()
+	return fs.linkBlobLocked(stagingDir, blobPath)
+}
+
+func (fs *FilesystemStorage) linkBlobLocked(stagingDir, blobPath string) (string, error) {
+	linkPath := filepath.Join(stagingDir, ".link-"+generateUploadID())
+	if err := os.Link(blobPath, linkPath); err != nil {
+		return "", err
+	}
+	return linkPath, nil
+}
+
+// blobOf returns the blob the stored object at key links to, or "" if it is
+// not deduplicated. Callers hold the key's stripe lock and pass the result to
+// releaseBlob once the object is gone and the lock is released. The answer is
+// only current because, in dedup mode, every write replaces the sidecar
+// together with the data file under that lock (see saveBlobMetadata).
+func (fs *FilesystemStorage) blobOf(bucket, key string) string {
+	if !fs.dedup {
+		return ""
+	}
+	metadata, err := fs.loadMetadata(bucket, key)
+	if err != nil {
+		return ""
+	}
+	return metadata.Blob
+}
+
+// saveBlobMetadata writes the sidecar of a write that just replaced the data
+// file at key, while the caller still holds the key's stripe lock. Outside
+// dedup mode sidecars are written after the lock is released, to keep it
+// short; with dedup a writer that slipped in before the sidecar changed would
+// read the previous object's blob in blobOf, and the blob actually unlinked
+// would never be released. Sidecar errors are non-fatal, as elsewhere.
+func (fs *FilesystemStorage) saveBlobMetadata(bucket, key string, metadata *ObjectMetadata) {
+	if fs.dedup && fs.enableMetadata {
+		fs.saveMetadata(bucket, key, metadata)
+	}
+}
+
+// releaseBlob removes the blob with SHA-256 sum if no object links to it any
+// more. An empty sum is ignored.
+func (fs *FilesystemStorage) releaseBlob(sum string) {
+	if sum == "" {
+		return
+	}
+	blobPath := fs.blobPath(sum)
+	mu := fs.stripe(blobPath)
+	mu.Lock()
+	defer mu.Unlock()
+	if n, ok := hardLinkCount(blobPath); ok && n == 1 {
+		os.Remove(blobPath)
+	}
+}
+
+// SweepBlobs removes blobs no object links to. Deletes and overwrites release
+// their blob as they go; this catches the ones they could not, after a crash
+// or when metadata sidecars (which record each object's blob) are disabled.
+func (fs *FilesystemStorage) SweepBlobs() (removed int, err error) {
+	root := filepath.Join(fs.dataDir, casDir)
+	dirs, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, dir := range dirs {
+		blobs, err := os.ReadDir(filepath.Join(root, dir.Name()))
+		if err != nil {
+			continue
+		}
+		for _, blob := range blobs {
+			blobPath := filepath.Join(root, dir.Name(), blob.Name())
+			mu := fs.stripe(blobPath)
+			mu.Lock()
+			if n, ok := hardLinkCount(blobPath); ok && n == 1 {
+				if os.Remove(blobPath) == nil {
+					removed++
+				}
+			}
+			mu.Unlock()
+		}
+	}
+	if removed > 0 {
+		log.Printf("Dedup: removed %d unreferenced blobs", removed)
+	}
+	return removed, nil
+}
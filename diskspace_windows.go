@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// availableBytes returns the space available to the calling user on the
+// volume holding path. ok is false if it cannot be determined.
+func availableBytes(path string) (avail uint64, ok bool) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false
+	}
+	r, _, _ := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&avail)), 0, 0)
+	return avail, r != 0
+}
@@ -4,21 +4,129 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/md5"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
+// defaultReadBufferSize is the copy buffer size used when streaming
+// non-seekable object readers to the client.
+const defaultReadBufferSize = 256 * 1024
+
+// smallObjectThreshold is the largest object size served by the small-object
+// GET fast path, which reads the whole file into a pooled buffer and skips
+// http.ServeContent's range and conditional-request machinery.
+const smallObjectThreshold = 16 * 1024
+
+// defaultMaxListParamLength is the default cap on listing prefixes, delimiters
+// and markers: S3's maximum key length, so no real key is excluded.
+const defaultMaxListParamLength = 1024
+
+// smallObjectPool holds buffers for the small-object fast path. Buffers are one
+// byte larger than the threshold so an object that grew past the threshold
+// since its metadata was written is detected rather than truncated.
+var smallObjectPool = sync.Pool{New: func() any {
+	buf := make([]byte, smallObjectThreshold+1)
+	return &buf
+}}
+
 type S3Handler struct {
-	storage Storage
-	auth    Authenticator
+	storage     Storage
+	auth        Authenticator
+	readBufPool *sync.Pool
+
+	// maxPartsPerUpload caps concurrent UploadPart requests per upload ID;
+	// 0 means unlimited. activeParts counts in-flight parts per upload ID.
+	maxPartsPerUpload int
+	partsMu           sync.Mutex
+	activeParts       map[string]int
+
+	// listDeadline bounds how long a listing may walk a bucket; 0 means no
+	// limit beyond the client's own connection.
+	listDeadline time.Duration
+
+	// maxListParamLength caps the length of listing prefixes, delimiters and
+	// markers; 0 means unlimited.
+	maxListParamLength int
+
+	// maxBuckets caps the number of buckets CreateBucket will allow; 0 means
+	// unlimited. bucketCount caches the current count (-1 until first read).
+	maxBuckets  int
+	bucketMu    sync.Mutex
+	bucketCount int
+
+	// endpointHost, when set, is the only Host header accepted.
+	endpointHost string
+
+	// anonWritePrefixes are "bucket/prefix" paths that accept unauthenticated
+	// object PUTs.
+	anonWritePrefixes []string
+
+	// errorDocBucket and errorDocKey name the object served in place of the
+	// XML error when an object GET finds nothing; empty disables it.
+	errorDocBucket string
+	errorDocKey    string
+
+	// indexDocument is the object name served for GETs of "dir/" keys and of
+	// unsigned bucket roots; empty disables website-style index handling.
+	indexDocument string
+
+	// defaultCacheControl is sent on GET and HEAD responses for objects
+	// stored without a Cache-Control header; empty sends none.
+	defaultCacheControl string
+
+	// acceptACL makes bucket ACL writes succeed (and be recorded) instead of
+	// returning 501. ACLs are never enforced.
+	acceptACL bool
+
+	// allowBucketRename enables the POST /{bucket}?rename admin extension.
+	allowBucketRename bool
+
+	// allowMetadataListing enables the ?metadata=true ListObjectsV2 extension.
+	allowMetadataListing bool
+
+	// allowCustomSort enables the ?sort= ListObjectsV2 extension.
+	allowCustomSort bool
+
+	// prettyXML indents XML responses for reading with curl.
+	prettyXML bool
+
+	// jsonErrors sends errors as JSON to clients whose Accept header
+	// prefers application/json.
+	jsonErrors bool
+
+	// allowBucketUsage enables the GET /?usage=true ListBuckets extension.
+	// usageCache holds the per-bucket totals it computed, for bucketUsageTTL.
+	allowBucketUsage bool
+	usageMu          sync.Mutex
+	usageCache       map[string]bucketUsage
+
+	// maintenance, when set, makes every data-plane request fail with 503.
+	maintenance atomic.Bool
+
+	// writeBarrier is entered by every mutating request and by the multipart
+	// GC, and closed by POST /admin/sync to quiesce writes around a flush.
+	writeBarrier writeGate
+
+	startTime time.Time
 }
 
 // MaxClientsMiddleware limits concurrent in-flight HTTP operations using a
@@ -36,26 +144,468 @@ func MaxClientsMiddleware(maxClients int) func(http.Handler) http.Handler {
 }
 
 func NewS3Handler(storage Storage, auth Authenticator) *S3Handler {
-	return &S3Handler{
-		storage: storage,
-		auth:    auth,
+	h := &S3Handler{
+		storage:     storage,
+		auth:        auth,
+		bucketCount: -1,
+		startTime:   time.Now(),
+
+		maxListParamLength: defaultMaxListParamLength,
+	}
+	h.SetReadBufferSize(defaultReadBufferSize)
+	return h
+}
+
+// SetReadBufferSize sets the size of the pooled buffers used to copy
+// non-seekable object readers to the client. Larger buffers reduce syscall
+// overhead for large sequential reads. Sizes <= 0 select the default.
+func (h *S3Handler) SetReadBufferSize(size int) {
+	if size <= 0 {
+		size = defaultReadBufferSize
+	}
+	h.readBufPool = &sync.Pool{
+		New: func() any {
+			buf := make([]byte, size)
+			return &buf
+		},
+	}
+}
+
+// SetMaxConcurrentParts limits how many UploadPart requests may be in flight
+// for a single upload ID. Requests over the limit get 503 SlowDown. A limit
+// <= 0 disables the check.
+func (h *S3Handler) SetMaxConcurrentParts(n int) {
+	h.partsMu.Lock()
+	defer h.partsMu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	h.maxPartsPerUpload = n
+	if h.activeParts == nil {
+		h.activeParts = make(map[string]int)
+	}
+}
+
+// SetListDeadline stops a ListObjects bucket walk that runs longer than d and
+// answers it with 503 SlowDown. A d <= 0 disables the deadline. Delimiter
+// listings read a single directory and are not affected.
+func (h *S3Handler) SetListDeadline(d time.Duration) {
+	h.listDeadline = d
+}
+
+// SetMaxListParamLength makes listings reject a prefix, delimiter, marker or
+// decoded continuation token longer than n bytes with 400 InvalidArgument,
+// since each is compared against every key the listing visits. n <= 0
+// removes the limit.
+func (h *S3Handler) SetMaxListParamLength(n int) {
+	if n < 0 {
+		n = 0
+	}
+	h.maxListParamLength = n
+}
+
+// listParam is a named listing query parameter, for rejectLongListParams.
+type listParam struct{ name, value string }
+
+// rejectLongListParams writes an InvalidArgument error and returns true if
+// any of params is longer than maxListParamLength.
+func (h *S3Handler) rejectLongListParams(w http.ResponseWriter, r *http.Request, params ...listParam) bool {
+	if h.maxListParamLength <= 0 {
+		return false
+	}
+	for _, p := range params {
+		if len(p.value) > h.maxListParamLength {
+			h.writeError(w, r, "InvalidArgument",
+				fmt.Sprintf("%s is %d bytes, longer than the maximum of %d", p.name, len(p.value), h.maxListParamLength), http.StatusBadRequest)
+			return true
+		}
+	}
+	return false
+}
+
+// SetMaxBuckets limits how many buckets may exist before CreateBucket starts
+// returning TooManyBuckets. A limit <= 0 disables the check. The bucket count
+// is read once and then tracked across creates and deletes, so buckets added
+// or removed directly in the data directory are not noticed until restart.
+func (h *S3Handler) SetMaxBuckets(n int) {
+	h.bucketMu.Lock()
+	defer h.bucketMu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	h.maxBuckets = n
+}
+
+// acquirePartSlot reserves an in-flight part slot for uploadID. It returns
+// false if the upload is already at the limit.
+func (h *S3Handler) acquirePartSlot(uploadID string) bool {
+	h.partsMu.Lock()
+	defer h.partsMu.Unlock()
+	if h.maxPartsPerUpload == 0 {
+		return true
+	}
+	if h.activeParts[uploadID] >= h.maxPartsPerUpload {
+		return false
+	}
+	h.activeParts[uploadID]++
+	return true
+}
+
+// releasePartSlot frees a slot taken by acquirePartSlot.
+func (h *S3Handler) releasePartSlot(uploadID string) {
+	h.partsMu.Lock()
+	defer h.partsMu.Unlock()
+	if n, ok := h.activeParts[uploadID]; ok {
+		if n <= 1 {
+			delete(h.activeParts, uploadID)
+		} else {
+			h.activeParts[uploadID] = n - 1
+		}
+	}
+}
+
+// forgetUpload drops the in-flight part count of a completed or aborted upload.
+func (h *S3Handler) forgetUpload(uploadID string) {
+	h.partsMu.Lock()
+	defer h.partsMu.Unlock()
+	delete(h.activeParts, uploadID)
+}
+
+// SetEndpointHost restricts requests to those whose Host header matches host.
+// If host has no port, any port on that hostname is accepted. An empty host
+// disables the check.
+func (h *S3Handler) SetEndpointHost(host string) {
+	h.endpointHost = strings.ToLower(host)
+}
+
+// hostAllowed reports whether the request's Host matches the configured
+// endpoint host.
+func (h *S3Handler) hostAllowed(r *http.Request) bool {
+	if h.endpointHost == "" {
+		return true
+	}
+	reqHost := strings.ToLower(r.Host)
+	if reqHost == h.endpointHost {
+		return true
+	}
+	if _, _, err := net.SplitHostPort(h.endpointHost); err == nil {
+		return false
+	}
+	hostname, _, err := net.SplitHostPort(reqHost)
+	return err == nil && hostname == h.endpointHost
+}
+
+// HealthStatus is the JSON body of GET /health with Accept: application/json.
+type HealthStatus struct {
+	Status        string `json:"status"`
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	Date          string `json:"date"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	Backend       string `json:"backend"`
+	Auth          string `json:"auth"`
+	Maintenance   bool   `json:"maintenance"`
+}
+
+// writeHealthJSON reports the running build, uptime, storage backend and auth
+// mode for operators.
+func (h *S3Handler) writeHealthJSON(w http.ResponseWriter) {
+	status := HealthStatus{
+		Status:        "ok",
+		Version:       version,
+		Commit:        commit,
+		Date:          date,
+		UptimeSeconds: int64(time.Since(h.startTime).Seconds()),
+		Backend:       "unknown",
+		Auth:          "unknown",
+		Maintenance:   h.maintenance.Load(),
+	}
+	if status.Maintenance {
+		status.Status = "maintenance"
+	}
+	switch h.storage.(type) {
+	case *FilesystemStorage, *MigratingStorage:
+		status.Backend = "filesystem"
+	case *CachingStorage:
+		status.Backend = "cached"
+	}
+	switch h.auth.(type) {
+	case *SigV4Authenticator:
+		status.Auth = "sigv4"
+	case *NoOpAuthenticator:
+		status.Auth = "none"
+	}
+
+	data, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// maintenanceRetryAfter is the Retry-After value, in seconds, sent while in
+// maintenance mode.
+const maintenanceRetryAfter = "30"
+
+// SetMaintenance turns maintenance mode on or off. While on, every request
+// except /health gets 503 ServiceUnavailable; in-flight requests are not
+// interrupted.
+func (h *S3Handler) SetMaintenance(on bool) {
+	h.maintenance.Store(on)
+}
+
+// ToggleMaintenance flips maintenance mode and returns the new state.
+func (h *S3Handler) ToggleMaintenance() bool {
+	for {
+		old := h.maintenance.Load()
+		if h.maintenance.CompareAndSwap(old, !old) {
+			return !old
+		}
+	}
+}
+
+// Maintenance reports whether maintenance mode is on.
+func (h *S3Handler) Maintenance() bool {
+	return h.maintenance.Load()
+}
+
+// SetErrorDocument configures a "bucket/key" object whose contents are served
+// with status 404 when an object GET hits a missing key or bucket. An empty
+// path disables it.
+func (h *S3Handler) SetErrorDocument(path string) error {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "/")
+	if path == "" {
+		h.errorDocBucket, h.errorDocKey = "", ""
+		return nil
+	}
+	bucket, key, ok := strings.Cut(path, "/")
+	if !ok || bucket == "" || key == "" {
+		return fmt.Errorf("error document %q must be of the form bucket/key", path)
+	}
+	h.errorDocBucket, h.errorDocKey = bucket, key
+	return nil
+}
+
+// SetIndexDocument configures the object name (e.g. "index.html") served for
+// GETs of keys ending in "/" and of the bucket root. An empty name disables it.
+func (h *S3Handler) SetIndexDocument(name string) error {
+	name = strings.TrimSpace(name)
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("index document %q must not contain a slash", name)
+	}
+	h.indexDocument = name
+	return nil
+}
+
+// isIndexRequest reports whether a bucket-level GET should serve the index
+// document instead of a listing. Only plain, unsigned requests qualify:
+// every SDK listing carries a query string or an Authorization header.
+func (h *S3Handler) isIndexRequest(r *http.Request) bool {
+	return h.indexDocument != "" && r.URL.RawQuery == "" && r.Header.Get("Authorization") == ""
+}
+
+// serveErrorDocument writes the configured error document with status 404.
+// It returns false if none is configured or it cannot be read, in which case
+// the caller should send the normal XML error.
+func (h *S3Handler) serveErrorDocument(w http.ResponseWriter, r *http.Request) bool {
+	if h.errorDocKey == "" || r.Method != http.MethodGet {
+		return false
+	}
+	reader, metadata, err := h.storage.GetObject(h.errorDocBucket, h.errorDocKey)
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+
+	ct := metadata.ContentType
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ct)
+	if metadata.ContentEncoding != "" {
+		w.Header().Set("Content-Encoding", metadata.ContentEncoding)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(metadata.Size, 10))
+	w.WriteHeader(http.StatusNotFound)
+	bufp := h.readBufPool.Get().(*[]byte)
+	io.CopyBuffer(writerOnly{w}, readerOnly{reader}, *bufp)
+	h.readBufPool.Put(bufp)
+	return true
+}
+
+// SetDefaultCacheControl sets the Cache-Control header sent for objects
+// stored without one, so static assets can be cached by browsers without
+// re-uploading them. It only affects responses; stored metadata is unchanged
+// and a stored value always wins.
+func (h *S3Handler) SetDefaultCacheControl(value string) {
+	h.defaultCacheControl = value
+}
+
+// SetAllowBucketRename enables the geckos3 admin extension that renames a
+// bucket in place. It is off by default because a rename breaks every client
+// still addressing the old name.
+func (h *S3Handler) SetAllowBucketRename(allow bool) {
+	h.allowBucketRename = allow
+}
+
+// SetAllowMetadataListing enables the geckos3 ListObjectsV2 extension
+// "metadata=true", which adds each object's stored headers and custom metadata
+// to the listing at the cost of one metadata read per returned object.
+func (h *S3Handler) SetAllowMetadataListing(allow bool) {
+	h.allowMetadataListing = allow
+}
+
+// SetAllowCustomSort enables the geckos3 ListObjectsV2 extension
+// "sort=lastmodified-desc", which lists newest objects first instead of in key
+// order. Continuation tokens assume key order, so a sorted listing is a
+// single page: see handleListObjectsV2.
+func (h *S3Handler) SetAllowCustomSort(allow bool) {
+	h.allowCustomSort = allow
+}
+
+// SetAcceptACL makes PUT /{bucket}?acl and the x-amz-acl header on
+// CreateBucket succeed for tools that insist on setting ACLs. Canned ACLs are
+// stored so GET /{bucket}?acl reports them, but access is never checked
+// against them. When off, the ?acl subresource returns 501.
+func (h *S3Handler) SetAcceptACL(accept bool) {
+	h.acceptACL = accept
+}
+
+// SetAllowBucketUsage enables the geckos3 ListBuckets extension
+// "usage=true", which adds each bucket's object count and total size to the
+// listing. Totals are computed by walking the bucket and cached for
+// bucketUsageTTL, so they may lag recent writes.
+func (h *S3Handler) SetAllowBucketUsage(allow bool) {
+	h.allowBucketUsage = allow
+}
+
+// SetPrettyXML makes XML responses indented two spaces per level, with a
+// trailing newline. Clients parse either form; compact is the default
+// because it is smaller.
+func (h *S3Handler) SetPrettyXML(pretty bool) {
+	h.prettyXML = pretty
+}
+
+// SetJSONErrors enables Accept-based error formatting: a request whose Accept
+// header prefers application/json gets its error as a JSON object with the
+// same Code and Message, plus Resource and RequestId. Off means errors are
+// always S3's XML.
+func (h *S3Handler) SetJSONErrors(enabled bool) {
+	h.jsonErrors = enabled
+}
+
+// SetAnonymousWritePrefixes lets unauthenticated clients PUT objects whose
+// "bucket/key" path starts with one of prefixes. Reads, listings, deletes,
+// copies and multipart operations still require authentication, and the PUTs
+// are limited by restrictAnonymousWrite, so the prefixes act as write-only
+// drop boxes.
+func (h *S3Handler) SetAnonymousWritePrefixes(prefixes []string) {
+	h.anonWritePrefixes = nil
+	for _, p := range prefixes {
+		if p = strings.TrimPrefix(strings.TrimSpace(p), "/"); p != "" {
+			h.anonWritePrefixes = append(h.anonWritePrefixes, p)
+		}
+	}
+}
+
+// isAnonymousWrite reports whether r is an unsigned single-object PUT into
+// one of the anonymous write prefixes.
+func (h *S3Handler) isAnonymousWrite(r *http.Request) bool {
+	if len(h.anonWritePrefixes) == 0 || r.Method != http.MethodPut {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" || r.Header.Get("X-Amz-Copy-Source") != "" {
+		return false
+	}
+	// Presigned URLs, multipart parts and subresources all carry a query.
+	if r.URL.RawQuery != "" {
+		return false
+	}
+	bucket, key := h.parsePath(r.URL.Path)
+	if bucket == "" || key == "" {
+		return false
+	}
+	// Dot segments could walk out of the prefix once the key is resolved.
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "." || seg == ".." {
+			return false
+		}
+	}
+	objectPath := bucket + "/" + key
+	for _, p := range h.anonWritePrefixes {
+		if strings.HasPrefix(objectPath, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// anonymousWriteDenied lists the PutObject headers an anonymous write may
+// not send: they would let anyone lock an object for years or label it.
+var anonymousWriteDenied = []string{"x-amz-meta-" + immutableUntilKey, "x-amz-tagging"}
+
+// restrictAnonymousWrite limits an anonymous PUT to what a drop box needs:
+// it may only create objects, never replace one, and may not set a retention
+// date or tags. It answers and returns false if r asks for more.
+func (h *S3Handler) restrictAnonymousWrite(w http.ResponseWriter, r *http.Request) bool {
+	for _, name := range anonymousWriteDenied {
+		if r.Header.Get(name) != "" {
+			h.writeError(w, r, "AccessDenied", "Anonymous writes cannot set "+name, http.StatusForbidden)
+			return false
+		}
 	}
+	// handlePutObject turns this into an atomic create-if-absent, so an
+	// existing object, whoever wrote it, gets 412 instead of being replaced.
+	r.Header.Set("If-None-Match", "*")
+	return true
 }
 
 func (h *S3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Health check endpoint (bypasses auth)
 	if r.URL.Path == "/health" && r.Method == http.MethodGet {
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			h.writeHealthJSON(w)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		if h.maintenance.Load() {
+			w.Write([]byte("MAINTENANCE"))
+		} else {
+			w.Write([]byte("OK"))
+		}
+		return
+	}
+
+	// In maintenance mode refuse new work; requests already running finish.
+	if h.maintenance.Load() {
+		w.Header().Set("Retry-After", maintenanceRetryAfter)
+		h.writeError(w, r, "ServiceUnavailable", "The server is in maintenance mode. Please retry later.", http.StatusServiceUnavailable)
 		return
 	}
 
-	// Authenticate request
-	if err := h.auth.Authenticate(r); err != nil {
+	// Reject requests addressed to a host other than the configured endpoint
+	if !h.hostAllowed(r) {
+		h.writeError(w, r, "InvalidRequest", "The Host header does not match the configured endpoint", http.StatusBadRequest)
+		return
+	}
+
+	// Authenticate request, except unsigned PUTs into an ingest prefix
+	if h.isAnonymousWrite(r) {
+		if !h.restrictAnonymousWrite(w, r) {
+			return
+		}
+	} else if err := h.auth.Authenticate(r); err != nil {
 		h.writeError(w, r, "AccessDenied", err.Error(), http.StatusForbidden)
 		return
 	}
 
+	// Mutating requests run under the write barrier so an admin sync can
+	// wait for them to finish and hold off new ones.
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.writeBarrier.enter()
+		defer h.writeBarrier.leave()
+	}
+
 	// Parse bucket and key from path
 	bucket, key := h.parsePath(r.URL.Path)
 
@@ -69,6 +619,9 @@ func (h *S3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	start := time.Now()
+	defer func() { bucketRequests.Observe(bucket, time.Since(start), h.storage.BucketExists) }()
+
 	if key == "" {
 		h.handleBucketOperation(w, r, bucket)
 	} else {
@@ -76,7 +629,48 @@ func (h *S3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Methods each resource type accepts, for the Allow header of a 405.
+const (
+	bucketMethods       = "GET, PUT, POST, DELETE, HEAD"
+	objectMethods       = "GET, PUT, POST, DELETE, HEAD"
+	aclMethods          = "GET, PUT"
+	bucketConfigMethods = "GET, PUT, DELETE"
+)
+
+// writeMethodNotAllowed answers a method the resource does not support,
+// listing the ones it does in Allow as RFC 9110 requires.
+func (h *S3Handler) writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Set("Allow", allow)
+	h.writeError(w, r, "MethodNotAllowed", "The specified method is not allowed against this resource", http.StatusMethodNotAllowed)
+}
+
 func (h *S3Handler) handleBucketOperation(w http.ResponseWriter, r *http.Request, bucket string) {
+	if r.URL.Query().Has("acl") {
+		switch r.Method {
+		case http.MethodPut:
+			h.handlePutBucketACL(w, r, bucket)
+		case http.MethodGet:
+			h.handleGetBucketACL(w, r, bucket)
+		default:
+			h.writeMethodNotAllowed(w, r, aclMethods)
+		}
+		return
+	}
+
+	// Subresource requests must not fall through to bucket creation,
+	// deletion or listing.
+	if sub := bucketSubresource(r.URL.Query()); sub != "" {
+		h.handleBucketSubresource(w, r, bucket, sub)
+		return
+	}
+
+	// Likewise the POST actions: a HEAD ?delete is not a HeadBucket, nor a
+	// PUT ?rename a CreateBucket.
+	if query := r.URL.Query(); (query.Has("delete") || query.Has("rename")) && r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodPut:
 		h.handleCreateBucket(w, r, bucket)
@@ -87,23 +681,239 @@ func (h *S3Handler) handleBucketOperation(w http.ResponseWriter, r *http.Request
 	case http.MethodPost:
 		if r.URL.Query().Has("delete") {
 			h.handleDeleteObjects(w, r, bucket)
+		} else if r.URL.Query().Has("rename") {
+			h.handleRenameBucket(w, r, bucket)
 		} else {
 			h.writeError(w, r, "NotImplemented", "Operation not supported", http.StatusNotImplemented)
 		}
 	case http.MethodGet:
-		if r.URL.Query().Get("list-type") == "2" {
+		if h.isIndexRequest(r) {
+			h.handleGetObject(w, r, bucket, h.indexDocument)
+		} else if r.URL.Query().Get("list-type") == "2" {
 			h.handleListObjectsV2(w, r, bucket)
 		} else {
 			h.handleListObjectsV1(w, r, bucket)
 		}
 	default:
-		h.writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w, r, bucketMethods)
+	}
+}
+
+// bucketSubresources are the bucket query parameters, besides acl, that name
+// a configuration subresource instead of modifying the bucket or a listing.
+var bucketSubresources = []string{"location", "versioning", "cors", "policy", "tagging", "lifecycle", "uploads"}
+
+// bucketSubresource returns the subresource query names, or "" for none.
+func bucketSubresource(query url.Values) string {
+	for _, name := range bucketSubresources {
+		if query.Has(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// handleBucketSubresource serves bucket tagging, CORS and the multipart upload
+// listing, and answers GETs of the other subresources as S3 does for a bucket
+// that never had them configured. Writes to those return 501 since their
+// configurations are not stored. No subresource has a HEAD form, so HEAD gets
+// a 405 rather than falling through to HeadBucket.
+func (h *S3Handler) handleBucketSubresource(w http.ResponseWriter, r *http.Request, bucket, sub string) {
+	if sub == "tagging" {
+		switch r.Method {
+		case http.MethodPut:
+			h.handlePutBucketTagging(w, r, bucket)
+		case http.MethodGet:
+			h.handleGetBucketTagging(w, r, bucket)
+		case http.MethodDelete:
+			h.handleDeleteBucketTagging(w, r, bucket)
+		default:
+			h.writeMethodNotAllowed(w, r, bucketConfigMethods)
+		}
+		return
+	}
+	if sub == "cors" {
+		switch r.Method {
+		case http.MethodPut:
+			h.handlePutBucketCORS(w, r, bucket)
+		case http.MethodGet:
+			h.handleGetBucketCORS(w, r, bucket)
+		case http.MethodDelete:
+			h.handleDeleteBucketCORS(w, r, bucket)
+		default:
+			h.writeMethodNotAllowed(w, r, bucketConfigMethods)
+		}
+		return
+	}
+	if sub == "uploads" {
+		if r.Method != http.MethodGet {
+			h.writeMethodNotAllowed(w, r, http.MethodGet)
+			return
+		}
+		h.handleListMultipartUploads(w, r, bucket)
+		return
+	}
+	if r.Method == http.MethodHead {
+		// Unlike a write, not a gap in geckos3: S3 has no HEAD form either.
+		h.writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	if r.Method != http.MethodGet {
+		h.writeError(w, r, "NotImplemented", "Bucket "+sub+" configuration is not supported", http.StatusNotImplemented)
+		return
+	}
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	switch sub {
+	case "location":
+		// An empty constraint means us-east-1.
+		h.writeXML(w, http.StatusOK, LocationConstraint{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/"})
+	case "versioning":
+		// No Status element: versioning has never been enabled.
+		h.writeXML(w, http.StatusOK, VersioningConfiguration{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/"})
+	case "policy":
+		h.writeError(w, r, "NoSuchBucketPolicy", "The bucket policy does not exist", http.StatusNotFound)
+	case "lifecycle":
+		h.writeError(w, r, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist", http.StatusNotFound)
+	}
+}
+
+func (h *S3Handler) handlePutBucketTagging(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1*1024*1024))
+	if err != nil {
+		h.writeError(w, r, "InternalError", "Failed to read request body", http.StatusInternalServerError)
+		return
+	}
+	var tagging Tagging
+	if err := xml.Unmarshal(body, &tagging); err != nil {
+		h.writeError(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+	if len(tagging.TagSet) > maxBucketTags {
+		h.writeError(w, r, "InvalidTag", fmt.Sprintf("Bucket tags cannot be greater than %d", maxBucketTags), http.StatusBadRequest)
+		return
 	}
+	tags, err := tagSetMap(tagging.TagSet)
+	if err != nil {
+		h.writeError(w, r, "InvalidTag", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.PutBucketTagging(bucket, tags); err != nil {
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *S3Handler) handleGetBucketTagging(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	tags, err := h.storage.GetBucketTagging(bucket)
+	if err != nil {
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(tags) == 0 {
+		h.writeError(w, r, "NoSuchTagSet", "The TagSet does not exist", http.StatusNotFound)
+		return
+	}
+	h.writeXML(w, http.StatusOK, newTagging(tags))
+}
+
+func (h *S3Handler) handleDeleteBucketTagging(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	if err := h.storage.PutBucketTagging(bucket, nil); err != nil {
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *S3Handler) handlePutBucketCORS(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1*1024*1024))
+	if err != nil {
+		h.writeError(w, r, "InternalError", "Failed to read request body", http.StatusInternalServerError)
+		return
+	}
+	var config CORSConfiguration
+	if err := xml.Unmarshal(body, &config); err != nil {
+		h.writeError(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+	if err := config.validate(); err != nil {
+		h.writeError(w, r, "InvalidRequest", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Store the normalized form so GET returns what was accepted.
+	config.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	data, err := xml.Marshal(config)
+	if err != nil {
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.storage.PutBucketCORS(bucket, data); err != nil {
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *S3Handler) handleGetBucketCORS(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	config := h.bucketCORS(bucket)
+	if config == nil {
+		h.writeError(w, r, "NoSuchCORSConfiguration", "The CORS configuration does not exist", http.StatusNotFound)
+		return
+	}
+	config.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	h.writeXML(w, http.StatusOK, config)
+}
+
+func (h *S3Handler) handleDeleteBucketCORS(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	if err := h.storage.PutBucketCORS(bucket, nil); err != nil {
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *S3Handler) handleObjectOperation(w http.ResponseWriter, r *http.Request, bucket, key string) {
 	query := r.URL.Query()
 
+	// Object ACLs are not stored; refuse them rather than treating the ACL
+	// body of a PUT as new object content.
+	if query.Has("acl") {
+		h.writeError(w, r, "NotImplemented", "Object ACLs are not supported", http.StatusNotImplemented)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodPost:
 		// POST /{bucket}/{key}?uploads → CreateMultipartUpload
@@ -116,9 +926,25 @@ func (h *S3Handler) handleObjectOperation(w http.ResponseWriter, r *http.Request
 			h.handleCompleteMultipartUpload(w, r, bucket, key)
 			return
 		}
+		// POST /{bucket}/{key}?rename → RenameObject (geckos3 extension)
+		if query.Has("rename") {
+			h.handleRenameObject(w, r, bucket, key)
+			return
+		}
+		// POST /{bucket}/{key}?select&select-type=2 → SelectObjectContent.
+		// Data tools probe for it; name it so they can tell why it failed.
+		if query.Has("select") {
+			h.writeError(w, r, "NotImplemented", "S3 Select (SelectObjectContent) is not supported", http.StatusNotImplemented)
+			return
+		}
 		h.writeError(w, r, "NotImplemented", "Operation not supported", http.StatusNotImplemented)
 
 	case http.MethodPut:
+		// PUT /{bucket}/{key}?tagging → PutObjectTagging
+		if query.Has("tagging") {
+			h.handlePutObjectTagging(w, r, bucket, key)
+			return
+		}
 		// PUT /{bucket}/{key}?partNumber=N&uploadId=X → UploadPart
 		if query.Has("partNumber") && query.Has("uploadId") {
 			h.handleUploadPart(w, r, bucket, key)
@@ -131,20 +957,40 @@ func (h *S3Handler) handleObjectOperation(w http.ResponseWriter, r *http.Request
 		}
 
 	case http.MethodGet:
+		// GET /{bucket}/{key}?tagging → GetObjectTagging
+		if query.Has("tagging") {
+			h.handleGetObjectTagging(w, r, bucket, key)
+			return
+		}
+		// GET /{bucket}/{key}?uploadId=X → ListParts
+		if query.Has("uploadId") {
+			h.handleListParts(w, r, bucket, key)
+			return
+		}
 		h.handleGetObject(w, r, bucket, key)
 	case http.MethodHead:
 		h.handleHeadObject(w, r, bucket, key)
 
 	case http.MethodDelete:
+		// DELETE /{bucket}/{key}?tagging → DeleteObjectTagging
+		if query.Has("tagging") {
+			h.handleDeleteObjectTagging(w, r, bucket, key)
+			return
+		}
 		// DELETE /{bucket}/{key}?uploadId=X → AbortMultipartUpload
 		if query.Has("uploadId") {
 			h.handleAbortMultipartUpload(w, r, bucket, key)
 			return
 		}
+		// DELETE /{bucket}/{key}?uploads → abort every upload for the key (geckos3 extension)
+		if query.Has("uploads") {
+			h.handleAbortMultipartUploadsForKey(w, r, bucket, key)
+			return
+		}
 		h.handleDeleteObject(w, r, bucket, key)
 
 	default:
-		h.writeError(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w, r, objectMethods)
 	}
 }
 
@@ -158,21 +1004,196 @@ func (h *S3Handler) handleCreateBucket(w http.ResponseWriter, r *http.Request, b
 		return
 	}
 
+	cannedACL := ""
+	if h.acceptACL {
+		cannedACL = r.Header.Get("x-amz-acl")
+		if cannedACL != "" && !cannedACLs[cannedACL] {
+			h.writeError(w, r, "InvalidArgument", "Unsupported canned ACL: "+cannedACL, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Hold bucketMu across the existence check, the count check and the
+	// create so concurrent creates cannot overshoot the limit.
+	h.bucketMu.Lock()
 	if h.storage.BucketExists(bucket) {
+		h.bucketMu.Unlock()
 		w.Header().Set("Location", "/"+bucket)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if err := h.storage.CreateBucket(bucket); err != nil {
+	if h.maxBuckets > 0 {
+		if h.bucketCount < 0 {
+			buckets, err := h.storage.ListBuckets()
+			if err != nil {
+				h.bucketMu.Unlock()
+				h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+				return
+			}
+			h.bucketCount = len(buckets)
+		}
+		if h.bucketCount >= h.maxBuckets {
+			h.bucketMu.Unlock()
+			h.writeError(w, r, "TooManyBuckets", "You have attempted to create more buckets than allowed", http.StatusBadRequest)
+			return
+		}
+	}
+	err := h.storage.CreateBucket(bucket)
+	if err == nil && h.bucketCount >= 0 {
+		h.bucketCount++
+	}
+	h.bucketMu.Unlock()
+	if err != nil {
 		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if cannedACL != "" && cannedACL != "private" {
+		if err := h.storage.PutBucketACL(bucket, cannedACL); err != nil {
+			h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Location", "/"+bucket)
 	w.WriteHeader(http.StatusOK)
 }
 
+// cannedACLs are the x-amz-acl values S3 accepts.
+var cannedACLs = map[string]bool{
+	"private":                   true,
+	"public-read":               true,
+	"public-read-write":         true,
+	"authenticated-read":        true,
+	"aws-exec-read":             true,
+	"bucket-owner-read":         true,
+	"bucket-owner-full-control": true,
+	"log-delivery-write":        true,
+}
+
+// aclOwnerID is the owner reported in ACL responses. geckos3 has a single
+// account, so every bucket has the same owner.
+const aclOwnerID = "geckos3"
+
+// handlePutBucketACL accepts PUT /{bucket}?acl when -accept-acl is set. A
+// canned x-amz-acl value is recorded; explicit grants (request body or
+// x-amz-grant-* headers) are ignored.
+func (h *S3Handler) handlePutBucketACL(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.acceptACL {
+		h.writeError(w, r, "NotImplemented", "Bucket ACLs are not supported", http.StatusNotImplemented)
+		return
+	}
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	acl := r.Header.Get("x-amz-acl")
+	if acl == "" {
+		log.Printf("Ignoring ACL grants for bucket %s: only canned ACLs are recorded, and ACLs are not enforced", bucket)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !cannedACLs[acl] {
+		h.writeError(w, r, "InvalidArgument", "Unsupported canned ACL: "+acl, http.StatusBadRequest)
+		return
+	}
+	if err := h.storage.PutBucketACL(bucket, acl); err != nil {
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Recorded canned ACL %s for bucket %s (not enforced)", acl, bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGetBucketACL reports the bucket's recorded canned ACL as the grants
+// S3 would list for it.
+func (h *S3Handler) handleGetBucketACL(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.acceptACL {
+		h.writeError(w, r, "NotImplemented", "Bucket ACLs are not supported", http.StatusNotImplemented)
+		return
+	}
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	acl, err := h.storage.GetBucketACL(bucket)
+	if err != nil {
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeXML(w, http.StatusOK, cannedACLPolicy(acl))
+}
+
+// cannedACLPolicy expands a canned ACL into its grants.
+func cannedACLPolicy(acl string) AccessControlPolicy {
+	const groupURI = "http://acs.amazonaws.com/groups/"
+	owner := ACLOwner{ID: aclOwnerID, DisplayName: aclOwnerID}
+	group := func(name, permission string) Grant {
+		return Grant{Grantee: Grantee{Xmlns: xsiNamespace, Type: "Group", URI: groupURI + name}, Permission: permission}
+	}
+
+	grants := []Grant{{
+		Grantee:    Grantee{Xmlns: xsiNamespace, Type: "CanonicalUser", ID: owner.ID, DisplayName: owner.DisplayName},
+		Permission: "FULL_CONTROL",
+	}}
+	switch acl {
+	case "public-read":
+		grants = append(grants, group("global/AllUsers", "READ"))
+	case "public-read-write":
+		grants = append(grants, group("global/AllUsers", "READ"), group("global/AllUsers", "WRITE"))
+	case "authenticated-read":
+		grants = append(grants, group("global/AuthenticatedUsers", "READ"))
+	case "log-delivery-write":
+		grants = append(grants, group("s3/LogDelivery", "WRITE"), group("s3/LogDelivery", "READ_ACP"))
+	}
+
+	return AccessControlPolicy{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Owner:  owner,
+		Grants: grants,
+	}
+}
+
+// handleRenameBucket implements the non-standard POST /{bucket}?rename admin
+// operation. The new name comes in the x-amz-rename-destination header.
+func (h *S3Handler) handleRenameBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.allowBucketRename {
+		h.writeError(w, r, "AccessDenied", "Bucket rename is disabled on this server", http.StatusForbidden)
+		return
+	}
+	newName := strings.TrimPrefix(r.Header.Get("x-amz-rename-destination"), "/")
+	if !isValidBucketName(newName) {
+		h.writeError(w, r, "InvalidBucketName", "The specified destination bucket is not valid", http.StatusBadRequest)
+		return
+	}
+
+	// Same lock as CreateBucket, so a create cannot claim newName between
+	// the existence check and the rename.
+	h.bucketMu.Lock()
+	if !h.storage.BucketExists(bucket) {
+		h.bucketMu.Unlock()
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	err := h.storage.RenameBucket(bucket, newName)
+	h.bucketMu.Unlock()
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBucketExists):
+			h.writeError(w, r, "BucketAlreadyExists", "The destination bucket already exists", http.StatusConflict)
+		case errors.Is(err, ErrCrossDevice), errors.Is(err, ErrMigrationInProgress):
+			h.writeError(w, r, "InvalidRequest", err.Error(), http.StatusBadRequest)
+		default:
+			h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Location", "/"+newName)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *S3Handler) handleDeleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
 	if !h.storage.BucketExists(bucket) {
 		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
@@ -183,6 +1204,11 @@ func (h *S3Handler) handleDeleteBucket(w http.ResponseWriter, r *http.Request, b
 		h.writeError(w, r, "BucketNotEmpty", "The bucket you tried to delete is not empty", http.StatusConflict)
 		return
 	}
+	h.bucketMu.Lock()
+	if h.bucketCount > 0 {
+		h.bucketCount--
+	}
+	h.bucketMu.Unlock()
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -217,15 +1243,46 @@ func (h *S3Handler) handleListObjectsV2(w http.ResponseWriter, r *http.Request,
 	}
 
 	startKey := startAfter
+	var tokenKey string
 	if continuationToken != "" {
 		if decoded, err := base64.StdEncoding.DecodeString(continuationToken); err == nil {
-			startKey = string(decoded)
+			startKey, tokenKey = string(decoded), string(decoded)
 		}
 	}
+	if h.rejectLongListParams(w, r, listParam{"prefix", prefix}, listParam{"delimiter", delimiter},
+		listParam{"start-after", startAfter}, listParam{"continuation-token", tokenKey}) {
+		return
+	}
 
-	objects, err := h.storage.ListObjects(bucket, prefix, 0)
+	sortOrder := ""
+	if h.allowCustomSort {
+		sortOrder = r.URL.Query().Get("sort")
+	}
+	if sortOrder != "" {
+		if sortOrder != "lastmodified-desc" {
+			h.writeError(w, r, "InvalidArgument", "Unsupported sort order; the only supported value is lastmodified-desc", http.StatusBadRequest)
+			return
+		}
+		if continuationToken != "" || startAfter != "" || delimiter != "" {
+			h.writeError(w, r, "InvalidArgument", "sort cannot be combined with continuation-token, start-after or delimiter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// One entry past the page tells whether the listing is truncated. The
+	// sorted extension orders by time, so it needs every key.
+	limit := maxKeys + 1
+	if sortOrder != "" {
+		limit = 0
+	}
+	objects, err := h.listObjects(r, bucket, prefix, delimiter, startKey, limit)
 	if err != nil {
-		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		h.writeListError(w, r, err)
+		return
+	}
+
+	if sortOrder != "" {
+		h.writeSortedListing(w, bucket, prefix, maxKeys, objects, r.URL.Query().Get("metadata") == "true")
 		return
 	}
 
@@ -251,7 +1308,7 @@ func (h *S3Handler) handleListObjectsV2(w http.ResponseWriter, r *http.Request,
 		lastKey := ""
 
 		for _, obj := range objects {
-			if maxKeys > 0 && totalCount >= maxKeys {
+			if totalCount >= maxKeys {
 				isTruncated = true
 				break
 			}
@@ -287,38 +1344,243 @@ func (h *S3Handler) handleListObjectsV2(w http.ResponseWriter, r *http.Request,
 		}
 	}
 
-	keyCount := len(objects) + len(commonPrefixes)
-	response := ListBucketResult{
-		Xmlns:                 "http://s3.amazonaws.com/doc/2006-03-01/",
-		Name:                  bucket,
-		Prefix:                prefix,
-		Delimiter:             delimiter,
-		MaxKeys:               maxKeys,
-		IsTruncated:           isTruncated,
-		KeyCount:              keyCount,
-		Contents:              make([]Object, len(objects)),
-		CommonPrefixes:        commonPrefixes,
-		NextContinuationToken: nextToken,
-		StartAfter:            startAfter,
-		ContinuationToken:     continuationToken,
+	keyCount := len(objects) + len(commonPrefixes)
+	response := ListBucketResult{
+		Xmlns:                 "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:                  bucket,
+		Prefix:                prefix,
+		Delimiter:             delimiter,
+		MaxKeys:               maxKeys,
+		IsTruncated:           isTruncated,
+		KeyCount:              keyCount,
+		Contents:              make([]Object, len(objects)),
+		CommonPrefixes:        commonPrefixes,
+		NextContinuationToken: nextToken,
+		StartAfter:            startAfter,
+		ContinuationToken:     continuationToken,
+	}
+
+	withMetadata := h.allowMetadataListing && r.URL.Query().Get("metadata") == "true"
+	for i, obj := range objects {
+		response.Contents[i] = Object{
+			Key:          obj.Key,
+			LastModified: obj.LastModified.Format(time.RFC3339),
+			ETag:         obj.ETag,
+			Size:         obj.Size,
+			StorageClass: "STANDARD",
+		}
+		if withMetadata {
+			response.Contents[i].UserMetadata = h.listingMetadata(bucket, obj.Key)
+		}
+	}
+
+	h.writeXML(w, http.StatusOK, response)
+}
+
+// writeSortedListing answers a ListObjectsV2 request with sort=lastmodified-desc:
+// the newest maxKeys objects, newest first, ties broken by key. A continuation
+// token could not resume a listing in this order once objects change, so none
+// is issued; IsTruncated tells the caller that older objects were left out.
+func (h *S3Handler) writeSortedListing(w http.ResponseWriter, bucket, prefix string, maxKeys int, objects []ObjectInfo, metadata bool) {
+	sort.Slice(objects, func(i, j int) bool {
+		if !objects[i].LastModified.Equal(objects[j].LastModified) {
+			return objects[i].LastModified.After(objects[j].LastModified)
+		}
+		return objects[i].Key < objects[j].Key
+	})
+	isTruncated := len(objects) > maxKeys
+	if isTruncated {
+		objects = objects[:maxKeys]
+	}
+
+	response := ListBucketResult{
+		Xmlns:       "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:        bucket,
+		Prefix:      prefix,
+		MaxKeys:     maxKeys,
+		IsTruncated: isTruncated,
+		KeyCount:    len(objects),
+		Contents:    make([]Object, len(objects)),
+	}
+	withMetadata := h.allowMetadataListing && metadata
+	for i, obj := range objects {
+		response.Contents[i] = Object{
+			Key:          obj.Key,
+			LastModified: obj.LastModified.Format(time.RFC3339),
+			ETag:         obj.ETag,
+			Size:         obj.Size,
+			StorageClass: "STANDARD",
+		}
+		if withMetadata {
+			response.Contents[i].UserMetadata = h.listingMetadata(bucket, obj.Key)
+		}
+	}
+
+	h.writeXML(w, http.StatusOK, response)
+}
+
+// listingMetadata returns the stored headers and custom metadata of one listed
+// object, or nil if it has none or vanished since the listing was taken.
+func (h *S3Handler) listingMetadata(bucket, key string) *ListingMetadata {
+	meta, err := h.storage.HeadObject(bucket, key)
+	if err != nil {
+		return nil
+	}
+	lm := &ListingMetadata{
+		ContentType:        meta.ContentType,
+		ContentEncoding:    meta.ContentEncoding,
+		ContentDisposition: meta.ContentDisposition,
+		CacheControl:       meta.CacheControl,
+	}
+	names := make([]string, 0, len(meta.CustomMetadata))
+	for k := range meta.CustomMetadata {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		lm.Items = append(lm.Items, MetadataItem{Key: k, Value: meta.CustomMetadata[k]})
+	}
+	if lm.ContentType == "" && lm.ContentEncoding == "" && lm.ContentDisposition == "" &&
+		lm.CacheControl == "" && len(lm.Items) == 0 {
+		return nil
+	}
+	return lm
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Object Handlers
+// ═══════════════════════════════════════════════════════════════════════════════
+
+// setUserMetadataHeaders emits stored x-amz-meta-* values for GET and HEAD.
+// Like S3, geckos3 keeps metadata names lowercased, and the names are sent
+// exactly as stored rather than in Go's canonical X-Amz-Meta-Foo form.
+func setUserMetadataHeaders(header http.Header, meta map[string]string) {
+	for k, v := range meta {
+		header["x-amz-meta-"+strings.ToLower(k)] = []string{v}
+	}
+}
+
+// customMetadata collects the x-amz-meta-* headers of a request, keyed by the
+// lowercased name without the prefix, or nil if there are none. Metadata
+// names are case-insensitive, so headers that differ only in case (or repeat
+// a header) collapse to one key; if they carry different values the request
+// is ambiguous and an error is returned instead of picking one.
+func customMetadata(header http.Header) (map[string]string, error) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var meta map[string]string
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if !strings.HasPrefix(lower, "x-amz-meta-") {
+			continue
+		}
+		metaKey := strings.TrimPrefix(lower, "x-amz-meta-")
+		for _, v := range header[name] {
+			if prev, ok := meta[metaKey]; ok {
+				if prev != v {
+					return nil, fmt.Errorf("conflicting values for metadata header x-amz-meta-%s", metaKey)
+				}
+				continue
+			}
+			if meta == nil {
+				meta = make(map[string]string)
+			}
+			meta[metaKey] = v
+		}
+	}
+	return meta, nil
+}
+
+// checkImmutableUntil validates the x-amz-meta-immutable-until value of a
+// write, if it has one.
+func checkImmutableUntil(meta map[string]string) error {
+	value, ok := meta[immutableUntilKey]
+	if !ok {
+		return nil
+	}
+	_, err := parseImmutableUntil(value)
+	return err
+}
+
+// Tag limits enforced by S3.
+const (
+	maxObjectTags  = 10
+	maxBucketTags  = 50
+	maxTagKeyLen   = 128
+	maxTagValueLen = 256
+)
+
+// checkTag checks one tag against the S3 key and value rules.
+func checkTag(k, v string) error {
+	switch {
+	case k == "":
+		return fmt.Errorf("the tag key cannot be empty")
+	case utf8.RuneCountInString(k) > maxTagKeyLen:
+		return fmt.Errorf("the tag key exceeds the maximum length of %d", maxTagKeyLen)
+	case utf8.RuneCountInString(v) > maxTagValueLen:
+		return fmt.Errorf("the tag value exceeds the maximum length of %d", maxTagValueLen)
+	case strings.HasPrefix(strings.ToLower(k), "aws:"):
+		return fmt.Errorf("tag keys beginning with \"aws:\" are reserved")
+	}
+	return nil
+}
+
+// tagSetMap converts the TagSet of a Tagging request body to a map, checking
+// each tag against the S3 rules and rejecting repeated keys.
+func tagSetMap(tagSet []Tag) (map[string]string, error) {
+	tags := make(map[string]string, len(tagSet))
+	for _, tag := range tagSet {
+		if _, dup := tags[tag.Key]; dup {
+			return nil, fmt.Errorf("Cannot provide multiple tags with the same key %q", tag.Key)
+		}
+		if err := checkTag(tag.Key, tag.Value); err != nil {
+			return nil, err
+		}
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
+
+// parseTaggingHeader parses an x-amz-tagging header ("k1=v1&k2=v2", URL
+// query encoded) and checks it against the S3 tag limits.
+func parseTaggingHeader(header string) (map[string]string, error) {
+	values, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, fmt.Errorf("the x-amz-tagging header is not valid URL query syntax")
 	}
-
-	for i, obj := range objects {
-		response.Contents[i] = Object{
-			Key:          obj.Key,
-			LastModified: obj.LastModified.Format(time.RFC3339),
-			ETag:         obj.ETag,
-			Size:         obj.Size,
-			StorageClass: "STANDARD",
+	if len(values) > maxObjectTags {
+		return nil, fmt.Errorf("object tags cannot be greater than %d", maxObjectTags)
+	}
+	tags := make(map[string]string, len(values))
+	for k, vs := range values {
+		if len(vs) > 1 {
+			return nil, fmt.Errorf("cannot provide multiple tags with the same key %q", k)
+		}
+		if err := checkTag(k, vs[0]); err != nil {
+			return nil, err
 		}
+		tags[k] = vs[0]
 	}
-
-	h.writeXML(w, http.StatusOK, response)
+	return tags, nil
 }
 
-// ═══════════════════════════════════════════════════════════════════════════════
-// Object Handlers
-// ═══════════════════════════════════════════════════════════════════════════════
+// newTagging builds a Tagging response listing tags sorted by key.
+func newTagging(tags map[string]string) Tagging {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	response := Tagging{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/", TagSet: make([]Tag, len(keys))}
+	for i, k := range keys {
+		response.TagSet[i] = Tag{Key: k, Value: tags[k]}
+	}
+	return response
+}
 
 func (h *S3Handler) handlePutObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
 	if !h.storage.BucketExists(bucket) {
@@ -326,63 +1588,159 @@ func (h *S3Handler) handlePutObject(w http.ResponseWriter, r *http.Request, buck
 		return
 	}
 
+	// Everything up to here runs before the body is read, so a client that
+	// sent Expect: 100-continue gets these errors without uploading.
+	if !h.hasSpaceFor(r) {
+		h.writeError(w, r, "InsufficientStorage", "Not enough free space to store the object", http.StatusInsufficientStorage)
+		return
+	}
+
+	// If-None-Match: * creates the object only if the key is free. The
+	// check here spares the upload; PutObject repeats it atomically.
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch != "" {
+		if ifNoneMatch != "*" {
+			h.writeError(w, r, "NotImplemented", "If-None-Match on PUT only supports *", http.StatusNotImplemented)
+			return
+		}
+		if _, err := h.storage.HeadObject(bucket, key); err == nil {
+			h.writeError(w, r, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+			return
+		}
+	}
+
 	// Build PutObjectInput from request headers
 	input := &PutObjectInput{
 		ContentType:        r.Header.Get("Content-Type"),
 		ContentEncoding:    r.Header.Get("Content-Encoding"),
 		ContentDisposition: r.Header.Get("Content-Disposition"),
 		CacheControl:       r.Header.Get("Cache-Control"),
+		IfNoneMatch:        ifNoneMatch == "*",
 	}
 
-	// Parse x-amz-meta-* custom metadata headers
-	customMeta := make(map[string]string)
-	for name, values := range r.Header {
-		lower := strings.ToLower(name)
-		if strings.HasPrefix(lower, "x-amz-meta-") && len(values) > 0 {
-			metaKey := strings.TrimPrefix(lower, "x-amz-meta-")
-			customMeta[metaKey] = values[0]
+	// Content-MD5 is the base64 of the raw digest. PutObject compares it
+	// with the MD5 it computes for the ETag anyway.
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		digest, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(digest) != md5.Size {
+			h.writeError(w, r, "InvalidDigest", "The Content-MD5 you specified was invalid", http.StatusBadRequest)
+			return
 		}
+		input.ExpectedMD5 = digest
+	}
+
+	// Parse x-amz-meta-* custom metadata headers
+	customMeta, err := customMetadata(r.Header)
+	if err != nil {
+		h.writeError(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkImmutableUntil(customMeta); err != nil {
+		h.writeError(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		return
 	}
-	if len(customMeta) > 0 {
-		input.CustomMetadata = customMeta
+	input.CustomMetadata = customMeta
+
+	if tagging := r.Header.Get("x-amz-tagging"); tagging != "" {
+		tags, err := parseTaggingHeader(tagging)
+		if err != nil {
+			h.writeError(w, r, "InvalidTag", err.Error(), http.StatusBadRequest)
+			return
+		}
+		input.Tags = tags
 	}
 
 	// Pass SHA256 expectation to storage layer for atomic verification.
 	// The storage layer will verify the hash before committing the file.
 	expectedSHA := r.Header.Get("X-Amz-Content-Sha256")
-	if expectedSHA != "" && expectedSHA != "UNSIGNED-PAYLOAD" && expectedSHA != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+	if expectedSHA != "" && expectedSHA != "UNSIGNED-PAYLOAD" && !strings.HasPrefix(expectedSHA, "STREAMING-") {
 		input.ExpectedSHA256 = expectedSHA
 	}
-
-	// If the client is using AWS chunked transfer encoding, decode the
-	// chunked framing so only raw object bytes reach the storage layer.
-	var body io.Reader = r.Body
-	if isAWSChunked(r) {
-		body = newAWSChunkedReader(r.Body)
+	if input.Checksum, err = checksumInput(r); err != nil {
+		h.writeError(w, r, "InvalidRequest", err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	metadata, err := h.storage.PutObject(bucket, key, body, input)
+	metadata, err := h.storage.PutObject(bucket, key, uploadBody(r, input.Checksum), input)
 	if err != nil {
+		if errors.Is(err, ErrBadChecksum) {
+			h.writeError(w, r, "BadDigest", fmt.Sprintf("The %s you specified did not match the calculated checksum.", input.Checksum.Algorithm), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, ErrBadContentMD5) {
+			h.writeError(w, r, "BadDigest", "The Content-MD5 you specified did not match what we received", http.StatusBadRequest)
+			return
+		}
 		if errors.Is(err, ErrBadDigest) {
 			h.writeError(w, r, "BadDigest", "The Content-SHA256 you specified did not match what we received", http.StatusBadRequest)
 			return
 		}
+		if errors.Is(err, ErrPreconditionFailed) {
+			h.writeError(w, r, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+			return
+		}
+		if errors.Is(err, ErrInvalidKeyName) {
+			h.writeError(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, ErrObjectImmutable) {
+			h.writeError(w, r, "AccessDenied", err.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, ErrRequestTooSlow) {
+			h.writeError(w, r, "RequestTimeout", "Your socket connection to the server was not read from or written to within the timeout period", http.StatusBadRequest)
+			return
+		}
 		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("ETag", metadata.ETag)
+	if metadata.Checksum != "" {
+		w.Header().Set(checksumHeader(metadata.ChecksumAlgorithm), metadata.Checksum)
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// responseHeaderOverrides lists the GetObject query parameters that replace a
+// response header, e.g. so a presigned URL can force a download with
+// response-content-disposition=attachment.
+var responseHeaderOverrides = []struct{ param, header string }{
+	{"response-content-type", "Content-Type"},
+	{"response-content-disposition", "Content-Disposition"},
+	{"response-cache-control", "Cache-Control"},
+	{"response-content-encoding", "Content-Encoding"},
+	{"response-expires", "Expires"},
+}
+
 func (h *S3Handler) handleGetObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if h.indexDocument != "" && strings.HasSuffix(key, "/") {
+		key += h.indexDocument
+	}
+	query := r.URL.Query()
+	for _, o := range responseHeaderOverrides {
+		if values, ok := query[o.param]; ok && values[0] == "" {
+			h.writeError(w, r, "InvalidArgument", o.param+" must not be empty", http.StatusBadRequest)
+			return
+		}
+	}
 	reader, metadata, err := h.storage.GetObject(bucket, key)
 	if err != nil {
+		if h.serveErrorDocument(w, r) {
+			return
+		}
 		h.writeError(w, r, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
 		return
 	}
 	defer reader.Close()
 
+	// Conditions are evaluated here rather than left to ServeContent, so a
+	// failed one gets an S3 error body and non-seekable readers honor them.
+	if preconditionFailed(r, metadata) {
+		h.writeError(w, r, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+		return
+	}
+
 	// Set ETag
 	if metadata.ETag != "" {
 		w.Header().Set("ETag", metadata.ETag)
@@ -404,15 +1762,61 @@ func (h *S3Handler) handleGetObject(w http.ResponseWriter, r *http.Request, buck
 	}
 	if metadata.CacheControl != "" {
 		w.Header().Set("Cache-Control", metadata.CacheControl)
+	} else if h.defaultCacheControl != "" {
+		w.Header().Set("Cache-Control", h.defaultCacheControl)
+	}
+
+	setUserMetadataHeaders(w.Header(), metadata.CustomMetadata)
+	if len(metadata.Tags) > 0 {
+		w.Header().Set("x-amz-tagging-count", strconv.Itoa(len(metadata.Tags)))
+	}
+	if r.Header.Get("Range") == "" {
+		setChecksumHeaders(w.Header(), metadata)
 	}
 
-	// Emit custom x-amz-meta-* headers
-	for k, v := range metadata.CustomMetadata {
-		w.Header().Set("x-amz-meta-"+k, v)
+	// Query overrides win over stored metadata. They are set before
+	// ServeContent, which keeps a Content-Type that is already present.
+	for _, o := range responseHeaderOverrides {
+		if value := query.Get(o.param); value != "" {
+			w.Header().Set(o.header, value)
+		}
+	}
+
+	// ServeContent answers a matching If-None-Match without Last-Modified,
+	// which caches need to refresh their stored validators, so the common
+	// revalidation case is answered here.
+	if notModified(r, metadata) {
+		writeNotModified(w, metadata)
+		return
+	}
+	// Already decided; ServeContent would re-check them more strictly (it
+	// rejects unquoted If-Match ETags). If-Range is left to it.
+	for _, name := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		r.Header.Del(name)
+	}
+
+	// Several ranges are answered here for every kind of reader, so the
+	// non-seekable fallback serves them too and an unsatisfiable set gets an
+	// S3 error body. A single range is left to ServeContent, as is If-Range.
+	if r.Header.Get("If-Range") == "" {
+		if ranges, specs := parseByteRanges(r.Header.Get("Range"), metadata.Size); specs > 1 {
+			if specs > maxByteRanges || totalLength(ranges) > metadata.Size {
+				// As ServeContent does, a set of ranges that asks for more
+				// than the object gets the object once, so a short header
+				// cannot multiply the response size.
+				r.Header.Del("Range")
+			} else {
+				h.serveByteRanges(w, r, reader, metadata, coalesceByteRanges(ranges))
+				return
+			}
+		}
 	}
 
 	// Use http.ServeContent for automatic Range request support
 	if rs, ok := reader.(io.ReadSeeker); ok {
+		if metadata.Size <= smallObjectThreshold && !hasRangeOrConditional(r) && h.serveSmallObject(w, r, rs, metadata) {
+			return
+		}
 		http.ServeContent(w, r, "", metadata.LastModified, rs)
 		return
 	}
@@ -421,7 +1825,348 @@ func (h *S3Handler) handleGetObject(w http.ResponseWriter, r *http.Request, buck
 	w.Header().Set("Content-Length", strconv.FormatInt(metadata.Size, 10))
 	w.Header().Set("Last-Modified", metadata.LastModified.Format(http.TimeFormat))
 	w.WriteHeader(http.StatusOK)
-	io.Copy(w, reader)
+	bufp := h.readBufPool.Get().(*[]byte)
+	io.CopyBuffer(writerOnly{w}, readerOnly{reader}, *bufp)
+	h.readBufPool.Put(bufp)
+}
+
+func (h *S3Handler) handleGetObjectTagging(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	tags, err := h.storage.GetObjectTagging(bucket, key)
+	if err != nil {
+		h.writeError(w, r, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		return
+	}
+
+	h.writeXML(w, http.StatusOK, newTagging(tags))
+}
+
+func (h *S3Handler) handlePutObjectTagging(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1*1024*1024))
+	if err != nil {
+		h.writeError(w, r, "InternalError", "Failed to read request body", http.StatusInternalServerError)
+		return
+	}
+	var tagging Tagging
+	if err := xml.Unmarshal(body, &tagging); err != nil {
+		h.writeError(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+	if len(tagging.TagSet) > maxObjectTags {
+		h.writeError(w, r, "InvalidTag", fmt.Sprintf("Object tags cannot be greater than %d", maxObjectTags), http.StatusBadRequest)
+		return
+	}
+	tags, err := tagSetMap(tagging.TagSet)
+	if err != nil {
+		h.writeError(w, r, "InvalidTag", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.PutObjectTagging(bucket, key, tags); err != nil {
+		h.writeObjectTaggingError(w, r, bucket, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *S3Handler) handleDeleteObjectTagging(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if err := h.storage.DeleteObjectTagging(bucket, key); err != nil {
+		h.writeObjectTaggingError(w, r, bucket, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeObjectTaggingError answers a failed PUT or DELETE of an object's tags.
+func (h *S3Handler) writeObjectTaggingError(w http.ResponseWriter, r *http.Request, bucket string, err error) {
+	switch {
+	case errors.Is(err, ErrMetadataDisabled):
+		h.writeError(w, r, "NotImplemented", "Object tags are not stored when the server runs with -metadata=false", http.StatusNotImplemented)
+	case !h.storage.BucketExists(bucket):
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+	case os.IsNotExist(err):
+		h.writeError(w, r, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+	default:
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serveSmallObject writes a whole small object from a pooled buffer. It
+// returns false, with the reader rewound, if the object turns out to be larger
+// than smallObjectThreshold so the caller can fall back to ServeContent.
+func (h *S3Handler) serveSmallObject(w http.ResponseWriter, r *http.Request, rs io.ReadSeeker, metadata *ObjectMetadata) bool {
+	bufp := smallObjectPool.Get().(*[]byte)
+	defer smallObjectPool.Put(bufp)
+
+	n, err := io.ReadFull(rs, *bufp)
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		// Either the buffer filled up (object too large) or the read failed.
+		if _, seekErr := rs.Seek(0, io.SeekStart); seekErr != nil {
+			h.writeError(w, r, "InternalError", seekErr.Error(), http.StatusInternalServerError)
+			return true
+		}
+		return false
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+	if !metadata.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.WriteHeader(http.StatusOK)
+	w.Write((*bufp)[:n])
+	return true
+}
+
+// hasRangeOrConditional reports whether a GET needs http.ServeContent's
+// handling of Range and conditional request headers.
+func hasRangeOrConditional(r *http.Request) bool {
+	for _, name := range []string{"Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"} {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// byteRange is a satisfiable range of an object, from start for length bytes.
+type byteRange struct {
+	start, length int64
+}
+
+// contentRange formats r as a Content-Range value for an object of size bytes.
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// maxByteRanges is the most ranges a GET may ask for before its Range header
+// is ignored and the whole object served.
+const maxByteRanges = 50
+
+// parseByteRanges parses a Range header against an object of size bytes. It
+// returns the satisfiable ranges, clamped to the object, and how many ranges
+// the header specified; specs is 0 when the header is absent or malformed,
+// which means it is ignored. Parsing stops, with no ranges, once specs
+// exceeds maxByteRanges.
+func parseByteRanges(header string, size int64) (ranges []byteRange, specs int) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, 0
+	}
+	for _, ra := range strings.Split(spec, ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, 0
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		if specs++; specs > maxByteRanges {
+			return nil, specs
+		}
+		if first == "" {
+			// A suffix range: the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, 0
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			ranges = append(ranges, byteRange{size - n, n})
+			continue
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, 0
+		}
+		end := size - 1
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return nil, 0
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start, end - start + 1})
+	}
+	return ranges, specs
+}
+
+// totalLength returns the number of bytes ranges cover, counting overlaps
+// as many times as they occur.
+func totalLength(ranges []byteRange) int64 {
+	var n int64
+	for _, ra := range ranges {
+		n += ra.length
+	}
+	return n
+}
+
+// coalesceByteRanges sorts ranges by start and merges the ones that overlap
+// or touch, as RFC 9110 lets a server do, so every byte is sent at most once
+// and a single forward pass over the object can serve them.
+func coalesceByteRanges(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	var out []byteRange
+	for _, ra := range ranges {
+		if n := len(out); n > 0 && ra.start <= out[n-1].start+out[n-1].length {
+			last := &out[n-1]
+			last.length = max(last.length, ra.start+ra.length-last.start)
+			continue
+		}
+		out = append(out, ra)
+	}
+	return out
+}
+
+// serveByteRanges answers a GET for several ranges, already coalesced: 206
+// with a single part when only one is left, multipart/byteranges when more
+// are, and 416 InvalidRange when none is satisfiable. Non-seekable readers
+// skip forward to each range.
+func (h *S3Handler) serveByteRanges(w http.ResponseWriter, r *http.Request, reader io.Reader, metadata *ObjectMetadata, ranges []byteRange) {
+	if len(ranges) == 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", metadata.Size))
+		h.writeError(w, r, "InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	rs, seekable := reader.(io.ReadSeeker)
+
+	var pos int64
+	// copyRange writes ra to dst, seeking or skipping forward to its start.
+	copyRange := func(dst io.Writer, ra byteRange) error {
+		if seekable {
+			if _, err := rs.Seek(ra.start, io.SeekStart); err != nil {
+				return err
+			}
+		} else if _, err := io.CopyN(io.Discard, reader, ra.start-pos); err != nil {
+			return err
+		}
+		bufp := h.readBufPool.Get().(*[]byte)
+		defer h.readBufPool.Put(bufp)
+		_, err := io.CopyBuffer(writerOnly{dst}, readerOnly{io.LimitReader(reader, ra.length)}, *bufp)
+		pos = ra.start + ra.length
+		return err
+	}
+
+	ct := w.Header().Get("Content-Type")
+	w.Header().Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if len(ranges) == 1 {
+		w.Header().Set("Content-Range", ranges[0].contentRange(metadata.Size))
+		w.Header().Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		copyRange(w, ranges[0])
+		return
+	}
+
+	partHeader := func(ra byteRange) textproto.MIMEHeader {
+		return textproto.MIMEHeader{
+			"Content-Type":  {ct},
+			"Content-Range": {ra.contentRange(metadata.Size)},
+		}
+	}
+	mw := multipart.NewWriter(w)
+	// Size the body with a dry run over the same boundary and part headers.
+	var counter countingWriter
+	dry := multipart.NewWriter(&counter)
+	dry.SetBoundary(mw.Boundary())
+	for _, ra := range ranges {
+		dry.CreatePart(partHeader(ra))
+		counter += countingWriter(ra.length)
+	}
+	dry.Close()
+
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.FormatInt(int64(counter), 10))
+	w.WriteHeader(http.StatusPartialContent)
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(partHeader(ra))
+		if err != nil {
+			return
+		}
+		if err := copyRange(part, ra); err != nil {
+			return
+		}
+	}
+	mw.Close()
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// opaqueTag returns an ETag without its quotes, so validators sent with or
+// without them compare equal to the stored, quoted ETag.
+func opaqueTag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
+// preconditionFailed reports whether a GET or HEAD's If-Match or, without
+// one, If-Unmodified-Since validator rules out serving the object. It is
+// checked before notModified, as RFC 9110 orders them. If-Match compares
+// ETags strongly, with or without quotes, and "*" matches any object.
+func preconditionFailed(r *http.Request, metadata *ObjectMetadata) bool {
+	if im := r.Header.Get("If-Match"); im != "" {
+		current := opaqueTag(metadata.ETag)
+		for _, tag := range splitHeaderList(im) {
+			if tag == "*" || (current != "" && !strings.HasPrefix(tag, "W/") && opaqueTag(tag) == current) {
+				return false
+			}
+		}
+		return true
+	}
+	if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !metadata.LastModified.IsZero() {
+		t, err := http.ParseTime(ius)
+		return err == nil && metadata.LastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// notModified reports whether a GET or HEAD's If-None-Match or, without one,
+// If-Modified-Since validator shows the client's copy is current. If-None-Match
+// compares ETags weakly, with or without quotes, like preconditionFailed.
+func notModified(r *http.Request, metadata *ObjectMetadata) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		current := opaqueTag(strings.TrimPrefix(metadata.ETag, "W/"))
+		for _, tag := range splitHeaderList(inm) {
+			if tag == "*" || (current != "" && opaqueTag(strings.TrimPrefix(tag, "W/")) == current) {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !metadata.LastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		// HTTP dates have whole-second resolution.
+		return err == nil && !metadata.LastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// writeNotModified answers with 304, keeping the validators and cache headers
+// already set but not the ones that describe a body.
+func writeNotModified(w http.ResponseWriter, metadata *ObjectMetadata) {
+	header := w.Header()
+	header.Del("Content-Type")
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+	if !metadata.LastModified.IsZero() {
+		header.Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNotModified)
 }
 
 func (h *S3Handler) handleHeadObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
@@ -430,13 +2175,27 @@ func (h *S3Handler) handleHeadObject(w http.ResponseWriter, r *http.Request, buc
 		h.writeError(w, r, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
 		return
 	}
+	if preconditionFailed(r, metadata) {
+		h.writeError(w, r, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+		return
+	}
+
+	// The file is the truth: a HEAD must not announce a Content-Length the
+	// GET that follows cannot deliver.
+	size := metadata.Size
+	if metadata.Stale {
+		log.Printf("WARNING: metadata for %s/%s records %d bytes but the data file has %d; the object was changed outside geckos3 or its sidecar is stale",
+			bucket, key, metadata.Size, metadata.FileSize)
+		metadataDrift.Add(1)
+		size = metadata.FileSize
+	}
 
 	ct := metadata.ContentType
 	if ct == "" {
 		ct = "application/octet-stream"
 	}
 	w.Header().Set("Content-Type", ct)
-	w.Header().Set("Content-Length", strconv.FormatInt(metadata.Size, 10))
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	w.Header().Set("Last-Modified", metadata.LastModified.Format(http.TimeFormat))
 	w.Header().Set("ETag", metadata.ETag)
 	w.Header().Set("Accept-Ranges", "bytes")
@@ -450,22 +2209,47 @@ func (h *S3Handler) handleHeadObject(w http.ResponseWriter, r *http.Request, buc
 	}
 	if metadata.CacheControl != "" {
 		w.Header().Set("Cache-Control", metadata.CacheControl)
+	} else if h.defaultCacheControl != "" {
+		w.Header().Set("Cache-Control", h.defaultCacheControl)
 	}
 
-	// Emit custom x-amz-meta-* headers
-	for k, v := range metadata.CustomMetadata {
-		w.Header().Set("x-amz-meta-"+k, v)
+	setUserMetadataHeaders(w.Header(), metadata.CustomMetadata)
+	if len(metadata.Tags) > 0 {
+		w.Header().Set("x-amz-tagging-count", strconv.Itoa(len(metadata.Tags)))
+	}
+	if !metadata.Stale {
+		setChecksumHeaders(w.Header(), metadata)
 	}
 
+	// A stale sidecar's ETag may describe content that is gone, so it must
+	// not confirm a cached copy.
+	if !metadata.Stale && notModified(r, metadata) {
+		writeNotModified(w, metadata)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
 func (h *S3Handler) handleDeleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
-	if err := h.storage.DeleteObject(bucket, key); err != nil {
+	result, err := h.storage.DeleteObject(bucket, key)
+	if err != nil {
+		if errors.Is(err, ErrObjectImmutable) {
+			h.writeError(w, r, "AccessDenied", err.Error(), http.StatusForbidden)
+			return
+		}
 		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// S3 sends these only for versioned buckets; an unversioned delete,
+	// including one of a missing key, carries neither.
+	if result.VersionID != "" {
+		w.Header().Set("x-amz-version-id", result.VersionID)
+	}
+	if result.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -480,12 +2264,24 @@ func (h *S3Handler) handleListBuckets(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	withUsage := h.allowBucketUsage && r.URL.Query().Get("usage") == "true"
+	if withUsage {
+		h.pruneUsageCache(buckets)
+	}
+
 	xmlBuckets := make([]XMLBucket, len(buckets))
 	for i, b := range buckets {
 		xmlBuckets[i] = XMLBucket{
 			Name:         b.Name,
 			CreationDate: b.CreationDate.Format(time.RFC3339),
 		}
+		if withUsage {
+			// A bucket deleted since ListBuckets simply has no usage.
+			if u, err := h.bucketUsage(b.Name); err == nil {
+				xmlBuckets[i].ObjectCount = &u.objects
+				xmlBuckets[i].Size = &u.size
+			}
+		}
 	}
 
 	response := ListAllMyBucketsResult{
@@ -496,6 +2292,61 @@ func (h *S3Handler) handleListBuckets(w http.ResponseWriter, r *http.Request) {
 	h.writeXML(w, http.StatusOK, response)
 }
 
+// bucketUsageTTL is how long a bucket's computed usage is reused.
+const bucketUsageTTL = 30 * time.Second
+
+// bucketUsage is a bucket's object count and total size at computed.
+type bucketUsage struct {
+	objects  int64
+	size     int64
+	computed time.Time
+}
+
+// bucketUsage returns the usage of bucket, walking it unless a cached value
+// younger than bucketUsageTTL exists.
+func (h *S3Handler) bucketUsage(bucket string) (bucketUsage, error) {
+	h.usageMu.Lock()
+	u, ok := h.usageCache[bucket]
+	h.usageMu.Unlock()
+	if ok && time.Since(u.computed) < bucketUsageTTL {
+		return u, nil
+	}
+
+	u = bucketUsage{computed: time.Now()}
+	err := h.storage.WalkObjects(bucket, "", func(obj ObjectInfo) error {
+		u.objects++
+		u.size += obj.Size
+		return nil
+	})
+	if err != nil {
+		return bucketUsage{}, err
+	}
+
+	h.usageMu.Lock()
+	if h.usageCache == nil {
+		h.usageCache = make(map[string]bucketUsage)
+	}
+	h.usageCache[bucket] = u
+	h.usageMu.Unlock()
+	return u, nil
+}
+
+// pruneUsageCache drops cached usage for buckets no longer listed, so deleted
+// buckets do not accumulate and a recreated bucket starts fresh.
+func (h *S3Handler) pruneUsageCache(buckets []BucketInfo) {
+	h.usageMu.Lock()
+	defer h.usageMu.Unlock()
+	listed := make(map[string]bool, len(buckets))
+	for _, b := range buckets {
+		listed[b.Name] = true
+	}
+	for name := range h.usageCache {
+		if !listed[name] {
+			delete(h.usageCache, name)
+		}
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // ListObjectsV1 Handler
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -518,10 +2369,13 @@ func (h *S3Handler) handleListObjectsV1(w http.ResponseWriter, r *http.Request,
 	if maxKeys > 1000 {
 		maxKeys = 1000
 	}
+	if h.rejectLongListParams(w, r, listParam{"prefix", prefix}, listParam{"delimiter", delimiter}, listParam{"marker", marker}) {
+		return
+	}
 
-	objects, err := h.storage.ListObjects(bucket, prefix, 0)
+	objects, err := h.listObjects(r, bucket, prefix, delimiter, marker, maxKeys+1)
 	if err != nil {
-		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		h.writeListError(w, r, err)
 		return
 	}
 
@@ -546,7 +2400,7 @@ func (h *S3Handler) handleListObjectsV1(w http.ResponseWriter, r *http.Request,
 		totalCount := 0
 
 		for _, obj := range objects {
-			if maxKeys > 0 && totalCount >= maxKeys {
+			if totalCount >= maxKeys {
 				isTruncated = true
 				break
 			}
@@ -638,21 +2492,42 @@ func (h *S3Handler) handleCopyObject(w http.ResponseWriter, r *http.Request, dst
 			ContentDisposition: r.Header.Get("Content-Disposition"),
 			CacheControl:       r.Header.Get("Cache-Control"),
 		}
-		customMeta := make(map[string]string)
-		for name, values := range r.Header {
-			lower := strings.ToLower(name)
-			if strings.HasPrefix(lower, "x-amz-meta-") && len(values) > 0 {
-				metaKey := strings.TrimPrefix(lower, "x-amz-meta-")
-				customMeta[metaKey] = values[0]
+		customMeta, err := customMetadata(r.Header)
+		if err == nil {
+			err = checkImmutableUntil(customMeta)
+		}
+		if err != nil {
+			h.writeError(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
+			return
+		}
+		overrideMeta.CustomMetadata = customMeta
+	}
+
+	// Check tagging directive: REPLACE uses x-amz-tagging from this request,
+	// and no header means no tags.
+	var overrideTags map[string]string
+	if strings.EqualFold(r.Header.Get("x-amz-tagging-directive"), "REPLACE") {
+		overrideTags = map[string]string{}
+		if tagging := r.Header.Get("x-amz-tagging"); tagging != "" {
+			tags, err := parseTaggingHeader(tagging)
+			if err != nil {
+				h.writeError(w, r, "InvalidTag", err.Error(), http.StatusBadRequest)
+				return
 			}
-		}
-		if len(customMeta) > 0 {
-			overrideMeta.CustomMetadata = customMeta
+			overrideTags = tags
 		}
 	}
 
-	metadata, err := h.storage.CopyObject(srcBucket, srcKey, dstBucket, dstKey, overrideMeta)
+	metadata, err := h.storage.CopyObject(srcBucket, srcKey, dstBucket, dstKey, overrideMeta, overrideTags)
 	if err != nil {
+		if errors.Is(err, ErrInvalidKeyName) {
+			h.writeError(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, ErrObjectImmutable) {
+			h.writeError(w, r, "AccessDenied", err.Error(), http.StatusForbidden)
+			return
+		}
 		h.writeError(w, r, "NoSuchKey", "The specified source key does not exist", http.StatusNotFound)
 		return
 	}
@@ -665,6 +2540,46 @@ func (h *S3Handler) handleCopyObject(w http.ResponseWriter, r *http.Request, dst
 	h.writeXML(w, http.StatusOK, response)
 }
 
+// handleRenameObject implements the non-standard POST /{bucket}/{key}?rename.
+// The destination key, in the same bucket, comes URL-encoded in the
+// x-amz-rename-destination header; x-amz-rename-overwrite: true allows
+// replacing an existing destination.
+func (h *S3Handler) handleRenameObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	dstKey, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("x-amz-rename-destination"), "/"))
+	if err != nil || dstKey == "" {
+		h.writeError(w, r, "InvalidArgument", "Invalid x-amz-rename-destination", http.StatusBadRequest)
+		return
+	}
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	overwrite := strings.EqualFold(r.Header.Get("x-amz-rename-overwrite"), "true")
+
+	metadata, err := h.storage.RenameObject(bucket, key, dstKey, overwrite)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidKeyName):
+			h.writeError(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrObjectExists):
+			h.writeError(w, r, "PreconditionFailed", "The destination key already exists; set x-amz-rename-overwrite: true to replace it", http.StatusPreconditionFailed)
+		case errors.Is(err, ErrObjectImmutable):
+			h.writeError(w, r, "AccessDenied", err.Error(), http.StatusForbidden)
+		case os.IsNotExist(err):
+			h.writeError(w, r, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		default:
+			h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.writeXML(w, http.StatusOK, RenameObjectResult{
+		Key:          dstKey,
+		LastModified: metadata.LastModified.Format(time.RFC3339),
+		ETag:         metadata.ETag,
+	})
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // DeleteObjects (Batch) Handler
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -688,18 +2603,34 @@ func (h *S3Handler) handleDeleteObjects(w http.ResponseWriter, r *http.Request,
 	}
 
 	var deleted []DeletedObject
-	var errors []DeleteError
+	var failed []DeleteError
 
 	for _, obj := range deleteReq.Objects {
-		if err := h.storage.DeleteObject(bucket, obj.Key); err != nil {
-			errors = append(errors, DeleteError{
-				Key:     obj.Key,
-				Code:    "InternalError",
-				Message: err.Error(),
-			})
+		var result *DeleteObjectResult
+		var err error
+		if obj.ETag != "" {
+			result, err = h.storage.DeleteObjectIfMatch(bucket, obj.Key, obj.ETag)
+		} else {
+			result, err = h.storage.DeleteObject(bucket, obj.Key)
+		}
+		if err != nil {
+			entry := DeleteError{Key: obj.Key, Code: "InternalError", Message: err.Error()}
+			switch {
+			case errors.Is(err, ErrPreconditionFailed):
+				entry.Code, entry.Message = "PreconditionFailed", "At least one of the pre-conditions you specified did not hold"
+			case errors.Is(err, ErrObjectImmutable):
+				entry.Code = "AccessDenied"
+			case os.IsNotExist(err):
+				entry.Code, entry.Message = "NoSuchKey", "The specified key does not exist"
+			}
+			failed = append(failed, entry)
 		} else {
 			if !deleteReq.Quiet {
-				deleted = append(deleted, DeletedObject{Key: obj.Key})
+				deleted = append(deleted, DeletedObject{
+					Key:                   obj.Key,
+					DeleteMarker:          result.DeleteMarker,
+					DeleteMarkerVersionID: result.VersionID,
+				})
 			}
 		}
 	}
@@ -707,7 +2638,7 @@ func (h *S3Handler) handleDeleteObjects(w http.ResponseWriter, r *http.Request,
 	response := DeleteResult{
 		Xmlns:   "http://s3.amazonaws.com/doc/2006-03-01/",
 		Deleted: deleted,
-		Errors:  errors,
+		Errors:  failed,
 	}
 
 	h.writeXML(w, http.StatusOK, response)
@@ -730,6 +2661,10 @@ func (h *S3Handler) handleCreateMultipartUpload(w http.ResponseWriter, r *http.R
 
 	uploadID, err := h.storage.CreateMultipartUpload(bucket, key, contentType)
 	if err != nil {
+		if errors.Is(err, ErrInvalidKeyName) {
+			h.writeError(w, r, "InvalidArgument", err.Error(), http.StatusBadRequest)
+			return
+		}
 		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -755,31 +2690,56 @@ func (h *S3Handler) handleUploadPart(w http.ResponseWriter, r *http.Request, buc
 		return
 	}
 
+	if !h.hasSpaceFor(r) {
+		h.writeError(w, r, "InsufficientStorage", "Not enough free space to store the part", http.StatusInsufficientStorage)
+		return
+	}
+
+	if !h.acquirePartSlot(uploadID) {
+		h.writeError(w, r, "SlowDown", "Too many concurrent part uploads for this upload ID", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.releasePartSlot(uploadID)
+
 	// Pass SHA256 expectation to storage layer for verification.
 	var expectedSHA string
 	sha := r.Header.Get("X-Amz-Content-Sha256")
 	if sha != "" && sha != "UNSIGNED-PAYLOAD" && !strings.HasPrefix(sha, "STREAMING-") {
 		expectedSHA = sha
 	}
-
-	// If the client is using AWS chunked transfer encoding, decode the
-	// chunked framing so only raw object bytes reach the storage layer.
-	var body io.Reader = r.Body
-	if isAWSChunked(r) {
-		body = newAWSChunkedReader(r.Body)
+	checksum, err := checksumInput(r)
+	if err != nil {
+		h.writeError(w, r, "InvalidRequest", err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	etag, err := h.storage.UploadPart(bucket, key, uploadID, partNumber, body, expectedSHA)
+	// Small and empty parts are accepted here, whatever Content-Length says:
+	// an upload cannot tell whether a part will be the last one, and a small
+	// final part (or a zero-byte object) is valid. CompleteMultipartUpload
+	// fixes the part list and rejects small non-final parts with
+	// EntityTooSmall.
+	etag, checksumValue, err := h.storage.UploadPartWithChecksum(bucket, key, uploadID, partNumber, uploadBody(r, checksum), expectedSHA, checksum)
 	if err != nil {
+		if errors.Is(err, ErrBadChecksum) {
+			h.writeError(w, r, "BadDigest", fmt.Sprintf("The %s you specified did not match the calculated checksum.", checksum.Algorithm), http.StatusBadRequest)
+			return
+		}
 		if errors.Is(err, ErrBadDigest) {
 			h.writeError(w, r, "BadDigest", "The Content-SHA256 you specified did not match what we received", http.StatusBadRequest)
 			return
 		}
+		if errors.Is(err, ErrRequestTooSlow) {
+			h.writeError(w, r, "RequestTimeout", "Your socket connection to the server was not read from or written to within the timeout period", http.StatusBadRequest)
+			return
+		}
 		h.writeError(w, r, "NoSuchUpload", err.Error(), http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("ETag", etag)
+	if checksumValue != "" {
+		w.Header().Set(checksumHeader(checksum.Algorithm), checksumValue)
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -809,9 +2769,26 @@ func (h *S3Handler) handleCompleteMultipartUpload(w http.ResponseWriter, r *http
 
 	metadata, err := h.storage.CompleteMultipartUpload(bucket, key, uploadID, parts)
 	if err != nil {
+		if errors.Is(err, ErrInvalidPart) {
+			h.writeError(w, r, "InvalidPart", err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, ErrInvalidPartOrder) {
+			h.writeError(w, r, "InvalidPartOrder", err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, ErrEntityTooSmall) {
+			h.writeError(w, r, "EntityTooSmall", err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, ErrObjectImmutable) {
+			h.writeError(w, r, "AccessDenied", err.Error(), http.StatusForbidden)
+			return
+		}
 		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.forgetUpload(uploadID)
 
 	response := CompleteMultipartUploadResultXML{
 		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
@@ -830,14 +2807,178 @@ func (h *S3Handler) handleAbortMultipartUpload(w http.ResponseWriter, r *http.Re
 		h.writeError(w, r, "NoSuchUpload", err.Error(), http.StatusNotFound)
 		return
 	}
+	h.forgetUpload(uploadID)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handleAbortMultipartUploadsForKey implements the non-standard
+// DELETE /{bucket}/{key}?uploads, which aborts every in-progress upload
+// targeting key and lists the aborted upload IDs.
+func (h *S3Handler) handleAbortMultipartUploadsForKey(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	aborted, err := h.storage.AbortMultipartUploadsForKey(bucket, key)
+	for _, uploadID := range aborted {
+		h.forgetUpload(uploadID)
+	}
+	if err != nil {
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeXML(w, http.StatusOK, AbortMultipartUploadsResult{
+		Xmlns:     "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket:    bucket,
+		Key:       key,
+		UploadIds: aborted,
+	})
+}
+
+// handleListParts lists the parts uploaded so far to an in-progress multipart
+// upload, which SDKs query to resume an interrupted upload.
+func (h *S3Handler) handleListParts(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	partNumberMarker := 0
+	if pm := query.Get("part-number-marker"); pm != "" {
+		if parsed, err := strconv.Atoi(pm); err == nil && parsed >= 0 {
+			partNumberMarker = parsed
+		}
+	}
+	maxParts := 1000
+	if mp := query.Get("max-parts"); mp != "" {
+		if parsed, err := strconv.Atoi(mp); err == nil && parsed >= 0 {
+			maxParts = parsed
+		}
+	}
+	if maxParts > 1000 {
+		maxParts = 1000
+	}
+
+	parts, isTruncated, err := h.storage.ListParts(bucket, key, uploadID, partNumberMarker, maxParts)
+	if err != nil {
+		h.writeError(w, r, "NoSuchUpload", "The specified multipart upload does not exist", http.StatusNotFound)
+		return
+	}
+
+	response := ListPartsResult{
+		Xmlns:            "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket:           bucket,
+		Key:              key,
+		UploadId:         uploadID,
+		StorageClass:     "STANDARD",
+		PartNumberMarker: partNumberMarker,
+		MaxParts:         maxParts,
+		IsTruncated:      isTruncated,
+		Parts:            make([]PartXML, len(parts)),
+	}
+	for i, p := range parts {
+		response.Parts[i] = PartXML{
+			PartNumber:   p.PartNumber,
+			LastModified: p.LastModified.Format(time.RFC3339),
+			ETag:         p.ETag,
+			Size:         p.Size,
+		}
+	}
+	if len(parts) > 0 {
+		response.NextPartNumberMarker = parts[len(parts)-1].PartNumber
+	}
+
+	h.writeXML(w, http.StatusOK, response)
+}
+
+// handleListMultipartUploads lists the bucket's in-progress multipart
+// uploads (GET /{bucket}?uploads), so abandoned ones can be found and
+// aborted.
+func (h *S3Handler) handleListMultipartUploads(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.storage.BucketExists(bucket) {
+		h.writeError(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	keyMarker := query.Get("key-marker")
+	uploadIDMarker := query.Get("upload-id-marker")
+	if h.rejectLongListParams(w, r, listParam{"prefix", prefix}, listParam{"key-marker", keyMarker}, listParam{"upload-id-marker", uploadIDMarker}) {
+		return
+	}
+	maxUploads := 1000
+	if mu := query.Get("max-uploads"); mu != "" {
+		if parsed, err := strconv.Atoi(mu); err == nil && parsed >= 0 {
+			maxUploads = parsed
+		}
+	}
+	if maxUploads > 1000 {
+		maxUploads = 1000
+	}
+
+	uploads, isTruncated, err := h.storage.ListMultipartUploads(bucket, prefix, keyMarker, uploadIDMarker, maxUploads)
+	if err != nil {
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := ListMultipartUploadsResult{
+		Xmlns:          "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket:         bucket,
+		KeyMarker:      keyMarker,
+		UploadIdMarker: uploadIDMarker,
+		Prefix:         prefix,
+		MaxUploads:     maxUploads,
+		IsTruncated:    isTruncated,
+		Uploads:        make([]MultipartUploadXML, len(uploads)),
+	}
+	for i, u := range uploads {
+		response.Uploads[i] = MultipartUploadXML{
+			Key:          u.Key,
+			UploadId:     u.UploadID,
+			StorageClass: "STANDARD",
+			Initiated:    u.Initiated.Format(time.RFC3339),
+		}
+	}
+	if isTruncated && len(uploads) > 0 {
+		last := uploads[len(uploads)-1]
+		response.NextKeyMarker = last.Key
+		response.NextUploadIdMarker = last.UploadID
+	}
+
+	h.writeXML(w, http.StatusOK, response)
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Helper Functions
 // ═══════════════════════════════════════════════════════════════════════════════
 
+// hasSpaceFor reports whether the data directory has room for the body r
+// announces. Requests without a declared length, and filesystems whose free
+// space is unknown, are let through; the write then fails if the disk fills.
+func (h *S3Handler) hasSpaceFor(r *http.Request) bool {
+	size := r.ContentLength
+	if isAWSChunked(r) {
+		// Content-Length includes the chunk signatures; the decoded length
+		// is what lands on disk.
+		size = -1
+		if decoded, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err == nil {
+			size = decoded
+		}
+	}
+	if size <= 0 {
+		return true
+	}
+	avail, ok := h.storage.AvailableSpace()
+	return !ok || uint64(size) <= avail
+}
+
 func (h *S3Handler) parsePath(path string) (bucket, key string) {
 	path = strings.TrimPrefix(path, "/")
 
@@ -855,10 +2996,59 @@ func (h *S3Handler) parsePath(path string) (bucket, key string) {
 	return bucket, key
 }
 
+// listObjects fetches the page of a listing that follows startAfter: at most
+// limit entries (all if limit <= 0) in key order. Each common prefix comes
+// back as a placeholder entry whose key is the prefix, which the delimiter
+// grouping in the list handlers folds into a CommonPrefix.
+func (h *S3Handler) listObjects(r *http.Request, bucket, prefix, delimiter, startAfter string, limit int) ([]ObjectInfo, error) {
+	ctx := r.Context()
+	if h.listDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.listDeadline)
+		defer cancel()
+	}
+	return h.storage.ListObjectsPage(ctx, bucket, prefix, delimiter, startAfter, limit)
+}
+
+// listRetryAfter is the Retry-After, in seconds, sent with SlowDown replies
+// to listings that hit the scan limit or the list deadline.
+const listRetryAfter = "5"
+
+// writeListError reports a failed listing. Listings that are too large or
+// too slow get 503 SlowDown, which SDKs retry with backoff, and a hint to
+// narrow the prefix; the walk has already stopped, so the server is not
+// tied up meanwhile.
+func (h *S3Handler) writeListError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrScanLimit):
+		w.Header().Set("Retry-After", listRetryAfter)
+		h.writeError(w, r, "SlowDown", fmt.Sprintf("Listing would scan more than %d objects; narrow it with a prefix or delimiter", MaxScanLimit), http.StatusServiceUnavailable)
+	case errors.Is(err, context.DeadlineExceeded):
+		w.Header().Set("Retry-After", listRetryAfter)
+		h.writeError(w, r, "SlowDown", fmt.Sprintf("Listing did not finish within %s; narrow it with a prefix or delimiter", h.listDeadline), http.StatusServiceUnavailable)
+	default:
+		h.writeError(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func (h *S3Handler) writeError(w http.ResponseWriter, r *http.Request, code, message string, status int) {
 	ctx := context.WithValue(r.Context(), errorContextKey, fmt.Sprintf("%s: %s", code, message))
 	*r = *r.WithContext(ctx)
 
+	if h.jsonErrors && prefersJSON(r.Header.Get("Accept")) {
+		data, _ := json.Marshal(jsonErrorResponse{
+			Code:      code,
+			Message:   message,
+			Resource:  r.URL.Path,
+			RequestID: w.Header().Get("x-amz-request-id"),
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		w.Write(data)
+		return
+	}
+
 	errorResponse := ErrorResponse{
 		Code:    code,
 		Message: message,
@@ -867,13 +3057,80 @@ func (h *S3Handler) writeError(w http.ResponseWriter, r *http.Request, code, mes
 	h.writeXML(w, status, errorResponse)
 }
 
+// prefersJSON reports whether an Accept header ranks application/json above
+// every XML type it accepts, including wildcards. Ties go to XML, so SDKs and
+// browsers sending */* keep getting S3's format.
+func prefersJSON(accept string) bool {
+	jsonQ, xmlQ := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if name, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(name, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "application/xml", "text/xml", "application/*", "text/*", "*/*":
+			xmlQ = max(xmlQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > xmlQ
+}
+
+// xmlBufPool holds buffers for encoding XML responses before sending them.
+// Buffers that grew past maxPooledXMLBuf are dropped instead of pooled.
+var xmlBufPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+const maxPooledXMLBuf = 4 * 1024 * 1024
+
+// writeXML encodes v into a pooled buffer first so the response is sent with
+// a Content-Length instead of chunked encoding, which strict clients and
+// proxies prefer and which lets clients show progress on large listings.
 func (h *S3Handler) writeXML(w http.ResponseWriter, status int, v interface{}) {
+	buf := xmlBufPool.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledXMLBuf {
+			xmlBufPool.Put(buf)
+		}
+	}()
+	buf.Reset()
+
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	if h.prettyXML {
+		enc.Indent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if h.prettyXML {
+		buf.WriteByte('\n')
+	}
+
 	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
 	w.WriteHeader(status)
-	w.Write([]byte(xml.Header))
-	xml.NewEncoder(w).Encode(v)
+	w.Write(buf.Bytes())
 }
 
+// writerOnly and readerOnly hide io.ReaderFrom / io.WriterTo so io.CopyBuffer
+// actually uses the supplied buffer instead of an internal 32KB one (as
+// *os.File.WriteTo and the ResponseWriter's ReadFrom do).
+type writerOnly struct{ io.Writer }
+
+type readerOnly struct{ io.Reader }
+
+// isValidBucketName applies the S3 general purpose bucket naming rules:
+// 3–63 characters of lowercase letters, digits, dots and hyphens, made of
+// non-empty dot-separated labels that each begin and end with a letter or
+// digit, not formatted as an IPv4 address and free of reserved prefixes and
+// suffixes.
 func isValidBucketName(name string) bool {
 	if len(name) < 3 || len(name) > 63 {
 		return false
@@ -883,12 +3140,50 @@ func isValidBucketName(name string) bool {
 			return false
 		}
 	}
-	if name[0] == '-' || name[0] == '.' || name[len(name)-1] == '-' || name[len(name)-1] == '.' {
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+	}
+	if looksLikeIPv4(name) {
 		return false
 	}
-	if strings.Contains(name, "..") {
+	for _, p := range reservedBucketPrefixes {
+		if strings.HasPrefix(name, p) {
+			return false
+		}
+	}
+	for _, suf := range reservedBucketSuffixes {
+		if strings.HasSuffix(name, suf) {
+			return false
+		}
+	}
+	return true
+}
+
+// Bucket name prefixes and suffixes that S3 reserves.
+var (
+	reservedBucketPrefixes = []string{"xn--", "sthree-"}
+	reservedBucketSuffixes = []string{"-s3alias", "--ol-s3"}
+)
+
+// looksLikeIPv4 reports whether name is four dot-separated runs of digits,
+// which S3 rejects as a bucket name.
+func looksLikeIPv4(name string) bool {
+	groups := strings.Split(name, ".")
+	if len(groups) != 4 {
 		return false
 	}
+	for _, g := range groups {
+		if g == "" {
+			return false
+		}
+		for _, c := range g {
+			if c < '0' || c > '9' {
+				return false
+			}
+		}
+	}
 	return true
 }
 
@@ -922,6 +3217,25 @@ type Object struct {
 	ETag         string `xml:"ETag"`
 	Size         int64  `xml:"Size"`
 	StorageClass string `xml:"StorageClass"`
+
+	// UserMetadata is only set by the geckos3 "metadata=true" extension.
+	UserMetadata *ListingMetadata `xml:"UserMetadata,omitempty"`
+}
+
+// ListingMetadata carries an object's stored headers and x-amz-meta-* values
+// in a ListObjectsV2 response. Custom metadata names are listed without the
+// x-amz-meta- prefix, sorted.
+type ListingMetadata struct {
+	ContentType        string         `xml:"ContentType,omitempty"`
+	ContentEncoding    string         `xml:"ContentEncoding,omitempty"`
+	ContentDisposition string         `xml:"ContentDisposition,omitempty"`
+	CacheControl       string         `xml:"CacheControl,omitempty"`
+	Items              []MetadataItem `xml:"Metadata,omitempty"`
+}
+
+type MetadataItem struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
 }
 
 type ErrorResponse struct {
@@ -930,12 +3244,80 @@ type ErrorResponse struct {
 	Message string   `xml:"Message"`
 }
 
+// jsonErrorResponse is the error body sent to clients that prefer JSON.
+type jsonErrorResponse struct {
+	Code      string `json:"Code"`
+	Message   string `json:"Message"`
+	Resource  string `json:"Resource"`
+	RequestID string `json:"RequestId,omitempty"`
+}
+
 type ListAllMyBucketsResult struct {
 	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
 	Xmlns   string     `xml:"xmlns,attr"`
 	Buckets XMLBuckets `xml:"Buckets"`
 }
 
+// xsiNamespace is the XML Schema instance namespace used by Grantee's type
+// attribute.
+const xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+type LocationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+type VersioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+type CORSConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration"`
+	Xmlns   string     `xml:"xmlns,attr,omitempty"`
+	Rules   []CORSRule `xml:"CORSRule"`
+}
+
+type CORSRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedHeaders []string `xml:"AllowedHeader,omitempty"`
+	ExposeHeaders  []string `xml:"ExposeHeader,omitempty"`
+	MaxAgeSeconds  int      `xml:"MaxAgeSeconds,omitempty"`
+
+	// AllowCredentials is a geckos3 extension: matching responses carry
+	// Access-Control-Allow-Credentials: true.
+	AllowCredentials bool `xml:"AllowCredentials,omitempty"`
+}
+
+type AccessControlPolicy struct {
+	XMLName xml.Name `xml:"AccessControlPolicy"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   ACLOwner `xml:"Owner"`
+	Grants  []Grant  `xml:"AccessControlList>Grant"`
+}
+
+type ACLOwner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type Grant struct {
+	Grantee    Grantee `xml:"Grantee"`
+	Permission string  `xml:"Permission"`
+}
+
+type Grantee struct {
+	Xmlns       string `xml:"xmlns:xsi,attr"`
+	Type        string `xml:"xsi:type,attr"`
+	ID          string `xml:"ID,omitempty"`
+	DisplayName string `xml:"DisplayName,omitempty"`
+	URI         string `xml:"URI,omitempty"`
+}
+
 type XMLBuckets struct {
 	Bucket []XMLBucket `xml:"Bucket"`
 }
@@ -943,6 +3325,10 @@ type XMLBuckets struct {
 type XMLBucket struct {
 	Name         string `xml:"Name"`
 	CreationDate string `xml:"CreationDate"`
+
+	// ObjectCount and Size are only set by the usage=true extension.
+	ObjectCount *int64 `xml:"ObjectCount,omitempty"`
+	Size        *int64 `xml:"Size,omitempty"`
 }
 
 type ListBucketResultV1 struct {
@@ -965,6 +3351,25 @@ type CopyObjectResult struct {
 	ETag         string   `xml:"ETag"`
 }
 
+type Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	TagSet  []Tag    `xml:"TagSet>Tag"`
+}
+
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// RenameObjectResult is the body of a successful geckos3 rename.
+type RenameObjectResult struct {
+	XMLName      xml.Name `xml:"RenameObjectResult"`
+	Key          string   `xml:"Key"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
 type DeleteRequest struct {
 	XMLName xml.Name            `xml:"Delete"`
 	Quiet   bool                `xml:"Quiet"`
@@ -973,6 +3378,8 @@ type DeleteRequest struct {
 
 type DeleteObjectEntry struct {
 	Key string `xml:"Key"`
+	// ETag makes the delete conditional (geckos3 extension).
+	ETag string `xml:"ETag,omitempty"`
 }
 
 type DeleteResult struct {
@@ -983,7 +3390,9 @@ type DeleteResult struct {
 }
 
 type DeletedObject struct {
-	Key string `xml:"Key"`
+	Key                   string `xml:"Key"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty"`
 }
 
 type DeleteError struct {
@@ -1020,6 +3429,58 @@ type CompleteMultipartUploadResultXML struct {
 	ETag    string   `xml:"ETag"`
 }
 
+type ListPartsResult struct {
+	XMLName              xml.Name  `xml:"ListPartsResult"`
+	Xmlns                string    `xml:"xmlns,attr"`
+	Bucket               string    `xml:"Bucket"`
+	Key                  string    `xml:"Key"`
+	UploadId             string    `xml:"UploadId"`
+	StorageClass         string    `xml:"StorageClass"`
+	PartNumberMarker     int       `xml:"PartNumberMarker"`
+	NextPartNumberMarker int       `xml:"NextPartNumberMarker"`
+	MaxParts             int       `xml:"MaxParts"`
+	IsTruncated          bool      `xml:"IsTruncated"`
+	Parts                []PartXML `xml:"Part"`
+}
+
+type PartXML struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type ListMultipartUploadsResult struct {
+	XMLName            xml.Name             `xml:"ListMultipartUploadsResult"`
+	Xmlns              string               `xml:"xmlns,attr"`
+	Bucket             string               `xml:"Bucket"`
+	KeyMarker          string               `xml:"KeyMarker"`
+	UploadIdMarker     string               `xml:"UploadIdMarker"`
+	NextKeyMarker      string               `xml:"NextKeyMarker,omitempty"`
+	NextUploadIdMarker string               `xml:"NextUploadIdMarker,omitempty"`
+	Prefix             string               `xml:"Prefix"`
+	MaxUploads         int                  `xml:"MaxUploads"`
+	IsTruncated        bool                 `xml:"IsTruncated"`
+	Uploads            []MultipartUploadXML `xml:"Upload"`
+}
+
+type MultipartUploadXML struct {
+	Key          string `xml:"Key"`
+	UploadId     string `xml:"UploadId"`
+	StorageClass string `xml:"StorageClass"`
+	Initiated    string `xml:"Initiated"`
+}
+
+// AbortMultipartUploadsResult is the body of the geckos3
+// DELETE /{bucket}/{key}?uploads extension.
+type AbortMultipartUploadsResult struct {
+	XMLName   xml.Name `xml:"AbortMultipartUploadsResult"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	Bucket    string   `xml:"Bucket"`
+	Key       string   `xml:"Key"`
+	UploadIds []string `xml:"UploadId"`
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // AWS Chunked Transfer Encoding Decoder
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -1045,12 +3506,81 @@ func isAWSChunked(r *http.Request) bool {
 	return strings.Contains(ce, "aws-chunked")
 }
 
+// checksumInput returns the x-amz-checksum digest a PutObject or UploadPart
+// request asks for, or nil if it asks for none. The expected value comes from
+// an x-amz-checksum-* header, or later from the trailer named by x-amz-trailer
+// (see uploadBody). x-amz-sdk-checksum-algorithm alone only has the digest
+// computed and stored.
+func checksumInput(r *http.Request) (*ChecksumInput, error) {
+	var checksum *ChecksumInput
+	for algorithm := range checksumAlgorithms {
+		if value := r.Header.Get(checksumHeader(algorithm)); value != "" {
+			if checksum != nil {
+				return nil, fmt.Errorf("Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.")
+			}
+			checksum = &ChecksumInput{Algorithm: algorithm, Expected: value}
+		}
+	}
+	if trailer := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Amz-Trailer"))); trailer != "" {
+		algorithm := strings.ToUpper(strings.TrimPrefix(trailer, "x-amz-checksum-"))
+		if _, ok := checksumAlgorithms[algorithm]; !ok || !strings.HasPrefix(trailer, "x-amz-checksum-") {
+			return nil, fmt.Errorf("The value specified in the x-amz-trailer header is not supported")
+		}
+		if checksum != nil {
+			return nil, fmt.Errorf("Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.")
+		}
+		checksum = &ChecksumInput{Algorithm: algorithm}
+	}
+	if sdk := r.Header.Get("X-Amz-Sdk-Checksum-Algorithm"); sdk != "" && checksum == nil {
+		algorithm := strings.ToUpper(sdk)
+		if _, ok := checksumAlgorithms[algorithm]; !ok {
+			return nil, fmt.Errorf("Value for x-amz-sdk-checksum-algorithm header is invalid.")
+		}
+		checksum = &ChecksumInput{Algorithm: algorithm}
+	}
+	return checksum, nil
+}
+
+// setChecksumHeaders echoes the x-amz-checksum digest an object was uploaded
+// with. It covers the whole object, so callers skip it for ranged reads.
+func setChecksumHeaders(header http.Header, metadata *ObjectMetadata) {
+	if metadata.Checksum == "" {
+		return
+	}
+	header.Set(checksumHeader(metadata.ChecksumAlgorithm), metadata.Checksum)
+	header.Set("x-amz-checksum-type", "FULL_OBJECT")
+}
+
+// uploadBody returns the body of a PutObject or UploadPart request with any
+// aws-chunked framing removed. If the checksum arrives as a trailer, it is
+// copied into checksum.Expected as the end of the body is read.
+func uploadBody(r *http.Request, checksum *ChecksumInput) io.Reader {
+	if !isAWSChunked(r) {
+		return r.Body
+	}
+	chunked := newAWSChunkedReader(r.Body)
+	if checksum != nil {
+		header := checksumHeader(checksum.Algorithm)
+		chunked.onTrailer = func(name, value string) {
+			if name == header {
+				checksum.Expected = value
+			}
+		}
+	}
+	return chunked
+}
+
 // awsChunkedReader strips AWS chunked framing from an io.Reader, yielding
 // only the raw object data.
 type awsChunkedReader struct {
 	scanner *bufio.Reader
 	chunk   io.Reader // current chunk data (limited reader)
 	done    bool
+
+	// onTrailer, if set, is called with the lower-cased name and the value
+	// of each trailing header after the final chunk, before Read returns
+	// io.EOF.
+	onTrailer func(name, value string)
 }
 
 func newAWSChunkedReader(r io.Reader) *awsChunkedReader {
@@ -1117,11 +3647,25 @@ func (a *awsChunkedReader) Read(p []byte) (int, error) {
 
 		if size == 0 {
 			a.done = true
-			// Drain any trailing headers/CRLF (best effort).
-			io.Copy(io.Discard, a.scanner)
+			a.readTrailers()
 			return 0, io.EOF
 		}
 
 		a.chunk = io.LimitReader(a.scanner, size)
 	}
 }
+
+// readTrailers reads what follows the final chunk, passing each trailing
+// header to onTrailer, and discards the rest.
+func (a *awsChunkedReader) readTrailers() {
+	for {
+		line, err := a.scanner.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if name, value, ok := strings.Cut(line, ":"); ok && a.onTrailer != nil {
+			a.onTrailer(strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -2,17 +2,31 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -73,6 +87,247 @@ func TestHealthEndpoint(t *testing.T) {
 	}
 }
 
+func TestAdminMetrics(t *testing.T) {
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	admin := httptest.NewServer(handler.AdminHandler())
+	defer admin.Close()
+
+	resp := mustDo(t, "GET", admin.URL+"/metrics", nil, nil)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := readBody(t, resp)
+	for _, want := range []string{
+		"# TYPE geckos3_gc_uploads_reclaimed_total counter\ngeckos3_gc_uploads_reclaimed_total ",
+		"# TYPE geckos3_gc_bytes_reclaimed_total counter\n",
+		"# TYPE geckos3_gc_last_run_timestamp gauge\n",
+		"# TYPE geckos3_stripe_lock_wait_seconds summary\ngeckos3_stripe_lock_wait_seconds_sum ",
+		"\ngeckos3_stripe_lock_wait_seconds_count ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestAdminMetricsPerBucket(t *testing.T) {
+	reset := func(limit int, allow []string) {
+		bucketRequests.configure(limit, allow)
+		bucketRequests.mu.Lock()
+		bucketRequests.series = make(map[string]*durationSummary)
+		bucketRequests.other.nanos.Store(0)
+		bucketRequests.other.count.Store(0)
+		bucketRequests.mu.Unlock()
+	}
+	t.Cleanup(func() { reset(defaultMetricsMaxBuckets, nil) })
+	reset(1, nil)
+
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	admin := httptest.NewServer(handler.AdminHandler())
+	defer admin.Close()
+	data := httptest.NewServer(handler)
+	defer data.Close()
+
+	metrics := func() string {
+		return readBody(t, mustDo(t, "GET", admin.URL+"/metrics", nil, nil))
+	}
+
+	mustDo(t, "GET", data.URL+"/nosuchbucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", data.URL+"/first", nil, nil).Body.Close()
+	mustDo(t, "PUT", data.URL+"/second", nil, nil).Body.Close()
+	mustDo(t, "GET", data.URL+"/second", nil, nil).Body.Close()
+	mustDo(t, "GET", data.URL+"/first", nil, nil).Body.Close()
+
+	body := metrics()
+	for _, want := range []string{
+		"# TYPE geckos3_bucket_request_duration_seconds summary\n",
+		// first exists once its PUT completes, so the PUT already counts.
+		`geckos3_bucket_request_duration_seconds_count{bucket="first"} 2` + "\n",
+		`geckos3_bucket_request_duration_seconds_count{bucket="_other"} 3` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	for _, unwanted := range []string{`bucket="second"`, `bucket="nosuchbucket"`} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("metrics contain %s beyond the limit:\n%s", unwanted, body)
+		}
+	}
+
+	reset(10, []string{"second"})
+	mustDo(t, "GET", data.URL+"/first", nil, nil).Body.Close()
+	mustDo(t, "GET", data.URL+"/second", nil, nil).Body.Close()
+	body = metrics()
+	if !strings.Contains(body, `geckos3_bucket_request_duration_seconds_count{bucket="second"} 1`) ||
+		strings.Contains(body, `bucket="first"`) {
+		t.Errorf("allowlist not applied:\n%s", body)
+	}
+
+	reset(0, nil)
+	mustDo(t, "GET", data.URL+"/first", nil, nil).Body.Close()
+	if body := metrics(); strings.Contains(body, "geckos3_bucket_request_duration_seconds") {
+		t.Errorf("per-bucket metrics not disabled:\n%s", body)
+	}
+}
+
+func TestAdminSync(t *testing.T) {
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	admin := httptest.NewServer(handler.AdminHandler())
+	defer admin.Close()
+	data := httptest.NewServer(handler)
+	defer data.Close()
+
+	mustDo(t, "PUT", data.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", data.URL+"/mybucket/k", strings.NewReader("v"), nil).Body.Close()
+
+	resp := mustDo(t, "POST", admin.URL+"/admin/sync?pause=forever", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("bad pause: %d", resp.StatusCode)
+	}
+
+	const pause = 200 * time.Millisecond
+	resp = mustDo(t, "POST", admin.URL+"/admin/sync?pause="+pause.String(), nil, nil)
+	var result SyncResult
+	if err := json.Unmarshal([]byte(readBody(t, resp)), &result); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("sync: %d %v", resp.StatusCode, err)
+	}
+	if result.PausedUntil == "" {
+		t.Error("paused_until not reported")
+	}
+
+	// Reads go through during the pause; writes wait for it to end.
+	start := time.Now()
+	resp = mustDo(t, "GET", data.URL+"/mybucket/k", nil, nil)
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed > pause/2 {
+		t.Errorf("GET blocked for %v during pause", elapsed)
+	}
+	mustDo(t, "PUT", data.URL+"/mybucket/k2", strings.NewReader("v"), nil).Body.Close()
+	if elapsed := time.Since(start); elapsed < pause/2 {
+		t.Errorf("PUT finished after %v, before the pause ended", elapsed)
+	}
+
+	resp = mustDo(t, "GET", admin.URL+"/admin/sync", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /admin/sync: %d", resp.StatusCode)
+	}
+
+	// A stalled upload makes the sync give up instead of blocking writes
+	// behind it indefinitely.
+	pr, pw := io.Pipe()
+	stalled := make(chan struct{})
+	go func() {
+		defer close(stalled)
+		req, _ := http.NewRequest("PUT", data.URL+"/mybucket/slow", pr)
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	pw.Write([]byte("partial"))
+	for inside := 0; inside == 0; time.Sleep(time.Millisecond) {
+		handler.writeBarrier.mu.Lock()
+		inside = handler.writeBarrier.active
+		handler.writeBarrier.mu.Unlock()
+	}
+	resp = mustDo(t, "POST", admin.URL+"/admin/sync?timeout=100ms", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("sync behind a stalled upload: %d, want 503", resp.StatusCode)
+	}
+	resp = mustDo(t, "PUT", data.URL+"/mybucket/k3", strings.NewReader("v"), nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("PUT after a timed-out sync: %d", resp.StatusCode)
+	}
+	pw.Close()
+	<-stalled
+
+	resp = mustDo(t, "POST", admin.URL+"/admin/sync?timeout=0s", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("bad timeout: %d", resp.StatusCode)
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	admin := httptest.NewServer(handler.AdminHandler())
+	defer admin.Close()
+	data := httptest.NewServer(handler)
+	defer data.Close()
+
+	mustDo(t, "PUT", data.URL+"/mybucket", nil, nil).Body.Close()
+
+	resp := mustDo(t, "POST", admin.URL+"/admin/maintenance?enabled=true", nil, nil)
+	if body := readBody(t, resp); body != `{"maintenance":true}` {
+		t.Fatalf("enable: %d %s", resp.StatusCode, body)
+	}
+
+	for _, method := range []string{"GET", "PUT", "HEAD"} {
+		resp := mustDo(t, method, data.URL+"/mybucket/k", nil, nil)
+		resp.Body.Close()
+		if resp.StatusCode != 503 || resp.Header.Get("Retry-After") == "" {
+			t.Errorf("%s in maintenance: %d, Retry-After %q", method, resp.StatusCode, resp.Header.Get("Retry-After"))
+		}
+	}
+
+	resp = mustDo(t, "GET", data.URL+"/health", nil, map[string]string{"Accept": "application/json"})
+	var health HealthStatus
+	json.Unmarshal([]byte(readBody(t, resp)), &health)
+	if resp.StatusCode != 200 || !health.Maintenance || health.Status != "maintenance" {
+		t.Errorf("health in maintenance: %d %+v", resp.StatusCode, health)
+	}
+
+	if handler.ToggleMaintenance() {
+		t.Fatal("toggle from on should turn maintenance off")
+	}
+	resp = mustDo(t, "GET", admin.URL+"/admin/maintenance", nil, nil)
+	if body := readBody(t, resp); body != `{"maintenance":false}` {
+		t.Errorf("status after toggle: %s", body)
+	}
+	resp = mustDo(t, "HEAD", data.URL+"/mybucket", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("after maintenance: %d", resp.StatusCode)
+	}
+
+	resp = mustDo(t, "POST", admin.URL+"/admin/maintenance?enabled=maybe", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("bad enabled value: %d", resp.StatusCode)
+	}
+}
+
+func TestHealthEndpointJSON(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	resp := mustDo(t, "GET", srv.URL+"/health", nil, map[string]string{"Accept": "application/json"})
+	body := readBody(t, resp)
+	if resp.StatusCode != 200 {
+		t.Fatalf("health status: %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type: %q", ct)
+	}
+
+	var status HealthStatus
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatalf("unmarshal %q: %v", body, err)
+	}
+	if status.Status != "ok" || status.Version != version || status.Commit != commit || status.Date != date {
+		t.Errorf("build info: %+v", status)
+	}
+	if status.Backend != "filesystem" || status.Auth != "none" {
+		t.Errorf("backend/auth: %+v", status)
+	}
+	if status.UptimeSeconds < 0 {
+		t.Errorf("uptime: %d", status.UptimeSeconds)
+	}
+}
+
 func TestHealthEndpointPostNotAllowed(t *testing.T) {
 	srv, _ := setupTestServer(t)
 
@@ -126,6 +381,8 @@ func TestHTTPCreateBucketInvalidName(t *testing.T) {
 		"buck..et",              // double dot
 		"buck et",               // space
 		strings.Repeat("a", 64), // too long
+		"1.2.3.4",               // IPv4 address
+		"192.168.1.1",           // IPv4 address
 	}
 	for _, name := range cases {
 		resp := mustDo(t, "PUT", srv.URL+"/"+name, nil, nil)
@@ -144,6 +401,8 @@ func TestHTTPCreateBucketValidNames(t *testing.T) {
 		"my-bucket",
 		"bucket.name",
 		"a123",
+		"1.2.3.4.5",
+		"v1.2.3",
 		strings.Repeat("a", 63),
 	}
 	for _, name := range cases {
@@ -155,6 +414,89 @@ func TestHTTPCreateBucketValidNames(t *testing.T) {
 	}
 }
 
+func TestHTTPRenameBucket(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	handler := NewS3Handler(storage, &NoOpAuthenticator{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	mustDo(t, "PUT", srv.URL+"/old-bucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/old-bucket/k", strings.NewReader("v"), nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/taken", nil, nil).Body.Close()
+
+	rename := func(src, dst string) int {
+		resp := mustDo(t, "POST", srv.URL+"/"+src+"?rename", nil,
+			map[string]string{"x-amz-rename-destination": dst})
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := rename("old-bucket", "new-bucket"); code != 403 {
+		t.Fatalf("rename without -allow-bucket-rename: %d", code)
+	}
+	handler.SetAllowBucketRename(true)
+
+	for _, tc := range []struct {
+		src, dst string
+		status   int
+	}{
+		{"old-bucket", "Bad_Name", 400},
+		{"old-bucket", "taken", 409},
+		{"missing", "fresh", 404},
+		{"old-bucket", "new-bucket", 200},
+	} {
+		if code := rename(tc.src, tc.dst); code != tc.status {
+			t.Errorf("rename %s -> %s: %d, want %d", tc.src, tc.dst, code, tc.status)
+		}
+	}
+
+	resp := mustDo(t, "GET", srv.URL+"/new-bucket/k", nil, nil)
+	if body := readBody(t, resp); body != "v" {
+		t.Errorf("object after rename: %d %q", resp.StatusCode, body)
+	}
+	resp = mustDo(t, "HEAD", srv.URL+"/old-bucket", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("old bucket still present: %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPCreateBucketMaxBuckets(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	storage.CreateBucket("existing")
+	handler := NewS3Handler(storage, &NoOpAuthenticator{})
+	handler.SetMaxBuckets(2)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp := mustDo(t, "PUT", srv.URL+"/second", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("create under limit: %d", resp.StatusCode)
+	}
+
+	resp = mustDo(t, "PUT", srv.URL+"/third", nil, nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "TooManyBuckets") {
+		t.Errorf("create over limit: %d %s", resp.StatusCode, body)
+	}
+
+	// Re-creating an existing bucket is still allowed at the limit.
+	resp = mustDo(t, "PUT", srv.URL+"/existing", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("idempotent create at limit: %d", resp.StatusCode)
+	}
+
+	// Deleting a bucket frees a slot.
+	mustDo(t, "DELETE", srv.URL+"/second", nil, nil).Body.Close()
+	resp = mustDo(t, "PUT", srv.URL+"/third", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("create after delete: %d", resp.StatusCode)
+	}
+}
+
 func TestHTTPHeadBucket(t *testing.T) {
 	srv, _ := setupTestServer(t)
 
@@ -341,6 +683,7 @@ func TestHTTPDeleteObject(t *testing.T) {
 	if resp.StatusCode != 204 {
 		t.Errorf("delete object: %d", resp.StatusCode)
 	}
+	assertNoVersionHeaders(t, resp)
 
 	// Verify gone
 	resp = mustDo(t, "GET", srv.URL+"/mybucket/del.txt", nil, nil)
@@ -361,6 +704,18 @@ func TestHTTPDeleteObjectIdempotent(t *testing.T) {
 	if resp.StatusCode != 204 {
 		t.Errorf("delete non-existent: %d", resp.StatusCode)
 	}
+	assertNoVersionHeaders(t, resp)
+}
+
+// assertNoVersionHeaders checks a delete response carries neither versioning
+// header, as S3 does for buckets without versioning.
+func assertNoVersionHeaders(t *testing.T, resp *http.Response) {
+	t.Helper()
+	for _, h := range []string{"x-amz-version-id", "x-amz-delete-marker"} {
+		if _, ok := resp.Header[http.CanonicalHeaderKey(h)]; ok {
+			t.Errorf("unversioned delete set %s: %q", h, resp.Header.Get(h))
+		}
+	}
 }
 
 func TestHTTPPutObjectNestedKey(t *testing.T) {
@@ -516,6 +871,81 @@ func TestHTTPListObjectsV2Delimiter(t *testing.T) {
 	}
 }
 
+func TestHTTPListObjectsV2DelimiterWithPrefix(t *testing.T) {
+	srv, storage := setupTestServer(t)
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/logs/app.log", strings.NewReader("a"), nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/logs/2024/01/x.log", strings.NewReader("b"), nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/logs/2025/y.log", strings.NewReader("c"), nil).Body.Close()
+	os.MkdirAll(filepath.Join(storage.dataDir, "mybucket", "logs", "stale"), 0755)
+
+	resp := mustDo(t, "GET", srv.URL+"/mybucket?list-type=2&delimiter=/&prefix=logs/", nil, nil)
+	body := readBody(t, resp)
+
+	var result ListBucketResult
+	xml.Unmarshal([]byte(body), &result)
+
+	if len(result.Contents) != 1 || result.Contents[0].Key != "logs/app.log" {
+		t.Errorf("Contents: %+v", result.Contents)
+	}
+	if len(result.CommonPrefixes) != 2 ||
+		result.CommonPrefixes[0].Prefix != "logs/2024/" ||
+		result.CommonPrefixes[1].Prefix != "logs/2025/" {
+		t.Errorf("CommonPrefixes: %+v", result.CommonPrefixes)
+	}
+	if result.KeyCount != 3 {
+		t.Errorf("KeyCount: %d", result.KeyCount)
+	}
+}
+
+func TestHTTPListObjectsV2DelimiterPagination(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("indexed=%v", indexed), func(t *testing.T) {
+			srv, storage := setupTestServer(t)
+			if indexed {
+				storage.SetIndexEnabled(true)
+				t.Cleanup(func() { storage.CloseIndex() })
+			}
+
+			mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+			mustDo(t, "PUT", srv.URL+"/mybucket/a/1.txt", strings.NewReader("x"), nil).Body.Close()
+			mustDo(t, "PUT", srv.URL+"/mybucket/a/2.txt", strings.NewReader("x"), nil).Body.Close()
+			mustDo(t, "PUT", srv.URL+"/mybucket/b.txt", strings.NewReader("x"), nil).Body.Close()
+			mustDo(t, "PUT", srv.URL+"/mybucket/c/3.txt", strings.NewReader("x"), nil).Body.Close()
+
+			// "." is not the directory separator, so it takes the full
+			// listing path rather than a directory read.
+			for delimiter, want := range map[string]string{"/": "a/,b.txt,c/", ".": "a/1.,a/2.,b.,c/3."} {
+				seen := []string{}
+				token := ""
+				for page := 0; page < 5; page++ {
+					url := srv.URL + "/mybucket?list-type=2&max-keys=1&delimiter=" + delimiter
+					if token != "" {
+						url += "&continuation-token=" + token
+					}
+					var result ListBucketResult
+					xml.Unmarshal([]byte(readBody(t, mustDo(t, "GET", url, nil, nil))), &result)
+					for _, cp := range result.CommonPrefixes {
+						seen = append(seen, cp.Prefix)
+					}
+					for _, obj := range result.Contents {
+						seen = append(seen, obj.Key)
+					}
+					if !result.IsTruncated {
+						break
+					}
+					token = result.NextContinuationToken
+				}
+
+				if strings.Join(seen, ",") != want {
+					t.Errorf("delimiter %q: paged listing: %v", delimiter, seen)
+				}
+			}
+		})
+	}
+}
+
 func TestHTTPListObjectsV2MaxKeys(t *testing.T) {
 	srv, _ := setupTestServer(t)
 
@@ -829,6 +1259,48 @@ func TestHTTPCopyObjectMissingSource(t *testing.T) {
 	}
 }
 
+func TestHTTPRenameObject(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/a.txt", strings.NewReader("hello"), nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/taken.txt", strings.NewReader("x"), nil).Body.Close()
+
+	rename := func(src, dst string, overwrite bool) *http.Response {
+		headers := map[string]string{"x-amz-rename-destination": dst}
+		if overwrite {
+			headers["x-amz-rename-overwrite"] = "true"
+		}
+		return mustDo(t, "POST", srv.URL+"/mybucket/"+src+"?rename", nil, headers)
+	}
+
+	resp := rename("a.txt", "new%20name.txt", false)
+	body := readBody(t, resp)
+	if resp.StatusCode != 200 || !strings.Contains(body, "<Key>new name.txt</Key>") {
+		t.Fatalf("rename: %d %s", resp.StatusCode, body)
+	}
+	resp = mustDo(t, "GET", srv.URL+"/mybucket/new%20name.txt", nil, nil)
+	if body := readBody(t, resp); body != "hello" {
+		t.Errorf("renamed object: %d %q", resp.StatusCode, body)
+	}
+
+	for _, tc := range []struct {
+		src, dst  string
+		overwrite bool
+		status    int
+	}{
+		{"new%20name.txt", "taken.txt", false, 412},
+		{"new%20name.txt", "taken.txt", true, 200},
+		{"missing.txt", "b.txt", false, 404},
+		{"taken.txt", "", false, 400},
+	} {
+		resp := rename(tc.src, tc.dst, tc.overwrite)
+		body := readBody(t, resp)
+		if resp.StatusCode != tc.status {
+			t.Errorf("rename %s -> %q (overwrite=%v): %d %s", tc.src, tc.dst, tc.overwrite, resp.StatusCode, body)
+		}
+	}
+}
+
 func TestHTTPCopyObjectInvalidSource(t *testing.T) {
 	srv, _ := setupTestServer(t)
 
@@ -900,6 +1372,90 @@ func TestHTTPDeleteObjects(t *testing.T) {
 	}
 }
 
+func TestHTTPImmutableUntil(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/bad.log", strings.NewReader("x"), map[string]string{"x-amz-meta-immutable-until": "in 30 days"})
+	if body := readBody(t, resp); resp.StatusCode != 400 || !strings.Contains(body, "<Code>InvalidArgument</Code>") {
+		t.Errorf("unparsable immutable-until: %d %s", resp.StatusCode, body)
+	}
+
+	until := time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket/audit.log", strings.NewReader("x"), map[string]string{"X-Amz-Meta-Immutable-Until": until})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("PUT: status %d", resp.StatusCode)
+	}
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		resp := mustDo(t, method, srv.URL+"/mybucket/audit.log", strings.NewReader("y"), nil)
+		if body := readBody(t, resp); resp.StatusCode != 403 || !strings.Contains(body, "<Code>AccessDenied</Code>") {
+			t.Errorf("%s of an immutable object: %d %s", method, resp.StatusCode, body)
+		}
+	}
+
+	resp = mustDo(t, "POST", srv.URL+"/mybucket?delete", strings.NewReader(`<Delete><Object><Key>audit.log</Key></Object></Delete>`), nil)
+	if body := readBody(t, resp); !strings.Contains(body, "<Code>AccessDenied</Code>") {
+		t.Errorf("DeleteObjects of an immutable object: %s", body)
+	}
+
+	resp = mustDo(t, "HEAD", srv.URL+"/mybucket/audit.log", nil, nil)
+	resp.Body.Close()
+	if got := resp.Header.Get("x-amz-meta-immutable-until"); got != until {
+		t.Errorf("x-amz-meta-immutable-until %q, want %q", got, until)
+	}
+}
+
+func TestHTTPDeleteObjectsConditional(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	etags := map[string]string{}
+	for _, k := range []string{"match.txt", "stale.txt", "plain.txt"} {
+		resp := mustDo(t, "PUT", srv.URL+"/mybucket/"+k, strings.NewReader("content of "+k), nil)
+		resp.Body.Close()
+		etags[k] = resp.Header.Get("ETag")
+	}
+
+	deleteXML := `<Delete>` +
+		`<Object><Key>match.txt</Key><ETag>` + etags["match.txt"] + `</ETag></Object>` +
+		`<Object><Key>stale.txt</Key><ETag>` + etags["match.txt"] + `</ETag></Object>` +
+		`<Object><Key>gone.txt</Key><ETag>` + etags["match.txt"] + `</ETag></Object>` +
+		`<Object><Key>plain.txt</Key></Object>` +
+		`</Delete>`
+	resp := mustDo(t, "POST", srv.URL+"/mybucket?delete", strings.NewReader(deleteXML), nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != 200 {
+		t.Fatalf("delete objects: %d, body: %s", resp.StatusCode, body)
+	}
+
+	var result DeleteResult
+	if err := xml.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatal(err)
+	}
+	var deleted []string
+	for _, d := range result.Deleted {
+		deleted = append(deleted, d.Key)
+	}
+	if strings.Join(deleted, ",") != "match.txt,plain.txt" {
+		t.Errorf("deleted = %v", deleted)
+	}
+	codes := map[string]string{}
+	for _, e := range result.Errors {
+		codes[e.Key] = e.Code
+	}
+	if codes["stale.txt"] != "PreconditionFailed" || codes["gone.txt"] != "NoSuchKey" || len(codes) != 2 {
+		t.Errorf("errors = %+v", result.Errors)
+	}
+
+	resp = mustDo(t, "GET", srv.URL+"/mybucket/stale.txt", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Error("stale.txt should survive its failed precondition")
+	}
+}
+
 func TestHTTPDeleteObjectsQuietMode(t *testing.T) {
 	srv, _ := setupTestServer(t)
 
@@ -982,6 +1538,56 @@ func TestHTTPMethodNotAllowedBucket(t *testing.T) {
 	if resp.StatusCode != 405 {
 		t.Errorf("PATCH on bucket: %d", resp.StatusCode)
 	}
+	if allow := resp.Header.Get("Allow"); allow != "GET, PUT, POST, DELETE, HEAD" {
+		t.Errorf("PATCH on bucket: Allow %q", allow)
+	}
+
+	for path, want := range map[string]string{"/mybucket?acl": "GET, PUT", "/mybucket?tagging": "GET, PUT, DELETE", "/mybucket?cors": "GET, PUT, DELETE"} {
+		resp := mustDo(t, "POST", srv.URL+path, nil, nil)
+		resp.Body.Close()
+		if resp.StatusCode != 405 || resp.Header.Get("Allow") != want {
+			t.Errorf("POST %s: %d, Allow %q, want %q", path, resp.StatusCode, resp.Header.Get("Allow"), want)
+		}
+	}
+}
+
+func TestHTTPBucketSubresourceHead(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	// None of these may be answered as a plain HeadBucket.
+	for query, want := range map[string]string{
+		"acl":        "GET, PUT",
+		"tagging":    "GET, PUT, DELETE",
+		"cors":       "GET, PUT, DELETE",
+		"uploads":    "GET",
+		"location":   "GET",
+		"versioning": "GET",
+		"policy":     "GET",
+		"lifecycle":  "GET",
+		"delete":     "POST",
+		"rename":     "POST",
+	} {
+		resp := mustDo(t, "HEAD", srv.URL+"/mybucket?"+query, nil, nil)
+		resp.Body.Close()
+		if resp.StatusCode != 405 || resp.Header.Get("Allow") != want {
+			t.Errorf("HEAD ?%s: %d, Allow %q, want 405 %q", query, resp.StatusCode, resp.Header.Get("Allow"), want)
+		}
+	}
+
+	// The POST actions must not reach listing, creation or deletion either.
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		resp := mustDo(t, method, srv.URL+"/mybucket?delete", nil, nil)
+		resp.Body.Close()
+		if resp.StatusCode != 405 {
+			t.Errorf("%s ?delete: %d, want 405", method, resp.StatusCode)
+		}
+	}
+	resp := mustDo(t, "HEAD", srv.URL+"/mybucket", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("HEAD bucket after DELETE ?delete: %d", resp.StatusCode)
+	}
 }
 
 func TestHTTPMethodNotAllowedObject(t *testing.T) {
@@ -994,6 +1600,9 @@ func TestHTTPMethodNotAllowedObject(t *testing.T) {
 	if resp.StatusCode != 405 {
 		t.Errorf("PATCH on object: %d", resp.StatusCode)
 	}
+	if allow := resp.Header.Get("Allow"); allow != "GET, PUT, POST, DELETE, HEAD" {
+		t.Errorf("PATCH on object: Allow %q", allow)
+	}
 }
 
 func TestHTTPServiceLevelUnsupported(t *testing.T) {
@@ -1023,37 +1632,641 @@ func TestXMLDeclarationPresent(t *testing.T) {
 	}
 }
 
-func TestErrorResponseXMLFormat(t *testing.T) {
-	srv, _ := setupTestServer(t)
+func TestHTTPListObjectsV2MetadataExtension(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	handler := NewS3Handler(storage, &NoOpAuthenticator{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
 
-	resp := mustDo(t, "GET", srv.URL+"/nonexistent?list-type=2", nil, nil)
-	body := readBody(t, resp)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/cat.jpg", strings.NewReader("x"), map[string]string{
+		"Content-Type":      "image/jpeg",
+		"Cache-Control":     "max-age=60",
+		"X-Amz-Meta-Owner":  "alice",
+		"X-Amz-Meta-Camera": "x100",
+	}).Body.Close()
+
+	list := func() ListBucketResult {
+		t.Helper()
+		resp := mustDo(t, "GET", srv.URL+"/mybucket?list-type=2&metadata=true", nil, nil)
+		var result ListBucketResult
+		if err := xml.Unmarshal([]byte(readBody(t, resp)), &result); err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Contents) != 1 {
+			t.Fatalf("got %d objects", len(result.Contents))
+		}
+		return result
+	}
 
-	// Should have XML declaration
-	if !strings.HasPrefix(body, "<?xml") {
-		t.Error("error response should have XML declaration")
+	// Ignored unless the server allows it.
+	if md := list().Contents[0].UserMetadata; md != nil {
+		t.Fatalf("metadata returned without -allow-metadata-listing: %+v", md)
 	}
 
-	var errResp ErrorResponse
-	// Strip the XML declaration for unmarshal
-	xmlContent := body[strings.Index(body, "<Error"):]
-	if err := xml.Unmarshal([]byte(xmlContent), &errResp); err != nil {
-		t.Fatalf("xml parse error response: %v\nbody: %s", err, body)
+	handler.SetAllowMetadataListing(true)
+	md := list().Contents[0].UserMetadata
+	if md == nil {
+		t.Fatal("no UserMetadata in listing")
 	}
-	if errResp.Code != "NoSuchBucket" {
-		t.Errorf("error code: %q", errResp.Code)
+	if md.ContentType != "image/jpeg" || md.CacheControl != "max-age=60" {
+		t.Errorf("headers: %+v", md)
+	}
+	want := []MetadataItem{{"camera", "x100"}, {"owner", "alice"}}
+	if !reflect.DeepEqual(md.Items, want) {
+		t.Errorf("custom metadata %v, want %v", md.Items, want)
 	}
 }
 
-func TestContentTypeIsXML(t *testing.T) {
-	srv, _ := setupTestServer(t)
-
+func TestHTTPListRejectsLongParams(t *testing.T) {
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
 	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
 
-	resp := mustDo(t, "GET", srv.URL+"/mybucket?list-type=2", nil, nil)
-	resp.Body.Close()
-
-	ct := resp.Header.Get("Content-Type")
+	long := strings.Repeat("a", defaultMaxListParamLength+1)
+	longToken := base64.StdEncoding.EncodeToString([]byte(long))
+	queries := []string{
+		"prefix=" + long,
+		"delimiter=" + long,
+		"marker=" + long,
+		"list-type=2&prefix=" + long,
+		"list-type=2&start-after=" + long,
+		"list-type=2&continuation-token=" + url.QueryEscape(longToken),
+		"uploads&prefix=" + long,
+		"uploads&key-marker=" + long,
+	}
+	for _, query := range queries {
+		resp := mustDo(t, "GET", srv.URL+"/mybucket?"+query, nil, nil)
+		body := readBody(t, resp)
+		if resp.StatusCode != 400 || !strings.Contains(body, "InvalidArgument") {
+			t.Errorf("%.40s...: %d %s", query, resp.StatusCode, body)
+		}
+	}
+
+	// Up to the limit is fine.
+	ok := strings.Repeat("a", defaultMaxListParamLength)
+	resp := mustDo(t, "GET", srv.URL+"/mybucket?list-type=2&prefix="+ok, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("prefix at the limit: %d", resp.StatusCode)
+	}
+
+	handler.SetMaxListParamLength(0)
+	for _, query := range queries {
+		resp := mustDo(t, "GET", srv.URL+"/mybucket?"+query, nil, nil)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("%.40s... without a limit: %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestHTTPListObjectsV2SortExtension(t *testing.T) {
+	dataDir := t.TempDir()
+	handler := NewS3Handler(NewFilesystemStorage(dataDir), &NoOpAuthenticator{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	base := time.Now().Add(-time.Hour)
+	for key, offset := range map[string]time.Duration{"old": 0, "newest": 2 * time.Minute, "middle": time.Minute} {
+		mustDo(t, "PUT", srv.URL+"/mybucket/"+key, strings.NewReader("x"), nil).Body.Close()
+		mtime := base.Add(offset)
+		if err := os.Chtimes(filepath.Join(dataDir, "mybucket", key), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := func(query string) (ListBucketResult, int) {
+		t.Helper()
+		resp := mustDo(t, "GET", srv.URL+"/mybucket?list-type=2&"+query, nil, nil)
+		body := readBody(t, resp)
+		var result ListBucketResult
+		if resp.StatusCode == 200 {
+			if err := xml.Unmarshal([]byte(body), &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return result, resp.StatusCode
+	}
+	keys := func(result ListBucketResult) []string {
+		var keys []string
+		for _, obj := range result.Contents {
+			keys = append(keys, obj.Key)
+		}
+		return keys
+	}
+
+	// Ignored unless the server allows it.
+	if result, _ := list("sort=lastmodified-desc"); !reflect.DeepEqual(keys(result), []string{"middle", "newest", "old"}) {
+		t.Fatalf("sort honoured without -allow-custom-sort: %v", keys(result))
+	}
+
+	handler.SetAllowCustomSort(true)
+	result, status := list("sort=lastmodified-desc")
+	if status != 200 || !reflect.DeepEqual(keys(result), []string{"newest", "middle", "old"}) || result.IsTruncated {
+		t.Errorf("sorted listing: %d %v truncated=%v", status, keys(result), result.IsTruncated)
+	}
+	result, _ = list("sort=lastmodified-desc&max-keys=2")
+	if !reflect.DeepEqual(keys(result), []string{"newest", "middle"}) || !result.IsTruncated || result.NextContinuationToken != "" {
+		t.Errorf("truncated sorted listing: %v truncated=%v token=%q", keys(result), result.IsTruncated, result.NextContinuationToken)
+	}
+
+	for _, query := range []string{
+		"sort=size",
+		"sort=lastmodified-desc&start-after=a",
+		"sort=lastmodified-desc&continuation-token=YQ==",
+		"sort=lastmodified-desc&delimiter=/",
+	} {
+		if _, status := list(query); status != 400 {
+			t.Errorf("%s: status %d, want 400", query, status)
+		}
+	}
+}
+
+func TestHTTPListBucketsUsageExtension(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	handler := NewS3Handler(storage, &NoOpAuthenticator{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	mustDo(t, "PUT", srv.URL+"/full", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/empty", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/full/a.txt", strings.NewReader("hello"), nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/full/dir/b.txt", strings.NewReader("abc"), nil).Body.Close()
+
+	list := func() map[string]XMLBucket {
+		t.Helper()
+		resp := mustDo(t, "GET", srv.URL+"/?usage=true", nil, nil)
+		var result ListAllMyBucketsResult
+		if err := xml.Unmarshal([]byte(readBody(t, resp)), &result); err != nil {
+			t.Fatal(err)
+		}
+		buckets := make(map[string]XMLBucket)
+		for _, b := range result.Buckets.Bucket {
+			buckets[b.Name] = b
+		}
+		return buckets
+	}
+
+	// Ignored unless the server allows it.
+	if b := list()["full"]; b.ObjectCount != nil || b.Size != nil {
+		t.Fatalf("usage returned without -allow-bucket-usage: %+v", b)
+	}
+
+	handler.SetAllowBucketUsage(true)
+	buckets := list()
+	if b := buckets["full"]; b.ObjectCount == nil || *b.ObjectCount != 2 || *b.Size != 8 {
+		t.Errorf("full bucket usage: %+v", b)
+	}
+	if b := buckets["empty"]; b.ObjectCount == nil || *b.ObjectCount != 0 || *b.Size != 0 {
+		t.Errorf("empty bucket usage: %+v", b)
+	}
+
+	// Results are cached, so a new object is not counted straight away.
+	mustDo(t, "PUT", srv.URL+"/full/c.txt", strings.NewReader("x"), nil).Body.Close()
+	if b := list()["full"]; *b.ObjectCount != 2 {
+		t.Errorf("cached count = %d, want 2", *b.ObjectCount)
+	}
+
+	// A deleted and recreated bucket does not inherit the old totals.
+	mustDo(t, "DELETE", srv.URL+"/empty", nil, nil).Body.Close()
+	list()
+	mustDo(t, "PUT", srv.URL+"/empty", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/empty/x", strings.NewReader("xy"), nil).Body.Close()
+	if b := list()["empty"]; *b.ObjectCount != 1 || *b.Size != 2 {
+		t.Errorf("recreated bucket usage: %+v", b)
+	}
+}
+
+func TestHTTPBucketSubresourceRouting(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/a.txt", strings.NewReader("x"), nil).Body.Close()
+
+	tests := []struct {
+		query, code string
+		status      int
+		root        string
+	}{
+		{"location", "", 200, "<LocationConstraint"},
+		{"versioning", "", 200, "<VersioningConfiguration"},
+		{"cors", "NoSuchCORSConfiguration", 404, ""},
+		{"policy", "NoSuchBucketPolicy", 404, ""},
+		{"tagging", "NoSuchTagSet", 404, ""},
+		{"lifecycle", "NoSuchLifecycleConfiguration", 404, ""},
+		{"uploads", "", 200, "<ListMultipartUploadsResult"},
+	}
+	for _, tt := range tests {
+		resp := mustDo(t, "GET", srv.URL+"/mybucket?"+tt.query, nil, nil)
+		body := readBody(t, resp)
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET ?%s: status %d, want %d", tt.query, resp.StatusCode, tt.status)
+		}
+		if strings.Contains(body, "ListBucketResult") {
+			t.Errorf("GET ?%s returned a listing:\n%s", tt.query, body)
+		}
+		if tt.code != "" && !strings.Contains(body, "<Code>"+tt.code+"</Code>") {
+			t.Errorf("GET ?%s: want code %s, got %s", tt.query, tt.code, body)
+		}
+		if tt.root != "" && !strings.Contains(body, tt.root) {
+			t.Errorf("GET ?%s: want %s, got %s", tt.query, tt.root, body)
+		}
+
+		resp = mustDo(t, "GET", srv.URL+"/missing?"+tt.query, nil, nil)
+		if body := readBody(t, resp); tt.status != 501 && !strings.Contains(body, "<Code>NoSuchBucket</Code>") {
+			t.Errorf("GET ?%s on missing bucket: %d %s", tt.query, resp.StatusCode, body)
+		}
+	}
+
+	// Writes to a subresource must not reach CreateBucket or DeleteBucket.
+	mustDo(t, "DELETE", srv.URL+"/mybucket/a.txt", nil, nil).Body.Close()
+	for _, method := range []string{"PUT", "DELETE"} {
+		resp := mustDo(t, method, srv.URL+"/mybucket?policy", strings.NewReader("{}"), nil)
+		resp.Body.Close()
+		if resp.StatusCode != 501 {
+			t.Errorf("%s ?policy: status %d, want 501", method, resp.StatusCode)
+		}
+	}
+	resp := mustDo(t, "HEAD", srv.URL+"/mybucket", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("DELETE ?policy removed the bucket: HEAD %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPBucketTagging(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	put := func(body string) *http.Response {
+		t.Helper()
+		return mustDo(t, "PUT", srv.URL+"/mybucket?tagging", strings.NewReader(body), nil)
+	}
+	tagSet := func(tags ...string) string {
+		var b strings.Builder
+		b.WriteString(`<Tagging xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><TagSet>`)
+		for i := 0; i+1 < len(tags); i += 2 {
+			fmt.Fprintf(&b, "<Tag><Key>%s</Key><Value>%s</Value></Tag>", tags[i], tags[i+1])
+		}
+		b.WriteString("</TagSet></Tagging>")
+		return b.String()
+	}
+
+	resp := put(tagSet("team", "storage", "env", "dev"))
+	resp.Body.Close()
+	if resp.StatusCode != 204 {
+		t.Fatalf("put tagging: %d", resp.StatusCode)
+	}
+	resp = mustDo(t, "GET", srv.URL+"/mybucket?tagging", nil, nil)
+	var got Tagging
+	if err := xml.Unmarshal([]byte(readBody(t, resp)), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []Tag{{Key: "env", Value: "dev"}, {Key: "team", Value: "storage"}}
+	if !reflect.DeepEqual(got.TagSet, want) {
+		t.Errorf("tag set = %+v, want %+v", got.TagSet, want)
+	}
+
+	// Bucket tags are separate from listing and from object tags.
+	resp = mustDo(t, "GET", srv.URL+"/mybucket?list-type=2", nil, nil)
+	if body := readBody(t, resp); strings.Contains(body, "<Key>") {
+		t.Errorf("listing shows the tag file:\n%s", body)
+	}
+
+	var fiftyOne []string
+	for i := 0; i < 51; i++ {
+		fiftyOne = append(fiftyOne, fmt.Sprintf("k%d", i), "v")
+	}
+	invalid := map[string]string{
+		"too many":   tagSet(fiftyOne...),
+		"duplicate":  tagSet("a", "1", "a", "2"),
+		"empty key":  tagSet("", "v"),
+		"long key":   tagSet(strings.Repeat("k", 129), "v"),
+		"long value": tagSet("k", strings.Repeat("v", 257)),
+		"reserved":   tagSet("aws:owner", "me"),
+	}
+	for name, body := range invalid {
+		resp := put(body)
+		if b := readBody(t, resp); resp.StatusCode != 400 || !strings.Contains(b, "<Code>InvalidTag</Code>") {
+			t.Errorf("%s: %d %s", name, resp.StatusCode, b)
+		}
+	}
+	resp = put("<Tagging><TagSet>")
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("malformed XML: %d", resp.StatusCode)
+	}
+	resp = put(tagSet(fiftyOne[:100]...))
+	resp.Body.Close()
+	if resp.StatusCode != 204 {
+		t.Errorf("50 tags: %d", resp.StatusCode)
+	}
+
+	resp = mustDo(t, "DELETE", srv.URL+"/mybucket?tagging", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 204 {
+		t.Errorf("delete tagging: %d", resp.StatusCode)
+	}
+	resp = mustDo(t, "GET", srv.URL+"/mybucket?tagging", nil, nil)
+	if body := readBody(t, resp); resp.StatusCode != 404 || !strings.Contains(body, "NoSuchTagSet") {
+		t.Errorf("after delete: %d %s", resp.StatusCode, body)
+	}
+	resp = mustDo(t, "HEAD", srv.URL+"/mybucket", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("DELETE ?tagging removed the bucket: HEAD %d", resp.StatusCode)
+	}
+
+	resp = mustDo(t, "PUT", srv.URL+"/missing?tagging", strings.NewReader(tagSet("a", "b")), nil)
+	if body := readBody(t, resp); resp.StatusCode != 404 || !strings.Contains(body, "NoSuchBucket") {
+		t.Errorf("missing bucket: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestHTTPSelectObjectContentNotImplemented(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/data.csv", strings.NewReader("a,b\n1,2\n"), nil).Body.Close()
+
+	req := `<SelectObjectContentRequest><Expression>SELECT * FROM s3object</Expression><ExpressionType>SQL</ExpressionType></SelectObjectContentRequest>`
+	resp := mustDo(t, "POST", srv.URL+"/mybucket/data.csv?select&select-type=2", strings.NewReader(req), nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("expected 501, got %d: %s", resp.StatusCode, body)
+	}
+	var errResp ErrorResponse
+	if err := xml.Unmarshal([]byte(body), &errResp); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if errResp.Code != "NotImplemented" || !strings.Contains(errResp.Message, "S3 Select") {
+		t.Errorf("error = %s: %q", errResp.Code, errResp.Message)
+	}
+}
+
+func TestHTTPBucketACL(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	handler := NewS3Handler(storage, &NoOpAuthenticator{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/obj", strings.NewReader("data"), nil).Body.Close()
+
+	// Without -accept-acl the subresource is refused, never misrouted.
+	for _, method := range []string{"PUT", "GET"} {
+		resp := mustDo(t, method, srv.URL+"/mybucket?acl", nil, map[string]string{"x-amz-acl": "public-read"})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotImplemented {
+			t.Errorf("%s ?acl without -accept-acl: %d", method, resp.StatusCode)
+		}
+	}
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/obj?acl", strings.NewReader("<AccessControlPolicy/>"), nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("object ?acl: %d", resp.StatusCode)
+	}
+	if body := readBody(t, mustDo(t, "GET", srv.URL+"/mybucket/obj", nil, nil)); body != "data" {
+		t.Errorf("object ACL PUT overwrote object: %q", body)
+	}
+
+	handler.SetAcceptACL(true)
+	getACL := func(bucket string) AccessControlPolicy {
+		t.Helper()
+		resp := mustDo(t, "GET", srv.URL+"/"+bucket+"?acl", nil, nil)
+		if resp.StatusCode != 200 {
+			t.Fatalf("GET %s?acl: %d", bucket, resp.StatusCode)
+		}
+		var policy AccessControlPolicy
+		if err := xml.Unmarshal([]byte(readBody(t, resp)), &policy); err != nil {
+			t.Fatal(err)
+		}
+		return policy
+	}
+	if grants := getACL("mybucket").Grants; len(grants) != 1 || grants[0].Permission != "FULL_CONTROL" {
+		t.Errorf("default ACL grants: %+v", grants)
+	}
+
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket?acl", nil, map[string]string{"x-amz-acl": "public-read"})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("PUT ?acl: %d", resp.StatusCode)
+	}
+	grants := getACL("mybucket").Grants
+	if len(grants) != 2 || grants[1].Grantee.URI != "http://acs.amazonaws.com/groups/global/AllUsers" || grants[1].Permission != "READ" {
+		t.Errorf("public-read grants: %+v", grants)
+	}
+
+	// Explicit grants are accepted but leave the recorded ACL alone.
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket?acl", strings.NewReader("<AccessControlPolicy/>"), nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || len(getACL("mybucket").Grants) != 2 {
+		t.Errorf("grant body: status %d", resp.StatusCode)
+	}
+
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket?acl", nil, map[string]string{"x-amz-acl": "everyone"})
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("unknown canned ACL: %d", resp.StatusCode)
+	}
+
+	// x-amz-acl on CreateBucket is recorded too.
+	resp = mustDo(t, "PUT", srv.URL+"/other", nil, map[string]string{"x-amz-acl": "authenticated-read"})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("CreateBucket with x-amz-acl: %d", resp.StatusCode)
+	}
+	if grants := getACL("other").Grants; len(grants) != 2 || !strings.HasSuffix(grants[1].Grantee.URI, "/AuthenticatedUsers") {
+		t.Errorf("authenticated-read grants: %+v", grants)
+	}
+
+	resp = mustDo(t, "GET", srv.URL+"/missing?acl", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("GET ?acl on missing bucket: %d", resp.StatusCode)
+	}
+}
+
+func TestListResponsesHaveContentLength(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	for i := 0; i < 50; i++ {
+		mustDo(t, "PUT", fmt.Sprintf("%s/mybucket/obj-%02d.txt", srv.URL, i), strings.NewReader("x"), nil).Body.Close()
+	}
+
+	for _, path := range []string{"/", "/mybucket", "/mybucket?list-type=2", "/mybucket?delimiter=/"} {
+		resp := mustDo(t, "GET", srv.URL+path, nil, nil)
+		body := readBody(t, resp)
+		if resp.StatusCode != 200 {
+			t.Fatalf("GET %s: %d", path, resp.StatusCode)
+		}
+		if len(resp.TransferEncoding) != 0 {
+			t.Errorf("GET %s: unexpected Transfer-Encoding %v", path, resp.TransferEncoding)
+		}
+		if resp.ContentLength != int64(len(body)) {
+			t.Errorf("GET %s: Content-Length %d, body %d bytes", path, resp.ContentLength, len(body))
+		}
+		if !strings.HasPrefix(body, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>") {
+			t.Errorf("GET %s: missing XML declaration", path)
+		}
+	}
+}
+
+func TestXMLResponsesHaveContentLength(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/src.txt", strings.NewReader("data"), nil).Body.Close()
+
+	requests := []struct {
+		name, method, path string
+		headers            map[string]string
+	}{
+		{"error", "GET", "/nonexistent?list-type=2", nil},
+		{"create multipart", "POST", "/mybucket/mp.bin?uploads", nil},
+		{"copy object", "PUT", "/mybucket/dst.txt", map[string]string{"X-Amz-Copy-Source": "/mybucket/src.txt"}},
+	}
+	for _, req := range requests {
+		resp := mustDo(t, req.method, srv.URL+req.path, nil, req.headers)
+		body := readBody(t, resp)
+		if len(resp.TransferEncoding) != 0 {
+			t.Errorf("%s: unexpected Transfer-Encoding %v", req.name, resp.TransferEncoding)
+		}
+		if resp.ContentLength != int64(len(body)) || len(body) == 0 {
+			t.Errorf("%s: Content-Length %d, body %d bytes", req.name, resp.ContentLength, len(body))
+		}
+		if !strings.HasPrefix(body, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>") {
+			t.Errorf("%s: missing XML declaration", req.name)
+		}
+	}
+}
+
+func TestErrorResponseXMLFormat(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	resp := mustDo(t, "GET", srv.URL+"/nonexistent?list-type=2", nil, nil)
+	body := readBody(t, resp)
+
+	// Should have XML declaration
+	if !strings.HasPrefix(body, "<?xml") {
+		t.Error("error response should have XML declaration")
+	}
+
+	var errResp ErrorResponse
+	// Strip the XML declaration for unmarshal
+	xmlContent := body[strings.Index(body, "<Error"):]
+	if err := xml.Unmarshal([]byte(xmlContent), &errResp); err != nil {
+		t.Fatalf("xml parse error response: %v\nbody: %s", err, body)
+	}
+	if errResp.Code != "NoSuchBucket" {
+		t.Errorf("error code: %q", errResp.Code)
+	}
+}
+
+func TestHTTPJSONErrors(t *testing.T) {
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	srv := httptest.NewServer(LoggingMiddleware(handler))
+	defer srv.Close()
+	accessLogOutput = io.Discard
+	defer func() { accessLogOutput = os.Stdout }()
+
+	jsonAccept := map[string]string{"Accept": "application/json"}
+	resp := mustDo(t, "GET", srv.URL+"/nonexistent?list-type=2", nil, jsonAccept)
+	body := readBody(t, resp)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/xml" {
+		t.Errorf("JSON errors disabled: Content-Type %q", ct)
+	}
+
+	handler.SetJSONErrors(true)
+	resp = mustDo(t, "GET", srv.URL+"/nonexistent?list-type=2", nil, jsonAccept)
+	body = readBody(t, resp)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" || resp.StatusCode != 404 {
+		t.Fatalf("status %d, Content-Type %q", resp.StatusCode, ct)
+	}
+	var errResp jsonErrorResponse
+	if err := json.Unmarshal([]byte(body), &errResp); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if errResp.Code != "NoSuchBucket" || errResp.Message == "" || errResp.Resource != "/nonexistent" ||
+		errResp.RequestID != resp.Header.Get("x-amz-request-id") || errResp.RequestID == "" {
+		t.Errorf("error = %+v", errResp)
+	}
+
+	// SDKs and browsers default to XML.
+	for _, accept := range []string{"", "*/*", "application/xml", "application/json;q=0.5, */*"} {
+		resp = mustDo(t, "GET", srv.URL+"/nonexistent?list-type=2", nil, map[string]string{"Accept": accept})
+		body = readBody(t, resp)
+		if !strings.HasPrefix(body, "<?xml") {
+			t.Errorf("Accept %q: got %s", accept, body)
+		}
+	}
+}
+
+func TestPrefersJSON(t *testing.T) {
+	tests := map[string]bool{
+		"":                                  false,
+		"*/*":                               false,
+		"application/json":                  true,
+		"application/json, text/plain, */*": false,
+		"application/json, */*;q=0.8":       true,
+		"application/xml;q=0.9, application/json": true,
+		"application/json;q=0":                    false,
+		"text/html, application/json;q=0.9":       true,
+	}
+	for accept, want := range tests {
+		if got := prefersJSON(accept); got != want {
+			t.Errorf("prefersJSON(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestPrettyXMLResponses(t *testing.T) {
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	handler.SetPrettyXML(true)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/a.txt", strings.NewReader("x"), nil).Body.Close()
+
+	resp := mustDo(t, "GET", srv.URL+"/mybucket?list-type=2", nil, nil)
+	body := readBody(t, resp)
+	if !strings.HasPrefix(body, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<ListBucketResult") {
+		t.Errorf("missing XML declaration:\n%s", body)
+	}
+	if !strings.Contains(body, "\n  <Contents>\n    <Key>a.txt</Key>") || !strings.HasSuffix(body, "</ListBucketResult>\n") {
+		t.Errorf("listing not indented:\n%s", body)
+	}
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("Content-Length %d, body %d bytes", resp.ContentLength, len(body))
+	}
+	var list ListBucketResult
+	if err := xml.Unmarshal([]byte(body), &list); err != nil || len(list.Contents) != 1 {
+		t.Errorf("indented listing does not parse: %v %+v", err, list)
+	}
+
+	resp = mustDo(t, "GET", srv.URL+"/nonexistent?list-type=2", nil, nil)
+	body = readBody(t, resp)
+	var errResp ErrorResponse
+	if err := xml.Unmarshal([]byte(body), &errResp); err != nil || errResp.Code != "NoSuchBucket" {
+		t.Errorf("indented error does not parse: %v\n%s", err, body)
+	}
+	if !strings.Contains(body, "\n  <Code>NoSuchBucket</Code>") {
+		t.Errorf("error not indented:\n%s", body)
+	}
+}
+
+func TestContentTypeIsXML(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	resp := mustDo(t, "GET", srv.URL+"/mybucket?list-type=2", nil, nil)
+	resp.Body.Close()
+
+	ct := resp.Header.Get("Content-Type")
 	if ct != "application/xml" {
 		t.Errorf("Content-Type: %q", ct)
 	}
@@ -1094,6 +2307,8 @@ func TestParsePath(t *testing.T) {
 func TestIsValidBucketName(t *testing.T) {
 	valid := []string{
 		"abc", "my-bucket", "bucket.name", "123bucket", "a23",
+		"a.b.c", "my--bucket", "a-1.b-2", "1.2.3.4.5", "v1.2.3",
+		"docexamplebucket1", "log-delivery-march-2020", "my-hosted-content",
 		strings.Repeat("a", 63),
 	}
 	for _, n := range valid {
@@ -1115,34 +2330,335 @@ func TestIsValidBucketName(t *testing.T) {
 		"has space",             // space
 		"has_underscore",        // underscore
 		strings.Repeat("a", 64), // too long
+		"a.-b",                  // label starts with dash
+		"a-.b",                  // label ends with dash
+		"abc.-def.ghi",          // inner label starts with dash
+		"abc.def-.ghi",          // inner label ends with dash
+		"192.168.5.4",           // IPv4 address
+		"xn--bucket",            // reserved prefix
+		"sthree-bucket",         // reserved prefix
+		"bucket-s3alias",        // reserved suffix
+		"bucket--ol-s3",         // reserved suffix
+		"doc_example_bucket",    // underscores
+		"DocExampleBucket",      // uppercase
+		"doc-example-bucket-",   // ends with dash
+	}
+	for _, n := range invalid {
+		if isValidBucketName(n) {
+			t.Errorf("should be invalid: %q", n)
+		}
+	}
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Range Request Support
+// ═══════════════════════════════════════════════════════════════════════════════
+
+func TestHTTPRangeRequest(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/range.txt",
+		strings.NewReader("0123456789"), nil).Body.Close()
+
+	resp := mustDo(t, "GET", srv.URL+"/mybucket/range.txt", nil,
+		map[string]string{"Range": "bytes=0-4"})
+	body := readBody(t, resp)
+
+	if resp.StatusCode != 206 {
+		t.Errorf("range: expected 206, got %d", resp.StatusCode)
+	}
+	if body != "01234" {
+		t.Errorf("range body: %q", body)
+	}
+}
+
+func TestHTTPGetSmallObjectFastPath(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	putResp := mustDo(t, "PUT", srv.URL+"/mybucket/small.json",
+		strings.NewReader(`{"a":1}`), map[string]string{"Content-Type": "application/json"})
+	putResp.Body.Close()
+
+	resp := mustDo(t, "GET", srv.URL+"/mybucket/small.json", nil, nil)
+	body := readBody(t, resp)
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("GET status: %d", resp.StatusCode)
+	}
+	if body != `{"a":1}` {
+		t.Errorf("body: %q", body)
+	}
+	if cl := resp.Header.Get("Content-Length"); cl != "7" {
+		t.Errorf("Content-Length: %q", cl)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type: %q", ct)
+	}
+	if resp.Header.Get("Last-Modified") == "" {
+		t.Error("Last-Modified should be set")
+	}
+	if ar := resp.Header.Get("Accept-Ranges"); ar != "bytes" {
+		t.Errorf("Accept-Ranges: %q", ar)
+	}
+	if resp.Header.Get("ETag") != putResp.Header.Get("ETag") {
+		t.Errorf("ETag: %q", resp.Header.Get("ETag"))
+	}
+}
+
+func TestHTTPGetSmallObjectConditionalStillHonored(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	putResp := mustDo(t, "PUT", srv.URL+"/mybucket/small.txt", strings.NewReader("tiny"), nil)
+	putResp.Body.Close()
+
+	resp := mustDo(t, "GET", srv.URL+"/mybucket/small.txt", nil,
+		map[string]string{"If-None-Match": putResp.Header.Get("ETag")})
+	resp.Body.Close()
+	if resp.StatusCode != 304 {
+		t.Errorf("If-None-Match on small object: expected 304, got %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPNotModifiedCarriesValidators(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	putResp := mustDo(t, "PUT", srv.URL+"/mybucket/page.html", strings.NewReader("<p>hi</p>"),
+		map[string]string{"Content-Type": "text/html", "Cache-Control": "max-age=60"})
+	putResp.Body.Close()
+	etag := putResp.Header.Get("ETag")
+
+	get := mustDo(t, "GET", srv.URL+"/mybucket/page.html", nil, nil)
+	get.Body.Close()
+	lastModified := get.Header.Get("Last-Modified")
+
+	for name, headers := range map[string]map[string]string{
+		"If-None-Match":      {"If-None-Match": etag},
+		"weak If-None-Match": {"If-None-Match": `"other", W/` + etag},
+		"If-Modified-Since":  {"If-Modified-Since": lastModified},
+	} {
+		resp := mustDo(t, "GET", srv.URL+"/mybucket/page.html", nil, headers)
+		body := readBody(t, resp)
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("%s: expected 304, got %d", name, resp.StatusCode)
+			continue
+		}
+		if body != "" {
+			t.Errorf("%s: 304 has a body: %q", name, body)
+		}
+		if got := resp.Header.Get("ETag"); got != etag {
+			t.Errorf("%s: ETag = %q, want %q", name, got, etag)
+		}
+		if got := resp.Header.Get("Last-Modified"); got != lastModified {
+			t.Errorf("%s: Last-Modified = %q, want %q", name, got, lastModified)
+		}
+		if got := resp.Header.Get("Cache-Control"); got != "max-age=60" {
+			t.Errorf("%s: Cache-Control = %q", name, got)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "" {
+			t.Errorf("%s: 304 should not carry Content-Type, got %q", name, got)
+		}
+	}
+
+	// A stale validator gets the full object.
+	resp := mustDo(t, "GET", srv.URL+"/mybucket/page.html", nil, map[string]string{"If-None-Match": `"stale"`})
+	if body := readBody(t, resp); resp.StatusCode != 200 || body != "<p>hi</p>" {
+		t.Errorf("stale If-None-Match: %d %q", resp.StatusCode, body)
+	}
+	// If-None-Match takes precedence over If-Modified-Since.
+	resp = mustDo(t, "GET", srv.URL+"/mybucket/page.html", nil,
+		map[string]string{"If-None-Match": `"stale"`, "If-Modified-Since": lastModified})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("If-None-Match should override If-Modified-Since: got %d", resp.StatusCode)
+	}
+}
+
+// streamingStorage returns object readers that cannot seek, as a network
+// backend would, so GETs take the non-ServeContent path.
+type streamingStorage struct {
+	*FilesystemStorage
+}
+
+func (s streamingStorage) GetObject(bucket, key string) (io.ReadCloser, *ObjectMetadata, error) {
+	reader, meta, err := s.FilesystemStorage.GetObject(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return struct{ io.ReadCloser }{reader}, meta, nil
+}
+
+func TestHTTPHeadObjectMetadataDrift(t *testing.T) {
+	dataDir := t.TempDir()
+	storage := NewFilesystemStorage(dataDir)
+	srv := httptest.NewServer(NewS3Handler(storage, &NoOpAuthenticator{}))
+	defer srv.Close()
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/k", strings.NewReader("original"), nil)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+
+	// Consistent objects are not counted.
+	before := metadataDrift.Value()
+	mustDo(t, "HEAD", srv.URL+"/mybucket/k", nil, nil).Body.Close()
+	if metadataDrift.Value() != before {
+		t.Fatal("drift counted for a consistent object")
+	}
+
+	// Edited out of band: HEAD reports the file's size, like GET.
+	if err := os.WriteFile(filepath.Join(dataDir, "mybucket", "k"), []byte("edited by hand"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp = mustDo(t, "HEAD", srv.URL+"/mybucket/k", nil, nil)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Length") != "14" {
+		t.Errorf("Content-Length = %q, want 14", resp.Header.Get("Content-Length"))
+	}
+	if metadataDrift.Value() != before+1 {
+		t.Errorf("drift counter = %d, want %d", metadataDrift.Value(), before+1)
+	}
+
+	// The stored ETag no longer vouches for the content.
+	resp = mustDo(t, "HEAD", srv.URL+"/mybucket/k", nil, map[string]string{"If-None-Match": etag})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("If-None-Match on stale metadata: %d, want 200", resp.StatusCode)
+	}
+
+	// Tagging saves the sidecar back without adopting the file's size and
+	// is not itself reported, so the next HEAD still sees the drift.
+	before = metadataDrift.Value()
+	tagging := `<Tagging><TagSet><Tag><Key>k</Key><Value>v</Value></Tag></TagSet></Tagging>`
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket/k?tagging", strings.NewReader(tagging), nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("PUT ?tagging: %d", resp.StatusCode)
+	}
+	mustDo(t, "DELETE", srv.URL+"/mybucket/k?tagging", nil, nil).Body.Close()
+	if metadataDrift.Value() != before {
+		t.Errorf("tagging counted drift: %d, want %d", metadataDrift.Value(), before)
+	}
+	if meta, err := storage.loadMetadata("mybucket", "k"); err != nil || meta.Size != 8 {
+		t.Errorf("sidecar after tagging: %+v, %v", meta, err)
+	}
+	resp = mustDo(t, "HEAD", srv.URL+"/mybucket/k", nil, map[string]string{"If-None-Match": etag})
+	resp.Body.Close()
+	if resp.StatusCode != 200 || metadataDrift.Value() != before+1 {
+		t.Errorf("HEAD after tagging: %d, drift counter %d, want 200 and %d", resp.StatusCode, metadataDrift.Value(), before+1)
+	}
+}
+
+func TestHTTPConditionalGet(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	storage.CreateBucket("mybucket")
+	meta, err := storage.PutObject("mybucket", "page.html", strings.NewReader("<p>hi</p>"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := meta.ETag
+	lastModified := meta.LastModified.UTC().Format(http.TimeFormat)
+	before := meta.LastModified.Add(-time.Hour).UTC().Format(http.TimeFormat)
+
+	for _, backend := range []Storage{storage, streamingStorage{storage}} {
+		srv := httptest.NewServer(NewS3Handler(backend, &NoOpAuthenticator{}))
+		name := fmt.Sprintf("%T", backend)
+
+		for _, tc := range []struct {
+			headers map[string]string
+			status  int
+		}{
+			{map[string]string{"If-None-Match": etag}, 304},
+			{map[string]string{"If-None-Match": "*"}, 304},
+			{map[string]string{"If-None-Match": strings.Trim(etag, `"`)}, 304},
+			{map[string]string{"If-None-Match": "W/" + strings.Trim(etag, `"`)}, 304},
+			{map[string]string{"If-None-Match": "stale"}, 200},
+			{map[string]string{"If-Modified-Since": lastModified}, 304},
+			{map[string]string{"If-Modified-Since": before}, 200},
+			{map[string]string{"If-Match": etag}, 200},
+			{map[string]string{"If-Match": strings.Trim(etag, `"`)}, 200},
+			{map[string]string{"If-Match": "*"}, 200},
+			{map[string]string{"If-Match": `"stale"`}, 412},
+			{map[string]string{"If-Match": "W/" + etag}, 412},
+			{map[string]string{"If-Unmodified-Since": lastModified}, 200},
+			{map[string]string{"If-Unmodified-Since": before}, 412},
+			// If-Match takes precedence over If-Unmodified-Since, and a
+			// passing one still lets If-None-Match answer 304.
+			{map[string]string{"If-Match": etag, "If-Unmodified-Since": before}, 200},
+			{map[string]string{"If-Match": etag, "If-None-Match": etag}, 304},
+			{map[string]string{"If-Match": `"stale"`, "If-None-Match": etag}, 412},
+		} {
+			for _, method := range []string{"GET", "HEAD"} {
+				resp := mustDo(t, method, srv.URL+"/mybucket/page.html", nil, tc.headers)
+				body := readBody(t, resp)
+				if resp.StatusCode != tc.status {
+					t.Errorf("%s %s %v: expected %d, got %d", name, method, tc.headers, tc.status, resp.StatusCode)
+					continue
+				}
+				switch {
+				case tc.status == 412 && method == "GET" && !strings.Contains(body, "<Code>PreconditionFailed</Code>"):
+					t.Errorf("%s %v: 412 body %q", name, tc.headers, body)
+				case tc.status == 304 && body != "":
+					t.Errorf("%s %s %v: 304 has a body: %q", name, method, tc.headers, body)
+				case tc.status == 304 && resp.Header.Get("ETag") != etag:
+					t.Errorf("%s %s %v: 304 ETag = %q", name, method, tc.headers, resp.Header.Get("ETag"))
+				case tc.status == 200 && method == "GET" && body != "<p>hi</p>":
+					t.Errorf("%s %v: body %q", name, tc.headers, body)
+				}
+			}
+		}
+		srv.Close()
+	}
+}
+
+func TestHTTPPutIfNoneMatch(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	create := map[string]string{"If-None-Match": "*"}
+
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/lock", strings.NewReader("owner-a"), create)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("create: %d", resp.StatusCode)
+	}
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket/lock", strings.NewReader("owner-b"), create)
+	if body := readBody(t, resp); resp.StatusCode != 412 || !strings.Contains(body, "<Code>PreconditionFailed</Code>") {
+		t.Errorf("create over an existing key: %d %s", resp.StatusCode, body)
+	}
+	if body := readBody(t, mustDo(t, "GET", srv.URL+"/mybucket/lock", nil, nil)); body != "owner-a" {
+		t.Errorf("content = %q", body)
+	}
+
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket/lock", strings.NewReader("x"), map[string]string{"If-None-Match": `"abc"`})
+	resp.Body.Close()
+	if resp.StatusCode != 501 {
+		t.Errorf("If-None-Match with an ETag: %d", resp.StatusCode)
 	}
-	for _, n := range invalid {
-		if isValidBucketName(n) {
-			t.Errorf("should be invalid: %q", n)
-		}
+	// Without the header the key is overwritten as usual.
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket/lock", strings.NewReader("owner-c"), nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("plain overwrite: %d", resp.StatusCode)
 	}
 }
 
-// ═══════════════════════════════════════════════════════════════════════════════
-// Range Request Support
-// ═══════════════════════════════════════════════════════════════════════════════
-
-func TestHTTPRangeRequest(t *testing.T) {
-	srv, _ := setupTestServer(t)
+func TestHTTPGetSmallObjectGrownPastThreshold(t *testing.T) {
+	srv, storage := setupTestServer(t)
 
 	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
-	mustDo(t, "PUT", srv.URL+"/mybucket/range.txt",
-		strings.NewReader("0123456789"), nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/grown.bin", strings.NewReader("tiny"), nil).Body.Close()
 
-	resp := mustDo(t, "GET", srv.URL+"/mybucket/range.txt", nil,
-		map[string]string{"Range": "bytes=0-4"})
-	body := readBody(t, resp)
+	// Rewrite the data file behind the metadata's back so the stored size is stale.
+	big := strings.Repeat("x", smallObjectThreshold+100)
+	os.WriteFile(filepath.Join(storage.dataDir, "mybucket", "grown.bin"), []byte(big), 0644)
 
-	if resp.StatusCode != 206 {
-		t.Errorf("range: expected 206, got %d", resp.StatusCode)
-	}
-	if body != "01234" {
-		t.Errorf("range body: %q", body)
+	resp := mustDo(t, "GET", srv.URL+"/mybucket/grown.bin", nil, nil)
+	body := readBody(t, resp)
+	if body != big {
+		t.Errorf("object should be served in full via fallback, got %d bytes", len(body))
 	}
 }
 
@@ -1257,6 +2773,7 @@ func TestHTTPPutEmptyObject(t *testing.T) {
 // ═══════════════════════════════════════════════════════════════════════════════
 
 func TestHTTPMultipartUploadBasic(t *testing.T) {
+	allowSmallParts(t)
 	srv, _ := setupTestServer(t)
 
 	// Create bucket
@@ -1373,6 +2890,373 @@ func TestHTTPMultipartUploadAbort(t *testing.T) {
 	}
 }
 
+func TestHTTPAbortMultipartUploadsForKey(t *testing.T) {
+	srv, storage := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	initiate := func(key string) string {
+		t.Helper()
+		resp := mustDo(t, "POST", srv.URL+"/mybucket/"+key+"?uploads", nil, nil)
+		var initResult InitiateMultipartUploadResult
+		xml.Unmarshal([]byte(readBody(t, resp)), &initResult)
+		return initResult.UploadId
+	}
+	first, second := initiate("dir/stale.bin"), initiate("dir/stale.bin")
+	other := initiate("dir/other.bin")
+
+	resp := mustDo(t, "DELETE", srv.URL+"/mybucket/dir/stale.bin?uploads", nil, nil)
+	var result AbortMultipartUploadsResult
+	if err := xml.Unmarshal([]byte(readBody(t, resp)), &result); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("abort all: %d %v", resp.StatusCode, err)
+	}
+	want := []string{first, second}
+	sort.Strings(want)
+	if result.Key != "dir/stale.bin" || !reflect.DeepEqual(result.UploadIds, want) {
+		t.Errorf("aborted %+v, want %v", result, want)
+	}
+	if _, err := os.Stat(storage.multipartStagingPath("mybucket", other)); err != nil {
+		t.Errorf("upload for another key was aborted: %v", err)
+	}
+
+	// Nothing left: 200 with an empty list.
+	resp = mustDo(t, "DELETE", srv.URL+"/mybucket/dir/stale.bin?uploads", nil, nil)
+	result = AbortMultipartUploadsResult{}
+	xml.Unmarshal([]byte(readBody(t, resp)), &result)
+	if resp.StatusCode != 200 || len(result.UploadIds) != 0 {
+		t.Errorf("second abort all: %d %v", resp.StatusCode, result.UploadIds)
+	}
+
+	resp = mustDo(t, "DELETE", srv.URL+"/nobucket/dir/stale.bin?uploads", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("missing bucket: %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPListParts(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	resp := mustDo(t, "POST", srv.URL+"/mybucket/big.bin?uploads", nil, nil)
+	var initResult InitiateMultipartUploadResult
+	xml.Unmarshal([]byte(readBody(t, resp)), &initResult)
+	uploadID := initResult.UploadId
+
+	etags := map[int]string{}
+	for n, body := range map[int]string{1: "one", 2: "two", 3: "three"} {
+		resp := mustDo(t, "PUT", fmt.Sprintf("%s/mybucket/big.bin?partNumber=%d&uploadId=%s", srv.URL, n, uploadID), strings.NewReader(body), nil)
+		resp.Body.Close()
+		etags[n] = resp.Header.Get("ETag")
+	}
+	// A re-uploaded part replaces the earlier one.
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket/big.bin?partNumber=2&uploadId="+uploadID, strings.NewReader("two again"), nil)
+	resp.Body.Close()
+	etags[2] = resp.Header.Get("ETag")
+
+	list := func(query string) ListPartsResult {
+		t.Helper()
+		resp := mustDo(t, "GET", srv.URL+"/mybucket/big.bin?uploadId="+uploadID+query, nil, nil)
+		var result ListPartsResult
+		if err := xml.Unmarshal([]byte(readBody(t, resp)), &result); err != nil || resp.StatusCode != 200 {
+			t.Fatalf("list parts%s: %d %v", query, resp.StatusCode, err)
+		}
+		return result
+	}
+
+	result := list("")
+	if len(result.Parts) != 3 || result.IsTruncated || result.UploadId != uploadID || result.Key != "big.bin" {
+		t.Fatalf("all parts: %+v", result)
+	}
+	for i, size := range []int64{3, 9, 5} {
+		part := result.Parts[i]
+		if part.PartNumber != i+1 || part.Size != size || part.ETag != etags[i+1] {
+			t.Errorf("part %d: %+v, want size %d and ETag %s", i+1, part, size, etags[i+1])
+		}
+		if _, err := time.Parse(time.RFC3339, part.LastModified); err != nil {
+			t.Errorf("part %d LastModified %q: %v", i+1, part.LastModified, err)
+		}
+	}
+
+	page := list("&max-parts=2")
+	if len(page.Parts) != 2 || !page.IsTruncated || page.NextPartNumberMarker != 2 {
+		t.Errorf("first page: %+v", page)
+	}
+	page = list("&max-parts=2&part-number-marker=2")
+	if len(page.Parts) != 1 || page.Parts[0].PartNumber != 3 || page.IsTruncated {
+		t.Errorf("second page: %+v", page)
+	}
+
+	for _, url := range []string{
+		"/mybucket/big.bin?uploadId=0123456789abcdef",
+		"/mybucket/other.bin?uploadId=" + uploadID,
+	} {
+		resp := mustDo(t, "GET", srv.URL+url, nil, nil)
+		if body := readBody(t, resp); resp.StatusCode != 404 || !strings.Contains(body, "<Code>NoSuchUpload</Code>") {
+			t.Errorf("GET %s: %d %s", url, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestHTTPListMultipartUploads(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	initiate := func(key string) string {
+		t.Helper()
+		resp := mustDo(t, "POST", srv.URL+"/mybucket/"+key+"?uploads", nil, nil)
+		var initResult InitiateMultipartUploadResult
+		xml.Unmarshal([]byte(readBody(t, resp)), &initResult)
+		return initResult.UploadId
+	}
+	list := func(query string) ListMultipartUploadsResult {
+		t.Helper()
+		resp := mustDo(t, "GET", srv.URL+"/mybucket?uploads"+query, nil, nil)
+		var result ListMultipartUploadsResult
+		if err := xml.Unmarshal([]byte(readBody(t, resp)), &result); err != nil || resp.StatusCode != 200 {
+			t.Fatalf("list%s: %d %v", query, resp.StatusCode, err)
+		}
+		return result
+	}
+	type upload struct{ key, id string }
+	uploads := func(result ListMultipartUploadsResult) []upload {
+		var got []upload
+		for _, u := range result.Uploads {
+			got = append(got, upload{u.Key, u.UploadId})
+		}
+		return got
+	}
+
+	logs1, logs2 := initiate("logs/b.bin"), initiate("logs/b.bin")
+	if logs2 < logs1 {
+		logs1, logs2 = logs2, logs1
+	}
+	logsA := initiate("logs/a.bin")
+	img := initiate("img/c.png")
+	all := []upload{{"img/c.png", img}, {"logs/a.bin", logsA}, {"logs/b.bin", logs1}, {"logs/b.bin", logs2}}
+
+	result := list("")
+	if got := uploads(result); !reflect.DeepEqual(got, all) || result.IsTruncated {
+		t.Errorf("all uploads: %v truncated=%v, want %v", got, result.IsTruncated, all)
+	}
+	for _, u := range result.Uploads {
+		if initiated, err := time.Parse(time.RFC3339, u.Initiated); err != nil || time.Since(initiated) > time.Minute {
+			t.Errorf("upload %s initiated %q", u.UploadId, u.Initiated)
+		}
+	}
+
+	if got := uploads(list("&prefix=logs/")); !reflect.DeepEqual(got, all[1:]) {
+		t.Errorf("prefix logs/: %v", got)
+	}
+
+	// Page through two at a time, then resume inside a key's uploads.
+	page := list("&max-uploads=2")
+	if got := uploads(page); !page.IsTruncated || !reflect.DeepEqual(got, all[:2]) {
+		t.Errorf("first page: %v truncated=%v", got, page.IsTruncated)
+	}
+	page = list("&max-uploads=2&key-marker=" + page.NextKeyMarker + "&upload-id-marker=" + page.NextUploadIdMarker)
+	if got := uploads(page); page.IsTruncated || !reflect.DeepEqual(got, all[2:]) {
+		t.Errorf("second page: %v truncated=%v", got, page.IsTruncated)
+	}
+	if got := uploads(list("&key-marker=logs/b.bin&upload-id-marker=" + logs1)); !reflect.DeepEqual(got, all[3:]) {
+		t.Errorf("after the first logs/b.bin upload: %v", got)
+	}
+	if got := uploads(list("&key-marker=logs/a.bin")); !reflect.DeepEqual(got, all[2:]) {
+		t.Errorf("after key logs/a.bin: %v", got)
+	}
+
+	// Aborted uploads drop out.
+	mustDo(t, "DELETE", srv.URL+"/mybucket/img/c.png?uploadId="+img, nil, nil).Body.Close()
+	if got := uploads(list("")); !reflect.DeepEqual(got, all[1:]) {
+		t.Errorf("after abort: %v", got)
+	}
+}
+
+func TestHTTPWindowsForbiddenKey(t *testing.T) {
+	saved := checkWindowsNames
+	checkWindowsNames = true
+	defer func() { checkWindowsNames = saved }()
+
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/12:00.log", strings.NewReader("x"), nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != 400 || !strings.Contains(body, "<Code>InvalidArgument</Code>") {
+		t.Errorf("forbidden key: %d %s", resp.StatusCode, body)
+	}
+
+	mustDo(t, "PUT", srv.URL+"/mybucket/ok.log", strings.NewReader("x"), nil).Body.Close()
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket/CON", nil, map[string]string{"x-amz-copy-source": "/mybucket/ok.log"})
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("copy to forbidden key: %d", resp.StatusCode)
+	}
+}
+
+// hugeBucketStorage reports every walked listing as over the scan limit.
+type hugeBucketStorage struct {
+	*FilesystemStorage
+}
+
+func (s hugeBucketStorage) ListObjectsPage(ctx context.Context, bucket, prefix, delimiter, startAfter string, limit int) ([]ObjectInfo, error) {
+	if delimiter == "/" {
+		return s.FilesystemStorage.ListObjectsPage(ctx, bucket, prefix, delimiter, startAfter, limit)
+	}
+	return nil, ErrScanLimit
+}
+
+func TestHTTPListSlowDown(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	storage.CreateBucket("big")
+	storage.PutObject("big", "dir/a.txt", strings.NewReader("a"), nil)
+
+	checkSlowDown := func(t *testing.T, srvURL, path string) {
+		t.Helper()
+		resp := mustDo(t, "GET", srvURL+path, nil, nil)
+		body := readBody(t, resp)
+		if resp.StatusCode != 503 || !strings.Contains(body, "<Code>SlowDown</Code>") || !strings.Contains(body, "prefix") {
+			t.Errorf("GET %s: %d %s", path, resp.StatusCode, body)
+		}
+		if resp.Header.Get("Retry-After") == "" {
+			t.Errorf("GET %s: no Retry-After", path)
+		}
+	}
+
+	t.Run("scan limit", func(t *testing.T) {
+		srv := httptest.NewServer(NewS3Handler(hugeBucketStorage{storage}, &NoOpAuthenticator{}))
+		defer srv.Close()
+		checkSlowDown(t, srv.URL, "/big?list-type=2")
+		checkSlowDown(t, srv.URL, "/big")
+
+		// Delimiter listings read one directory and still work.
+		resp := mustDo(t, "GET", srv.URL+"/big?list-type=2&delimiter=/", nil, nil)
+		if body := readBody(t, resp); resp.StatusCode != 200 || !strings.Contains(body, "<Prefix>dir/</Prefix>") {
+			t.Errorf("delimiter listing: %d %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		handler := NewS3Handler(storage, &NoOpAuthenticator{})
+		handler.SetListDeadline(time.Nanosecond)
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+		checkSlowDown(t, srv.URL, "/big?list-type=2")
+
+		handler.SetListDeadline(0)
+		resp := mustDo(t, "GET", srv.URL+"/big?list-type=2", nil, nil)
+		if body := readBody(t, resp); resp.StatusCode != 200 || !strings.Contains(body, "<Key>dir/a.txt</Key>") {
+			t.Errorf("without deadline: %d %s", resp.StatusCode, body)
+		}
+	})
+}
+
+// fullDiskStorage reports almost no free space.
+type fullDiskStorage struct {
+	*FilesystemStorage
+}
+
+func (fullDiskStorage) AvailableSpace() (uint64, bool) { return 1024, true }
+
+// trackedBody fails the request if the client ever starts sending it.
+type trackedBody struct{ read atomic.Bool }
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	b.read.Store(true)
+	return 0, errors.New("body should not have been sent")
+}
+
+func TestHTTPExpectContinueRejectsEarly(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	storage.CreateBucket("mybucket")
+	srv := httptest.NewServer(NewS3Handler(fullDiskStorage{storage}, &NoOpAuthenticator{}))
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 10 * time.Second}}
+
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{"/nobucket/big.bin", http.StatusNotFound},
+		{"/mybucket/big.bin", http.StatusInsufficientStorage},
+		{"/mybucket/big.bin?partNumber=1&uploadId=x", http.StatusInsufficientStorage},
+	} {
+		body := &trackedBody{}
+		req, _ := http.NewRequest("PUT", srv.URL+tc.path, body)
+		req.ContentLength = 1 << 30
+		req.Header.Set("Expect", "100-continue")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.path, resp.StatusCode, tc.status)
+		}
+		if body.read.Load() {
+			t.Errorf("%s: client sent the body before the error", tc.path)
+		}
+	}
+
+	// Small objects still fit.
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/small.txt", strings.NewReader("fits"), map[string]string{"Expect": "100-continue"})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("small PUT: %d", resp.StatusCode)
+	}
+}
+
+// TestHTTPMultipartEmptyParts documents that zero-byte parts are accepted at
+// upload time, whether or not they end up last. Completion then applies the
+// minimum part size to every part but the last.
+func TestHTTPMultipartEmptyParts(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	full := strings.Repeat("x", int(minPartSize))
+	for _, tc := range []struct {
+		key      string
+		parts    []string
+		tooSmall bool
+	}{
+		{"empty-object.bin", []string{""}, false},
+		{"empty-last.bin", []string{full, ""}, false},
+		{"empty-first.bin", []string{"", "data"}, true},
+	} {
+		resp := mustDo(t, "POST", srv.URL+"/mybucket/"+tc.key+"?uploads", nil, nil)
+		var initResult InitiateMultipartUploadResult
+		xml.Unmarshal([]byte(readBody(t, resp)), &initResult)
+
+		var completeXML strings.Builder
+		completeXML.WriteString("<CompleteMultipartUpload>")
+		for i, data := range tc.parts {
+			resp := mustDo(t, "PUT", fmt.Sprintf("%s/mybucket/%s?partNumber=%d&uploadId=%s",
+				srv.URL, tc.key, i+1, initResult.UploadId), strings.NewReader(data), nil)
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Fatalf("%s: part %d: %d", tc.key, i+1, resp.StatusCode)
+			}
+			fmt.Fprintf(&completeXML, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, resp.Header.Get("ETag"))
+		}
+		completeXML.WriteString("</CompleteMultipartUpload>")
+
+		resp = mustDo(t, "POST", fmt.Sprintf("%s/mybucket/%s?uploadId=%s", srv.URL, tc.key, initResult.UploadId),
+			strings.NewReader(completeXML.String()), nil)
+		body := readBody(t, resp)
+		if tc.tooSmall {
+			if resp.StatusCode != 400 || !strings.Contains(body, "<Code>EntityTooSmall</Code>") {
+				t.Errorf("%s: complete: %d %s, want EntityTooSmall", tc.key, resp.StatusCode, body)
+			}
+			continue
+		}
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: complete: %d %s", tc.key, resp.StatusCode, body)
+		}
+		if got, want := readBody(t, mustDo(t, "GET", srv.URL+"/mybucket/"+tc.key, nil, nil)), strings.Join(tc.parts, ""); got != want {
+			t.Errorf("%s: content of %d bytes, want %d", tc.key, len(got), len(want))
+		}
+	}
+}
+
 func TestHTTPMultipartUploadInvalidPartNumber(t *testing.T) {
 	srv, _ := setupTestServer(t)
 	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
@@ -1400,35 +3284,251 @@ func TestHTTPMultipartUploadInvalidPartNumber(t *testing.T) {
 	if badResp2.StatusCode != 400 {
 		t.Errorf("non-numeric part: expected 400, got %d", badResp2.StatusCode)
 	}
-}
+}
+
+func TestHTTPUploadPartConcurrencyLimit(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	handler := NewS3Handler(storage, &NoOpAuthenticator{})
+	handler.SetMaxConcurrentParts(1)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	resp := mustDo(t, "POST", srv.URL+"/mybucket/file.txt?uploads", nil, nil)
+	var initResult InitiateMultipartUploadResult
+	xml.Unmarshal([]byte(readBody(t, resp)), &initResult)
+	uploadID := initResult.UploadId
+	partURL := func(n int) string {
+		return fmt.Sprintf("%s/mybucket/file.txt?partNumber=%d&uploadId=%s", srv.URL, n, uploadID)
+	}
+
+	// Hold part 1 open until the second request has been rejected.
+	pr, pw := io.Pipe()
+	done := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest("PUT", partURL(1), pr)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	pw.Write([]byte("part-one-"))
+	for i := 0; ; i++ {
+		handler.partsMu.Lock()
+		active := handler.activeParts[uploadID]
+		handler.partsMu.Unlock()
+		if active == 1 {
+			break
+		}
+		if i == 500 {
+			t.Fatal("part 1 never became active")
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	resp = mustDo(t, "PUT", partURL(2), strings.NewReader("part-two"), nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, "SlowDown") {
+		t.Errorf("second concurrent part: %d %s", resp.StatusCode, body)
+	}
+
+	// Another upload ID has its own budget.
+	resp = mustDo(t, "POST", srv.URL+"/mybucket/other.txt?uploads", nil, nil)
+	var other InitiateMultipartUploadResult
+	xml.Unmarshal([]byte(readBody(t, resp)), &other)
+	resp = mustDo(t, "PUT",
+		fmt.Sprintf("%s/mybucket/other.txt?partNumber=1&uploadId=%s", srv.URL, other.UploadId),
+		strings.NewReader("x"), nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("part for other upload: %d", resp.StatusCode)
+	}
+
+	pw.Close()
+	if code := <-done; code != 200 {
+		t.Fatalf("part 1: %d", code)
+	}
+
+	// Once part 1 finished the slot is free again.
+	resp = mustDo(t, "PUT", partURL(2), strings.NewReader("part-two"), nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("part 2 after part 1 finished: %d", resp.StatusCode)
+	}
+
+	handler.partsMu.Lock()
+	remaining := len(handler.activeParts)
+	handler.partsMu.Unlock()
+	if remaining != 0 {
+		t.Errorf("activeParts not cleaned up: %d entries", remaining)
+	}
+}
+
+func TestHTTPMultipartUploadNonExistentBucket(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	resp := mustDo(t, "POST", srv.URL+"/ghostbucket/file.txt?uploads", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("initiate in non-existent bucket: expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPMultipartCompleteMalformedXML(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	resp := mustDo(t, "POST", srv.URL+"/mybucket/file.txt?uploads", nil, nil)
+	body := readBody(t, resp)
+	var initResult InitiateMultipartUploadResult
+	xml.Unmarshal([]byte(body), &initResult)
+	uploadID := initResult.UploadId
+
+	// Send malformed XML
+	completeResp := mustDo(t, "POST",
+		fmt.Sprintf("%s/mybucket/file.txt?uploadId=%s", srv.URL, uploadID),
+		strings.NewReader("not xml at all <<<<"), nil)
+	completeResp.Body.Close()
+	if completeResp.StatusCode != 400 {
+		t.Errorf("malformed XML: expected 400, got %d", completeResp.StatusCode)
+	}
+}
+
+func TestHTTPMultipartEntityTooSmall(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	resp := mustDo(t, "POST", srv.URL+"/mybucket/file.txt?uploads", nil, nil)
+	var initResult InitiateMultipartUploadResult
+	xml.Unmarshal([]byte(readBody(t, resp)), &initResult)
+	uploadID := initResult.UploadId
+
+	var etags []string
+	for i, data := range []string{"too short", "tail"} {
+		partResp := mustDo(t, "PUT",
+			fmt.Sprintf("%s/mybucket/file.txt?partNumber=%d&uploadId=%s", srv.URL, i+1, uploadID),
+			strings.NewReader(data), nil)
+		partResp.Body.Close()
+		etags = append(etags, partResp.Header.Get("ETag"))
+	}
+
+	completeXML := fmt.Sprintf(`<CompleteMultipartUpload>
+		<Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part>
+		<Part><PartNumber>2</PartNumber><ETag>%s</ETag></Part>
+	</CompleteMultipartUpload>`, etags[0], etags[1])
+	completeResp := mustDo(t, "POST",
+		fmt.Sprintf("%s/mybucket/file.txt?uploadId=%s", srv.URL, uploadID),
+		strings.NewReader(completeXML), nil)
+	body := readBody(t, completeResp)
+
+	if completeResp.StatusCode != 400 {
+		t.Fatalf("expected 400, got %d: %s", completeResp.StatusCode, body)
+	}
+	var errResp ErrorResponse
+	if err := xml.Unmarshal([]byte(body), &errResp); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if errResp.Code != "EntityTooSmall" {
+		t.Errorf("Code: %q", errResp.Code)
+	}
+	if !strings.Contains(errResp.Message, "part 1") {
+		t.Errorf("Message should name the part: %q", errResp.Message)
+	}
+}
+
+func TestHTTPMultipartInvalidPartOrder(t *testing.T) {
+	allowSmallParts(t)
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	resp := mustDo(t, "POST", srv.URL+"/mybucket/file.txt?uploads", nil, nil)
+	var initResult InitiateMultipartUploadResult
+	xml.Unmarshal([]byte(readBody(t, resp)), &initResult)
+	uploadID := initResult.UploadId
+
+	var etags []string
+	for i, data := range []string{"first", "second"} {
+		partResp := mustDo(t, "PUT",
+			fmt.Sprintf("%s/mybucket/file.txt?partNumber=%d&uploadId=%s", srv.URL, i+1, uploadID),
+			strings.NewReader(data), nil)
+		partResp.Body.Close()
+		etags = append(etags, partResp.Header.Get("ETag"))
+	}
 
-func TestHTTPMultipartUploadNonExistentBucket(t *testing.T) {
-	srv, _ := setupTestServer(t)
+	completeXML := fmt.Sprintf(`<CompleteMultipartUpload>
+		<Part><PartNumber>2</PartNumber><ETag>%s</ETag></Part>
+		<Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part>
+	</CompleteMultipartUpload>`, etags[1], etags[0])
+	completeResp := mustDo(t, "POST",
+		fmt.Sprintf("%s/mybucket/file.txt?uploadId=%s", srv.URL, uploadID),
+		strings.NewReader(completeXML), nil)
+	body := readBody(t, completeResp)
 
-	resp := mustDo(t, "POST", srv.URL+"/ghostbucket/file.txt?uploads", nil, nil)
-	resp.Body.Close()
-	if resp.StatusCode != 404 {
-		t.Errorf("initiate in non-existent bucket: expected 404, got %d", resp.StatusCode)
+	if completeResp.StatusCode != 400 {
+		t.Fatalf("expected 400, got %d: %s", completeResp.StatusCode, body)
+	}
+	var errResp ErrorResponse
+	if err := xml.Unmarshal([]byte(body), &errResp); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if errResp.Code != "InvalidPartOrder" {
+		t.Errorf("Code: %q", errResp.Code)
 	}
 }
 
-func TestHTTPMultipartCompleteMalformedXML(t *testing.T) {
+func TestHTTPMultipartCompleteMissingPartIsInvalidPart(t *testing.T) {
+	allowSmallParts(t)
 	srv, _ := setupTestServer(t)
 	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
 
 	resp := mustDo(t, "POST", srv.URL+"/mybucket/file.txt?uploads", nil, nil)
-	body := readBody(t, resp)
 	var initResult InitiateMultipartUploadResult
-	xml.Unmarshal([]byte(body), &initResult)
+	xml.Unmarshal([]byte(readBody(t, resp)), &initResult)
 	uploadID := initResult.UploadId
 
-	// Send malformed XML
+	partResp := mustDo(t, "PUT",
+		fmt.Sprintf("%s/mybucket/file.txt?partNumber=1&uploadId=%s", srv.URL, uploadID),
+		strings.NewReader("part-one"), nil)
+	partResp.Body.Close()
+	etag1 := partResp.Header.Get("ETag")
+
+	// Part 3 was never uploaded.
+	completeXML := fmt.Sprintf(`<CompleteMultipartUpload>
+		<Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part>
+		<Part><PartNumber>3</PartNumber><ETag>"0123456789abcdef0123456789abcdef"</ETag></Part>
+	</CompleteMultipartUpload>`, etag1)
 	completeResp := mustDo(t, "POST",
 		fmt.Sprintf("%s/mybucket/file.txt?uploadId=%s", srv.URL, uploadID),
-		strings.NewReader("not xml at all <<<<"), nil)
-	completeResp.Body.Close()
+		strings.NewReader(completeXML), nil)
+	body := readBody(t, completeResp)
+
 	if completeResp.StatusCode != 400 {
-		t.Errorf("malformed XML: expected 400, got %d", completeResp.StatusCode)
+		t.Fatalf("expected 400, got %d: %s", completeResp.StatusCode, body)
+	}
+	var errResp ErrorResponse
+	if err := xml.Unmarshal([]byte(body), &errResp); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if errResp.Code != "InvalidPart" {
+		t.Errorf("Code: %q", errResp.Code)
+	}
+	if !strings.Contains(errResp.Message, "part 3") || !strings.Contains(errResp.Message, "0123456789abcdef0123456789abcdef") {
+		t.Errorf("Message should name the part and ETag: %q", errResp.Message)
+	}
+
+	// The upload is still intact and can be completed with the real parts.
+	completeXML = fmt.Sprintf(`<CompleteMultipartUpload>
+		<Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part>
+	</CompleteMultipartUpload>`, etag1)
+	completeResp = mustDo(t, "POST",
+		fmt.Sprintf("%s/mybucket/file.txt?uploadId=%s", srv.URL, uploadID),
+		strings.NewReader(completeXML), nil)
+	completeResp.Body.Close()
+	if completeResp.StatusCode != 200 {
+		t.Errorf("complete with uploaded parts: %d", completeResp.StatusCode)
 	}
 }
 
@@ -1485,6 +3585,38 @@ func TestHTTPCustomMetadataHeaders(t *testing.T) {
 	}
 }
 
+func TestHTTPCustomMetadataMixedCase(t *testing.T) {
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	serve := func(method, path string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		for k, v := range headers {
+			req.Header[k] = []string{v}
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	serve("PUT", "/mybucket", nil, nil)
+	if rec := serve("PUT", "/mybucket/obj.txt", strings.NewReader("x"), map[string]string{"X-Amz-Meta-CamelCase": "Value"}); rec.Code != 200 {
+		t.Fatalf("put: %d", rec.Code)
+	}
+
+	// GET and HEAD send the name exactly as S3 does: lowercased, not in
+	// Go's canonical form.
+	for _, method := range []string{"GET", "HEAD"} {
+		header := serve(method, "/mybucket/obj.txt", nil, nil).Header()
+		if got := header["x-amz-meta-camelcase"]; len(got) != 1 || got[0] != "Value" {
+			t.Errorf("%s: x-amz-meta-camelcase = %v, headers %v", method, got, header)
+		}
+		for name := range header {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") && name != "x-amz-meta-camelcase" {
+				t.Errorf("%s: unexpected metadata header %q", method, name)
+			}
+		}
+	}
+}
+
 func TestHTTPStandardHeaders(t *testing.T) {
 	srv, _ := setupTestServer(t)
 	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
@@ -1550,6 +3682,115 @@ func TestHTTPStandardHeaders(t *testing.T) {
 	}
 }
 
+func TestHTTPDefaultCacheControl(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	handler := NewS3Handler(storage, &NoOpAuthenticator{})
+	handler.SetDefaultCacheControl("public, max-age=3600")
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/plain.css", strings.NewReader("a{}"), nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/stored.css", strings.NewReader("b{}"),
+		map[string]string{"Cache-Control": "no-store"}).Body.Close()
+
+	for _, method := range []string{"GET", "HEAD"} {
+		for key, want := range map[string]string{"plain.css": "public, max-age=3600", "stored.css": "no-store"} {
+			resp := mustDo(t, method, srv.URL+"/mybucket/"+key, nil, nil)
+			resp.Body.Close()
+			if got := resp.Header.Get("Cache-Control"); got != want {
+				t.Errorf("%s %s: Cache-Control = %q, want %q", method, key, got, want)
+			}
+		}
+	}
+
+	// The default is never written into the stored metadata.
+	meta, err := storage.HeadObject("mybucket", "plain.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.CacheControl != "" {
+		t.Errorf("stored CacheControl = %q", meta.CacheControl)
+	}
+}
+
+func TestHTTPGetResponseHeaderOverrides(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	storage.CreateBucket("mybucket")
+	large := strings.Repeat("x", smallObjectThreshold+1)
+	for key, body := range map[string]string{"small.pdf": "pdf", "large.pdf": large} {
+		if _, err := storage.PutObject("mybucket", key, strings.NewReader(body), &PutObjectInput{
+			ContentType:  "application/pdf",
+			CacheControl: "no-store",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	overrides := url.Values{
+		"response-content-type":        {"text/plain"},
+		"response-content-disposition": {`attachment; filename="report.pdf"`},
+		"response-cache-control":       {"max-age=60"},
+		"response-content-encoding":    {"identity"},
+		"response-expires":             {"Thu, 01 Dec 2026 16:00:00 GMT"},
+	}
+	want := map[string]string{
+		"Content-Type":        "text/plain",
+		"Content-Disposition": `attachment; filename="report.pdf"`,
+		"Cache-Control":       "max-age=60",
+		"Content-Encoding":    "identity",
+		"Expires":             "Thu, 01 Dec 2026 16:00:00 GMT",
+	}
+
+	// Small objects, ServeContent and non-seekable readers all apply them.
+	for _, backend := range []Storage{storage, streamingStorage{storage}} {
+		srv := httptest.NewServer(NewS3Handler(backend, &NoOpAuthenticator{}))
+		for _, key := range []string{"small.pdf", "large.pdf"} {
+			resp := mustDo(t, "GET", srv.URL+"/mybucket/"+key+"?"+overrides.Encode(), nil, nil)
+			resp.Body.Close()
+			for header, value := range want {
+				if got := resp.Header.Get(header); got != value {
+					t.Errorf("%T %s: %s = %q, want %q", backend, key, header, got, value)
+				}
+			}
+		}
+		srv.Close()
+	}
+
+	srv := httptest.NewServer(NewS3Handler(storage, &NoOpAuthenticator{}))
+	defer srv.Close()
+
+	// Ranged reads too.
+	resp := mustDo(t, "GET", srv.URL+"/mybucket/large.pdf?response-content-type=text%2Fplain", nil,
+		map[string]string{"Range": "bytes=0-9"})
+	resp.Body.Close()
+	if resp.StatusCode != 206 || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("range: %d Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// HEAD ignores them.
+	resp = mustDo(t, "HEAD", srv.URL+"/mybucket/small.pdf?"+overrides.Encode(), nil, nil)
+	resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "application/pdf" {
+		t.Errorf("HEAD Content-Type = %q", got)
+	}
+
+	for _, query := range []string{"response-content-type=", "response-expires"} {
+		resp := mustDo(t, "GET", srv.URL+"/mybucket/small.pdf?"+query, nil, nil)
+		body := readBody(t, resp)
+		if resp.StatusCode != 400 || !strings.Contains(body, "InvalidArgument") {
+			t.Errorf("%s: %d %s", query, resp.StatusCode, body)
+		}
+	}
+
+	// Without overrides the stored values are served.
+	resp = mustDo(t, "GET", srv.URL+"/mybucket/small.pdf", nil, nil)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/pdf" || resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("stored headers: %v", resp.Header)
+	}
+}
+
 func TestHTTPNoStandardHeadersWhenNotSet(t *testing.T) {
 	srv, _ := setupTestServer(t)
 	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
@@ -1572,6 +3813,235 @@ func TestHTTPNoStandardHeadersWhenNotSet(t *testing.T) {
 	}
 }
 
+func TestHTTPCustomMetadataCaseCollision(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	put := func(key string, a, b string) *http.Response {
+		req, _ := http.NewRequest("PUT", srv.URL+"/mybucket/"+key, strings.NewReader("x"))
+		// Set non-canonical names directly so both are sent as written.
+		req.Header["x-amz-meta-Foo"] = []string{a}
+		req.Header["x-amz-meta-foo"] = []string{b}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := put("conflict", "one", "two")
+	body := readBody(t, resp)
+	if resp.StatusCode != 400 || !strings.Contains(body, "InvalidArgument") {
+		t.Errorf("conflicting meta: %d %s", resp.StatusCode, body)
+	}
+
+	resp = put("same", "v", "v")
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("identical duplicates: %d", resp.StatusCode)
+	}
+	resp = mustDo(t, "HEAD", srv.URL+"/mybucket/same", nil, nil)
+	resp.Body.Close()
+	if got := resp.Header.Values("X-Amz-Meta-Foo"); len(got) != 1 || got[0] != "v" {
+		t.Errorf("stored meta: %q", got)
+	}
+}
+
+func TestCustomMetadataDeterministic(t *testing.T) {
+	header := http.Header{
+		"X-Amz-Meta-B": {"2"},
+		"x-amz-meta-a": {"1"},
+		"X-Amz-Meta-A": {"1"},
+		"Content-Type": {"text/plain"},
+	}
+	meta, err := customMetadata(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(meta, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("got %v", meta)
+	}
+
+	header["x-amz-meta-b"] = []string{"3"}
+	for i := 0; i < 20; i++ {
+		if _, err := customMetadata(header); err == nil {
+			t.Fatal("conflict not detected")
+		}
+	}
+	if meta, _ := customMetadata(http.Header{"Content-Type": {"x"}}); meta != nil {
+		t.Errorf("expected nil map, got %v", meta)
+	}
+}
+
+func TestHTTPPutObjectTaggingHeader(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/tagged.txt", strings.NewReader("x"),
+		map[string]string{"x-amz-tagging": "project=gecko&team=storage%20ops&empty="})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("PUT with tagging: %d", resp.StatusCode)
+	}
+
+	resp = mustDo(t, "GET", srv.URL+"/mybucket/tagged.txt?tagging", nil, nil)
+	var tagging Tagging
+	if err := xml.Unmarshal([]byte(readBody(t, resp)), &tagging); err != nil {
+		t.Fatal(err)
+	}
+	want := []Tag{{"empty", ""}, {"project", "gecko"}, {"team", "storage ops"}}
+	if !reflect.DeepEqual(tagging.TagSet, want) {
+		t.Errorf("tags %v, want %v", tagging.TagSet, want)
+	}
+
+	resp = mustDo(t, "HEAD", srv.URL+"/mybucket/tagged.txt", nil, nil)
+	resp.Body.Close()
+	if got := resp.Header.Get("x-amz-tagging-count"); got != "3" {
+		t.Errorf("x-amz-tagging-count = %q", got)
+	}
+
+	// Copies keep their tags.
+	mustDo(t, "PUT", srv.URL+"/mybucket/copy.txt", nil,
+		map[string]string{"x-amz-copy-source": "/mybucket/tagged.txt"}).Body.Close()
+	resp = mustDo(t, "GET", srv.URL+"/mybucket/copy.txt?tagging", nil, nil)
+	if body := readBody(t, resp); !strings.Contains(body, "<Key>project</Key>") {
+		t.Errorf("copy lost tags: %s", body)
+	}
+
+	tooMany := make([]string, 11)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("k%d=v", i)
+	}
+	for _, bad := range []string{
+		strings.Join(tooMany, "&"),
+		"a=1&a=2",
+		"aws:created=now",
+		strings.Repeat("k", 129) + "=v",
+		"k=" + strings.Repeat("v", 257),
+		"=v",
+	} {
+		resp := mustDo(t, "PUT", srv.URL+"/mybucket/bad.txt", strings.NewReader("x"),
+			map[string]string{"x-amz-tagging": bad})
+		body := readBody(t, resp)
+		if resp.StatusCode != 400 || !strings.Contains(body, "InvalidTag") {
+			t.Errorf("tagging %.40q: %d %s", bad, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestHTTPObjectTaggingSubresource(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/obj", strings.NewReader("data"), nil).Body.Close()
+
+	tagging := `<Tagging xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><TagSet>` +
+		`<Tag><Key>team</Key><Value>storage</Value></Tag><Tag><Key>env</Key><Value>dev</Value></Tag>` +
+		`</TagSet></Tagging>`
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/obj?tagging", strings.NewReader(tagging), nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("PUT ?tagging: %d", resp.StatusCode)
+	}
+	if body := readBody(t, mustDo(t, "GET", srv.URL+"/mybucket/obj", nil, nil)); body != "data" {
+		t.Errorf("PUT ?tagging overwrote the object: %q", body)
+	}
+
+	resp = mustDo(t, "GET", srv.URL+"/mybucket/obj?tagging", nil, nil)
+	var got Tagging
+	if err := xml.Unmarshal([]byte(readBody(t, resp)), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []Tag{{Key: "env", Value: "dev"}, {Key: "team", Value: "storage"}}
+	if !reflect.DeepEqual(got.TagSet, want) {
+		t.Errorf("tag set = %+v, want %+v", got.TagSet, want)
+	}
+
+	var eleven strings.Builder
+	for i := 0; i < 11; i++ {
+		fmt.Fprintf(&eleven, "<Tag><Key>k%d</Key><Value>v</Value></Tag>", i)
+	}
+	for name, body := range map[string]string{
+		"too many": "<Tagging><TagSet>" + eleven.String() + "</TagSet></Tagging>",
+		"reserved": "<Tagging><TagSet><Tag><Key>aws:x</Key><Value>v</Value></Tag></TagSet></Tagging>",
+	} {
+		resp := mustDo(t, "PUT", srv.URL+"/mybucket/obj?tagging", strings.NewReader(body), nil)
+		if b := readBody(t, resp); resp.StatusCode != 400 || !strings.Contains(b, "<Code>InvalidTag</Code>") {
+			t.Errorf("%s: %d %s", name, resp.StatusCode, b)
+		}
+	}
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket/missing?tagging", strings.NewReader(tagging), nil)
+	if b := readBody(t, resp); resp.StatusCode != 404 || !strings.Contains(b, "<Code>NoSuchKey</Code>") {
+		t.Errorf("PUT ?tagging on a missing key: %d %s", resp.StatusCode, b)
+	}
+
+	resp = mustDo(t, "DELETE", srv.URL+"/mybucket/obj?tagging", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 204 {
+		t.Fatalf("DELETE ?tagging: %d", resp.StatusCode)
+	}
+	resp = mustDo(t, "HEAD", srv.URL+"/mybucket/obj", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("DELETE ?tagging removed the object: %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("x-amz-tagging-count"); got != "" {
+		t.Errorf("x-amz-tagging-count after delete = %q", got)
+	}
+}
+
+func TestHTTPCopyObjectTaggingDirective(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket/src.txt", strings.NewReader("x"),
+		map[string]string{"x-amz-tagging": "stage=raw"}).Body.Close()
+
+	tagsOf := func(key string) []Tag {
+		t.Helper()
+		resp := mustDo(t, "GET", srv.URL+"/mybucket/"+key+"?tagging", nil, nil)
+		var tagging Tagging
+		if err := xml.Unmarshal([]byte(readBody(t, resp)), &tagging); err != nil {
+			t.Fatal(err)
+		}
+		return tagging.TagSet
+	}
+
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		want    []Tag
+	}{
+		{"default", nil, []Tag{{"stage", "raw"}}},
+		{"copy", map[string]string{"x-amz-tagging-directive": "COPY", "x-amz-tagging": "ignored=1"}, []Tag{{"stage", "raw"}}},
+		{"replace", map[string]string{"x-amz-tagging-directive": "REPLACE", "x-amz-tagging": "stage=clean"}, []Tag{{"stage", "clean"}}},
+		{"replace-empty", map[string]string{"x-amz-tagging-directive": "REPLACE"}, nil},
+		// Tags follow their own directive, independent of metadata.
+		{"meta-replace", map[string]string{"x-amz-metadata-directive": "REPLACE", "x-amz-tagging-directive": "REPLACE", "x-amz-tagging": "a=b"}, []Tag{{"a", "b"}}},
+	} {
+		headers := map[string]string{"x-amz-copy-source": "/mybucket/src.txt"}
+		for k, v := range tc.headers {
+			headers[k] = v
+		}
+		resp := mustDo(t, "PUT", srv.URL+"/mybucket/"+tc.name, nil, headers)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: copy status %d", tc.name, resp.StatusCode)
+		}
+		if got := tagsOf(tc.name); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: tags %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/bad", nil, map[string]string{
+		"x-amz-copy-source":       "/mybucket/src.txt",
+		"x-amz-tagging-directive": "REPLACE",
+		"x-amz-tagging":           "aws:x=1",
+	})
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("invalid replacement tags: %d", resp.StatusCode)
+	}
+}
+
 func TestHTTPMetadataOverwriteReplacesAll(t *testing.T) {
 	srv, _ := setupTestServer(t)
 	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
@@ -1590,17 +4060,168 @@ func TestHTTPMetadataOverwriteReplacesAll(t *testing.T) {
 			"Cache-Control":   "max-age=600",
 		}).Body.Close()
 
-	headResp := mustDo(t, "HEAD", srv.URL+"/mybucket/evolve.txt", nil, nil)
-	headResp.Body.Close()
+	headResp := mustDo(t, "HEAD", srv.URL+"/mybucket/evolve.txt", nil, nil)
+	headResp.Body.Close()
+
+	if headResp.Header.Get("x-amz-meta-key2") != "val2" {
+		t.Errorf("key2: %q", headResp.Header.Get("x-amz-meta-key2"))
+	}
+	if headResp.Header.Get("x-amz-meta-key1") != "" {
+		t.Errorf("key1 should be gone after overwrite: %q", headResp.Header.Get("x-amz-meta-key1"))
+	}
+	if headResp.Header.Get("Cache-Control") != "max-age=600" {
+		t.Errorf("Cache-Control: %q", headResp.Header.Get("Cache-Control"))
+	}
+}
+
+func TestHTTPContentTypeCharsetPreserved(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	// Mixed case and spacing must come back byte for byte.
+	const ct = "text/html; Charset=UTF-8"
+	small := "<p>héllo wörld</p>"
+	large := strings.Repeat("<p>grüße</p>", 4096) // past the small-object fast path
+
+	for name, body := range map[string]string{"small.html": small, "large.html": large} {
+		resp := mustDo(t, "PUT", srv.URL+"/mybucket/"+name, strings.NewReader(body),
+			map[string]string{"Content-Type": ct})
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("PUT %s: %d", name, resp.StatusCode)
+		}
+
+		for _, method := range []string{"GET", "HEAD"} {
+			resp := mustDo(t, method, srv.URL+"/mybucket/"+name, nil, nil)
+			resp.Body.Close()
+			if got := resp.Header.Get("Content-Type"); got != ct {
+				t.Errorf("%s %s: Content-Type %q, want %q", method, name, got, ct)
+			}
+		}
+	}
+
+	// Copies keep it too.
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/copy.html", nil,
+		map[string]string{"X-Amz-Copy-Source": "/mybucket/small.html"})
+	resp.Body.Close()
+	resp = mustDo(t, "HEAD", srv.URL+"/mybucket/copy.html", nil, nil)
+	resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != ct {
+		t.Errorf("copy: Content-Type %q, want %q", got, ct)
+	}
+
+	// And multipart uploads.
+	resp = mustDo(t, "POST", srv.URL+"/mybucket/mp.html?uploads", nil, map[string]string{"Content-Type": ct})
+	var initResult InitiateMultipartUploadResult
+	xml.Unmarshal([]byte(readBody(t, resp)), &initResult)
+	partResp := mustDo(t, "PUT",
+		fmt.Sprintf("%s/mybucket/mp.html?partNumber=1&uploadId=%s", srv.URL, initResult.UploadId),
+		strings.NewReader(small), nil)
+	partResp.Body.Close()
+	completeXML := fmt.Sprintf(`<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part></CompleteMultipartUpload>`,
+		partResp.Header.Get("ETag"))
+	mustDo(t, "POST", fmt.Sprintf("%s/mybucket/mp.html?uploadId=%s", srv.URL, initResult.UploadId),
+		strings.NewReader(completeXML), nil).Body.Close()
+	resp = mustDo(t, "GET", srv.URL+"/mybucket/mp.html", nil, nil)
+	resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != ct {
+		t.Errorf("multipart: Content-Type %q, want %q", got, ct)
+	}
+}
+
+func TestHTTPErrorDocument(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	handler := NewS3Handler(storage, &NoOpAuthenticator{})
+	if err := handler.SetErrorDocument("site/404.html"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	mustDo(t, "PUT", srv.URL+"/site", nil, nil).Body.Close()
+
+	// Until the document exists the XML error is returned.
+	resp := mustDo(t, "GET", srv.URL+"/site/missing", nil, nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != 404 || !strings.Contains(body, "<Code>NoSuchKey</Code>") {
+		t.Fatalf("without document: %d %s", resp.StatusCode, body)
+	}
+
+	const page = "<h1>Not here</h1>"
+	mustDo(t, "PUT", srv.URL+"/site/404.html", strings.NewReader(page),
+		map[string]string{"Content-Type": "text/html"}).Body.Close()
+
+	for _, path := range []string{"/site/missing", "/nobucket/missing"} {
+		resp := mustDo(t, "GET", srv.URL+path, nil, nil)
+		body := readBody(t, resp)
+		if resp.StatusCode != 404 || body != page {
+			t.Errorf("GET %s: %d %q, want 404 %q", path, resp.StatusCode, body, page)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/html" {
+			t.Errorf("GET %s: Content-Type %q", path, ct)
+		}
+	}
+
+	resp = mustDo(t, "HEAD", srv.URL+"/site/missing", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 404 || resp.Header.Get("Content-Type") == "text/html" {
+		t.Errorf("HEAD: %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	resp = mustDo(t, "GET", srv.URL+"/nobucket", nil, nil)
+	body = readBody(t, resp)
+	if !strings.Contains(body, "<Code>NoSuchBucket</Code>") {
+		t.Errorf("list of missing bucket: %d %s", resp.StatusCode, body)
+	}
+
+	if err := handler.SetErrorDocument("no-key"); err == nil {
+		t.Error("SetErrorDocument accepted a path without a key")
+	}
+}
+
+func TestHTTPIndexDocument(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	handler := NewS3Handler(storage, &NoOpAuthenticator{})
+	if err := handler.SetIndexDocument("index.html"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	mustDo(t, "PUT", srv.URL+"/site", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/site/index.html", strings.NewReader("root"),
+		map[string]string{"Content-Type": "text/html"}).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/site/docs/index.html", strings.NewReader("docs"), nil).Body.Close()
+
+	for path, want := range map[string]string{"/site": "root", "/site/": "root", "/site/docs/": "docs"} {
+		resp := mustDo(t, "GET", srv.URL+path, nil, nil)
+		if body := readBody(t, resp); resp.StatusCode != 200 || body != want {
+			t.Errorf("GET %s: %d %q, want 200 %q", path, resp.StatusCode, body, want)
+		}
+	}
 
-	if headResp.Header.Get("x-amz-meta-key2") != "val2" {
-		t.Errorf("key2: %q", headResp.Header.Get("x-amz-meta-key2"))
+	// Listings with a query string are unaffected.
+	resp := mustDo(t, "GET", srv.URL+"/site?list-type=2", nil, nil)
+	if body := readBody(t, resp); !strings.Contains(body, "<ListBucketResult") {
+		t.Errorf("list: %d %s", resp.StatusCode, body)
 	}
-	if headResp.Header.Get("x-amz-meta-key1") != "" {
-		t.Errorf("key1 should be gone after overwrite: %q", headResp.Header.Get("x-amz-meta-key1"))
+
+	// A directory without an index falls back to the error document.
+	mustDo(t, "PUT", srv.URL+"/site/404.html", strings.NewReader("gone"), nil).Body.Close()
+	resp = mustDo(t, "GET", srv.URL+"/site/empty/", nil, nil)
+	if body := readBody(t, resp); resp.StatusCode != 404 || !strings.Contains(body, "NoSuchKey") {
+		t.Errorf("missing index without error document: %d %s", resp.StatusCode, body)
 	}
-	if headResp.Header.Get("Cache-Control") != "max-age=600" {
-		t.Errorf("Cache-Control: %q", headResp.Header.Get("Cache-Control"))
+	if err := handler.SetErrorDocument("site/404.html"); err != nil {
+		t.Fatal(err)
+	}
+	resp = mustDo(t, "GET", srv.URL+"/site/empty/", nil, nil)
+	if body := readBody(t, resp); resp.StatusCode != 404 || body != "gone" {
+		t.Errorf("missing index with error document: %d %q", resp.StatusCode, body)
+	}
+
+	if err := handler.SetIndexDocument("a/index.html"); err == nil {
+		t.Error("SetIndexDocument accepted a name with a slash")
 	}
 }
 
@@ -1853,6 +4474,59 @@ func TestHTTPSHA256WrongHashPreservesExistingObject(t *testing.T) {
 	}
 }
 
+func TestHTTPContentMD5(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	contentMD5 := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/k", strings.NewReader("original"),
+		map[string]string{"Content-MD5": contentMD5("original")})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("matching Content-MD5: %d", resp.StatusCode)
+	}
+
+	for _, tc := range []struct{ header, code string }{
+		{contentMD5("something else"), "BadDigest"},
+		{"not base64!", "InvalidDigest"},
+		{base64.StdEncoding.EncodeToString([]byte("short")), "InvalidDigest"},
+	} {
+		resp := mustDo(t, "PUT", srv.URL+"/mybucket/k", strings.NewReader("replacement"),
+			map[string]string{"Content-MD5": tc.header})
+		body := readBody(t, resp)
+		if resp.StatusCode != 400 || !strings.Contains(body, "<Code>"+tc.code+"</Code>") {
+			t.Errorf("Content-MD5 %q: %d %s", tc.header, resp.StatusCode, body)
+		}
+	}
+	if got := readBody(t, mustDo(t, "GET", srv.URL+"/mybucket/k", nil, nil)); got != "original" {
+		t.Errorf("content = %q, want the original", got)
+	}
+}
+
+func TestHTTPEmptySHA256Constant(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	headers := map[string]string{"X-Amz-Content-Sha256": emptySHA256}
+
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/empty", nil, headers)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("empty body: %d", resp.StatusCode)
+	}
+
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket/empty", strings.NewReader("oops"), headers)
+	body := readBody(t, resp)
+	if resp.StatusCode != 400 || !strings.Contains(body, "BadDigest") {
+		t.Errorf("non-empty body: %d %s", resp.StatusCode, body)
+	}
+	if got := readBody(t, mustDo(t, "GET", srv.URL+"/mybucket/empty", nil, nil)); got != "" {
+		t.Errorf("object = %q, want empty", got)
+	}
+}
+
 func TestHTTPSHA256BadDigestErrorFormat(t *testing.T) {
 	srv, _ := setupTestServer(t)
 	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
@@ -2021,6 +4695,177 @@ func TestCORSAllowedMethods(t *testing.T) {
 	}
 }
 
+// setupBucketCORSServer serves "web" through the bucket-aware CORS middleware
+// with one credentialed rule for https://app.example.com and one anonymous
+// read-only rule for any origin.
+func setupBucketCORSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	srv := httptest.NewServer(handler.CORSMiddleware(handler))
+	t.Cleanup(srv.Close)
+
+	mustDo(t, "PUT", srv.URL+"/web", nil, nil).Body.Close()
+	mustDo(t, "PUT", srv.URL+"/web/a.txt", strings.NewReader("x"), nil).Body.Close()
+	config := `<CORSConfiguration>
+  <CORSRule>
+    <AllowedOrigin>https://app.example.com</AllowedOrigin>
+    <AllowedMethod>GET</AllowedMethod>
+    <AllowedMethod>PUT</AllowedMethod>
+    <AllowedHeader>x-amz-*</AllowedHeader>
+    <ExposeHeader>ETag</ExposeHeader>
+    <MaxAgeSeconds>600</MaxAgeSeconds>
+    <AllowCredentials>true</AllowCredentials>
+  </CORSRule>
+  <CORSRule>
+    <AllowedOrigin>*</AllowedOrigin>
+    <AllowedMethod>GET</AllowedMethod>
+  </CORSRule>
+</CORSConfiguration>`
+	resp := mustDo(t, "PUT", srv.URL+"/web?cors", strings.NewReader(config), nil)
+	if body := readBody(t, resp); resp.StatusCode != 200 {
+		t.Fatalf("PUT ?cors: %d %s", resp.StatusCode, body)
+	}
+	return srv
+}
+
+func TestBucketCORSCredentialedRule(t *testing.T) {
+	srv := setupBucketCORSServer(t)
+
+	resp := mustDo(t, "GET", srv.URL+"/web/a.txt", nil, map[string]string{"Origin": "https://app.example.com"})
+	resp.Body.Close()
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Allow-Origin %q, want the request origin", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Allow-Credentials %q, want true", got)
+	}
+	if got := resp.Header.Get("Vary"); got != "Origin" {
+		t.Errorf("Vary %q, want Origin", got)
+	}
+	if got := resp.Header.Get("Access-Control-Expose-Headers"); got != "ETag" {
+		t.Errorf("Expose-Headers %q, want ETag", got)
+	}
+}
+
+func TestBucketCORSNonCredentialedRule(t *testing.T) {
+	srv := setupBucketCORSServer(t)
+
+	resp := mustDo(t, "GET", srv.URL+"/web/a.txt", nil, map[string]string{"Origin": "https://other.example.org"})
+	resp.Body.Close()
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Allow-Origin %q, want *", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Allow-Credentials %q on a non-credentialed rule", got)
+	}
+
+	// No rule allows PUT from this origin: the request is served but the
+	// response carries no CORS headers.
+	resp = mustDo(t, "PUT", srv.URL+"/web/b.txt", strings.NewReader("y"), map[string]string{"Origin": "https://other.example.org"})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("PUT: status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin %q on a request no rule allows", got)
+	}
+}
+
+func TestBucketCORSPreflight(t *testing.T) {
+	srv := setupBucketCORSServer(t)
+
+	preflight := func(origin, method, headers string) *http.Response {
+		t.Helper()
+		h := map[string]string{"Origin": origin, "Access-Control-Request-Method": method}
+		if headers != "" {
+			h["Access-Control-Request-Headers"] = headers
+		}
+		resp := mustDo(t, "OPTIONS", srv.URL+"/web/a.txt", nil, h)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := preflight("https://app.example.com", "PUT", "X-Amz-Date, x-amz-meta-color")
+	if resp.StatusCode != 200 {
+		t.Fatalf("allowed preflight: status %d", resp.StatusCode)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, PUT",
+		"Access-Control-Allow-Headers":     "X-Amz-Date, x-amz-meta-color",
+		"Access-Control-Max-Age":           "600",
+	} {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("%s %q, want %q", header, got, want)
+		}
+	}
+
+	for _, tt := range []struct{ origin, method, headers string }{
+		{"https://evil.example.net", "PUT", ""},
+		{"https://app.example.com", "DELETE", ""},
+		{"https://app.example.com", "PUT", "Content-MD5"},
+	} {
+		resp := preflight(tt.origin, tt.method, tt.headers)
+		if resp.StatusCode != 403 {
+			t.Errorf("preflight %+v: status %d, want 403", tt, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("preflight %+v: Allow-Origin %q", tt, got)
+		}
+	}
+
+	// Buckets without a configuration keep the permissive defaults.
+	mustDo(t, "PUT", srv.URL+"/plain", nil, nil).Body.Close()
+	resp = mustDo(t, "OPTIONS", srv.URL+"/plain/a.txt", nil, map[string]string{
+		"Origin": "https://evil.example.net", "Access-Control-Request-Method": "DELETE",
+	})
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Access-Control-Allow-Origin") != "https://evil.example.net" {
+		t.Errorf("unconfigured bucket preflight: %d %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestHTTPBucketCORSConfiguration(t *testing.T) {
+	srv := setupBucketCORSServer(t)
+
+	resp := mustDo(t, "GET", srv.URL+"/web?cors", nil, nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != 200 {
+		t.Fatalf("GET ?cors: %d %s", resp.StatusCode, body)
+	}
+	var config CORSConfiguration
+	if err := xml.Unmarshal([]byte(body), &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Rules) != 2 || !config.Rules[0].AllowCredentials || config.Rules[1].AllowCredentials {
+		t.Errorf("GET ?cors returned %+v", config.Rules)
+	}
+
+	for name, rules := range map[string]string{
+		"credentials with any origin": `<CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod><AllowCredentials>true</AllowCredentials></CORSRule>`,
+		"unsupported method":          `<CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>PATCH</AllowedMethod></CORSRule>`,
+		"no origin":                   `<CORSRule><AllowedMethod>GET</AllowedMethod></CORSRule>`,
+		"no rules":                    ``,
+	} {
+		resp := mustDo(t, "PUT", srv.URL+"/web?cors", strings.NewReader("<CORSConfiguration>"+rules+"</CORSConfiguration>"), nil)
+		body := readBody(t, resp)
+		if resp.StatusCode != 400 || !strings.Contains(body, "<Code>InvalidRequest</Code>") {
+			t.Errorf("%s: %d %s", name, resp.StatusCode, body)
+		}
+	}
+
+	resp = mustDo(t, "DELETE", srv.URL+"/web?cors", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 204 {
+		t.Errorf("DELETE ?cors: status %d", resp.StatusCode)
+	}
+	resp = mustDo(t, "GET", srv.URL+"/web?cors", nil, nil)
+	if body := readBody(t, resp); !strings.Contains(body, "<Code>NoSuchCORSConfiguration</Code>") {
+		t.Errorf("GET ?cors after DELETE: %d %s", resp.StatusCode, body)
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Fix 6: MaxKeys Pagination Cap at 1000
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -2338,6 +5183,110 @@ func TestParseBoolEnvDefaults(t *testing.T) {
 	os.Unsetenv(key)
 }
 
+func TestParseIntEnv(t *testing.T) {
+	key := "GECKOS3_TEST_INT"
+	os.Unsetenv(key)
+	if n := parseIntEnv(key, 42); n != 42 {
+		t.Errorf("empty var should return default, got %d", n)
+	}
+
+	os.Setenv(key, "1048576")
+	if n := parseIntEnv(key, 42); n != 1048576 {
+		t.Errorf("parseIntEnv = %d, want 1048576", n)
+	}
+
+	os.Setenv(key, "lots")
+	if n := parseIntEnv(key, 42); n != 42 {
+		t.Errorf("unparseable should return default, got %d", n)
+	}
+	os.Unsetenv(key)
+}
+
+func TestStartupLogSettings(t *testing.T) {
+	config := &Config{
+		DataDir:     "relative/data",
+		ListenAddr:  ":9000",
+		AccessKey:   "mykey",
+		SecretKey:   "supersecret",
+		AuthEnabled: true,
+		MaxBuckets:  1000,
+		LogFormat:   "text",
+	}
+	settings := effectiveSettings(config)
+
+	text := formatStartupLog(settings, "text")
+	if strings.Contains(text, "supersecret") {
+		t.Errorf("secret key leaked: %s", text)
+	}
+	for _, want := range []string{"listen=:9000", "auth=true", "access_key=mykey", "secret_key=***", "max_buckets=1000", "tls=false"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text log missing %q: %s", want, text)
+		}
+	}
+	abs, _ := filepath.Abs("relative/data")
+	if !strings.Contains(text, "data_dir="+abs) {
+		t.Errorf("data_dir should be absolute: %s", text)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(formatStartupLog(settings, "json")), &entry); err != nil {
+		t.Fatalf("json log: %v", err)
+	}
+	if entry["secret_key"] != "***" || entry["listen"] != ":9000" || entry["auth"] != true {
+		t.Errorf("json log fields: %v", entry)
+	}
+	if _, ok := entry["timestamp"]; !ok {
+		t.Errorf("json log missing timestamp: %v", entry)
+	}
+}
+
+func TestValidateTimeouts(t *testing.T) {
+	ok := &Config{ReadTimeout: 6 * time.Hour, WriteTimeout: 0, IdleTimeout: 2 * time.Minute, HeaderTimeout: 10 * time.Second}
+	if err := validateTimeouts(ok); err != nil {
+		t.Errorf("valid timeouts: %v", err)
+	}
+	bad := *ok
+	bad.HeaderTimeout = -time.Second
+	if err := validateTimeouts(&bad); err == nil || !strings.Contains(err.Error(), "-read-header-timeout") {
+		t.Errorf("negative -read-header-timeout: %v", err)
+	}
+}
+
+func TestUsesDefaultCredentials(t *testing.T) {
+	cases := []struct {
+		access, secret string
+		want           bool
+	}{
+		{"geckoadmin", "geckoadmin", true},
+		{"mykey", "geckoadmin", true},
+		{"geckoadmin", "mysecret", true},
+		{"mykey", "mysecret", false},
+	}
+	for _, tc := range cases {
+		if got := usesDefaultCredentials(&Config{AccessKey: tc.access, SecretKey: tc.secret}); got != tc.want {
+			t.Errorf("%s/%s: got %v, want %v", tc.access, tc.secret, got, tc.want)
+		}
+	}
+}
+
+func TestProductionProblems(t *testing.T) {
+	secure := &Config{AuthEnabled: true, AccessKey: "mykey", SecretKey: "mysecret", TrustForwarded: true}
+	if p := productionProblems(secure); len(p) != 0 {
+		t.Errorf("secure config flagged: %v", p)
+	}
+
+	demo := &Config{AuthEnabled: true, AccessKey: "geckoadmin", SecretKey: "geckoadmin"}
+	if p := productionProblems(demo); len(p) != 2 {
+		t.Errorf("default credentials without TLS: %v", p)
+	}
+
+	noAuth := &Config{AuthEnabled: false, TrustForwarded: true}
+	p := productionProblems(noAuth)
+	if len(p) != 1 || !strings.Contains(p[0], "Authentication is disabled") {
+		t.Errorf("auth disabled: %v", p)
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Fix 5: CopyObject Metadata Directive via HTTP
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -2448,6 +5397,29 @@ func TestHTTPCopyObjectMetadataDirectiveDefault(t *testing.T) {
 	}
 }
 
+func TestHTTPCopyObjectETagMatchesSource(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	putResp := mustDo(t, "PUT", srv.URL+"/mybucket/src.txt", strings.NewReader("payload"), nil)
+	putResp.Body.Close()
+	srcETag := putResp.Header.Get("ETag")
+
+	for _, directive := range []string{"COPY", "REPLACE"} {
+		resp := mustDo(t, "PUT", srv.URL+"/mybucket/dst-"+directive, nil, map[string]string{
+			"x-amz-copy-source":        "/mybucket/src.txt",
+			"x-amz-metadata-directive": directive,
+		})
+		body := readBody(t, resp)
+
+		var result CopyObjectResult
+		xml.Unmarshal([]byte(body), &result)
+		if result.ETag != srcETag {
+			t.Errorf("%s: CopyObjectResult ETag %q, want %q", directive, result.ETag, srcETag)
+		}
+	}
+}
+
 func TestHTTPCopyObjectReplaceWithContentEncoding(t *testing.T) {
 	srv, _ := setupTestServer(t)
 
@@ -2480,6 +5452,212 @@ func TestHTTPCopyObjectReplaceWithContentEncoding(t *testing.T) {
 	}
 }
 
+// ═══════════════════════════════════════════════════════════════════════════════
+// GetObject Read Buffer
+// ═══════════════════════════════════════════════════════════════════════════════
+
+// nonSeekableStorage hides io.Seeker on GetObject readers so the handler
+// takes the buffered streaming fallback instead of http.ServeContent.
+type nonSeekableStorage struct {
+	Storage
+}
+
+type nonSeekableReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (s nonSeekableStorage) GetObject(bucket, key string) (io.ReadCloser, *ObjectMetadata, error) {
+	rc, meta, err := s.Storage.GetObject(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nonSeekableReadCloser{rc, rc}, meta, nil
+}
+
+func TestHTTPGetObjectNonSeekableFallback(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	handler := NewS3Handler(nonSeekableStorage{storage}, &NoOpAuthenticator{})
+	handler.SetReadBufferSize(7) // force many small copies
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	payload := strings.Repeat("0123456789", 100)
+	storage.CreateBucket("mybucket")
+	storage.PutObject("mybucket", "obj.txt", strings.NewReader(payload), &PutObjectInput{ContentType: "text/plain"})
+
+	resp := mustDo(t, "GET", srv.URL+"/mybucket/obj.txt", nil, nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != 200 {
+		t.Fatalf("GET status: %d", resp.StatusCode)
+	}
+	if body != payload {
+		t.Errorf("body mismatch: got %d bytes", len(body))
+	}
+	if cl := resp.Header.Get("Content-Length"); cl != "1000" {
+		t.Errorf("Content-Length: %q", cl)
+	}
+}
+
+func TestHTTPGetMultipleRanges(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	storage.CreateBucket("mybucket")
+	payload := "0123456789abcdefghijklmnopqrstuvwxyz"
+	storage.PutObject("mybucket", "obj.txt", strings.NewReader(payload), &PutObjectInput{ContentType: "text/plain"})
+
+	for name, backend := range map[string]Storage{"seekable": storage, "non-seekable": nonSeekableStorage{storage}} {
+		t.Run(name, func(t *testing.T) {
+			handler := NewS3Handler(backend, &NoOpAuthenticator{})
+			handler.SetReadBufferSize(3)
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+			objURL := srv.URL + "/mybucket/obj.txt"
+
+			resp := mustDo(t, "GET", objURL, nil, map[string]string{"Range": "bytes=0-4,10-14,-3"})
+			if resp.StatusCode != http.StatusPartialContent {
+				t.Fatalf("status: %d", resp.StatusCode)
+			}
+			mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/byteranges" {
+				t.Fatalf("Content-Type: %q", resp.Header.Get("Content-Type"))
+			}
+			body := readBody(t, resp)
+			if cl := resp.Header.Get("Content-Length"); cl != strconv.Itoa(len(body)) {
+				t.Errorf("Content-Length %s, body is %d bytes", cl, len(body))
+			}
+			mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+			want := []struct{ contentRange, data string }{
+				{"bytes 0-4/36", "01234"},
+				{"bytes 10-14/36", "abcde"},
+				{"bytes 33-35/36", "xyz"},
+			}
+			for i, w := range want {
+				part, err := mr.NextPart()
+				if err != nil {
+					t.Fatalf("part %d: %v", i, err)
+				}
+				data, _ := io.ReadAll(part)
+				if cr := part.Header.Get("Content-Range"); cr != w.contentRange || string(data) != w.data {
+					t.Errorf("part %d: Content-Range %q data %q, want %q %q", i, cr, data, w.contentRange, w.data)
+				}
+				if ct := part.Header.Get("Content-Type"); ct != "text/plain" {
+					t.Errorf("part %d Content-Type: %q", i, ct)
+				}
+			}
+			if _, err := mr.NextPart(); err != io.EOF {
+				t.Errorf("expected 3 parts, next: %v", err)
+			}
+
+			// Only one satisfiable range: a plain 206.
+			resp = mustDo(t, "GET", objURL, nil, map[string]string{"Range": "bytes=5-7,100-200"})
+			body = readBody(t, resp)
+			if resp.StatusCode != http.StatusPartialContent || body != "567" || resp.Header.Get("Content-Range") != "bytes 5-7/36" {
+				t.Errorf("one satisfiable: %d %q %q", resp.StatusCode, body, resp.Header.Get("Content-Range"))
+			}
+
+			resp = mustDo(t, "GET", objURL, nil, map[string]string{"Range": "bytes=40-50,100-"})
+			body = readBody(t, resp)
+			if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || !strings.Contains(body, "<Code>InvalidRange</Code>") {
+				t.Errorf("all unsatisfiable: %d %s", resp.StatusCode, body)
+			}
+			if cr := resp.Header.Get("Content-Range"); cr != "bytes */36" {
+				t.Errorf("416 Content-Range: %q", cr)
+			}
+		})
+	}
+
+}
+
+func TestHTTPGetMultipleRangesLimits(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	storage.CreateBucket("mybucket")
+	payload := "0123456789abcdefghijklmnopqrstuvwxyz"
+	storage.PutObject("mybucket", "obj.txt", strings.NewReader(payload), nil)
+
+	var many []string
+	for i := 0; i <= maxByteRanges; i++ {
+		many = append(many, fmt.Sprintf("%d-%d", i%36, i%36))
+	}
+	tests := []struct {
+		name, rangeHeader string
+		status            int
+		contentRange      string
+		body              string
+	}{
+		// Overlapping and touching ranges are merged into one.
+		{"overlapping", "bytes=5-14,0-9,15-19", 206, "bytes 0-19/36", payload[:20]},
+		// Asking for more bytes than the object has serves it once.
+		{"repeated whole", "bytes=0-,0-,0-", 200, "", payload},
+		{"too many", "bytes=" + strings.Join(many, ","), 200, "", payload},
+	}
+	for name, backend := range map[string]Storage{"seekable": storage, "non-seekable": nonSeekableStorage{storage}} {
+		srv := httptest.NewServer(NewS3Handler(backend, &NoOpAuthenticator{}))
+		defer srv.Close()
+		for _, tt := range tests {
+			resp := mustDo(t, "GET", srv.URL+"/mybucket/obj.txt", nil, map[string]string{"Range": tt.rangeHeader})
+			body := readBody(t, resp)
+			if resp.StatusCode != tt.status || resp.Header.Get("Content-Range") != tt.contentRange || body != tt.body {
+				t.Errorf("%s %s: %d %q %q, want %d %q %q", name, tt.name, resp.StatusCode, resp.Header.Get("Content-Range"), body, tt.status, tt.contentRange, tt.body)
+			}
+		}
+
+		// Out-of-order ranges are sorted, so a non-seekable reader serves
+		// them in one pass.
+		resp := mustDo(t, "GET", srv.URL+"/mybucket/obj.txt", nil, map[string]string{"Range": "bytes=10-14,0-4"})
+		_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		mr := multipart.NewReader(resp.Body, params["boundary"])
+		for _, want := range []string{"bytes 0-4/36", "bytes 10-14/36"} {
+			part, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("%s out of order: %d %v", name, resp.StatusCode, err)
+			}
+			if cr := part.Header.Get("Content-Range"); cr != want {
+				t.Errorf("%s out of order: Content-Range %q, want %q", name, cr, want)
+			}
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestSetReadBufferSizeDefault(t *testing.T) {
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	handler.SetReadBufferSize(0)
+	bufp := handler.readBufPool.Get().(*[]byte)
+	if len(*bufp) != defaultReadBufferSize {
+		t.Errorf("buffer size: %d, want %d", len(*bufp), defaultReadBufferSize)
+	}
+}
+
+// BenchmarkHTTPGetObjectReadBuffer streams a 64MB object through the
+// non-seekable fallback path at different copy buffer sizes.
+func BenchmarkHTTPGetObjectReadBuffer(b *testing.B) {
+	storage := NewFilesystemStorage(b.TempDir())
+	storage.CreateBucket("benchbucket")
+	payload := bytes.Repeat([]byte("a"), 64*1024*1024)
+	storage.PutObject("benchbucket", "large.bin", bytes.NewReader(payload), nil)
+
+	for _, size := range []int{32 * 1024, 256 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			handler := NewS3Handler(nonSeekableStorage{storage}, &NoOpAuthenticator{})
+			handler.SetReadBufferSize(size)
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+			client := srv.Client()
+
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := client.Get(srv.URL + "/benchbucket/large.bin")
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // HTTP Benchmarks
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -2539,6 +5717,35 @@ func BenchmarkHTTPGetObject(b *testing.B) {
 	}
 }
 
+// BenchmarkHTTPListObjects1000 measures a full 1000-key ListObjectsV2 page.
+// "ttfb" is the time until response headers arrive; "total" includes reading
+// the body.
+func BenchmarkHTTPListObjects1000(b *testing.B) {
+	srv := setupBenchServer(b)
+	client := srv.Client()
+	for i := 0; i < 1000; i++ {
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/benchbucket/dir/object-%04d.txt", srv.URL, i), strings.NewReader("x"))
+		resp, _ := client.Do(req)
+		resp.Body.Close()
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	var ttfb time.Duration
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		resp, err := client.Get(srv.URL + "/benchbucket?list-type=2")
+		if err != nil {
+			b.Fatal(err)
+		}
+		ttfb += time.Since(start)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	b.ReportMetric(float64(ttfb.Microseconds())/float64(b.N), "ttfb-us/op")
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // AWS Chunked Encoding Tests
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -2640,6 +5847,92 @@ func TestHTTPPutObjectAWSChunkedEncoding(t *testing.T) {
 	}
 }
 
+func TestHTTPChecksumHeaders(t *testing.T) {
+	srv, _ := setupTestServer(t)
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+	crc := func(s string) string {
+		sum := crc32.ChecksumIEEE([]byte(s))
+		return base64.StdEncoding.EncodeToString([]byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)})
+	}
+
+	resp := mustDo(t, "PUT", srv.URL+"/mybucket/k", strings.NewReader("hello"),
+		map[string]string{"x-amz-checksum-crc32": crc("hello")})
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("x-amz-checksum-crc32") != crc("hello") {
+		t.Fatalf("PUT: %d checksum %q", resp.StatusCode, resp.Header.Get("x-amz-checksum-crc32"))
+	}
+	for _, method := range []string{"GET", "HEAD"} {
+		resp := mustDo(t, method, srv.URL+"/mybucket/k", nil, nil)
+		resp.Body.Close()
+		if resp.Header.Get("x-amz-checksum-crc32") != crc("hello") || resp.Header.Get("x-amz-checksum-type") != "FULL_OBJECT" {
+			t.Errorf("%s: checksum %q type %q", method, resp.Header.Get("x-amz-checksum-crc32"), resp.Header.Get("x-amz-checksum-type"))
+		}
+	}
+	// A range is not the whole object, so its checksum would not match.
+	resp = mustDo(t, "GET", srv.URL+"/mybucket/k", nil, map[string]string{"Range": "bytes=0-1"})
+	resp.Body.Close()
+	if got := resp.Header.Get("x-amz-checksum-crc32"); got != "" {
+		t.Errorf("ranged GET echoed checksum %q", got)
+	}
+
+	// Trailers of aws-chunked bodies, as recent SDKs send by default.
+	trailer := func(data, sum string) []byte {
+		encoded := buildAWSChunkedBody([]byte(data), 4)
+		encoded = encoded[:len(encoded)-2]
+		return append(encoded, "x-amz-checksum-crc32:"+sum+"\r\n\r\n"...)
+	}
+	trailerHeaders := map[string]string{
+		"X-Amz-Content-Sha256": "STREAMING-UNSIGNED-PAYLOAD-TRAILER",
+		"Content-Encoding":     "aws-chunked",
+		"X-Amz-Trailer":        "x-amz-checksum-crc32",
+	}
+	resp = mustDo(t, "PUT", srv.URL+"/mybucket/trailed", bytes.NewReader(trailer("trailed body", crc("trailed body"))), trailerHeaders)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("x-amz-checksum-crc32") != crc("trailed body") {
+		t.Fatalf("trailer PUT: %d checksum %q", resp.StatusCode, resp.Header.Get("x-amz-checksum-crc32"))
+	}
+	if got := readBody(t, mustDo(t, "GET", srv.URL+"/mybucket/trailed", nil, nil)); got != "trailed body" {
+		t.Errorf("trailer PUT stored %q", got)
+	}
+
+	for name, tc := range map[string]struct {
+		body    io.Reader
+		headers map[string]string
+		code    string
+	}{
+		"header mismatch":  {strings.NewReader("other"), map[string]string{"x-amz-checksum-crc32": crc("hello")}, "BadDigest"},
+		"trailer mismatch": {bytes.NewReader(trailer("other", crc("hello"))), trailerHeaders, "BadDigest"},
+		"two algorithms": {strings.NewReader("hello"), map[string]string{
+			"x-amz-checksum-crc32": crc("hello"), "x-amz-checksum-sha1": "x"}, "InvalidRequest"},
+		"unknown algorithm": {strings.NewReader("hello"), map[string]string{"x-amz-sdk-checksum-algorithm": "MD4"}, "InvalidRequest"},
+	} {
+		resp := mustDo(t, "PUT", srv.URL+"/mybucket/k", tc.body, tc.headers)
+		body := readBody(t, resp)
+		if resp.StatusCode != 400 || !strings.Contains(body, "<Code>"+tc.code+"</Code>") {
+			t.Errorf("%s: %d %s", name, resp.StatusCode, body)
+		}
+	}
+	if got := readBody(t, mustDo(t, "GET", srv.URL+"/mybucket/k", nil, nil)); got != "hello" {
+		t.Errorf("rejected PUTs changed the object to %q", got)
+	}
+
+	// UploadPart verifies and echoes too.
+	var initResult InitiateMultipartUploadResult
+	xml.Unmarshal([]byte(readBody(t, mustDo(t, "POST", srv.URL+"/mybucket/mp?uploads", nil, nil))), &initResult)
+	uploadID := initResult.UploadId
+	partURL := srv.URL + "/mybucket/mp?partNumber=1&uploadId=" + uploadID
+	resp = mustDo(t, "PUT", partURL, strings.NewReader("part"), map[string]string{"x-amz-checksum-crc32": crc("part")})
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("x-amz-checksum-crc32") != crc("part") {
+		t.Errorf("UploadPart: %d checksum %q", resp.StatusCode, resp.Header.Get("x-amz-checksum-crc32"))
+	}
+	resp = mustDo(t, "PUT", partURL, strings.NewReader("tampered"), map[string]string{"x-amz-checksum-crc32": crc("part")})
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("UploadPart with a bad checksum: %d", resp.StatusCode)
+	}
+}
+
 func TestHTTPPutObjectAWSChunkedSizeMatchesHEAD(t *testing.T) {
 	srv, _ := setupTestServer(t)
 	defer srv.Close()
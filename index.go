@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// indexDir is the hidden per-bucket directory holding the key index.
+const indexDir = ".geckos3-index"
+
+const (
+	// indexLogName is the append-only log of key additions and removals.
+	indexLogName = "keys.log"
+	// indexDirtyName marks an index that is open for writing. It is removed
+	// on clean shutdown; if it is present at load time the process crashed
+	// and the log may have missed writes, so the index is rebuilt.
+	indexDirtyName = "dirty"
+)
+
+// indexCompactMin is the minimum number of superseded log records before a
+// bucket's log is rewritten, so small buckets are not compacted constantly.
+const indexCompactMin = 1024
+
+// keyIndex keeps a sorted set of object keys per bucket so ListObjects can
+// answer from memory instead of walking the bucket directory. Each bucket's
+// set is persisted as an append-only log that is compacted when it grows to
+// more than twice the live key count, and is rebuilt from a filesystem scan
+// when missing or left dirty by an unclean shutdown.
+type keyIndex struct {
+	mu      sync.Mutex
+	buckets map[string]*bucketIndex
+}
+
+// drop closes bucket's log and forgets it; the caller holds k.mu.
+func (k *keyIndex) drop(bucket string) {
+	if idx, ok := k.buckets[bucket]; ok {
+		idx.mu.Lock()
+		idx.log.Close()
+		idx.mu.Unlock()
+		delete(k.buckets, bucket)
+	}
+}
+
+// bucketIndex is the in-memory sorted key set for one bucket plus its log.
+type bucketIndex struct {
+	mu      sync.RWMutex
+	dir     string
+	keys    []string // sorted, unique
+	log     *os.File
+	records int // lines in the log, including superseded ones
+	fsync   bool
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{buckets: make(map[string]*bucketIndex)}
+}
+
+// SetIndexEnabled turns the on-disk key index on or off. When enabled,
+// ListObjects reads keys from the index instead of walking the bucket, and
+// PutObject/DeleteObject/CompleteMultipartUpload keep it up to date. Objects
+// added to the data directory by means other than the S3 API are only picked
+// up when the index is rebuilt (e.g. by deleting the bucket's index directory).
+func (fs *FilesystemStorage) SetIndexEnabled(enabled bool) {
+	if enabled {
+		if fs.index == nil {
+			fs.index = newKeyIndex()
+		}
+		return
+	}
+	fs.CloseIndex()
+	fs.index = nil
+}
+
+// LoadIndexes opens (and if needed rebuilds) the index of every existing
+// bucket. Buckets created later are indexed lazily on first use.
+func (fs *FilesystemStorage) LoadIndexes() error {
+	if fs.index == nil {
+		return nil
+	}
+	buckets, err := fs.ListBuckets()
+	if err != nil {
+		return err
+	}
+	for _, b := range buckets {
+		if _, err := fs.bucketIndex(b.Name); err != nil {
+			return fmt.Errorf("index for bucket %s: %w", b.Name, err)
+		}
+	}
+	return nil
+}
+
+// CloseIndex compacts and closes every open bucket index and clears the dirty
+// markers so the indexes are trusted on the next start.
+func (fs *FilesystemStorage) CloseIndex() error {
+	if fs.index == nil {
+		return nil
+	}
+	fs.index.mu.Lock()
+	defer fs.index.mu.Unlock()
+
+	var firstErr error
+	for bucket, idx := range fs.index.buckets {
+		if err := idx.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(fs.index.buckets, bucket)
+	}
+	return firstErr
+}
+
+// bucketIndex returns the loaded index for bucket, loading or rebuilding it on
+// first use. It returns nil if indexing is disabled.
+func (fs *FilesystemStorage) bucketIndex(bucket string) (*bucketIndex, error) {
+	if fs.index == nil {
+		return nil, nil
+	}
+	fs.index.mu.Lock()
+	defer fs.index.mu.Unlock()
+
+	if idx, ok := fs.index.buckets[bucket]; ok {
+		return idx, nil
+	}
+	if !fs.BucketExists(bucket) {
+		return nil, nil
+	}
+
+	bucketPath := filepath.Join(fs.dataDir, bucket)
+	idx, err := loadBucketIndex(filepath.Join(bucketPath, indexDir), fs.enableFsync, func() ([]string, error) {
+		return fs.scanKeys(context.Background(), bucketPath, "", 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	fs.index.buckets[bucket] = idx
+	return idx, nil
+}
+
+// indexAdd records key in the bucket index. Failures drop the in-memory index
+// and leave it dirty on disk, so the next access rebuilds it from a scan.
+func (fs *FilesystemStorage) indexAdd(bucket, key string) {
+	fs.indexUpdate(bucket, key, true)
+}
+
+// indexRemove removes key from the bucket index.
+func (fs *FilesystemStorage) indexRemove(bucket, key string) {
+	fs.indexUpdate(bucket, key, false)
+}
+
+func (fs *FilesystemStorage) indexUpdate(bucket, key string, add bool) {
+	idx, err := fs.bucketIndex(bucket)
+	if err != nil || idx == nil {
+		return
+	}
+	if err := idx.apply(key, add); err != nil {
+		fs.dropIndex(bucket)
+	}
+}
+
+// dropIndex closes the bucket's log without compacting and forgets it. The
+// dirty marker stays behind so a later load rebuilds from the filesystem.
+func (fs *FilesystemStorage) dropIndex(bucket string) {
+	if fs.index == nil {
+		return
+	}
+	fs.index.mu.Lock()
+	defer fs.index.mu.Unlock()
+	fs.index.drop(bucket)
+}
+
+// withoutIndex drops the bucket's index like dropIndex and runs fn before
+// any request can load it again, for changes that move the directory the
+// index log lives in.
+func (fs *FilesystemStorage) withoutIndex(bucket string, fn func() error) error {
+	if fs.index == nil {
+		return fn()
+	}
+	fs.index.mu.Lock()
+	defer fs.index.mu.Unlock()
+	fs.index.drop(bucket)
+	return fn()
+}
+
+// loadBucketIndex reads the key log in dir, falling back to scan when the log
+// is missing, unreadable or marked dirty. The loaded set is immediately
+// compacted into a fresh log and the dirty marker is set while it is open.
+func loadBucketIndex(dir string, fsync bool, scan func() ([]string, error)) (*bucketIndex, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	idx := &bucketIndex{dir: dir, fsync: fsync}
+
+	keys, err := readIndexLog(filepath.Join(dir, indexLogName))
+	if _, statErr := os.Stat(filepath.Join(dir, indexDirtyName)); err != nil || statErr == nil {
+		keys, err = scan()
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(keys)
+	}
+	idx.keys = keys
+
+	if err := os.WriteFile(filepath.Join(dir, indexDirtyName), nil, 0644); err != nil {
+		return nil, err
+	}
+	if err := idx.compact(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// readIndexLog replays a key log into a sorted key slice.
+func readIndexLog(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	set := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 1 {
+			continue
+		}
+		key, err := strconv.Unquote(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("corrupt index record %q", line)
+		}
+		switch line[0] {
+		case '+':
+			set[key] = struct{}{}
+		case '-':
+			delete(set, key)
+		default:
+			return nil, fmt.Errorf("corrupt index record %q", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// page returns the indexed keys of one listing page; see pageKeys.
+func (idx *bucketIndex) page(prefix, delimiter, startAfter string, limit int) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return pageKeys(idx.keys, prefix, delimiter, startAfter, limit)
+}
+
+// apply adds or removes key, appending the change to the log. No-op changes
+// (adding a present key, removing an absent one) are not logged.
+func (idx *bucketIndex) apply(key string, add bool) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	i := sort.SearchStrings(idx.keys, key)
+	present := i < len(idx.keys) && idx.keys[i] == key
+	if present == add {
+		return nil
+	}
+
+	op := byte('-')
+	if add {
+		op = '+'
+	}
+	if _, err := idx.log.WriteString(string(op) + strconv.Quote(key) + "\n"); err != nil {
+		return err
+	}
+	if idx.fsync {
+		if err := idx.log.Sync(); err != nil {
+			return err
+		}
+	}
+	idx.records++
+
+	if add {
+		idx.keys = append(idx.keys, "")
+		copy(idx.keys[i+1:], idx.keys[i:])
+		idx.keys[i] = key
+	} else {
+		idx.keys = append(idx.keys[:i], idx.keys[i+1:]...)
+	}
+
+	if idx.records > indexCompactMin && idx.records > 2*len(idx.keys) {
+		return idx.compact()
+	}
+	return nil
+}
+
+// compact rewrites the log as one record per live key and reopens it for
+// appending. The caller must hold idx.mu (or own idx exclusively).
+func (idx *bucketIndex) compact() error {
+	tmp, err := os.CreateTemp(idx.dir, ".keys-tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	w := bufio.NewWriter(tmp)
+	for _, k := range idx.keys {
+		w.WriteByte('+')
+		w.WriteString(strconv.Quote(k))
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if idx.fsync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	logPath := filepath.Join(idx.dir, indexLogName)
+	if idx.log != nil {
+		idx.log.Close()
+		idx.log = nil
+	}
+	if err := os.Rename(tmpPath, logPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if idx.fsync {
+		syncParentDir(logPath)
+	}
+
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	idx.log = f
+	idx.records = len(idx.keys)
+	return nil
+}
+
+// close compacts the log, closes it and clears the dirty marker.
+func (idx *bucketIndex) close() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := idx.compact(); err != nil {
+		return err
+	}
+	if err := idx.log.Close(); err != nil {
+		return err
+	}
+	idx.log = nil
+	return os.Remove(filepath.Join(idx.dir, indexDirtyName))
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Key Index
+// ═══════════════════════════════════════════════════════════════════════════════
+
+func setupIndexedStorage(t *testing.T) *FilesystemStorage {
+	t.Helper()
+	s := NewFilesystemStorage(t.TempDir())
+	s.SetIndexEnabled(true)
+	t.Cleanup(func() { s.CloseIndex() })
+	return s
+}
+
+func listKeys(t *testing.T, s *FilesystemStorage, bucket, prefix string) string {
+	t.Helper()
+	objs, err := s.ListObjects(bucket, prefix, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, len(objs))
+	for i, o := range objs {
+		keys[i] = o.Key
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestIndexTracksPutAndDelete(t *testing.T) {
+	s := setupIndexedStorage(t)
+	s.CreateBucket("b")
+
+	s.PutObject("b", "c.txt", strings.NewReader("c"), nil)
+	s.PutObject("b", "a.txt", strings.NewReader("a"), nil)
+	s.PutObject("b", "dir/b.txt", strings.NewReader("b"), nil)
+
+	if got := listKeys(t, s, "b", ""); got != "a.txt,c.txt,dir/b.txt" {
+		t.Errorf("after puts: %s", got)
+	}
+
+	s.DeleteObject("b", "a.txt")
+	if got := listKeys(t, s, "b", ""); got != "c.txt,dir/b.txt" {
+		t.Errorf("after delete: %s", got)
+	}
+	if got := listKeys(t, s, "b", "dir/"); got != "dir/b.txt" {
+		t.Errorf("prefix listing: %s", got)
+	}
+}
+
+func TestIndexTracksMultipartComplete(t *testing.T) {
+	s := setupIndexedStorage(t)
+	s.CreateBucket("b")
+
+	uploadID, _ := s.CreateMultipartUpload("b", "big.bin", "")
+	etag, _ := s.UploadPart("b", "big.bin", uploadID, 1, strings.NewReader("part"), "")
+	if _, err := s.CompleteMultipartUpload("b", "big.bin", uploadID, []CompletedPart{{PartNumber: 1, ETag: etag}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := listKeys(t, s, "b", ""); got != "big.bin" {
+		t.Errorf("listing: %s", got)
+	}
+}
+
+func TestIndexPersistsAcrossCleanRestart(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFilesystemStorage(dir)
+	s.SetIndexEnabled(true)
+	s.CreateBucket("b")
+	s.PutObject("b", "one.txt", strings.NewReader("1"), nil)
+	s.PutObject("b", "two.txt", strings.NewReader("2"), nil)
+	s.DeleteObject("b", "one.txt")
+	if err := s.CloseIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "b", indexDir, indexDirtyName)); !os.IsNotExist(err) {
+		t.Error("dirty marker should be removed on clean close")
+	}
+
+	// A file dropped in by hand is not visible when the index is trusted.
+	os.WriteFile(filepath.Join(dir, "b", "manual.txt"), []byte("m"), 0644)
+
+	s2 := NewFilesystemStorage(dir)
+	s2.SetIndexEnabled(true)
+	defer s2.CloseIndex()
+	if got := listKeys(t, s2, "b", ""); got != "two.txt" {
+		t.Errorf("reloaded index: %s", got)
+	}
+}
+
+func TestIndexRebuiltWhenDirty(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFilesystemStorage(dir)
+	s.SetIndexEnabled(true)
+	s.CreateBucket("b")
+	s.PutObject("b", "one.txt", strings.NewReader("1"), nil)
+	// Simulate a crash: the dirty marker is never cleared.
+	s.dropIndex("b")
+
+	os.WriteFile(filepath.Join(dir, "b", "manual.txt"), []byte("m"), 0644)
+
+	s2 := NewFilesystemStorage(dir)
+	s2.SetIndexEnabled(true)
+	defer s2.CloseIndex()
+	if got := listKeys(t, s2, "b", ""); got != "manual.txt,one.txt" {
+		t.Errorf("rebuilt index: %s", got)
+	}
+}
+
+func TestIndexRebuiltWhenMissing(t *testing.T) {
+	dir := t.TempDir()
+	plain := NewFilesystemStorage(dir)
+	plain.CreateBucket("b")
+	plain.PutObject("b", "x/y.txt", strings.NewReader("y"), nil)
+	plain.PutObject("b", "z.txt", strings.NewReader("z"), nil)
+
+	s := NewFilesystemStorage(dir)
+	s.SetIndexEnabled(true)
+	defer s.CloseIndex()
+	if err := s.LoadIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	if got := listKeys(t, s, "b", ""); got != "x/y.txt,z.txt" {
+		t.Errorf("rebuilt index: %s", got)
+	}
+}
+
+func TestIndexRebuiltWhenCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFilesystemStorage(dir)
+	s.SetIndexEnabled(true)
+	s.CreateBucket("b")
+	s.PutObject("b", "a.txt", strings.NewReader("a"), nil)
+	s.CloseIndex()
+
+	os.WriteFile(filepath.Join(dir, "b", indexDir, indexLogName), []byte("garbage\n"), 0644)
+
+	s2 := NewFilesystemStorage(dir)
+	s2.SetIndexEnabled(true)
+	defer s2.CloseIndex()
+	if got := listKeys(t, s2, "b", ""); got != "a.txt" {
+		t.Errorf("rebuilt index: %s", got)
+	}
+}
+
+func TestIndexKeysWithSpecialCharacters(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFilesystemStorage(dir)
+	s.SetIndexEnabled(true)
+	s.CreateBucket("b")
+	key := "line\nbreak \"quoted\" ünïcode.txt"
+	s.PutObject("b", key, strings.NewReader("x"), nil)
+	s.CloseIndex()
+
+	s2 := NewFilesystemStorage(dir)
+	s2.SetIndexEnabled(true)
+	defer s2.CloseIndex()
+	if got := listKeys(t, s2, "b", ""); got != key {
+		t.Errorf("round trip: %q", got)
+	}
+}
+
+func TestIndexCompaction(t *testing.T) {
+	s := setupIndexedStorage(t)
+	s.CreateBucket("b")
+
+	for i := 0; i < indexCompactMin; i++ {
+		s.PutObject("b", "churn.txt", strings.NewReader("x"), nil)
+		s.DeleteObject("b", "churn.txt")
+	}
+	s.PutObject("b", "keep.txt", strings.NewReader("k"), nil)
+
+	idx, _ := s.bucketIndex("b")
+	if idx.records > indexCompactMin {
+		t.Errorf("log should have been compacted, has %d records", idx.records)
+	}
+	if got := listKeys(t, s, "b", ""); got != "keep.txt" {
+		t.Errorf("listing after compaction: %s", got)
+	}
+}
+
+func TestIndexDirHiddenFromListingsAndDelete(t *testing.T) {
+	s := setupIndexedStorage(t)
+	s.CreateBucket("b")
+	s.PutObject("b", "a.txt", strings.NewReader("a"), nil)
+
+	objs, prefixes, _ := s.ListDirectory("b", "")
+	if len(objs) != 1 || len(prefixes) != 0 {
+		t.Errorf("index dir leaked into directory listing: %v %v", objs, prefixes)
+	}
+
+	s.DeleteObject("b", "a.txt")
+	if err := s.DeleteBucket("b"); err != nil {
+		t.Fatalf("index dir should not block bucket deletion: %v", err)
+	}
+	if s.BucketExists("b") {
+		t.Error("bucket should be gone")
+	}
+}
+
+func TestIndexDeleteObjectMissingBucketDoesNotCreateIt(t *testing.T) {
+	s := setupIndexedStorage(t)
+
+	s.DeleteObject("ghost", "a.txt")
+	if s.BucketExists("ghost") {
+		t.Error("index update must not create the bucket")
+	}
+}
+
+func TestIndexFollowsBucketRename(t *testing.T) {
+	s := setupIndexedStorage(t)
+	s.CreateBucket("old-name")
+	s.PutObject("old-name", "a.txt", strings.NewReader("a"), nil)
+	listKeys(t, s, "old-name", "")
+
+	if err := s.RenameBucket("old-name", "new-name"); err != nil {
+		t.Fatal(err)
+	}
+	s.PutObject("new-name", "b.txt", strings.NewReader("b"), nil)
+	if got := listKeys(t, s, "new-name", ""); got != "a.txt,b.txt" {
+		t.Errorf("after rename: %q", got)
+	}
+}
+
+func TestIndexListObjectsPage(t *testing.T) {
+	s := setupIndexedStorage(t)
+	s.CreateBucket("b")
+	for _, key := range []string{"a.txt", "dir/x.txt", "dir/y.txt", "dir/sub/z.txt", "e.txt"} {
+		s.PutObject("b", key, strings.NewReader("x"), nil)
+	}
+
+	page := func(delimiter, startAfter string, limit int) string {
+		t.Helper()
+		objs, err := s.ListObjectsPage(context.Background(), "b", "", delimiter, startAfter, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := make([]string, len(objs))
+		for i, o := range objs {
+			keys[i] = o.Key
+		}
+		return strings.Join(keys, ",")
+	}
+
+	tests := []struct {
+		delimiter, startAfter string
+		limit                 int
+		want                  string
+	}{
+		{"", "", 2, "a.txt,dir/sub/z.txt"},
+		{"", "dir/x.txt", 0, "dir/y.txt,e.txt"},
+		{"/", "", 0, "a.txt,dir/,e.txt"},
+		{"/", "", 2, "a.txt,dir/"},
+		{"/", "dir/", 0, "e.txt"},
+		{"/", "dir/x.txt", 0, "e.txt"},
+		{"x", "", 0, "a.tx,dir/sub/z.tx,dir/x,dir/y.tx,e.tx"},
+	}
+	for _, tt := range tests {
+		if got := page(tt.delimiter, tt.startAfter, tt.limit); got != tt.want {
+			t.Errorf("delimiter %q, start-after %q, limit %d: %s, want %s", tt.delimiter, tt.startAfter, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestIndexListingHasNoScanLimit(t *testing.T) {
+	s := setupIndexedStorage(t)
+	s.CreateBucket("b")
+	s.PutObject("b", "k000000", strings.NewReader("x"), nil)
+	idx, err := s.bucketIndex("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= MaxScanLimit; i++ {
+		if err := idx.apply(fmt.Sprintf("k%06d", i), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objs, err := s.ListObjects("b", "", 1)
+	if err != nil || len(objs) != 1 || objs[0].Key != "k000000" {
+		t.Errorf("ListObjects = %v, %v", objs, err)
+	}
+	objs, err = s.ListObjectsPage(context.Background(), "b", "", "/", "", 1)
+	if err != nil || len(objs) != 1 || objs[0].Key != "k000000" {
+		t.Errorf("ListObjectsPage = %v, %v", objs, err)
+	}
+}
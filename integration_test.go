@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"encoding/xml"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -410,6 +415,78 @@ func TestE2EListBucketsAfterCreateDelete(t *testing.T) {
 	assertBucketCount(t, srv, 2)
 }
 
+// TestSelftestCommand runs the "geckos3 selftest" script end to end
+func TestSelftestCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runSelftest(nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("selftest exit %d:\n%s%s", code, stdout.String(), stderr.String())
+	}
+	if !strings.HasSuffix(stdout.String(), "PASS\n") {
+		t.Errorf("output:\n%s", stdout.String())
+	}
+
+	// A data directory that is a file makes the first step fail.
+	notDir := t.TempDir() + "/file"
+	os.WriteFile(notDir, []byte("x"), 0644)
+	stdout.Reset()
+	if code := runSelftest([]string{"-data-dir", notDir}, &stdout, &stderr); code != 1 {
+		t.Errorf("selftest on a file: exit %d", code)
+	}
+	if !strings.HasPrefix(stdout.String(), "FAIL CreateBucket") {
+		t.Errorf("output:\n%s", stdout.String())
+	}
+}
+
+// TestBenchCommand drives a short authenticated "geckos3 bench" run
+func TestBenchCommand(t *testing.T) {
+	storage := NewFilesystemStorage(t.TempDir())
+	server := httptest.NewServer(NewS3Handler(storage, NewSigV4Authenticator("testkey", "testsecret")))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := runBench([]string{
+		"-target", server.URL, "-access-key", "testkey", "-secret-key", "testsecret",
+		"-concurrency", "2", "-duration", "200ms", "-object-size", "4KiB", "-json",
+	}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("bench exit %d: %s", code, stderr.String())
+	}
+	var report benchReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("bench -json output: %v\n%s", err, stdout.String())
+	}
+	if report.ObjectSize != 4096 || report.Put.Ops == 0 || report.Get.Ops == 0 || report.Put.Errors != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if report.Put.P50Ms <= 0 || report.Put.P50Ms > report.Put.MaxMs {
+		t.Errorf("PUT percentiles = %+v", report.Put)
+	}
+	if objects, _ := storage.ListObjects("geckos3-bench", "", 1000); len(objects) != 0 {
+		t.Errorf("bench left %d objects behind", len(objects))
+	}
+
+	// Wrong credentials: every request fails.
+	stdout.Reset()
+	code = runBench([]string{"-target", server.URL, "-secret-key", "wrong", "-duration", "50ms"}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("bench with bad credentials: exit %d", code)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{"4096": 4096, "4KB": 4096, "4kib": 4096, "1M": 1 << 20, "16MiB": 16 << 20, "2GB": 2 << 30, "512B": 512}
+	for in, want := range tests {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "0", "-1KB", "1.5MB", "MB", "10TB", "2000GB"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) succeeded", in)
+		}
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Logging Middleware
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -458,6 +535,206 @@ func TestLoggingMiddlewareIncrements(t *testing.T) {
 	}
 }
 
+// captureAccessLog redirects the access log into a buffer for one test.
+func captureAccessLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := accessLogOutput
+	accessLogOutput = &buf
+	t.Cleanup(func() { accessLogOutput = prev })
+	return &buf
+}
+
+func TestLoggingMiddlewareCountsBytesInAndOut(t *testing.T) {
+	logBuf := captureAccessLog(t)
+	storage := NewFilesystemStorage(t.TempDir())
+	logged := LoggingMiddleware(NewS3Handler(storage, &NoOpAuthenticator{}))
+	storage.CreateBucket("logs")
+
+	// Upload: bytes_in counts the request body
+	payload := strings.Repeat("u", 1500)
+	rec := httptest.NewRecorder()
+	logged.ServeHTTP(rec, httptest.NewRequest("PUT", "/logs/up.txt", strings.NewReader(payload)))
+
+	var entry LogEntry
+	if err := json.Unmarshal(logBuf.Bytes(), &entry); err != nil {
+		t.Fatalf("log line: %v (%q)", err, logBuf.String())
+	}
+	if entry.BytesIn != 1500 {
+		t.Errorf("PUT bytes_in: %d, want 1500", entry.BytesIn)
+	}
+	if entry.BytesOut != 0 {
+		t.Errorf("PUT bytes_out: %d, want 0", entry.BytesOut)
+	}
+
+	// Download: bytes_out counts the response body
+	logBuf.Reset()
+	rec = httptest.NewRecorder()
+	logged.ServeHTTP(rec, httptest.NewRequest("GET", "/logs/up.txt", nil))
+
+	entry = LogEntry{}
+	if err := json.Unmarshal(logBuf.Bytes(), &entry); err != nil {
+		t.Fatalf("log line: %v (%q)", err, logBuf.String())
+	}
+	if entry.BytesOut != 1500 {
+		t.Errorf("GET bytes_out: %d, want 1500", entry.BytesOut)
+	}
+	if entry.BytesIn != 0 {
+		t.Errorf("GET bytes_in: %d, want 0", entry.BytesIn)
+	}
+	if !strings.Contains(logBuf.String(), `"bytes_out":1500`) {
+		t.Errorf("log line missing bytes_out field: %s", logBuf.String())
+	}
+}
+
+// readFromRecorder is a ResponseRecorder that also implements io.ReaderFrom,
+// standing in for net/http's sendfile-capable response writer.
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFromCalls int
+}
+
+func (r *readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFromCalls++
+	return io.Copy(r.ResponseRecorder.Body, src)
+}
+
+func TestLoggingMiddlewarePreservesReaderFrom(t *testing.T) {
+	logBuf := captureAccessLog(t)
+	storage := NewFilesystemStorage(t.TempDir())
+	logged := LoggingMiddleware(NewS3Handler(storage, &NoOpAuthenticator{}))
+	storage.CreateBucket("logs")
+
+	payload := bytes.Repeat([]byte("s"), 1<<20)
+	storage.PutObject("logs", "big.bin", bytes.NewReader(payload), nil)
+
+	rec := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	logged.ServeHTTP(rec, httptest.NewRequest("GET", "/logs/big.bin", nil))
+
+	assertStatus(t, "GET", rec.Code, http.StatusOK)
+	if rec.readFromCalls == 0 {
+		t.Error("GET through logging middleware did not use the writer's ReadFrom")
+	}
+	if !bytes.Equal(rec.Body.Bytes(), payload) {
+		t.Errorf("body mismatch: got %d bytes", rec.Body.Len())
+	}
+
+	var entry LogEntry
+	if err := json.Unmarshal(logBuf.Bytes(), &entry); err != nil {
+		t.Fatalf("log line: %v (%q)", err, logBuf.String())
+	}
+	if entry.BytesOut != int64(len(payload)) {
+		t.Errorf("bytes_out: %d, want %d", entry.BytesOut, len(payload))
+	}
+}
+
+func TestLoggingMiddlewareExposesFlusherAndHijacker(t *testing.T) {
+	captureAccessLog(t)
+	var flushed, hijackable bool
+	logged := LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+			flushed = true
+		}
+		_, hijackable = w.(http.Hijacker)
+	}))
+
+	rec := httptest.NewRecorder()
+	logged.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if !flushed || !rec.Flushed {
+		t.Error("Flush did not reach the underlying writer")
+	}
+	if !hijackable {
+		t.Error("wrapper does not implement http.Hijacker")
+	}
+}
+
+func TestMinUploadRateMiddleware(t *testing.T) {
+	handler := NewS3Handler(NewFilesystemStorage(t.TempDir()), &NoOpAuthenticator{})
+	// 1000 bytes/s over 200ms: each window must deliver 200 bytes.
+	srv := httptest.NewServer(MinUploadRateMiddleware(1000, 200*time.Millisecond, time.Hour)(handler))
+	defer srv.Close()
+	mustDo(t, "PUT", srv.URL+"/mybucket", nil, nil).Body.Close()
+
+	// A client that sends a few bytes and then stalls is cut off.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("trickle"))
+	req, _ := http.NewRequest("PUT", srv.URL+"/mybucket/slow.bin", pr)
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 400 || !strings.Contains(string(body), "<Code>RequestTimeout</Code>") {
+		t.Errorf("stalled upload: %d %s", resp.StatusCode, body)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled upload took %v to abort", elapsed)
+	}
+
+	// A slow but steady upload above the floor completes.
+	pr, pw = io.Pipe()
+	go func() {
+		for i := 0; i < 5; i++ {
+			pw.Write(bytes.Repeat([]byte("s"), 300))
+			time.Sleep(100 * time.Millisecond)
+		}
+		pw.Close()
+	}()
+	req, _ = http.NewRequest("PUT", srv.URL+"/mybucket/steady.bin", pr)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assertStatus(t, "steady upload", resp.StatusCode, 200)
+
+	// Requests whose body is never read are not affected by the deadline.
+	time.Sleep(300 * time.Millisecond)
+	resp = mustDo(t, "GET", srv.URL+"/mybucket/steady.bin", nil, nil)
+	if body := readBody(t, resp); len(body) != 1500 {
+		t.Errorf("GET after upload: %d bytes", len(body))
+	}
+}
+
+func BenchmarkLoggingMiddlewareLargeGet(b *testing.B) {
+	accessLogOutput = io.Discard
+	defer func() { accessLogOutput = os.Stdout }()
+
+	storage := NewFilesystemStorage(b.TempDir())
+	handler := NewS3Handler(storage, &NoOpAuthenticator{})
+	storage.CreateBucket("bench")
+	const size = 64 << 20
+	storage.PutObject("bench", "big.bin", bytes.NewReader(make([]byte, size)), nil)
+
+	for _, tc := range []struct {
+		name string
+		h    http.Handler
+	}{
+		{"direct", handler},
+		{"logged", LoggingMiddleware(handler)},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			srv := httptest.NewServer(tc.h)
+			defer srv.Close()
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(srv.URL + "/bench/big.bin")
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Helpers
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/base32"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Key encodings selectable with SetKeyEncoding.
+const (
+	// keyEncodingRaw stores a key at the path it spells, so the data
+	// directory is browsable but inherits the filesystem's quirks.
+	keyEncodingRaw = "raw"
+	// keyEncodingBase32 stores each "/"-separated key segment as lowercase
+	// base32hex. Encoded names use only [0-9a-v], so keys differing in case
+	// or using names the filesystem reserves (CON, NUL, trailing dots,
+	// "..") never collide or fail.
+	keyEncodingBase32 = "base32"
+)
+
+// segmentEncoding encodes key segments; its alphabet has no letters beyond v,
+// so lowercasing it is lossless.
+var segmentEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// SetKeyEncoding selects how keys map to paths inside a bucket. Objects
+// stored under one encoding are invisible under the other, so the encoding
+// must not change once a data directory holds objects.
+func (fs *FilesystemStorage) SetKeyEncoding(mode string) error {
+	switch mode {
+	case keyEncodingRaw, "":
+		fs.encodeKeys = false
+	case keyEncodingBase32:
+		fs.encodeKeys = true
+	default:
+		return fmt.Errorf("unknown key encoding %q (want %s or %s)", mode, keyEncodingRaw, keyEncodingBase32)
+	}
+	return nil
+}
+
+// keyToRel returns the slash-separated path of key relative to its bucket.
+// Segments are encoded one by one so "/" still maps to directories and
+// delimiter listings can read a single directory.
+func (fs *FilesystemStorage) keyToRel(key string) string {
+	if !fs.encodeKeys {
+		return key
+	}
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = strings.ToLower(segmentEncoding.EncodeToString([]byte(seg)))
+	}
+	return strings.Join(segments, "/")
+}
+
+// relToKey reverses keyToRel. It reports false for paths that are not a valid
+// encoding, such as files placed in the bucket by hand.
+func (fs *FilesystemStorage) relToKey(rel string) (string, bool) {
+	if !fs.encodeKeys {
+		return rel, true
+	}
+	segments := strings.Split(rel, "/")
+	for i, seg := range segments {
+		name, ok := decodeSegment(seg)
+		if !ok {
+			return "", false
+		}
+		segments[i] = name
+	}
+	return strings.Join(segments, "/"), true
+}
+
+// decodeSegment decodes one encoded path segment.
+func decodeSegment(seg string) (string, bool) {
+	if seg != strings.ToLower(seg) {
+		return "", false
+	}
+	data, err := segmentEncoding.DecodeString(strings.ToUpper(seg))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// checkWindowsNames makes raw-mode keys whose segments Windows cannot use as
+// file names fail with ErrInvalidKeyName instead of an opaque OS error.
+var checkWindowsNames = runtime.GOOS == "windows"
+
+// windowsReservedNames are device names Windows refuses as file names, with
+// or without an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true,
+	"COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+	"LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// windowsNameProblem explains why seg cannot be a Windows file name, or
+// returns "" if it can.
+func windowsNameProblem(seg string) string {
+	if i := strings.IndexAny(seg, `<>:"|?*\`); i >= 0 {
+		return fmt.Sprintf("contains %q", seg[i])
+	}
+	for _, c := range seg {
+		if c < 0x20 {
+			return "contains a control character"
+		}
+	}
+	if strings.HasSuffix(seg, " ") || strings.HasSuffix(seg, ".") {
+		return "ends with a space or dot"
+	}
+	base, _, _ := strings.Cut(seg, ".")
+	if windowsReservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		return "is a reserved device name"
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// layoutVersionFile records, at the data directory root, the on-disk format
+// version the directory was last written with.
+const layoutVersionFile = ".geckos3-version"
+
+// layoutVersion is the on-disk format this build writes. Bump it, and add an
+// entry to layoutUpgrades, whenever a change makes the data directory
+// unreadable by older builds.
+const layoutVersion = 1
+
+// layoutUpgrades[i] rewrites a data directory from format i+1 to i+2. Data
+// directories from before the version file existed are format 1.
+var layoutUpgrades = []func(fs *FilesystemStorage) error{}
+
+// ErrLayoutTooNew is returned by UpgradeLayout and CheckLayout when the data
+// directory was written by a newer build with an incompatible format.
+var ErrLayoutTooNew = errors.New("the data directory was written by a newer geckos3 with an incompatible on-disk format")
+
+// LayoutVersion returns the format version recorded in the data directory.
+// A directory without a version file is reported as format 1, the format
+// geckos3 used before it recorded versions.
+func (fs *FilesystemStorage) LayoutVersion() (int, error) {
+	data, err := os.ReadFile(filepath.Join(fs.dataDir, layoutVersionFile))
+	if os.IsNotExist(err) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("corrupt %s: %q", layoutVersionFile, data)
+	}
+	return version, nil
+}
+
+// CheckLayout returns ErrLayoutTooNew if this build cannot read the data
+// directory. It never writes, so it is safe on directories that are only
+// read, such as the source of -migrate-from.
+func (fs *FilesystemStorage) CheckLayout() error {
+	version, err := fs.LayoutVersion()
+	if err != nil {
+		return err
+	}
+	if version > layoutVersion {
+		return fmt.Errorf("%w: %s is format %d, this build reads up to format %d; run a newer geckos3 or restore a backup taken before the upgrade",
+			ErrLayoutTooNew, fs.dataDir, version, layoutVersion)
+	}
+	return nil
+}
+
+// UpgradeLayout prepares the data directory for this build at startup. It
+// refuses directories from a newer format, brings older ones up to date one
+// version at a time, and records the current version. The file is rewritten
+// after each step, so an upgrade interrupted by a crash resumes where it
+// stopped.
+func (fs *FilesystemStorage) UpgradeLayout() error {
+	if err := fs.CheckLayout(); err != nil {
+		return err
+	}
+	version, err := fs.LayoutVersion()
+	if err != nil {
+		return err
+	}
+	for ; version < layoutVersion; version++ {
+		log.Printf("Upgrading data directory %s from format %d to %d", fs.dataDir, version, version+1)
+		if err := layoutUpgrades[version-1](fs); err != nil {
+			return fmt.Errorf("upgrade from format %d: %w", version, err)
+		}
+		if err := fs.writeLayoutVersion(version + 1); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(fs.dataDir, layoutVersionFile)); os.IsNotExist(err) {
+		return fs.writeLayoutVersion(layoutVersion)
+	}
+	return nil
+}
+
+func (fs *FilesystemStorage) writeLayoutVersion(version int) error {
+	tmpFile, err := os.CreateTemp(fs.dataDir, layoutVersionFile+"-tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	if _, err := fmt.Fprintf(tmpFile, "%d\n", version); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if fs.enableFsync {
+		if err := tmpFile.Sync(); err != nil {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(fs.dataDir, layoutVersionFile)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if fs.enableFsync {
+		syncParentDir(filepath.Join(fs.dataDir, layoutVersionFile))
+	}
+	return nil
+}
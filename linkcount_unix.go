@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// hardLinksSupported reports whether hardLinkCount works on this platform.
+const hardLinksSupported = true
+
+// hardLinkCount returns the number of hard links to the file at path. ok is
+// false if it cannot be determined.
+func hardLinkCount(path string) (n uint64, ok bool) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, false
+	}
+	return uint64(st.Nlink), true
+}
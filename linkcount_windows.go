@@ -0,0 +1,12 @@
+//go:build windows
+
+package main
+
+// hardLinksSupported reports whether hardLinkCount works on this platform.
+// os.FileInfo does not expose link counts on Windows.
+const hardLinksSupported = false
+
+// hardLinkCount is not available on Windows.
+func hardLinkCount(path string) (n uint64, ok bool) {
+	return 0, false
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"sync/atomic"
@@ -11,6 +15,9 @@ import (
 
 var requestCounter atomic.Int64
 
+// accessLogOutput is where LoggingMiddleware writes its JSON lines.
+var accessLogOutput io.Writer = os.Stdout
+
 type responseWriterWithRequest struct {
 	http.ResponseWriter
 	statusCode int
@@ -29,6 +36,50 @@ func (rw *responseWriterWithRequest) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// ReadFrom keeps the underlying writer's io.ReaderFrom reachable so
+// http.ServeContent can still use sendfile through the wrapper.
+func (rw *responseWriterWithRequest) ReadFrom(src io.Reader) (int64, error) {
+	var n int64
+	var err error
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(writerOnly{rw.ResponseWriter}, src)
+	}
+	rw.written += n
+	return n, err
+}
+
+func (rw *responseWriterWithRequest) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *responseWriterWithRequest) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := rw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriterWithRequest) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// countingReadCloser wraps a request body and counts the bytes read from it.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 type contextKey string
 
 const errorContextKey contextKey = "geckos3-error"
@@ -40,7 +91,8 @@ type LogEntry struct {
 	URI       string `json:"uri"`
 	Status    int    `json:"status"`
 	Duration  int64  `json:"duration_ms"`
-	Bytes     int64  `json:"bytes,omitempty"`
+	BytesIn   int64  `json:"bytes_in,omitempty"`
+	BytesOut  int64  `json:"bytes_out,omitempty"`
 	ClientIP  string `json:"client_ip"`
 	Error     string `json:"error,omitempty"` // Log errors
 }
@@ -62,6 +114,13 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 			request:        r,
 		}
 
+		// Count bytes read from the request body (uploads)
+		var body *countingReadCloser
+		if r.Body != nil {
+			body = &countingReadCloser{ReadCloser: r.Body}
+			r.Body = body
+		}
+
 		// Call next handler
 		next.ServeHTTP(rw, r)
 
@@ -75,9 +134,12 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 			URI:       r.RequestURI,
 			Status:    rw.statusCode,
 			Duration:  duration,
-			Bytes:     rw.written,
+			BytesOut:  rw.written,
 			ClientIP:  r.RemoteAddr,
 		}
+		if body != nil {
+			entry.BytesIn = body.n
+		}
 
 		// Extract error from context if present
 		if errVal := r.Context().Value(errorContextKey); errVal != nil {
@@ -88,6 +150,6 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 
 		// Write JSON log line to stdout
 		data, _ := json.Marshal(entry)
-		fmt.Fprintln(os.Stdout, string(data))
+		fmt.Fprintln(accessLogOutput, string(data))
 	})
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -28,9 +30,65 @@ type Config struct {
 	AuthEnabled     bool
 	FsyncEnabled    bool
 	MetadataEnabled bool
+	IndexEnabled    bool
+	Dedup           bool
+	DirectWrite     bool
+	MigrateFrom     string
+	CacheDir        string
+	CacheSize       int
+	ReadBufferSize  int
+	MaxParts        int
+	MaxBuckets      int
+	EndpointHost    string
+	TrustForwarded  bool
+	TrustedProxies  string
+	AnonWrite       string
+	LogFormat       string
+	PrettyXML       bool
+	JSONErrors      bool
+	Quiet           bool
+	RequireSecure   bool
+	Production      bool
+	CopyRehashMPU   bool
+	ErrorDocument   string
+	IndexDocument   string
+	DefaultCacheCtl string
+	MetadataListing bool
+	CustomSort      bool
+	BucketUsage     bool
+	AcceptACL       bool
+	GCDryRun        bool
+	PackedParts     bool
+	RequireCase     bool
+	KeyEncoding     string
+	BucketRename    bool
+	NoFollowLinks   bool
+	NegativeTTL     time.Duration
+	LockWaitWarn    time.Duration
+	ListDeadline    time.Duration
+	MaxListParamLen int
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	HeaderTimeout   time.Duration
+	MinUploadRate   int
+	MinRateWindow   time.Duration
+	AdminListen     string
+	MetricsBuckets  string
+	MetricsMaxBkts  int
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "presign" {
+		os.Exit(runPresign(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		os.Exit(runSelftest(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	var showVersion bool
 	config := &Config{}
 
@@ -42,6 +100,52 @@ func main() {
 	flag.BoolVar(&config.AuthEnabled, "auth", parseBoolEnv("GECKOS3_AUTH_ENABLED", true), "Enable authentication")
 	flag.BoolVar(&config.FsyncEnabled, "fsync", parseBoolEnv("GECKOS3_FSYNC", false), "Fsync files and directories after writes (slower, stronger durability)")
 	flag.BoolVar(&config.MetadataEnabled, "metadata", parseBoolEnv("GECKOS3_METADATA", true), "Persist metadata in .json sidecar files (disable for performance)")
+	flag.BoolVar(&config.IndexEnabled, "index", parseBoolEnv("GECKOS3_INDEX", false), "Maintain an on-disk key index per bucket for fast listings of huge buckets")
+	flag.BoolVar(&config.Dedup, "dedup", parseBoolEnv("GECKOS3_DEDUP", false), "Store identical PutObject bodies once, as hard links to a shared content-addressed blob")
+	flag.BoolVar(&config.DirectWrite, "direct-write", parseBoolEnv("GECKOS3_DIRECT_WRITE", false), "Write PutObject bodies next to the object while holding its lock, instead of staging them (trusted single-writer setups only)")
+	flag.StringVar(&config.MigrateFrom, "migrate-from", getEnv("GECKOS3_MIGRATE_FROM", ""), "Old data directory to migrate from: reads fall through to it and its objects are copied into -data-dir in the background")
+	flag.StringVar(&config.CacheDir, "cache-dir", getEnv("GECKOS3_CACHE_DIR", ""), "Local directory for a read-through cache of object data (empty = no cache)")
+	flag.IntVar(&config.CacheSize, "cache-size", parseIntEnv("GECKOS3_CACHE_SIZE", 1<<30), "Maximum bytes of object data kept in -cache-dir")
+	flag.IntVar(&config.ReadBufferSize, "read-buffer-size", parseIntEnv("GECKOS3_READ_BUFFER_SIZE", defaultReadBufferSize), "Copy buffer size in bytes for streaming object reads")
+	flag.IntVar(&config.MaxParts, "max-concurrent-parts", parseIntEnv("GECKOS3_MAX_CONCURRENT_PARTS", 0), "Max concurrent part uploads per multipart upload ID (0 = unlimited)")
+	flag.IntVar(&config.MaxBuckets, "max-buckets", parseIntEnv("GECKOS3_MAX_BUCKETS", 1000), "Maximum number of buckets (0 = unlimited)")
+	flag.StringVar(&config.EndpointHost, "endpoint-host", getEnv("GECKOS3_ENDPOINT_HOST", ""), "Only accept requests whose Host header matches this host[:port] (empty = any)")
+	flag.BoolVar(&config.TrustForwarded, "trust-forwarded", parseBoolEnv("GECKOS3_TRUST_FORWARDED", false), "Use X-Forwarded-Host/Proto from trusted proxies for signature verification")
+	flag.StringVar(&config.TrustedProxies, "trusted-proxies", getEnv("GECKOS3_TRUSTED_PROXIES", "127.0.0.1,::1"), "Comma-separated proxy IPs/CIDRs whose forwarded headers are trusted")
+	flag.StringVar(&config.ErrorDocument, "error-document", getEnv("GECKOS3_ERROR_DOCUMENT", ""), "bucket/key of an object served with status 404 when an object GET finds nothing")
+	flag.StringVar(&config.IndexDocument, "index-document", getEnv("GECKOS3_INDEX_DOCUMENT", ""), "Object name served for GETs of keys ending in / and unsigned bucket roots (e.g. index.html)")
+	flag.StringVar(&config.DefaultCacheCtl, "default-cache-control", getEnv("GECKOS3_DEFAULT_CACHE_CONTROL", ""), "Cache-Control header sent on GET/HEAD for objects stored without one (e.g. public, max-age=3600)")
+	flag.BoolVar(&config.MetadataListing, "allow-metadata-listing", parseBoolEnv("GECKOS3_ALLOW_METADATA_LISTING", false), "Allow the ListObjectsV2 metadata=true extension (one metadata read per listed object)")
+	flag.BoolVar(&config.CustomSort, "allow-custom-sort", parseBoolEnv("GECKOS3_ALLOW_CUSTOM_SORT", false), "Allow the ListObjectsV2 sort=lastmodified-desc extension (single page, no continuation token)")
+	flag.BoolVar(&config.BucketUsage, "allow-bucket-usage", parseBoolEnv("GECKOS3_ALLOW_BUCKET_USAGE", false), "Allow the ListBuckets usage=true extension (walks each bucket; results cached for 30s)")
+	flag.BoolVar(&config.AcceptACL, "accept-acl", parseBoolEnv("GECKOS3_ACCEPT_ACL", false), "Accept bucket ACL writes with 200 and record canned ACLs (ACLs are never enforced)")
+	flag.BoolVar(&config.BucketRename, "allow-bucket-rename", parseBoolEnv("GECKOS3_ALLOW_BUCKET_RENAME", false), "Allow the POST /{bucket}?rename admin extension")
+	flag.BoolVar(&config.NoFollowLinks, "no-follow-symlinks", parseBoolEnv("GECKOS3_NO_FOLLOW_SYMLINKS", false), "Never serve or list objects reached through a symlink inside a bucket")
+	flag.DurationVar(&config.NegativeTTL, "negative-cache-ttl", parseDurationEnv("GECKOS3_NEGATIVE_CACHE_TTL", 0), "Cache missing-key lookups for GET/HEAD for this long (e.g. 5s); 0 disables")
+	flag.DurationVar(&config.LockWaitWarn, "lock-wait-warn", parseDurationEnv("GECKOS3_LOCK_WAIT_WARN", time.Second), "Log a warning when a write waits longer than this for a stripe lock; 0 disables")
+	flag.DurationVar(&config.ListDeadline, "list-deadline", parseDurationEnv("GECKOS3_LIST_DEADLINE", 30*time.Second), "Answer ListObjects with 503 SlowDown if walking the bucket takes longer than this; 0 disables")
+	flag.IntVar(&config.MaxListParamLen, "max-list-param-length", parseIntEnv("GECKOS3_MAX_LIST_PARAM_LENGTH", defaultMaxListParamLength), "Reject listing prefixes, delimiters and markers longer than this many bytes; 0 disables")
+	flag.DurationVar(&config.ReadTimeout, "read-timeout", parseDurationEnv("GECKOS3_READ_TIMEOUT", 6*time.Hour), "Maximum time to read a whole request, body included; 0 means no limit")
+	flag.DurationVar(&config.WriteTimeout, "write-timeout", parseDurationEnv("GECKOS3_WRITE_TIMEOUT", 6*time.Hour), "Maximum time from the end of the request headers to the end of the response; 0 means no limit")
+	flag.DurationVar(&config.IdleTimeout, "idle-timeout", parseDurationEnv("GECKOS3_IDLE_TIMEOUT", 120*time.Second), "How long an idle keep-alive connection stays open; 0 uses -read-timeout")
+	flag.DurationVar(&config.HeaderTimeout, "read-header-timeout", parseDurationEnv("GECKOS3_READ_HEADER_TIMEOUT", 10*time.Second), "Maximum time to read request headers; 0 uses -read-timeout")
+	flag.IntVar(&config.MinUploadRate, "min-upload-rate", parseIntEnv("GECKOS3_MIN_UPLOAD_RATE", 0), "Abort request bodies sent slower than this many bytes per second over -min-upload-rate-window (0 = disabled)")
+	flag.DurationVar(&config.MinRateWindow, "min-upload-rate-window", parseDurationEnv("GECKOS3_MIN_UPLOAD_RATE_WINDOW", 30*time.Second), "Window over which -min-upload-rate is measured")
+	flag.StringVar(&config.AdminListen, "admin-listen", getEnv("GECKOS3_ADMIN_LISTEN", ""), "Address for the unauthenticated admin API (e.g. 127.0.0.1:9001); empty disables it")
+	flag.IntVar(&config.MetricsMaxBkts, "metrics-max-buckets", parseIntEnv("GECKOS3_METRICS_MAX_BUCKETS", defaultMetricsMaxBuckets), "Buckets that get their own per-bucket metrics series; the rest are counted as _other (0 disables per-bucket metrics)")
+	flag.StringVar(&config.MetricsBuckets, "metrics-buckets", getEnv("GECKOS3_METRICS_BUCKETS", ""), "Comma-separated buckets that get per-bucket metrics series, instead of the first -metrics-max-buckets seen")
+	flag.BoolVar(&config.PackedParts, "multipart-packed", parseBoolEnv("GECKOS3_MULTIPART_PACKED", false), "Stage all parts of a multipart upload in one file with an offset index instead of one file per part")
+	flag.BoolVar(&config.GCDryRun, "gc-dry-run", parseBoolEnv("GECKOS3_GC_DRY_RUN", false), "Log the abandoned multipart uploads the GC would remove instead of removing them")
+	flag.StringVar(&config.AnonWrite, "anonymous-write-prefix", getEnv("GECKOS3_ANONYMOUS_WRITE_PREFIX", ""), "Comma-separated bucket/prefix paths that accept unauthenticated object PUTs")
+	flag.StringVar(&config.LogFormat, "log-format", getEnv("GECKOS3_LOG_FORMAT", "text"), "Startup log format: text or json")
+	flag.BoolVar(&config.PrettyXML, "pretty-xml", parseBoolEnv("GECKOS3_PRETTY_XML", false), "Indent XML responses (for debugging with curl)")
+	flag.BoolVar(&config.JSONErrors, "json-errors", parseBoolEnv("GECKOS3_JSON_ERRORS", true), "Send errors as JSON to clients whose Accept header prefers application/json (false = always XML)")
+	flag.BoolVar(&config.Quiet, "quiet", parseBoolEnv("GECKOS3_QUIET", false), "Silence the default-credential and auth-disabled warnings")
+	flag.BoolVar(&config.RequireSecure, "require-secure-credentials", parseBoolEnv("GECKOS3_REQUIRE_SECURE_CREDENTIALS", false), "Refuse to start with the default credentials")
+	flag.StringVar(&config.KeyEncoding, "key-encoding", getEnv("GECKOS3_KEY_ENCODING", keyEncodingRaw), "How keys map to file names: raw (browsable) or base32 (safe on case-insensitive and restrictive filesystems); do not change once objects exist")
+	flag.BoolVar(&config.RequireCase, "require-case-sensitive", parseBoolEnv("GECKOS3_REQUIRE_CASE_SENSITIVE", false), "Refuse to start if the data directory's filesystem is case-insensitive")
+	flag.BoolVar(&config.Production, "production", parseBoolEnv("GECKOS3_PRODUCTION", false), "Refuse to start with an insecure configuration (no auth, default credentials, no TLS proxy)")
+	flag.BoolVar(&config.CopyRehashMPU, "copy-recompute-etag", parseBoolEnv("GECKOS3_COPY_RECOMPUTE_ETAG", false), "Give copies of multipart objects a single-part MD5 ETag, as S3 does, instead of keeping the source's -N ETag")
 	flag.Parse()
 
 	if showVersion {
@@ -51,6 +155,27 @@ func main() {
 		os.Exit(0)
 	}
 
+	if config.LogFormat != "text" && config.LogFormat != "json" {
+		log.Fatalf("Invalid -log-format %q: must be text or json", config.LogFormat)
+	}
+
+	if err := validateTimeouts(config); err != nil {
+		log.Fatal(err)
+	}
+	if config.MinUploadRate < 0 || (config.MinUploadRate > 0 && config.MinRateWindow <= 0) {
+		log.Fatalf("Invalid -min-upload-rate %d / -min-upload-rate-window %s: the rate must not be negative and the window must be positive", config.MinUploadRate, config.MinRateWindow)
+	}
+
+	if config.Production {
+		if problems := productionProblems(config); len(problems) > 0 {
+			fmt.Fprintln(os.Stderr, "Refusing to start in -production mode. Fix the following:")
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "  [ ] %s\n", p)
+			}
+			os.Exit(1)
+		}
+	}
+
 	// Create data directory if it doesn't exist
 	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
 		log.Fatalf("Failed to create data directory: %v", err)
@@ -58,6 +183,21 @@ func main() {
 
 	// Initialize storage layer
 	storage := NewFilesystemStorage(config.DataDir)
+	if err := storage.SetKeyEncoding(config.KeyEncoding); err != nil {
+		log.Fatalf("Invalid -key-encoding: %v", err)
+	}
+	// Encoded keys never differ only in case, so the filesystem's case
+	// handling cannot cause collisions.
+	if config.KeyEncoding == keyEncodingBase32 {
+		log.Printf("Key encoding: base32 (file names in %s are not the object keys)", config.DataDir)
+	} else if insensitive, err := storage.CaseInsensitive(); err != nil {
+		log.Printf("Could not check whether %s is case-sensitive: %v", config.DataDir, err)
+	} else if insensitive {
+		if config.RequireCase {
+			log.Fatalf("Data directory %s is on a case-insensitive filesystem (-require-case-sensitive is set)", config.DataDir)
+		}
+		log.Printf("WARNING: Data directory %s is on a case-insensitive filesystem. Keys that differ only in case (File.txt, file.txt) are stored as the same file and overwrite each other, unlike S3.", config.DataDir)
+	}
 	if config.FsyncEnabled {
 		storage.SetFsync(true)
 		log.Println("Fsync enabled: per-object durability mode (slower writes)")
@@ -66,46 +206,223 @@ func main() {
 		storage.SetMetadataEnabled(false)
 		log.Println("WARNING: Metadata persistence disabled. Custom headers and ETags will not be preserved.")
 	}
+	if config.CopyRehashMPU {
+		storage.SetCopyRecomputesMultipartETag(true)
+	}
+	if config.NoFollowLinks {
+		storage.SetNoFollowSymlinks(true)
+	}
+	if config.PackedParts {
+		storage.SetPackedMultipart(true)
+	}
+	if config.DirectWrite {
+		storage.SetDirectWrite(true)
+		log.Println("Direct writes enabled: a slow upload blocks other writers on the same lock stripe")
+	}
+	// Before anything reads the on-disk format: refuse directories written
+	// by a newer build and bring older ones up to date.
+	if err := storage.UpgradeLayout(); err != nil {
+		log.Fatalf("Data directory %s: %v", config.DataDir, err)
+	}
+	if config.Dedup {
+		if err := storage.SetDedup(true); err != nil {
+			log.Fatalf("Invalid -dedup: %v", err)
+		}
+		if _, err := storage.SweepBlobs(); err != nil {
+			log.Printf("Dedup: could not sweep unreferenced blobs: %v", err)
+		}
+		log.Printf("Dedup enabled: object data is shared through %s", filepath.Join(config.DataDir, casDir))
+	}
+	storage.SetNegativeCacheTTL(config.NegativeTTL)
+	storage.SetLockWaitWarning(config.LockWaitWarn)
+	if config.IndexEnabled {
+		storage.SetIndexEnabled(true)
+		if err := storage.LoadIndexes(); err != nil {
+			log.Fatalf("Failed to load key index: %v", err)
+		}
+		log.Println("Key index enabled: listings are served from the on-disk index")
+	}
+
+	var backend Storage = storage
+	var migrating *MigratingStorage
+	if config.MigrateFrom != "" {
+		if _, err := os.Stat(config.MigrateFrom); err != nil {
+			log.Fatalf("Invalid -migrate-from: %v", err)
+		}
+		old := NewFilesystemStorage(config.MigrateFrom)
+		if old.dataDir == storage.dataDir {
+			log.Fatalf("Invalid -migrate-from: %s is the data directory itself", config.MigrateFrom)
+		}
+		// The old directory was written with the same layout options.
+		old.SetKeyEncoding(config.KeyEncoding)
+		old.SetMetadataEnabled(config.MetadataEnabled)
+		old.SetNoFollowSymlinks(config.NoFollowLinks)
+		if err := old.CheckLayout(); err != nil {
+			log.Fatalf("Invalid -migrate-from: %v", err)
+		}
+		var err error
+		if migrating, err = NewMigratingStorage(storage, old); err != nil {
+			log.Fatalf("Failed to start migration from %s: %v", config.MigrateFrom, err)
+		}
+		backend = migrating
+		log.Printf("Migrating from %s: reads fall through to it until every object is copied", config.MigrateFrom)
+	}
+
+	if config.CacheDir != "" {
+		cached, err := NewCachingStorage(backend, config.CacheDir, int64(config.CacheSize))
+		if err != nil {
+			log.Fatalf("Invalid -cache-dir: %v", err)
+		}
+		backend = cached
+		log.Printf("Read cache enabled: up to %d bytes of object data in %s", config.CacheSize, cached.dir)
+	}
 
 	// Initialize auth layer
 	var auth Authenticator
 	if config.AuthEnabled {
 		auth = NewSigV4Authenticator(config.AccessKey, config.SecretKey)
-		if config.AccessKey == "geckoadmin" || config.SecretKey == "geckoadmin" {
-			log.Println("WARNING: Using default credentials. Set GECKOS3_ACCESS_KEY and GECKOS3_SECRET_KEY for production use.")
+		if usesDefaultCredentials(config) {
+			if config.RequireSecure {
+				log.Fatal("Refusing to start with default credentials (-require-secure-credentials). Set GECKOS3_ACCESS_KEY and GECKOS3_SECRET_KEY.")
+			}
+			if !config.Quiet {
+				log.Println("WARNING: Using default credentials. Set GECKOS3_ACCESS_KEY and GECKOS3_SECRET_KEY for production use.")
+			}
 		}
 	} else {
 		auth = &NoOpAuthenticator{}
-		log.Println("WARNING: Authentication is disabled. All requests will be accepted.")
+		if !config.Quiet {
+			log.Println("WARNING: Authentication is disabled. All requests will be accepted.")
+		}
 	}
 
 	// Initialize handler
-	handler := NewS3Handler(storage, auth)
+	handler := NewS3Handler(backend, auth)
+	handler.SetReadBufferSize(config.ReadBufferSize)
+	handler.SetMaxConcurrentParts(config.MaxParts)
+	handler.SetMaxBuckets(config.MaxBuckets)
+	handler.SetListDeadline(config.ListDeadline)
+	handler.SetMaxListParamLength(config.MaxListParamLen)
+	handler.SetEndpointHost(config.EndpointHost)
+	if config.AnonWrite != "" {
+		handler.SetAnonymousWritePrefixes(strings.Split(config.AnonWrite, ","))
+		for _, p := range handler.anonWritePrefixes {
+			log.Printf("Anonymous write enabled for prefix %s (PUT only)", p)
+		}
+	}
+
+	handler.SetAllowMetadataListing(config.MetadataListing)
+	handler.SetAllowCustomSort(config.CustomSort)
+	handler.SetAllowBucketUsage(config.BucketUsage)
+	handler.SetPrettyXML(config.PrettyXML)
+	handler.SetJSONErrors(config.JSONErrors)
+	handler.SetAllowBucketRename(config.BucketRename)
+	handler.SetAcceptACL(config.AcceptACL)
+	if err := handler.SetErrorDocument(config.ErrorDocument); err != nil {
+		log.Fatalf("Invalid -error-document: %v", err)
+	}
+	if err := handler.SetIndexDocument(config.IndexDocument); err != nil {
+		log.Fatalf("Invalid -index-document: %v", err)
+	}
+	handler.SetDefaultCacheControl(config.DefaultCacheCtl)
+	var metricsBuckets []string
+	for _, b := range strings.Split(config.MetricsBuckets, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			metricsBuckets = append(metricsBuckets, b)
+		}
+	}
+	bucketRequests.configure(config.MetricsMaxBkts, metricsBuckets)
 
 	// Wrap with CORS, logging middleware and concurrency limit
-	loggedHandler := CORSMiddleware(LoggingMiddleware(MaxClientsMiddleware(1024)(handler)))
+	var inner http.Handler = MaxClientsMiddleware(1024)(handler)
+	if config.TrustForwarded {
+		proxies, err := ParseTrustedProxies(config.TrustedProxies)
+		if err != nil {
+			log.Fatalf("Invalid -trusted-proxies: %v", err)
+		}
+		inner = ForwardedHeadersMiddleware(proxies)(inner)
+		log.Printf("Trusting X-Forwarded-Host/Proto from %s", config.TrustedProxies)
+	}
+	loggedHandler := handler.CORSMiddleware(LoggingMiddleware(inner))
+	loggedHandler = MinUploadRateMiddleware(int64(config.MinUploadRate), config.MinRateWindow, config.ReadTimeout)(loggedHandler)
+	if config.MinUploadRate > 0 {
+		log.Printf("Aborting request bodies slower than %d bytes/s over %s", config.MinUploadRate, config.MinRateWindow)
+	}
+
+	if migrating != nil {
+		go func() {
+			migrated, failed, err := migrating.MigrateAll(context.Background())
+			switch {
+			case err != nil:
+				log.Printf("Migration stopped after copying %d objects: %v", migrated, err)
+			case failed > 0:
+				log.Printf("Migration pass done: %d objects copied, %d failed; restart to retry them", migrated, failed)
+			default:
+				log.Printf("Migration complete: %d objects copied. Every object of %s is now in %s; restart without -migrate-from.", migrated, config.MigrateFrom, config.DataDir)
+			}
+		}()
+	}
+
+	// Direct-write part files left by a crash are never listed or read, only
+	// left behind; sweep them without delaying startup.
+	if config.DirectWrite {
+		go func() {
+			if n, err := storage.RemoveDirectWriteParts(); err != nil {
+				log.Printf("Direct-write part cleanup failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Removed %d direct-write part files left by interrupted uploads", n)
+			}
+		}()
+	}
 
 	// Start background garbage collection for abandoned multipart uploads.
-	startMultipartGC(config.DataDir, 1*time.Hour, 24*time.Hour)
+	startMultipartGC(config.DataDir, 1*time.Hour, 24*time.Hour, config.GCDryRun, &handler.writeBarrier)
+	if config.GCDryRun {
+		log.Printf("Multipart GC is in dry-run mode: abandoned uploads are logged, not removed")
+	}
 
 	server := &http.Server{
 		Addr:              config.ListenAddr,
 		Handler:           loggedHandler,
-		ReadHeaderTimeout: 10 * time.Second,
-		ReadTimeout:       6 * time.Hour,
-		WriteTimeout:      6 * time.Hour,
-		IdleTimeout:       120 * time.Second,
+		ReadHeaderTimeout: config.HeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
 	}
 
 	// Start server in goroutine for graceful shutdown support
 	go func() {
-		log.Printf("Starting geckos3 %s on %s (data-dir=%s, auth=%v)",
-			version, config.ListenAddr, config.DataDir, config.AuthEnabled)
+		logStartup(config)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
 
+	var adminServer *http.Server
+	if config.AdminListen != "" {
+		adminServer = &http.Server{
+			Addr:              config.AdminListen,
+			Handler:           LoggingMiddleware(handler.AdminHandler()),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Printf("Admin API listening on %s", config.AdminListen)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin server failed: %v", err)
+			}
+		}()
+	}
+
+	if len(maintenanceSignals) > 0 {
+		toggle := make(chan os.Signal, 1)
+		signal.Notify(toggle, maintenanceSignals...)
+		go func() {
+			for range toggle {
+				log.Printf("Maintenance mode %s (signal)", onOff(handler.ToggleMaintenance()))
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -114,12 +431,168 @@ func main() {
 	log.Println("Shutting down server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
+	if adminServer != nil {
+		adminServer.Shutdown(ctx)
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced shutdown: %v", err)
 	}
+	if err := storage.CloseIndex(); err != nil {
+		log.Printf("Failed to close key index: %v", err)
+	}
 	log.Println("Server stopped")
 }
 
+// usesDefaultCredentials reports whether either key is still the built-in
+// default.
+func usesDefaultCredentials(config *Config) bool {
+	return config.AccessKey == "geckoadmin" || config.SecretKey == "geckoadmin"
+}
+
+// productionProblems lists the insecure settings that -production rejects.
+// geckos3 has no built-in TLS, so TLS counts as configured only when it is
+// terminated by a trusted reverse proxy (-trust-forwarded).
+func productionProblems(config *Config) []string {
+	var problems []string
+	if !config.AuthEnabled {
+		problems = append(problems, "Authentication is disabled: remove -auth=false / GECKOS3_AUTH_ENABLED=false")
+	} else if usesDefaultCredentials(config) {
+		problems = append(problems, "Default credentials in use: set GECKOS3_ACCESS_KEY and GECKOS3_SECRET_KEY")
+	}
+	if !config.TrustForwarded {
+		problems = append(problems, "TLS is not configured: run behind a TLS-terminating proxy and set -trust-forwarded")
+	}
+	return problems
+}
+
+// setting is one named effective configuration value for the startup log.
+type setting struct {
+	Name  string
+	Value any
+}
+
+// validateTimeouts rejects negative server timeouts, which net/http would
+// silently treat as "no limit".
+func validateTimeouts(config *Config) error {
+	for _, t := range []struct {
+		flag  string
+		value time.Duration
+	}{
+		{"read-timeout", config.ReadTimeout},
+		{"write-timeout", config.WriteTimeout},
+		{"idle-timeout", config.IdleTimeout},
+		{"read-header-timeout", config.HeaderTimeout},
+	} {
+		if t.value < 0 {
+			return fmt.Errorf("invalid -%s %s: must not be negative", t.flag, t.value)
+		}
+	}
+	return nil
+}
+
+// effectiveSettings lists every configuration value the server runs with.
+// The data directory is reported as an absolute path and the secret key is
+// redacted.
+func effectiveSettings(config *Config) []setting {
+	dataDir := config.DataDir
+	if abs, err := filepath.Abs(dataDir); err == nil {
+		dataDir = abs
+	}
+	secret := "***"
+	if config.SecretKey == "" {
+		secret = ""
+	}
+	return []setting{
+		{"version", version},
+		{"data_dir", dataDir},
+		{"listen", config.ListenAddr},
+		{"tls", false},
+		{"auth", config.AuthEnabled},
+		{"access_key", config.AccessKey},
+		{"secret_key", secret},
+		{"fsync", config.FsyncEnabled},
+		{"metadata", config.MetadataEnabled},
+		{"index", config.IndexEnabled},
+		{"dedup", config.Dedup},
+		{"direct_write", config.DirectWrite},
+		{"migrate_from", config.MigrateFrom},
+		{"cache_dir", config.CacheDir},
+		{"cache_size", config.CacheSize},
+		{"read_buffer_size", config.ReadBufferSize},
+		{"max_buckets", config.MaxBuckets},
+		{"max_concurrent_parts", config.MaxParts},
+		{"endpoint_host", config.EndpointHost},
+		{"trust_forwarded", config.TrustForwarded},
+		{"trusted_proxies", config.TrustedProxies},
+		{"anonymous_write_prefix", config.AnonWrite},
+		{"error_document", config.ErrorDocument},
+		{"index_document", config.IndexDocument},
+		{"default_cache_control", config.DefaultCacheCtl},
+		{"allow_metadata_listing", config.MetadataListing},
+		{"allow_custom_sort", config.CustomSort},
+		{"allow_bucket_usage", config.BucketUsage},
+		{"allow_bucket_rename", config.BucketRename},
+		{"accept_acl", config.AcceptACL},
+		{"no_follow_symlinks", config.NoFollowLinks},
+		{"negative_cache_ttl", config.NegativeTTL.String()},
+		{"lock_wait_warn", config.LockWaitWarn.String()},
+		{"list_deadline", config.ListDeadline.String()},
+		{"max_list_param_length", config.MaxListParamLen},
+		{"read_timeout", config.ReadTimeout.String()},
+		{"write_timeout", config.WriteTimeout.String()},
+		{"idle_timeout", config.IdleTimeout.String()},
+		{"read_header_timeout", config.HeaderTimeout.String()},
+		{"min_upload_rate", config.MinUploadRate},
+		{"min_upload_rate_window", config.MinRateWindow.String()},
+		{"admin_listen", config.AdminListen},
+		{"metrics_max_buckets", config.MetricsMaxBkts},
+		{"metrics_buckets", config.MetricsBuckets},
+		{"multipart_packed", config.PackedParts},
+		{"gc_dry_run", config.GCDryRun},
+		{"log_format", config.LogFormat},
+		{"pretty_xml", config.PrettyXML},
+		{"json_errors", config.JSONErrors},
+		{"quiet", config.Quiet},
+		{"require_secure_credentials", config.RequireSecure},
+		{"key_encoding", config.KeyEncoding},
+		{"require_case_sensitive", config.RequireCase},
+		{"production", config.Production},
+		{"copy_recompute_etag", config.CopyRehashMPU},
+	}
+}
+
+// formatStartupLog renders the startup line as space-separated key=value
+// pairs, or as a single JSON object when format is "json".
+func formatStartupLog(settings []setting, format string) string {
+	if format == "json" {
+		entry := map[string]any{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"msg":       "starting geckos3",
+		}
+		for _, s := range settings {
+			entry[s.Name] = s.Value
+		}
+		data, _ := json.Marshal(entry)
+		return string(data)
+	}
+
+	var b strings.Builder
+	b.WriteString("Starting geckos3 with")
+	for _, s := range settings {
+		fmt.Fprintf(&b, " %s=%v", s.Name, s.Value)
+	}
+	return b.String()
+}
+
+func logStartup(config *Config) {
+	line := formatStartupLog(effectiveSettings(config), config.LogFormat)
+	if config.LogFormat == "json" {
+		fmt.Fprintln(log.Writer(), line)
+		return
+	}
+	log.Println(line)
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -141,21 +614,63 @@ func parseBoolEnv(key string, defaultVal bool) bool {
 	return b
 }
 
+// parseIntEnv reads an environment variable and parses it with strconv.Atoi.
+// Returns defaultVal if the variable is empty or unparseable.
+func parseIntEnv(key string, defaultVal int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return defaultVal
+	}
+	return n
+}
+
+// parseDurationEnv reads an environment variable and parses it with
+// time.ParseDuration. Returns defaultVal if the variable is empty or unparseable.
+func parseDurationEnv(key string, defaultVal time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return defaultVal
+	}
+	return d
+}
+
 // startMultipartGC launches a background goroutine that periodically removes
-// abandoned multipart upload staging directories older than maxAge.
-func startMultipartGC(dataDir string, interval, maxAge time.Duration) {
+// abandoned multipart upload staging directories older than maxAge. With
+// dryRun it only logs what it would remove. Each cycle runs inside gate, so
+// it does not remove anything while an admin sync flushes or pauses writes.
+func startMultipartGC(dataDir string, interval, maxAge time.Duration, dryRun bool, gate *writeGate) {
 	ticker := time.NewTicker(interval)
 	go func() {
 		for range ticker.C {
-			cleanAbandonedUploads(dataDir, maxAge)
+			gate.enter()
+			cleanAbandonedUploads(dataDir, maxAge, dryRun)
+			gate.leave()
 		}
 	}()
 }
 
-func cleanAbandonedUploads(dataDir string, maxAge time.Duration) {
+// gcResult summarizes one multipart GC cycle.
+type gcResult struct {
+	Uploads int   // staging directories removed (or that would be, in dry-run)
+	Bytes   int64 // bytes of staged parts they held
+}
+
+// cleanAbandonedUploads removes multipart staging directories not modified
+// within maxAge and logs a summary of the cycle. With dryRun each candidate is
+// logged instead of removed.
+func cleanAbandonedUploads(dataDir string, maxAge time.Duration, dryRun bool) gcResult {
+	var result gcResult
 	buckets, err := os.ReadDir(dataDir)
 	if err != nil {
-		return
+		return result
 	}
 	cutoff := time.Now().Add(-maxAge)
 	for _, b := range buckets {
@@ -172,9 +687,51 @@ func cleanAbandonedUploads(dataDir string, maxAge time.Duration) {
 			if err != nil {
 				continue
 			}
-			if info.ModTime().Before(cutoff) {
-				os.RemoveAll(filepath.Join(mpDir, u.Name()))
+			if !info.ModTime().Before(cutoff) {
+				continue
+			}
+			path := filepath.Join(mpDir, u.Name())
+			size := dirSize(path)
+			age := time.Since(info.ModTime()).Round(time.Second)
+			if dryRun {
+				log.Printf("Multipart GC (dry run): would remove upload %s in bucket %s (age %s, %d bytes)", u.Name(), b.Name(), age, size)
+			} else {
+				if err := os.RemoveAll(path); err != nil {
+					log.Printf("Multipart GC: failed to remove upload %s in bucket %s: %v", u.Name(), b.Name(), err)
+					continue
+				}
+				log.Printf("Multipart GC: removed upload %s in bucket %s (age %s, %d bytes)", u.Name(), b.Name(), age, size)
 			}
+			result.Uploads++
+			result.Bytes += size
 		}
 	}
+
+	if dryRun {
+		log.Printf("Multipart GC (dry run): would reclaim %d uploads, %d bytes", result.Uploads, result.Bytes)
+	} else {
+		gcUploadsReclaimed.Add(int64(result.Uploads))
+		gcBytesReclaimed.Add(result.Bytes)
+		log.Printf("Multipart GC: reclaimed %d uploads, %d bytes freed", result.Uploads, result.Bytes)
+	}
+	gcLastRun.Set(time.Now().Unix())
+	return result
+}
+
+// dirSize returns the total size of the regular files under path, ignoring
+// entries that cannot be read.
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
 }
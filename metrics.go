@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metric is a single counter or gauge in the Prometheus text format.
+type metric struct {
+	name  string
+	help  string
+	kind  string // "counter" or "gauge"
+	value atomic.Int64
+}
+
+// Add increments the metric by n.
+func (m *metric) Add(n int64) { m.value.Add(n) }
+
+// Set replaces the metric's value; meant for gauges.
+func (m *metric) Set(n int64) { m.value.Store(n) }
+
+// Value returns the current value.
+func (m *metric) Value() int64 { return m.value.Load() }
+
+// writeText writes the metric in the Prometheus text exposition format.
+func (m *metric) writeText(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.Value())
+}
+
+// durationSummary accumulates observed durations as a Prometheus summary
+// without quantiles: a running _sum in seconds and a _count.
+type durationSummary struct {
+	name  string
+	help  string
+	count atomic.Int64
+	nanos atomic.Int64
+}
+
+// Observe records one duration.
+func (s *durationSummary) Observe(d time.Duration) {
+	s.nanos.Add(int64(d))
+	s.count.Add(1)
+}
+
+// Count returns the number of observations.
+func (s *durationSummary) Count() int64 { return s.count.Load() }
+
+// writeText writes the summary in the Prometheus text exposition format.
+func (s *durationSummary) writeText(w io.Writer) {
+	sum := time.Duration(s.nanos.Load()).Seconds()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n%s_sum %g\n%s_count %d\n", s.name, s.help, s.name, s.name, sum, s.name, s.Count())
+}
+
+// collector is anything the registry can expose.
+type collector interface {
+	writeText(w io.Writer)
+}
+
+// metricsRegistry holds process-wide metrics in registration order.
+type metricsRegistry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func (r *metricsRegistry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *metricsRegistry) counter(name, help string) *metric {
+	m := &metric{name: name, help: help, kind: "counter"}
+	r.register(m)
+	return m
+}
+
+func (r *metricsRegistry) gauge(name, help string) *metric {
+	m := &metric{name: name, help: help, kind: "gauge"}
+	r.register(m)
+	return m
+}
+
+func (r *metricsRegistry) summary(name, help string) *durationSummary {
+	s := &durationSummary{name: name, help: help}
+	r.register(s)
+	return s
+}
+
+// writeText writes every metric in the Prometheus text exposition format.
+func (r *metricsRegistry) writeText(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.collectors {
+		c.writeText(w)
+	}
+}
+
+// defaultMetrics is the registry served at GET /metrics on the admin listener.
+var defaultMetrics = &metricsRegistry{}
+
+// Multipart GC metrics, updated by cleanAbandonedUploads.
+var (
+	gcUploadsReclaimed = defaultMetrics.counter("geckos3_gc_uploads_reclaimed_total", "Abandoned multipart uploads removed by the background GC.")
+	gcBytesReclaimed   = defaultMetrics.counter("geckos3_gc_bytes_reclaimed_total", "Bytes of staged parts freed by the background GC.")
+	gcLastRun          = defaultMetrics.gauge("geckos3_gc_last_run_timestamp", "Unix time the background GC last finished a cycle.")
+)
+
+// stripeLockWait measures how long writers wait for a stripe lock, see
+// FilesystemStorage.lockStripe.
+var stripeLockWait = defaultMetrics.summary("geckos3_stripe_lock_wait_seconds", "Time PutObject and CompleteMultipartUpload spent waiting for a stripe lock.")
+
+// metadataDrift counts HEAD requests that found the metadata sidecar
+// recording a different size than its data file, see S3Handler.handleHeadObject.
+var metadataDrift = defaultMetrics.counter("geckos3_metadata_drift_total", "HEAD requests whose metadata sidecar recorded a different size than the data file.")
+
+// Read cache metrics, see CachingStorage.
+var (
+	cacheHits      = defaultMetrics.counter("geckos3_cache_hits_total", "GET and HEAD requests answered from the -cache-dir read cache.")
+	cacheMisses    = defaultMetrics.counter("geckos3_cache_misses_total", "GET and HEAD requests the -cache-dir read cache passed to the origin.")
+	cacheEvictions = defaultMetrics.counter("geckos3_cache_evictions_total", "Objects evicted from the read cache to stay within -cache-size.")
+	cacheBytes     = defaultMetrics.gauge("geckos3_cache_bytes", "Bytes of object data held in the read cache.")
+)
+
+// otherBucketsLabel is the bucket label of requests to buckets without a
+// series of their own.
+const otherBucketsLabel = "_other"
+
+// bucketSummary is a durationSummary per bucket, exposed with a bucket
+// label. The number of series is bounded so that clients cannot grow the
+// scrape without limit by addressing made-up bucket names: with an allowlist
+// only its buckets get a label, otherwise the first limit existing buckets
+// seen do. Everything else is counted under otherBucketsLabel. A limit of 0
+// turns the metric off.
+type bucketSummary struct {
+	name string
+	help string
+
+	mu     sync.Mutex
+	limit  int
+	allow  map[string]bool
+	series map[string]*durationSummary
+	other  durationSummary
+}
+
+// configure sets the series bound; see bucketSummary. An empty allow list
+// means any bucket.
+func (b *bucketSummary) configure(limit int, allow []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = limit
+	b.allow = nil
+	if len(allow) > 0 {
+		b.allow = make(map[string]bool, len(allow))
+		for _, bucket := range allow {
+			b.allow[bucket] = true
+		}
+	}
+}
+
+// Observe records one request to bucket that took d. exists is called, with
+// no lock held, only for buckets that have no series yet while there is room
+// for more.
+func (b *bucketSummary) Observe(bucket string, d time.Duration, exists func(string) bool) {
+	b.mu.Lock()
+	s, ok := b.series[bucket]
+	limit, allow, full := b.limit, b.allow, len(b.series) >= b.limit
+	b.mu.Unlock()
+	if limit <= 0 {
+		return
+	}
+	if !ok {
+		s = &b.other
+		var track bool
+		if allow != nil {
+			track = allow[bucket]
+		} else {
+			track = !full && exists(bucket)
+		}
+		if track {
+			b.mu.Lock()
+			if existing, ok := b.series[bucket]; ok {
+				s = existing
+			} else if allow != nil || len(b.series) < b.limit {
+				s = &durationSummary{}
+				b.series[bucket] = s
+			}
+			b.mu.Unlock()
+		}
+	}
+	s.Observe(d)
+}
+
+// writeText writes the summary in the Prometheus text exposition format, one
+// labeled _sum and _count per bucket.
+func (b *bucketSummary) writeText(w io.Writer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit <= 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", b.name, b.help, b.name)
+	buckets := make([]string, 0, len(b.series))
+	for bucket := range b.series {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	write := func(label string, s *durationSummary) {
+		sum := time.Duration(s.nanos.Load()).Seconds()
+		fmt.Fprintf(w, "%s_sum{bucket=%q} %g\n%s_count{bucket=%q} %d\n", b.name, label, sum, b.name, label, s.Count())
+	}
+	for _, bucket := range buckets {
+		write(bucket, b.series[bucket])
+	}
+	write(otherBucketsLabel, &b.other)
+}
+
+func (r *metricsRegistry) bucketSummary(name, help string) *bucketSummary {
+	b := &bucketSummary{name: name, help: help, limit: defaultMetricsMaxBuckets, series: make(map[string]*durationSummary)}
+	r.register(b)
+	return b
+}
+
+// defaultMetricsMaxBuckets is the default -metrics-max-buckets.
+const defaultMetricsMaxBuckets = 100
+
+// bucketRequests times authenticated requests per bucket, see
+// S3Handler.ServeHTTP. Its _count is the request count.
+var bucketRequests = defaultMetrics.bucketSummary("geckos3_bucket_request_duration_seconds", "Time spent serving authenticated requests, by bucket.")
+
+// handleMetrics serves defaultMetrics for Prometheus scrapes.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	defaultMetrics.writeText(w)
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrMigrationInProgress is returned for operations MigratingStorage cannot
+// perform across its two data directories.
+var ErrMigrationInProgress = errors.New("not available while -migrate-from is set")
+
+// migrateQueueSize bounds the objects waiting for lazy migration; reads of
+// further old objects are served without queueing them until it drains.
+const migrateQueueSize = 1024
+
+// MigratingStorage serves a data directory that is being moved to a new
+// volume (-migrate-from). The embedded FilesystemStorage is the new, primary
+// directory and takes every write. GET, HEAD and listings fall through to the
+// old directory for keys the primary does not have yet, and objects read from
+// the old directory are copied into the primary in the background.
+//
+// Consistency caveats:
+//   - Deletes and renames remove the key from the old directory too, or it
+//     would show through again, so the old directory must stay writable.
+//   - Buckets are created in the primary at startup with their ACL, tagging
+//     and CORS configuration; configuration changes made to the old directory
+//     afterwards are not seen.
+//   - Multipart uploads in progress in the old directory cannot be completed.
+//   - Every write must go through this server: a key written to the old
+//     directory by hand is only visible while the primary lacks it.
+type MigratingStorage struct {
+	*FilesystemStorage
+	old *FilesystemStorage
+
+	// stripes serialize writes of a key with its migration, so a copy from
+	// the old directory never overwrites a newer write. They are separate
+	// from the primary's own stripes, which its methods lock internally.
+	stripes [lockStripes]sync.Mutex
+	queue   chan objectRef
+	queued  sync.Map // objectRef → struct{}, keys waiting in queue
+}
+
+type objectRef struct{ bucket, key string }
+
+// NewMigratingStorage layers primary over old, creates old's buckets in
+// primary and starts the lazy migration worker.
+func NewMigratingStorage(primary, old *FilesystemStorage) (*MigratingStorage, error) {
+	m := &MigratingStorage{
+		FilesystemStorage: primary,
+		old:               old,
+		queue:             make(chan objectRef, migrateQueueSize),
+	}
+	buckets, err := old.ListBuckets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets of %s: %w", old.dataDir, err)
+	}
+	for _, b := range buckets {
+		if err := m.copyBucket(b.Name); err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", b.Name, err)
+		}
+	}
+	go m.worker()
+	return m, nil
+}
+
+// copyBucket creates bucket in the primary with the old directory's
+// configuration, unless the primary already has it.
+func (m *MigratingStorage) copyBucket(bucket string) error {
+	if m.FilesystemStorage.BucketExists(bucket) {
+		return nil
+	}
+	if err := m.FilesystemStorage.CreateBucket(bucket); err != nil {
+		return err
+	}
+	if acl, err := m.old.GetBucketACL(bucket); err == nil && acl != "" {
+		m.FilesystemStorage.PutBucketACL(bucket, acl)
+	}
+	if tags, err := m.old.GetBucketTagging(bucket); err == nil && len(tags) > 0 {
+		m.FilesystemStorage.PutBucketTagging(bucket, tags)
+	}
+	if cors, err := m.old.GetBucketCORS(bucket); err == nil && cors != nil {
+		m.FilesystemStorage.PutBucketCORS(bucket, cors)
+	}
+	return nil
+}
+
+func (m *MigratingStorage) stripe(bucket, key string) *sync.Mutex {
+	return &m.stripes[stripeIndex(bucket+"/"+key)]
+}
+
+// lockPair locks the stripes of two keys in a fixed order.
+func (m *MigratingStorage) lockPair(bucket, a, b string) func() {
+	i, j := stripeIndex(bucket+"/"+a), stripeIndex(bucket+"/"+b)
+	if i == j {
+		m.stripes[i].Lock()
+		return m.stripes[i].Unlock
+	}
+	if i > j {
+		i, j = j, i
+	}
+	m.stripes[i].Lock()
+	m.stripes[j].Lock()
+	return func() {
+		m.stripes[j].Unlock()
+		m.stripes[i].Unlock()
+	}
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Migration
+// ═══════════════════════════════════════════════════════════════════════════════
+
+// migrateObject copies key from the old directory into the primary unless the
+// primary already has it. The copy keeps the object's ETag and Last-Modified,
+// so clients holding validators see the same object. It reports whether an
+// object was copied.
+func (m *MigratingStorage) migrateObject(bucket, key string) (bool, error) {
+	mu := m.stripe(bucket, key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := m.FilesystemStorage.HeadObject(bucket, key); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	reader, meta, err := m.old.GetObject(bucket, key)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	written, err := m.FilesystemStorage.PutObject(bucket, key, reader, &PutObjectInput{
+		ContentType:        meta.ContentType,
+		ContentEncoding:    meta.ContentEncoding,
+		ContentDisposition: meta.ContentDisposition,
+		CacheControl:       meta.CacheControl,
+		CustomMetadata:     meta.CustomMetadata,
+		Tags:               meta.Tags,
+	})
+	if err != nil {
+		return false, err
+	}
+	// PutObject gave the copy a fresh Last-Modified and, for multipart
+	// objects, a different ETag; restore the stored ones. The key's stripe
+	// keeps every other write out until this is done.
+	if stored, err := m.old.loadMetadata(bucket, key); err == nil && m.enableMetadata {
+		kept := *stored
+		kept.Size = written.Size
+		kept.Blob = written.Blob
+		m.saveMetadata(bucket, key, &kept)
+	}
+	return true, nil
+}
+
+// enqueue schedules key for lazy migration after it was read from the old
+// directory. It never blocks; when the queue is full the key waits for a
+// later read or MigrateAll.
+func (m *MigratingStorage) enqueue(bucket, key string) {
+	ref := objectRef{bucket, key}
+	if _, loaded := m.queued.LoadOrStore(ref, struct{}{}); loaded {
+		return
+	}
+	select {
+	case m.queue <- ref:
+	default:
+		m.queued.Delete(ref)
+	}
+}
+
+func (m *MigratingStorage) worker() {
+	for ref := range m.queue {
+		if _, err := m.migrateObject(ref.bucket, ref.key); err != nil {
+			log.Printf("Migration: failed to copy %s/%s: %v", ref.bucket, ref.key, err)
+		}
+		m.queued.Delete(ref)
+	}
+}
+
+// MigrateAll copies every object of the old directory that the primary does
+// not have yet. It stops early, returning ctx.Err(), once ctx is done. Errors
+// copying single objects are logged and counted rather than stopping the
+// run; the operator can remove -migrate-from once a run finishes with none.
+func (m *MigratingStorage) MigrateAll(ctx context.Context) (migrated, failed int, err error) {
+	buckets, err := m.old.ListBuckets()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, b := range buckets {
+		err := m.old.WalkObjects(b.Name, "", func(obj ObjectInfo) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			copied, err := m.migrateObject(b.Name, obj.Key)
+			switch {
+			case err != nil:
+				log.Printf("Migration: failed to copy %s/%s: %v", b.Name, obj.Key, err)
+				failed++
+			case copied:
+				migrated++
+			}
+			return nil
+		})
+		if err != nil {
+			return migrated, failed, err
+		}
+	}
+	return migrated, failed, nil
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Reads
+// ═══════════════════════════════════════════════════════════════════════════════
+
+func (m *MigratingStorage) GetObject(bucket, key string) (io.ReadCloser, *ObjectMetadata, error) {
+	reader, meta, err := m.FilesystemStorage.GetObject(bucket, key)
+	if err == nil || !os.IsNotExist(err) {
+		return reader, meta, err
+	}
+	reader, meta, oldErr := m.old.GetObject(bucket, key)
+	if oldErr != nil {
+		return nil, nil, err
+	}
+	m.enqueue(bucket, key)
+	return reader, meta, nil
+}
+
+func (m *MigratingStorage) HeadObject(bucket, key string) (*ObjectMetadata, error) {
+	meta, err := m.FilesystemStorage.HeadObject(bucket, key)
+	if err == nil || !os.IsNotExist(err) {
+		return meta, err
+	}
+	meta, oldErr := m.old.HeadObject(bucket, key)
+	if oldErr != nil {
+		return nil, err
+	}
+	m.enqueue(bucket, key)
+	return meta, nil
+}
+
+func (m *MigratingStorage) ListObjects(bucket, prefix string, maxKeys int) ([]ObjectInfo, error) {
+	return m.ListObjectsContext(context.Background(), bucket, prefix, maxKeys)
+}
+
+// ListObjectsContext merges both directories' listings. Each returns its
+// first maxKeys keys, so the merge holds the first maxKeys keys overall.
+func (m *MigratingStorage) ListObjectsContext(ctx context.Context, bucket, prefix string, maxKeys int) ([]ObjectInfo, error) {
+	objects, err := m.FilesystemStorage.ListObjectsContext(ctx, bucket, prefix, maxKeys)
+	if err != nil || !m.old.BucketExists(bucket) {
+		return objects, err
+	}
+	oldObjects, err := m.old.ListObjectsContext(ctx, bucket, prefix, maxKeys)
+	if err != nil {
+		return nil, err
+	}
+	objects = mergeObjects(objects, oldObjects)
+	if maxKeys > 0 && len(objects) > maxKeys {
+		objects = objects[:maxKeys]
+	}
+	return objects, nil
+}
+
+// ListObjectsPage merges both directories' pages. Each holds the first limit
+// entries after startAfter, so the merge holds the first limit overall.
+func (m *MigratingStorage) ListObjectsPage(ctx context.Context, bucket, prefix, delimiter, startAfter string, limit int) ([]ObjectInfo, error) {
+	objects, err := m.FilesystemStorage.ListObjectsPage(ctx, bucket, prefix, delimiter, startAfter, limit)
+	if err != nil || !m.old.BucketExists(bucket) {
+		return objects, err
+	}
+	oldObjects, err := m.old.ListObjectsPage(ctx, bucket, prefix, delimiter, startAfter, limit)
+	if err != nil {
+		return nil, err
+	}
+	objects = mergeObjects(objects, oldObjects)
+	if limit > 0 && len(objects) > limit {
+		objects = objects[:limit]
+	}
+	return objects, nil
+}
+
+func (m *MigratingStorage) ListDirectory(bucket, prefix string) ([]ObjectInfo, []string, error) {
+	objects, prefixes, err := m.FilesystemStorage.ListDirectory(bucket, prefix)
+	if err != nil || !m.old.BucketExists(bucket) {
+		return objects, prefixes, err
+	}
+	oldObjects, oldPrefixes, err := m.old.ListDirectory(bucket, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[string]bool, len(prefixes))
+	for _, p := range prefixes {
+		seen[p] = true
+	}
+	for _, p := range oldPrefixes {
+		if !seen[p] {
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Strings(prefixes)
+	return mergeObjects(objects, oldObjects), prefixes, nil
+}
+
+// WalkObjects visits the primary's objects, then the old directory's that
+// the primary does not have.
+func (m *MigratingStorage) WalkObjects(bucket, prefix string, fn func(ObjectInfo) error) error {
+	if err := m.FilesystemStorage.WalkObjects(bucket, prefix, fn); err != nil {
+		return err
+	}
+	if !m.old.BucketExists(bucket) {
+		return nil
+	}
+	return m.old.WalkObjects(bucket, prefix, func(obj ObjectInfo) error {
+		if _, err := m.FilesystemStorage.HeadObject(bucket, obj.Key); err == nil {
+			return nil
+		}
+		return fn(obj)
+	})
+}
+
+// mergeObjects returns the objects of both listings sorted by key, taking
+// the primary's entry for keys in both.
+func mergeObjects(primary, old []ObjectInfo) []ObjectInfo {
+	seen := make(map[string]bool, len(primary))
+	for _, obj := range primary {
+		seen[obj.Key] = true
+	}
+	for _, obj := range old {
+		if !seen[obj.Key] {
+			primary = append(primary, obj)
+		}
+	}
+	sort.Slice(primary, func(i, j int) bool { return primary[i].Key < primary[j].Key })
+	return primary
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Writes
+// ═══════════════════════════════════════════════════════════════════════════════
+
+// PutObject with IfNoneMatch also fails if the key is still only in the old
+// directory.
+func (m *MigratingStorage) PutObject(bucket, key string, reader io.Reader, input *PutObjectInput) (*ObjectMetadata, error) {
+	mu := m.stripe(bucket, key)
+	mu.Lock()
+	defer mu.Unlock()
+	if input != nil && input.IfNoneMatch {
+		if _, err := m.old.HeadObject(bucket, key); err == nil {
+			return nil, ErrPreconditionFailed
+		}
+	}
+	return m.FilesystemStorage.PutObject(bucket, key, reader, input)
+}
+
+func (m *MigratingStorage) CompleteMultipartUpload(bucket, key, uploadID string, parts []CompletedPart) (*ObjectMetadata, error) {
+	mu := m.stripe(bucket, key)
+	mu.Lock()
+	defer mu.Unlock()
+	return m.FilesystemStorage.CompleteMultipartUpload(bucket, key, uploadID, parts)
+}
+
+// CopyObject migrates the source first, so the primary can copy it.
+func (m *MigratingStorage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, overrideMeta *PutObjectInput, overrideTags map[string]string) (*ObjectMetadata, error) {
+	if _, err := m.migrateObject(srcBucket, srcKey); err != nil {
+		return nil, err
+	}
+	mu := m.stripe(dstBucket, dstKey)
+	mu.Lock()
+	defer mu.Unlock()
+	return m.FilesystemStorage.CopyObject(srcBucket, srcKey, dstBucket, dstKey, overrideMeta, overrideTags)
+}
+
+// PutObjectTagging migrates the key first, so the tags are written to the
+// primary's sidecar.
+func (m *MigratingStorage) PutObjectTagging(bucket, key string, tags map[string]string) error {
+	if _, err := m.migrateObject(bucket, key); err != nil {
+		return err
+	}
+	mu := m.stripe(bucket, key)
+	mu.Lock()
+	defer mu.Unlock()
+	return m.FilesystemStorage.PutObjectTagging(bucket, key, tags)
+}
+
+func (m *MigratingStorage) GetObjectTagging(bucket, key string) (map[string]string, error) {
+	meta, err := m.HeadObject(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return meta.Tags, nil
+}
+
+func (m *MigratingStorage) DeleteObjectTagging(bucket, key string) error {
+	return m.PutObjectTagging(bucket, key, nil)
+}
+
+func (m *MigratingStorage) DeleteObject(bucket, key string) (*DeleteObjectResult, error) {
+	return m.deleteObject(bucket, key, "")
+}
+
+// DeleteObjectIfMatch migrates the key first, so the ETag is checked by the
+// primary under its lock.
+func (m *MigratingStorage) DeleteObjectIfMatch(bucket, key, etag string) (*DeleteObjectResult, error) {
+	if _, err := m.migrateObject(bucket, key); err != nil {
+		return nil, err
+	}
+	return m.deleteObject(bucket, key, etag)
+}
+
+// deleteObject removes key from the old directory before the primary: if
+// that fails the object is still whole in the primary.
+func (m *MigratingStorage) deleteObject(bucket, key, ifMatch string) (*DeleteObjectResult, error) {
+	mu := m.stripe(bucket, key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if ifMatch != "" {
+		current, err := m.FilesystemStorage.HeadObject(bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		if ifMatch != "*" && strings.Trim(ifMatch, `"`) != strings.Trim(current.ETag, `"`) {
+			return nil, ErrPreconditionFailed
+		}
+	}
+	if m.old.BucketExists(bucket) {
+		if _, err := m.old.DeleteObject(bucket, key); err != nil {
+			return nil, err
+		}
+	}
+	if ifMatch != "" {
+		return m.FilesystemStorage.DeleteObjectIfMatch(bucket, key, ifMatch)
+	}
+	return m.FilesystemStorage.DeleteObject(bucket, key)
+}
+
+// RenameObject migrates both keys, so the primary sees the source and any
+// destination it must not overwrite, then drops the old copy of the source.
+func (m *MigratingStorage) RenameObject(bucket, srcKey, dstKey string, overwrite bool) (*ObjectMetadata, error) {
+	for _, key := range []string{srcKey, dstKey} {
+		if _, err := m.migrateObject(bucket, key); err != nil {
+			return nil, err
+		}
+	}
+	unlock := m.lockPair(bucket, srcKey, dstKey)
+	defer unlock()
+	meta, err := m.FilesystemStorage.RenameObject(bucket, srcKey, dstKey, overwrite)
+	if err != nil {
+		return nil, err
+	}
+	if m.old.BucketExists(bucket) && srcKey != dstKey {
+		if _, err := m.old.DeleteObject(bucket, srcKey); err != nil {
+			log.Printf("Migration: renamed %s/%s but could not remove it from %s: %v", bucket, srcKey, m.old.dataDir, err)
+		}
+	}
+	return meta, nil
+}
+
+// DeleteBucket deletes the bucket from the old directory first, which fails
+// while it still holds objects there.
+func (m *MigratingStorage) DeleteBucket(bucket string) error {
+	if m.old.BucketExists(bucket) {
+		if err := m.old.DeleteBucket(bucket); err != nil {
+			return err
+		}
+	}
+	return m.FilesystemStorage.DeleteBucket(bucket)
+}
+
+func (m *MigratingStorage) RenameBucket(bucket, newName string) error {
+	return fmt.Errorf("bucket rename: %w", ErrMigrationInProgress)
+}
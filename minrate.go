@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ErrRequestTooSlow is returned by request body reads when the client sends
+// the body more slowly than the -min-upload-rate floor.
+var ErrRequestTooSlow = errors.New("the request body was sent below the minimum upload rate")
+
+// MinUploadRateMiddleware aborts request bodies that arrive slower than rate
+// bytes per second, measured over window: once a body starts being read, the
+// client must deliver rate*window bytes within each window or the read
+// deadline fires and further reads fail with ErrRequestTooSlow. This frees
+// connections held open by trickling bodies (slowloris) well before the long
+// read timeout, without capping slow-but-steady uploads. readTimeout, if
+// positive, still bounds the whole request. A rate <= 0 disables the check.
+func MinUploadRateMiddleware(rate int64, window, readTimeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if rate <= 0 || window <= 0 {
+			return next
+		}
+		quota := int64(float64(rate) * window.Seconds())
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil && r.Body != http.NoBody {
+				body := &minRateBody{
+					ReadCloser: r.Body,
+					rc:         http.NewResponseController(w),
+					quota:      max(quota, 1),
+					window:     window,
+				}
+				if readTimeout > 0 {
+					body.limit = time.Now().Add(readTimeout)
+				}
+				r.Body = body
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// minRateBody enforces the upload rate floor through the connection's read
+// deadline, which also interrupts a read blocked on a client that sends
+// nothing at all. The deadline is only set once the handler starts reading,
+// so requests whose body is never read are unaffected.
+type minRateBody struct {
+	io.ReadCloser
+	rc     *http.ResponseController
+	quota  int64
+	window time.Duration
+	limit  time.Time // -read-timeout deadline, zero if none
+
+	started  bool
+	disabled bool  // the connection does not support read deadlines
+	received int64 // bytes since the deadline was last extended
+}
+
+func (b *minRateBody) Read(p []byte) (int, error) {
+	if !b.started {
+		b.started = true
+		b.extend()
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.received += int64(n)
+	if b.received >= b.quota {
+		b.received = 0
+		b.extend()
+	}
+	switch {
+	case err == io.EOF && !b.disabled:
+		// The body is done; restore the server's own deadline handling.
+		b.rc.SetReadDeadline(b.limit)
+	case err != nil && errors.Is(err, os.ErrDeadlineExceeded) && !b.disabled:
+		if b.limit.IsZero() || time.Now().Before(b.limit) {
+			err = ErrRequestTooSlow
+		}
+	}
+	return n, err
+}
+
+// extend gives the client another window to deliver its next quota.
+func (b *minRateBody) extend() {
+	if b.disabled {
+		return
+	}
+	deadline := time.Now().Add(b.window)
+	if !b.limit.IsZero() && b.limit.Before(deadline) {
+		deadline = b.limit
+	}
+	if err := b.rc.SetReadDeadline(deadline); err != nil {
+		b.disabled = true
+	}
+}
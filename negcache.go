@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// negativeCacheMaxEntries bounds the number of missing keys remembered.
+const negativeCacheMaxEntries = 10000
+
+// negativeCache remembers object keys recently found missing, so repeated
+// GETs and HEADs of the same absent key are answered without touching the
+// filesystem. Entries expire after ttl and are dropped whenever the key (or
+// its whole bucket) is written.
+//
+// A lookup that misses the disk races with a concurrent write of the same
+// key: the write may land and invalidate between the stat and the cache
+// insert. gen counts invalidations, and add only inserts if none happened
+// since the caller read gen before its stat.
+type negativeCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	gen     uint64
+	entries map[string]time.Time // bucket + "/" + key → expiry
+}
+
+func newNegativeCache(ttl time.Duration) *negativeCache {
+	return &negativeCache{ttl: ttl, entries: make(map[string]time.Time)}
+}
+
+// generation returns the invalidation counter to pass to add.
+func (c *negativeCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// missing reports whether bucket/key is cached as absent.
+func (c *negativeCache) missing(bucket, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := bucket + "/" + key
+	expiry, ok := c.entries[id]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expiry) {
+		delete(c.entries, id)
+		return false
+	}
+	return true
+}
+
+// add records bucket/key as absent unless the cache was invalidated since
+// gen was read. When full, expired entries are swept first; if it is still
+// full the key is not cached.
+func (c *negativeCache) add(bucket, key string, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	now := time.Now()
+	if len(c.entries) >= negativeCacheMaxEntries {
+		for id, expiry := range c.entries {
+			if now.After(expiry) {
+				delete(c.entries, id)
+			}
+		}
+		if len(c.entries) >= negativeCacheMaxEntries {
+			return
+		}
+	}
+	c.entries[bucket+"/"+key] = now.Add(c.ttl)
+}
+
+// forget drops bucket/key after it has been written.
+func (c *negativeCache) forget(bucket, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	delete(c.entries, bucket+"/"+key)
+}
+
+// forgetBucket drops every entry in bucket after it was created, renamed or
+// deleted.
+func (c *negativeCache) forgetBucket(bucket string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	prefix := bucket + "/"
+	for id := range c.entries {
+		if strings.HasPrefix(id, prefix) {
+			delete(c.entries, id)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// runPresign implements the "geckos3 presign" subcommand: it prints a SigV4
+// presigned URL for one object using the configured credentials. It returns
+// the process exit code.
+func runPresign(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("presign", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	endpoint := fs.String("endpoint", defaultEndpoint(getEnv("GECKOS3_LISTEN", ":9000")), "Server base URL the client will use")
+	accessKey := fs.String("access-key", getEnv("GECKOS3_ACCESS_KEY", "geckoadmin"), "AWS access key")
+	secretKey := fs.String("secret-key", getEnv("GECKOS3_SECRET_KEY", "geckoadmin"), "AWS secret key")
+	region := fs.String("region", "us-east-1", "Region in the credential scope")
+	bucket := fs.String("bucket", "", "Bucket name (required)")
+	key := fs.String("key", "", "Object key (required)")
+	method := fs.String("method", "GET", "HTTP method the URL is valid for")
+	expires := fs.Int("expires", 3600, "Validity in seconds (max 604800)")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *bucket == "" || *key == "" {
+		fmt.Fprintln(stderr, "presign: -bucket and -key are required")
+		fs.Usage()
+		return 2
+	}
+
+	base, err := url.Parse(*endpoint)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		fmt.Fprintf(stderr, "presign: invalid -endpoint %q\n", *endpoint)
+		return 2
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/" + *bucket + "/" + *key
+
+	signer := sigV4Signer{accessKey: *accessKey, secretKey: *secretKey}
+	signed, err := signer.PresignURL(strings.ToUpper(*method), base.String(), *region,
+		time.Duration(*expires)*time.Second, time.Now())
+	if err != nil {
+		fmt.Fprintf(stderr, "presign: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, signed)
+	return 0
+}
+
+// defaultEndpoint turns a listen address like ":9000" into a local base URL.
+func defaultEndpoint(listen string) string {
+	if strings.HasPrefix(listen, ":") {
+		listen = "localhost" + listen
+	}
+	return "http://" + listen
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses a comma-separated list of IP addresses and CIDR
+// ranges. Bare addresses are treated as single-host ranges.
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ForwardedHeadersMiddleware replaces r.Host with the X-Forwarded-Host sent
+// by a trusted reverse proxy, so SigV4 verification and the endpoint host
+// check see the public host the client signed against. X-Forwarded-Proto is
+// used to drop the scheme's default port, which SDKs omit when signing.
+// Forwarded headers from any other peer are ignored.
+func ForwardedHeadersMiddleware(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fwdHost := r.Header.Get("X-Forwarded-Host"); fwdHost != "" && fromTrustedProxy(r, trusted) {
+				// With several proxies in the chain the first value is the
+				// host the client originally addressed.
+				if i := strings.IndexByte(fwdHost, ','); i >= 0 {
+					fwdHost = fwdHost[:i]
+				}
+				fwdHost = strings.TrimSpace(fwdHost)
+
+				proto := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]))
+				switch {
+				case proto == "https" && strings.HasSuffix(fwdHost, ":443"):
+					fwdHost = strings.TrimSuffix(fwdHost, ":443")
+				case proto == "http" && strings.HasSuffix(fwdHost, ":80"):
+					fwdHost = strings.TrimSuffix(fwdHost, ":80")
+				}
+				if fwdHost != "" {
+					r.Host = fwdHost
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// fromTrustedProxy reports whether the request's direct peer is in trusted.
+func fromTrustedProxy(r *http.Request, trusted []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// runSelftest implements the "geckos3 selftest" subcommand: it starts a
+// server on a loopback port with a scratch data directory and SigV4 auth,
+// runs a scripted sequence of S3 calls against it, and prints one line per
+// step. Steps build on each other, so the run stops at the first failure. It
+// returns the process exit code.
+func runSelftest(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dataDir := fs.String("data-dir", "", "Data directory to test against (default: a temporary directory, removed afterwards)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	dir := *dataDir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "geckos3-selftest-*")
+		if err != nil {
+			fmt.Fprintf(stderr, "selftest: %v\n", err)
+			return 1
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintf(stderr, "selftest: %v\n", err)
+		return 1
+	}
+	const accessKey, secretKey = "selftest", "selftest-secret"
+	handler := NewS3Handler(NewFilesystemStorage(dir), NewSigV4Authenticator(accessKey, secretKey))
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	c := &selftestClient{
+		base:   "http://" + ln.Addr().String(),
+		signer: sigV4Signer{accessKey: accessKey, secretKey: secretKey},
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, step := range selftestSteps() {
+		if err := step.run(c); err != nil {
+			fmt.Fprintf(stdout, "FAIL %s: %v\n", step.name, err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "ok   %s\n", step.name)
+	}
+	fmt.Fprintln(stdout, "PASS")
+	return 0
+}
+
+// selftestClient sends presigned requests to the selftest server.
+type selftestClient struct {
+	base   string
+	signer sigV4Signer
+	client *http.Client
+}
+
+// do sends one request for path (which may carry a query string) and returns
+// the response with its body read. It fails unless the status is wantStatus.
+func (c *selftestClient) do(method, path string, body []byte, header map[string]string, wantStatus int) (*http.Response, []byte, error) {
+	signed, err := c.signer.PresignURL(method, c.base+path, "us-east-1", 5*time.Minute, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest(method, signed, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != wantStatus {
+		return nil, nil, fmt.Errorf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, wantStatus, strings.TrimSpace(string(data)))
+	}
+	return resp, data, nil
+}
+
+type selftestStep struct {
+	name string
+	run  func(c *selftestClient) error
+}
+
+// selftestSteps is the scripted sequence: it covers the bucket, object,
+// copy, multipart and batch delete operations and leaves nothing behind.
+func selftestSteps() []selftestStep {
+	const bucket = "/geckos3-selftest"
+	content := []byte("hello from the geckos3 selftest\n")
+	part1 := bytes.Repeat([]byte("a"), 5*1024*1024) // S3's minimum part size
+	part2 := []byte("tail")
+
+	return []selftestStep{
+		{"CreateBucket", func(c *selftestClient) error {
+			_, _, err := c.do("PUT", bucket, nil, nil, http.StatusOK)
+			return err
+		}},
+		{"HeadBucket", func(c *selftestClient) error {
+			_, _, err := c.do("HEAD", bucket, nil, nil, http.StatusOK)
+			return err
+		}},
+		{"PutObject", func(c *selftestClient) error {
+			resp, _, err := c.do("PUT", bucket+"/dir/hello.txt", content, map[string]string{
+				"Content-Type":      "text/plain",
+				"x-amz-meta-origin": "selftest",
+			}, http.StatusOK)
+			if err != nil {
+				return err
+			}
+			sum := md5.Sum(content)
+			if want := `"` + hex.EncodeToString(sum[:]) + `"`; resp.Header.Get("ETag") != want {
+				return fmt.Errorf("ETag %s, want %s", resp.Header.Get("ETag"), want)
+			}
+			return nil
+		}},
+		{"GetObject", func(c *selftestClient) error {
+			resp, data, err := c.do("GET", bucket+"/dir/hello.txt", nil, nil, http.StatusOK)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(data, content) {
+				return fmt.Errorf("body %q, want %q", data, content)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "text/plain" {
+				return fmt.Errorf("Content-Type %q", ct)
+			}
+			return nil
+		}},
+		{"GetObject range", func(c *selftestClient) error {
+			_, data, err := c.do("GET", bucket+"/dir/hello.txt", nil, map[string]string{"Range": "bytes=0-4"}, http.StatusPartialContent)
+			if err == nil && string(data) != "hello" {
+				err = fmt.Errorf("body %q, want %q", data, "hello")
+			}
+			return err
+		}},
+		{"HeadObject", func(c *selftestClient) error {
+			resp, _, err := c.do("HEAD", bucket+"/dir/hello.txt", nil, nil, http.StatusOK)
+			if err != nil {
+				return err
+			}
+			if resp.ContentLength != int64(len(content)) {
+				return fmt.Errorf("Content-Length %d, want %d", resp.ContentLength, len(content))
+			}
+			if v := resp.Header.Get("x-amz-meta-origin"); v != "selftest" {
+				return fmt.Errorf("x-amz-meta-origin %q", v)
+			}
+			return nil
+		}},
+		{"CopyObject", func(c *selftestClient) error {
+			if _, _, err := c.do("PUT", bucket+"/copy.txt", nil, map[string]string{"x-amz-copy-source": bucket + "/dir/hello.txt"}, http.StatusOK); err != nil {
+				return err
+			}
+			_, data, err := c.do("GET", bucket+"/copy.txt", nil, nil, http.StatusOK)
+			if err == nil && !bytes.Equal(data, content) {
+				err = fmt.Errorf("copy body %q, want %q", data, content)
+			}
+			return err
+		}},
+		{"Multipart upload", func(c *selftestClient) error {
+			_, data, err := c.do("POST", bucket+"/multi.bin?uploads", nil, nil, http.StatusOK)
+			if err != nil {
+				return err
+			}
+			var initiate InitiateMultipartUploadResult
+			if err := xml.Unmarshal(data, &initiate); err != nil || initiate.UploadId == "" {
+				return fmt.Errorf("bad CreateMultipartUpload response: %s", data)
+			}
+			var complete CompleteMultipartUploadRequest
+			for i, part := range [][]byte{part1, part2} {
+				path := fmt.Sprintf("%s/multi.bin?partNumber=%d&uploadId=%s", bucket, i+1, initiate.UploadId)
+				resp, _, err := c.do("PUT", path, part, nil, http.StatusOK)
+				if err != nil {
+					return err
+				}
+				complete.Parts = append(complete.Parts, CompletedPartXML{PartNumber: i + 1, ETag: resp.Header.Get("ETag")})
+			}
+			body, err := xml.Marshal(complete)
+			if err != nil {
+				return err
+			}
+			if _, _, err := c.do("POST", bucket+"/multi.bin?uploadId="+initiate.UploadId, body, nil, http.StatusOK); err != nil {
+				return err
+			}
+			resp, data, err := c.do("GET", bucket+"/multi.bin", nil, nil, http.StatusOK)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(data, append(append([]byte{}, part1...), part2...)) {
+				return fmt.Errorf("assembled object has %d bytes, want %d", len(data), len(part1)+len(part2))
+			}
+			if etag := resp.Header.Get("ETag"); !strings.HasSuffix(etag, `-2"`) {
+				return fmt.Errorf("multipart ETag %s", etag)
+			}
+			return nil
+		}},
+		{"ListObjectsV2", func(c *selftestClient) error {
+			return expectKeys(c, bucket, "copy.txt", "dir/hello.txt", "multi.bin")
+		}},
+		{"DeleteObject", func(c *selftestClient) error {
+			if _, _, err := c.do("DELETE", bucket+"/dir/hello.txt", nil, nil, http.StatusNoContent); err != nil {
+				return err
+			}
+			_, _, err := c.do("GET", bucket+"/dir/hello.txt", nil, nil, http.StatusNotFound)
+			return err
+		}},
+		{"DeleteObjects", func(c *selftestClient) error {
+			body, err := xml.Marshal(DeleteRequest{Objects: []DeleteObjectEntry{{Key: "copy.txt"}, {Key: "multi.bin"}}})
+			if err != nil {
+				return err
+			}
+			_, data, err := c.do("POST", bucket+"?delete", body, nil, http.StatusOK)
+			if err != nil {
+				return err
+			}
+			var result DeleteResult
+			if err := xml.Unmarshal(data, &result); err != nil || len(result.Deleted) != 2 || len(result.Errors) != 0 {
+				return fmt.Errorf("bad DeleteObjects response: %s", data)
+			}
+			return expectKeys(c, bucket)
+		}},
+		{"DeleteBucket", func(c *selftestClient) error {
+			if _, _, err := c.do("DELETE", bucket, nil, nil, http.StatusNoContent); err != nil {
+				return err
+			}
+			_, _, err := c.do("HEAD", bucket, nil, nil, http.StatusNotFound)
+			return err
+		}},
+	}
+}
+
+// expectKeys checks that a ListObjectsV2 of bucket returns exactly keys.
+func expectKeys(c *selftestClient, bucket string, keys ...string) error {
+	_, data, err := c.do("GET", bucket+"?list-type=2", nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var list ListBucketResult
+	if err := xml.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	var got []string
+	for _, obj := range list.Contents {
+		got = append(got, obj.Key)
+	}
+	if strings.Join(got, ",") != strings.Join(keys, ",") {
+		return fmt.Errorf("listed keys %v, want %v", got, keys)
+	}
+	return nil
+}
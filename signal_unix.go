@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// maintenanceSignals toggle maintenance mode when received.
+var maintenanceSignals = []os.Signal{syscall.SIGUSR1}
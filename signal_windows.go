@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// maintenanceSignals is empty on Windows, which has no SIGUSR1; use the admin
+// API to toggle maintenance mode instead.
+var maintenanceSignals []os.Signal
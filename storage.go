@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
@@ -8,13 +10,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"hash/fnv"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -29,31 +34,176 @@ const multipartStagingDir = ".geckos3-multipart"
 // Temp files are written here to avoid races with DeleteObject cleanup.
 const tmpStagingDir = ".geckos3-tmp"
 
+// directWriteSuffix is appended to an object's path to name the file a
+// direct-write PutObject streams into before renaming it over the object.
+// Listings skip such files, like metadata sidecars.
+const directWriteSuffix = ".geckos3.part"
+
+// reservedFileSuffixes are the endings of the files geckos3 keeps next to
+// objects. An object stored under such a name would be hidden from listings
+// and clobbered by the object the name belongs to, so validateObjectPath
+// rejects keys that map to one.
+var reservedFileSuffixes = []string{".metadata.json", directWriteSuffix}
+
+// bucketConfigDir is the hidden per-bucket directory holding bucket-level
+// settings such as the canned ACL.
+const bucketConfigDir = ".geckos3-config"
+
+// isInternalDir reports whether a directory name is one of geckos3's hidden
+// bookkeeping directories, which never contain user objects.
+func isInternalDir(name string) bool {
+	return name == multipartStagingDir || name == tmpStagingDir || name == indexDir || name == bucketConfigDir
+}
+
 // lockStripes is the number of mutexes in the lock-striping array.
 const lockStripes = 256
 
+// copyBufferSize is the size of the pooled buffers used to stream request
+// bodies and multipart parts to disk.
+const copyBufferSize = 32 * 1024
+
+// concatBufferSize is the size of the pooled buffers used to concatenate
+// multipart parts. Parts are large local files, so bigger reads cut syscall
+// overhead noticeably on fast disks.
+const concatBufferSize = 1024 * 1024
+
+// Pools for the per-write hashers and copy buffers. Hashers are Reset before
+// being handed out and are only returned to the pool once the write that
+// borrowed them has finished, so they are never shared between writes.
+var (
+	md5Pool     = sync.Pool{New: func() any { return md5.New() }}
+	sha256Pool  = sync.Pool{New: func() any { return sha256.New() }}
+	copyBufPool = sync.Pool{New: func() any {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	}}
+	concatBufPool = sync.Pool{New: func() any {
+		buf := make([]byte, concatBufferSize)
+		return &buf
+	}}
+)
+
+// getHasher takes a reset hasher from pool.
+func getHasher(pool *sync.Pool) hash.Hash {
+	h := pool.Get().(hash.Hash)
+	h.Reset()
+	return h
+}
+
+// copyPooled is io.Copy using a buffer from copyBufPool.
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	bufp := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufp)
+	return io.CopyBuffer(dst, src, *bufp)
+}
+
 // ErrBadDigest is returned when the SHA256 hash of the uploaded content
 // does not match the expected hash provided in the request.
 var ErrBadDigest = errors.New("the Content-SHA256 you specified did not match what we received")
 
+// emptySHA256 is the hex SHA-256 of zero bytes, which clients send as
+// x-amz-content-sha256 for empty bodies. Only an empty body has it, so a
+// write expecting it checks the size instead of hashing.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// ErrBadContentMD5 is the ErrBadDigest PutObject returns when the MD5 of the
+// uploaded content does not match PutObjectInput.ExpectedMD5.
+var ErrBadContentMD5 = fmt.Errorf("%w (Content-MD5)", ErrBadDigest)
+
+// ErrInvalidPart is returned by CompleteMultipartUpload when a listed part
+// was never uploaded or its ETag does not match the part's content.
+var ErrInvalidPart = errors.New("one or more of the specified parts could not be found, or the specified entity tag did not match the part's entity tag")
+
+// ErrInvalidPartOrder is returned by CompleteMultipartUpload when the listed
+// part numbers are not in ascending order or repeat.
+var ErrInvalidPartOrder = errors.New("the list of parts was not in ascending order")
+
+// ErrEntityTooSmall is returned by CompleteMultipartUpload when a part other
+// than the last is smaller than minPartSize.
+var ErrEntityTooSmall = errors.New("your proposed upload is smaller than the minimum allowed object size")
+
+// minPartSize is S3's minimum size of every multipart upload part but the
+// last. It is a variable so tests can complete uploads of small parts.
+var minPartSize int64 = 5 * 1024 * 1024
+
+// ErrObjectExists is returned by RenameObject when the destination key is
+// already taken and overwriting was not requested.
+var ErrObjectExists = errors.New("the destination object already exists")
+
+// ErrInvalidKeyName is returned for keys that cannot be stored as file names:
+// keys whose file name ends in one of reservedFileSuffixes, and on Windows
+// with raw key encoding, names the host filesystem does not allow.
+var ErrInvalidKeyName = errors.New("key cannot be stored on this filesystem")
+
+// ErrScanLimit is returned by listings that would collect more than
+// MaxScanLimit keys.
+var ErrScanLimit = fmt.Errorf("listing exceeds the scan limit of %d objects", MaxScanLimit)
+
+// ErrPreconditionFailed is returned by DeleteObjectIfMatch when the object's
+// current ETag is not the expected one, and by PutObject with IfNoneMatch set
+// when the key already exists.
+var ErrPreconditionFailed = errors.New("at least one of the pre-conditions you specified did not hold")
+
+// ErrObjectImmutable is returned by writes, deletes and renames of an object
+// whose immutable-until time has not passed yet.
+var ErrObjectImmutable = errors.New("the object is immutable")
+
+// ErrMetadataDisabled is returned by PutObjectTagging and DeleteObjectTagging
+// when metadata sidecars, which hold object tags, are disabled.
+var ErrMetadataDisabled = errors.New("object metadata is not stored when metadata sidecars are disabled")
+
+// ErrBucketExists is returned by RenameBucket when the new name is taken.
+var ErrBucketExists = errors.New("the destination bucket already exists")
+
+// ErrCrossDevice is returned by RenameBucket when the bucket directory cannot
+// be renamed because its new location is on another filesystem.
+var ErrCrossDevice = errors.New("the bucket cannot be renamed across filesystems")
+
 // Storage defines the interface for bucket/object operations.
 type Storage interface {
 	BucketExists(bucket string) bool
 	CreateBucket(bucket string) error
 	DeleteBucket(bucket string) error
+	RenameBucket(bucket, newName string) error
+	PutBucketACL(bucket, acl string) error
+	GetBucketACL(bucket string) (string, error)
+	PutBucketTagging(bucket string, tags map[string]string) error
+	GetBucketTagging(bucket string) (map[string]string, error)
+	PutBucketCORS(bucket string, config []byte) error
+	GetBucketCORS(bucket string) ([]byte, error)
 	ListBuckets() ([]BucketInfo, error)
 	ListObjects(bucket, prefix string, maxKeys int) ([]ObjectInfo, error)
+	ListObjectsContext(ctx context.Context, bucket, prefix string, maxKeys int) ([]ObjectInfo, error)
+	ListObjectsPage(ctx context.Context, bucket, prefix, delimiter, startAfter string, limit int) ([]ObjectInfo, error)
+	ListDirectory(bucket, prefix string) ([]ObjectInfo, []string, error)
+	WalkObjects(bucket, prefix string, fn func(ObjectInfo) error) error
 	PutObject(bucket, key string, reader io.Reader, input *PutObjectInput) (*ObjectMetadata, error)
 	GetObject(bucket, key string) (io.ReadCloser, *ObjectMetadata, error)
 	HeadObject(bucket, key string) (*ObjectMetadata, error)
-	DeleteObject(bucket, key string) error
-	CopyObject(srcBucket, srcKey, dstBucket, dstKey string, overrideMeta *PutObjectInput) (*ObjectMetadata, error)
+	DeleteObject(bucket, key string) (*DeleteObjectResult, error)
+	DeleteObjectIfMatch(bucket, key, etag string) (*DeleteObjectResult, error)
+	CopyObject(srcBucket, srcKey, dstBucket, dstKey string, overrideMeta *PutObjectInput, overrideTags map[string]string) (*ObjectMetadata, error)
+	RenameObject(bucket, srcKey, dstKey string, overwrite bool) (*ObjectMetadata, error)
+	PutObjectTagging(bucket, key string, tags map[string]string) error
+	GetObjectTagging(bucket, key string) (map[string]string, error)
+	DeleteObjectTagging(bucket, key string) error
 
 	// Multipart upload operations
 	CreateMultipartUpload(bucket, key, contentType string) (string, error)
 	UploadPart(bucket, key, uploadID string, partNumber int, reader io.Reader, expectedSHA256 string) (string, error)
+	UploadPartWithChecksum(bucket, key, uploadID string, partNumber int, reader io.Reader, expectedSHA256 string, checksum *ChecksumInput) (etag, checksumValue string, err error)
 	CompleteMultipartUpload(bucket, key, uploadID string, parts []CompletedPart) (*ObjectMetadata, error)
 	AbortMultipartUpload(bucket, key, uploadID string) error
+	AbortMultipartUploadsForKey(bucket, key string) ([]string, error)
+	ListMultipartUploads(bucket, prefix, keyMarker, uploadIDMarker string, maxUploads int) ([]MultipartUploadInfo, bool, error)
+	ListParts(bucket, key, uploadID string, partNumberMarker, maxParts int) ([]PartInfo, bool, error)
+
+	// SyncAll flushes everything stored to stable storage.
+	SyncAll() error
+
+	// AvailableSpace reports the free bytes new objects can use; ok is false
+	// if unknown.
+	AvailableSpace() (avail uint64, ok bool)
 }
 
 type BucketInfo struct {
@@ -67,8 +217,26 @@ type BucketInfo struct {
 type FilesystemStorage struct {
 	dataDir        string
 	stripes        [lockStripes]sync.Mutex
-	enableFsync    bool // When true, fsync files and directories after writes
-	enableMetadata bool // When true, persist metadata to .metadata.json sidecar files
+	enableFsync    bool           // When true, fsync files and directories after writes
+	enableMetadata bool           // When true, persist metadata to .metadata.json sidecar files
+	index          *keyIndex      // When non-nil, ListObjects reads keys from the on-disk index
+	copyRehashMPU  bool           // When true, copies of multipart objects get a single-part ETag
+	noFollowLinks  bool           // When true, symlinks inside buckets are never served or listed
+	negCache       *negativeCache // When non-nil, recently missing keys are answered from memory
+	packedParts    bool           // When true, new multipart uploads append parts to one staging file
+	encodeKeys     bool           // When true, key segments are base32-encoded on disk
+	lockWaitWarn   time.Duration  // When positive, stripe lock waits longer than this are logged
+	dedup          bool           // When true, PutObject bodies are stored once per content in casDir
+	directWrite    bool           // When true, PutObject streams next to the object under the stripe lock
+}
+
+// DeleteObjectResult describes what a delete did, for the x-amz-version-id
+// and x-amz-delete-marker response headers. Without versioning a delete
+// removes the object outright, so both fields stay empty and S3 omits the
+// headers.
+type DeleteObjectResult struct {
+	VersionID    string // Version removed, or of the delete marker created
+	DeleteMarker bool   // True if the delete created or removed a delete marker
 }
 
 type ObjectMetadata struct {
@@ -80,6 +248,24 @@ type ObjectMetadata struct {
 	ContentDisposition string            `json:"contentDisposition,omitempty"`
 	CacheControl       string            `json:"cacheControl,omitempty"`
 	CustomMetadata     map[string]string `json:"customMetadata,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+
+	// ChecksumAlgorithm and Checksum hold the x-amz-checksum digest the
+	// object was uploaded with, e.g. "CRC32C" and its base64 value.
+	ChecksumAlgorithm string `json:"checksumAlgorithm,omitempty"`
+	Checksum          string `json:"checksum,omitempty"`
+
+	// Blob is the SHA-256 of the casDir blob the object's data file links
+	// to, when it was stored with -dedup.
+	Blob string `json:"blob,omitempty"`
+
+	// Stale is set by HeadObject when the sidecar records a different size
+	// than the data file, which was then changed or truncated behind
+	// geckos3's back; FileSize is then the file's size. Size, the ETag and
+	// the other fields stay as stored, so a caller that saves the metadata
+	// back keeps the drift detectable. Neither is persisted.
+	Stale    bool  `json:"-"`
+	FileSize int64 `json:"-"`
 }
 
 type ObjectInfo struct {
@@ -96,7 +282,11 @@ type PutObjectInput struct {
 	ContentDisposition string
 	CacheControl       string
 	CustomMetadata     map[string]string
-	ExpectedSHA256     string // If set, verify content hash before committing
+	Tags               map[string]string // Object tags, e.g. from x-amz-tagging
+	ExpectedSHA256     string            // If set, verify content hash before committing
+	ExpectedMD5        []byte            // If set, verify the raw MD5 (from Content-MD5) before committing
+	Checksum           *ChecksumInput    // If set, compute (and verify) an x-amz-checksum digest and store it
+	IfNoneMatch        bool              // If set, fail with ErrPreconditionFailed if the key exists
 }
 
 // CompletedPart represents a single part in a CompleteMultipartUpload request.
@@ -105,13 +295,52 @@ type CompletedPart struct {
 	ETag       string
 }
 
+// NewFilesystemStorage creates a storage rooted at dataDir. The directory is
+// resolved once to its canonical absolute path (following symlinks), and
+// every later path is built and validated against that, so re-pointing a
+// symlinked data directory at runtime cannot move the root out from under
+// the traversal checks.
 func NewFilesystemStorage(dataDir string) *FilesystemStorage {
 	return &FilesystemStorage{
-		dataDir:        dataDir,
+		dataDir:        canonicalDir(dataDir),
 		enableMetadata: true,
 	}
 }
 
+// canonicalDir returns the absolute, symlink-free form of dir. If dir does not
+// exist yet it falls back to the cleaned absolute path.
+func canonicalDir(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.Clean(dir)
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	return abs
+}
+
+// CaseInsensitive reports whether the filesystem holding the data directory
+// folds case, as macOS and Windows do by default. There, keys that differ
+// only in case (File.txt and file.txt) map to the same file and silently
+// overwrite each other. It probes by creating a file and looking it up under
+// an upper-cased name.
+func (fs *FilesystemStorage) CaseInsensitive() (bool, error) {
+	probe, err := os.CreateTemp(fs.dataDir, ".geckos3-case-probe-*")
+	if err != nil {
+		return false, err
+	}
+	probe.Close()
+	defer os.Remove(probe.Name())
+
+	upper := filepath.Join(filepath.Dir(probe.Name()), strings.ToUpper(filepath.Base(probe.Name())))
+	_, err = os.Stat(upper)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // SetFsync enables or disables per-object fsync. When disabled (default),
 // writes rely on OS page cache and atomic rename for consistency, matching
 // the behavior of MinIO and other high-performance object stores.
@@ -119,6 +348,85 @@ func (fs *FilesystemStorage) SetFsync(enabled bool) {
 	fs.enableFsync = enabled
 }
 
+// SetNoFollowSymlinks makes reads, copies and listings treat any object whose
+// path inside the bucket (or the bucket directory itself) is a symbolic link
+// as missing, so a link planted in the data directory cannot expose files
+// outside it.
+func (fs *FilesystemStorage) SetNoFollowSymlinks(enabled bool) {
+	fs.noFollowLinks = enabled
+}
+
+// refusesLink reports whether key must be treated as missing because
+// noFollowLinks is set and the bucket directory or any path component of the
+// key is a symlink. Components are checked with os.Lstat from the bucket down.
+func (fs *FilesystemStorage) refusesLink(bucket, key string) bool {
+	if !fs.noFollowLinks {
+		return false
+	}
+	p := fs.dataDir
+	for _, part := range append([]string{bucket}, strings.Split(fs.keyToRel(key), "/")...) {
+		if part == "" {
+			continue
+		}
+		p = filepath.Join(p, part)
+		info, err := os.Lstat(p)
+		if err != nil {
+			// Missing: the caller's own open or stat reports it.
+			return false
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SetNegativeCacheTTL enables a bounded in-memory cache of keys that GET or
+// HEAD recently found missing, so hot 404s skip the filesystem for up to ttl.
+// Writes through this storage invalidate the key immediately; files added to
+// the data directory by hand are only seen once the entry expires. A ttl <= 0
+// disables the cache.
+func (fs *FilesystemStorage) SetNegativeCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		fs.negCache = nil
+		return
+	}
+	fs.negCache = newNegativeCache(ttl)
+}
+
+// knownMissing reports whether key is cached as missing. Otherwise it returns
+// the cache generation to hand to rememberMissing if the lookup then fails.
+func (fs *FilesystemStorage) knownMissing(bucket, key string) (bool, uint64) {
+	if fs.negCache == nil {
+		return false, 0
+	}
+	if fs.negCache.missing(bucket, key) {
+		return true, 0
+	}
+	return false, fs.negCache.generation()
+}
+
+// rememberMissing caches key as missing if err says it does not exist.
+func (fs *FilesystemStorage) rememberMissing(bucket, key string, gen uint64, err error) {
+	if fs.negCache != nil && os.IsNotExist(err) {
+		fs.negCache.add(bucket, key, gen)
+	}
+}
+
+// forgetMissingBucket drops every negative cache entry of bucket.
+func (fs *FilesystemStorage) forgetMissingBucket(bucket string) {
+	if fs.negCache != nil {
+		fs.negCache.forgetBucket(bucket)
+	}
+}
+
+// forgetMissing drops key from the negative cache after a write.
+func (fs *FilesystemStorage) forgetMissing(bucket, key string) {
+	if fs.negCache != nil {
+		fs.negCache.forget(bucket, key)
+	}
+}
+
 // SetMetadataEnabled controls whether metadata is persisted to .metadata.json files.
 // When disabled, metadata is computed on-demand from file attributes for performance.
 // Default: true (full S3 compatibility).
@@ -126,11 +434,86 @@ func (fs *FilesystemStorage) SetMetadataEnabled(enabled bool) {
 	fs.enableMetadata = enabled
 }
 
+// SetCopyRecomputesMultipartETag controls the ETag CopyObject gives a copy of
+// a multipart-origin object (ETag "<md5>-N"). By default the source ETag,
+// suffix included, is carried over like any other ETag. When enabled, the copy
+// is hashed and gets the plain MD5 of its content, as S3 does; this costs a
+// full read of the data instead of a server-side clone.
+func (fs *FilesystemStorage) SetCopyRecomputesMultipartETag(enabled bool) {
+	fs.copyRehashMPU = enabled
+}
+
+// SetPackedMultipart makes new multipart uploads stage all their parts in a
+// single file with an offset index, instead of one file per part, so uploads
+// with thousands of parts use a handful of inodes. Each part is still written
+// to a temp file first and then appended, so staging costs twice the writes.
+// Uploads keep the layout they were created with.
+func (fs *FilesystemStorage) SetPackedMultipart(enabled bool) {
+	fs.packedParts = enabled
+}
+
+// SetDirectWrite makes PutObject stream each body into a part file next to
+// the object (the object path plus directWriteSuffix) while holding the key's
+// stripe lock, then rename it over the object. This skips the shared staging
+// directory, but holds the lock for the whole upload, so a slow client stalls
+// every other writer on the same stripe. It is meant for trusted single-writer
+// deployments. Multipart uploads, copies and -dedup writes stay staged.
+func (fs *FilesystemStorage) SetDirectWrite(enabled bool) {
+	fs.directWrite = enabled
+}
+
 // stripe returns the mutex for a given key using FNV-1a hashing.
 func (fs *FilesystemStorage) stripe(key string) *sync.Mutex {
+	return &fs.stripes[stripeIndex(key)]
+}
+
+// SetLockWaitWarning logs a warning whenever PutObject or
+// CompleteMultipartUpload waits longer than d for a stripe lock. Zero disables
+// the warning; the wait is recorded in geckos3_stripe_lock_wait_seconds
+// either way.
+func (fs *FilesystemStorage) SetLockWaitWarning(d time.Duration) {
+	fs.lockWaitWarn = d
+}
+
+// lockStripe locks the stripe of path and records how long that took, so
+// same-stripe contention shows up as a metric and a log line instead of
+// unexplained latency.
+func (fs *FilesystemStorage) lockStripe(op, path string) *sync.Mutex {
+	mu := fs.stripe(path)
+	start := time.Now()
+	mu.Lock()
+	wait := time.Since(start)
+	stripeLockWait.Observe(wait)
+	if fs.lockWaitWarn > 0 && wait > fs.lockWaitWarn {
+		log.Printf("WARNING: %s waited %s for stripe lock %d (%s)", op, wait.Round(time.Millisecond), stripeIndex(path), path)
+	}
+	return mu
+}
+
+func stripeIndex(key string) uint32 {
 	h := fnv.New32a()
 	h.Write([]byte(key))
-	return &fs.stripes[h.Sum32()%lockStripes]
+	return h.Sum32() % lockStripes
+}
+
+// lockPair locks the stripes of two paths in index order, so two goroutines
+// locking the same pair in opposite roles cannot deadlock. The returned
+// function unlocks both.
+func (fs *FilesystemStorage) lockPair(a, b string) func() {
+	i, j := stripeIndex(a), stripeIndex(b)
+	if i == j {
+		fs.stripes[i].Lock()
+		return fs.stripes[i].Unlock
+	}
+	if i > j {
+		i, j = j, i
+	}
+	fs.stripes[i].Lock()
+	fs.stripes[j].Lock()
+	return func() {
+		fs.stripes[j].Unlock()
+		fs.stripes[i].Unlock()
+	}
 }
 
 // Path validation to prevent directory traversal
@@ -138,16 +521,10 @@ func (fs *FilesystemStorage) validateBucketPath(bucket string) error {
 	if bucket == "" {
 		return fmt.Errorf("invalid bucket name")
 	}
+	// fs.dataDir is already absolute and clean, so Join alone resolves any
+	// ".." in bucket.
 	resolved := filepath.Join(fs.dataDir, bucket)
-	absData, err := filepath.Abs(fs.dataDir)
-	if err != nil {
-		return err
-	}
-	absResolved, err := filepath.Abs(resolved)
-	if err != nil {
-		return err
-	}
-	if !strings.HasPrefix(absResolved, absData+string(filepath.Separator)) {
+	if !strings.HasPrefix(resolved, fs.dataDir+string(filepath.Separator)) {
 		return fmt.Errorf("invalid bucket name")
 	}
 	return nil
@@ -160,17 +537,25 @@ func (fs *FilesystemStorage) validateObjectPath(bucket, key string) error {
 	if key == "" || strings.Contains(key, "\x00") {
 		return fmt.Errorf("invalid key")
 	}
-	resolved := filepath.Join(fs.dataDir, bucket, filepath.FromSlash(key))
-	absBucket, err := filepath.Abs(filepath.Join(fs.dataDir, bucket))
-	if err != nil {
-		return err
+	resolved := fs.objectPath(bucket, key)
+	bucketPath := filepath.Join(fs.dataDir, bucket)
+	if !strings.HasPrefix(resolved, bucketPath+string(filepath.Separator)) {
+		return fmt.Errorf("invalid key")
 	}
-	absResolved, err := filepath.Abs(resolved)
-	if err != nil {
-		return err
+	for _, suffix := range reservedFileSuffixes {
+		if strings.HasSuffix(resolved, suffix) {
+			return fmt.Errorf("%w: keys may not end in %q", ErrInvalidKeyName, suffix)
+		}
 	}
-	if !strings.HasPrefix(absResolved, absBucket+string(filepath.Separator)) {
-		return fmt.Errorf("invalid key")
+	if checkWindowsNames && !fs.encodeKeys {
+		for _, seg := range strings.Split(key, "/") {
+			if seg == "" {
+				continue
+			}
+			if problem := windowsNameProblem(seg); problem != "" {
+				return fmt.Errorf("%w: %q %s (use -key-encoding base32)", ErrInvalidKeyName, seg, problem)
+			}
+		}
 	}
 	return nil
 }
@@ -182,8 +567,9 @@ func (fs *FilesystemStorage) computeFileETag(path string) (string, error) {
 		return "", err
 	}
 	defer f.Close()
-	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
+	h := getHasher(&md5Pool)
+	defer md5Pool.Put(h)
+	if _, err := copyPooled(h, f); err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("\"%s\"", hex.EncodeToString(h.Sum(nil))), nil
@@ -224,26 +610,252 @@ func (fs *FilesystemStorage) DeleteBucket(bucket string) error {
 	}
 	path := filepath.Join(fs.dataDir, bucket)
 
-	entries, err := os.ReadDir(path)
+	hasObjects, err := fs.dirHasObjects(path)
 	if err != nil {
 		return err
 	}
+	if hasObjects {
+		return fmt.Errorf("bucket not empty")
+	}
+
+	fs.dropIndex(bucket)
+	fs.forgetMissingBucket(bucket)
+	return os.RemoveAll(path)
+}
 
-	// A bucket is empty if it contains nothing besides internal hidden directories
-	// and common OS artifacts.
-	hiddenEntries := map[string]bool{
-		multipartStagingDir: true,
-		tmpStagingDir:       true,
-		".DS_Store":         true,
-		"Thumbs.db":         true,
+// RenameBucket renames the bucket directory with a single os.Rename. Object
+// sidecars and multipart staging data hold no bucket-absolute paths, so they
+// move with it unchanged. The caller validates newName and must serialize
+// renames and creates so the existence check cannot race.
+func (fs *FilesystemStorage) RenameBucket(bucket, newName string) error {
+	if err := fs.validateBucketPath(bucket); err != nil {
+		return err
 	}
-	for _, entry := range entries {
-		if !hiddenEntries[entry.Name()] {
-			return fmt.Errorf("bucket not empty")
+	if err := fs.validateBucketPath(newName); err != nil {
+		return err
+	}
+	if !fs.BucketExists(bucket) {
+		return fmt.Errorf("bucket does not exist")
+	}
+	newPath := filepath.Join(fs.dataDir, newName)
+	if _, err := os.Lstat(newPath); err == nil {
+		return ErrBucketExists
+	}
+
+	// The index log is opened inside the bucket directory; close it so it is
+	// reloaded (and rebuilt, since it is left dirty) under the new name. No
+	// request can reopen it under the old name before the directory moves.
+	// Writes racing the rename cannot recreate the old directory either, as
+	// mkdirInBucket never creates a bucket.
+	err := fs.withoutIndex(bucket, func() error {
+		return os.Rename(filepath.Join(fs.dataDir, bucket), newPath)
+	})
+	if err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			return ErrCrossDevice
 		}
+		return err
+	}
+	if fs.enableFsync {
+		syncParentDir(newPath)
 	}
+	fs.forgetMissingBucket(newName)
+	return nil
+}
 
-	return os.RemoveAll(path)
+// PutBucketACL records the canned ACL of bucket. ACLs are not enforced; the
+// value is only kept so GetBucketACL can report it back.
+func (fs *FilesystemStorage) PutBucketACL(bucket, acl string) error {
+	if err := fs.validateBucketPath(bucket); err != nil {
+		return err
+	}
+	if !fs.BucketExists(bucket) {
+		return fmt.Errorf("bucket does not exist")
+	}
+	return fs.writeBucketConfig(bucket, "acl", []byte(acl))
+}
+
+// mkdirInBucket creates dir, which lies inside bucket, and any missing
+// directories between the two. Unlike os.MkdirAll it never creates the bucket
+// directory itself, so a write racing DeleteBucket or RenameBucket fails
+// instead of bringing the old bucket back as a new, empty directory.
+func (fs *FilesystemStorage) mkdirInBucket(bucket, dir string) error {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return nil
+	}
+	bucketPath := filepath.Join(fs.dataDir, bucket)
+	rel, err := filepath.Rel(bucketPath, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is not inside bucket %s", dir, bucket)
+	}
+	path := bucketPath
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		if name == "." {
+			continue
+		}
+		path = filepath.Join(path, name)
+		if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+			if os.IsNotExist(err) && filepath.Dir(path) == bucketPath {
+				return fmt.Errorf("bucket does not exist")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBucketConfig atomically replaces the file name in the bucket's
+// configuration directory.
+func (fs *FilesystemStorage) writeBucketConfig(bucket, name string, data []byte) error {
+	dir := filepath.Join(fs.dataDir, bucket, bucketConfigDir)
+	if err := fs.mkdirInBucket(bucket, dir); err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(dir, "."+name+"-tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(dir, name))
+}
+
+// GetBucketACL returns the canned ACL recorded for bucket, or "private" if
+// none was set.
+func (fs *FilesystemStorage) GetBucketACL(bucket string) (string, error) {
+	if err := fs.validateBucketPath(bucket); err != nil {
+		return "", err
+	}
+	if !fs.BucketExists(bucket) {
+		return "", fmt.Errorf("bucket does not exist")
+	}
+	data, err := os.ReadFile(filepath.Join(fs.dataDir, bucket, bucketConfigDir, "acl"))
+	if os.IsNotExist(err) {
+		return "private", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// PutBucketTagging replaces the tag set of bucket. An empty set deletes it.
+func (fs *FilesystemStorage) PutBucketTagging(bucket string, tags map[string]string) error {
+	if err := fs.validateBucketPath(bucket); err != nil {
+		return err
+	}
+	if !fs.BucketExists(bucket) {
+		return fmt.Errorf("bucket does not exist")
+	}
+	if len(tags) == 0 {
+		err := os.Remove(filepath.Join(fs.dataDir, bucket, bucketConfigDir, "tagging.json"))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	return fs.writeBucketConfig(bucket, "tagging.json", data)
+}
+
+// GetBucketTagging returns the tag set of bucket, or nil if it has none.
+func (fs *FilesystemStorage) GetBucketTagging(bucket string) (map[string]string, error) {
+	if err := fs.validateBucketPath(bucket); err != nil {
+		return nil, err
+	}
+	if !fs.BucketExists(bucket) {
+		return nil, fmt.Errorf("bucket does not exist")
+	}
+	data, err := os.ReadFile(filepath.Join(fs.dataDir, bucket, bucketConfigDir, "tagging.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tags map[string]string
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, fmt.Errorf("corrupt bucket tagging: %w", err)
+	}
+	return tags, nil
+}
+
+// PutBucketCORS stores the bucket's encoded CORS configuration, which the
+// handler parses and validates. An empty config deletes it.
+func (fs *FilesystemStorage) PutBucketCORS(bucket string, config []byte) error {
+	if err := fs.validateBucketPath(bucket); err != nil {
+		return err
+	}
+	if !fs.BucketExists(bucket) {
+		return fmt.Errorf("bucket does not exist")
+	}
+	if len(config) == 0 {
+		err := os.Remove(filepath.Join(fs.dataDir, bucket, bucketConfigDir, "cors.xml"))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return fs.writeBucketConfig(bucket, "cors.xml", config)
+}
+
+// GetBucketCORS returns the bucket's stored CORS configuration, or nil if it
+// has none.
+func (fs *FilesystemStorage) GetBucketCORS(bucket string) ([]byte, error) {
+	if err := fs.validateBucketPath(bucket); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(fs.dataDir, bucket, bucketConfigDir, "cors.xml"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// dirHasObjects walks a directory inside a bucket and reports whether it
+// contains at least one real object file. Internal staging directories,
+// metadata sidecars, common OS artifacts and empty directories (e.g. left
+// behind by an interrupted delete) do not count, so they never block bucket
+// deletion or show up as common prefixes.
+func (fs *FilesystemStorage) dirHasObjects(root string) (bool, error) {
+	ignoredFiles := map[string]bool{
+		".DS_Store": true,
+		"Thumbs.db": true,
+	}
+
+	found := false
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && isInternalDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignoredFiles[d.Name()] || strings.HasSuffix(d.Name(), ".metadata.json") || strings.HasSuffix(d.Name(), directWriteSuffix) {
+			return nil
+		}
+		found = true
+		return filepath.SkipAll
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
 }
 
 func (fs *FilesystemStorage) ListBuckets() ([]BucketInfo, error) {
@@ -254,7 +866,7 @@ func (fs *FilesystemStorage) ListBuckets() ([]BucketInfo, error) {
 
 	var buckets []BucketInfo
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		if !entry.IsDir() || entry.Name() == casDir {
 			continue
 		}
 		info, err := entry.Info()
@@ -270,6 +882,12 @@ func (fs *FilesystemStorage) ListBuckets() ([]BucketInfo, error) {
 }
 
 func (fs *FilesystemStorage) ListObjects(bucket, prefix string, maxKeys int) ([]ObjectInfo, error) {
+	return fs.ListObjectsContext(context.Background(), bucket, prefix, maxKeys)
+}
+
+// ListObjectsContext is ListObjects with a context: a bucket walk stops with
+// ctx.Err() once ctx is done, so a slow listing can be abandoned.
+func (fs *FilesystemStorage) ListObjectsContext(ctx context.Context, bucket, prefix string, maxKeys int) ([]ObjectInfo, error) {
 	if err := fs.validateBucketPath(bucket); err != nil {
 		return nil, err
 	}
@@ -279,89 +897,327 @@ func (fs *FilesystemStorage) ListObjects(bucket, prefix string, maxKeys int) ([]
 		return nil, fmt.Errorf("bucket does not exist")
 	}
 
-	// Collect keys as strings only, skipping metadata/staging files.
-	// Enforces MaxScanLimit to prevent unbounded memory growth.
+	// Collect keys as strings only, from the index when enabled and by
+	// walking the bucket otherwise.
 	var keys []string
-	scanCount := 0
-
-	err := filepath.WalkDir(bucketPath, func(path string, d os.DirEntry, err error) error {
+	idx, err := fs.bucketIndex(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if idx != nil {
+		keys = idx.page(prefix, "", "", maxKeys)
+	} else {
+		keys, err = fs.scanKeys(ctx, bucketPath, prefix, MaxScanLimit)
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
 
-		// Skip internal staging directories entirely
-		if d.IsDir() && (d.Name() == multipartStagingDir || d.Name() == tmpStagingDir) {
-			return filepath.SkipDir
-		}
+	// Sort keys lexicographically (S3 compliance)
+	sort.Strings(keys)
 
-		// Skip directories and metadata sidecar files
-		if d.IsDir() || strings.HasSuffix(path, ".metadata.json") {
-			return nil
+	// Apply maxKeys pagination
+	if maxKeys > 0 && len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+	}
+
+	// Fetch metadata only for the keys in the current page
+	objects := make([]ObjectInfo, 0, len(keys))
+	for _, key := range keys {
+		if obj, ok := fs.objectInfo(bucket, key); ok {
+			objects = append(objects, obj)
 		}
+	}
 
-		// Get relative path from bucket
-		relPath, err := filepath.Rel(bucketPath, path)
+	return objects, nil
+}
+
+// ListObjectsPage returns one page of a listing in key order: the objects
+// after startAfter that start with prefix, at most limit of them (all if
+// limit <= 0). With a delimiter, the keys that share a common prefix come
+// back as a single placeholder entry whose key is that prefix, and a
+// startAfter inside a common prefix skips the rest of it. With the index only
+// the returned page is looked up on disk; without it the bucket is walked
+// (or, for the "/" delimiter, the one directory the prefix points at is read)
+// and the page is cut from the result.
+func (fs *FilesystemStorage) ListObjectsPage(ctx context.Context, bucket, prefix, delimiter, startAfter string, limit int) ([]ObjectInfo, error) {
+	if err := fs.validateBucketPath(bucket); err != nil {
+		return nil, err
+	}
+	if !fs.BucketExists(bucket) {
+		return nil, fmt.Errorf("bucket does not exist")
+	}
+
+	idx, err := fs.bucketIndex(bucket)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	switch {
+	case idx != nil:
+		keys = idx.page(prefix, delimiter, startAfter, limit)
+	case delimiter == "/":
+		objects, prefixes, err := fs.ListDirectory(bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range prefixes {
+			objects = append(objects, ObjectInfo{Key: p})
+		}
+		sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+		i := sort.Search(len(objects), func(i int) bool { return objects[i].Key > startAfter })
+		objects = objects[i:]
+		if limit > 0 && len(objects) > limit {
+			objects = objects[:limit]
+		}
+		return objects, nil
+	default:
+		keys, err = fs.scanKeys(ctx, filepath.Join(fs.dataDir, bucket), prefix, MaxScanLimit)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(keys)
+		keys = pageKeys(keys, prefix, delimiter, startAfter, limit)
+	}
+
+	objects := make([]ObjectInfo, 0, len(keys))
+	for _, key := range keys {
+		if delimiter != "" && strings.Contains(key[len(prefix):], delimiter) {
+			objects = append(objects, ObjectInfo{Key: key})
+			continue
+		}
+		if obj, ok := fs.objectInfo(bucket, key); ok {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// pageKeys cuts one listing page out of the sorted keys: those after
+// startAfter that start with prefix, at most limit of them (all if
+// limit <= 0). With a delimiter each run of keys sharing a common prefix is
+// replaced by the prefix itself, found by binary search so a large "folder"
+// costs no more than a single key; the prefix is left out if it is not after
+// startAfter.
+func pageKeys(keys []string, prefix, delimiter, startAfter string, limit int) []string {
+	i := sort.Search(len(keys), func(i int) bool {
+		return keys[i] >= prefix && keys[i] > startAfter
+	})
+	var page []string
+	for i < len(keys) && strings.HasPrefix(keys[i], prefix) {
+		if limit > 0 && len(page) >= limit {
+			break
+		}
+		key := keys[i]
+		if n := strings.Index(key[len(prefix):], delimiter); delimiter != "" && n >= 0 {
+			cp := key[:len(prefix)+n+len(delimiter)]
+			rest := keys[i:]
+			i += sort.Search(len(rest), func(j int) bool { return !strings.HasPrefix(rest[j], cp) })
+			if cp > startAfter {
+				page = append(page, cp)
+			}
+			continue
+		}
+		page = append(page, key)
+		i++
+	}
+	return page
+}
+
+// scanKeys walks a bucket directory and returns the keys of all objects that
+// start with prefix, skipping metadata sidecars and internal directories. A
+// positive limit aborts the walk with ErrScanLimit once more than limit keys
+// have been found, preventing unbounded memory growth; ctx being done aborts
+// it with ctx.Err().
+func (fs *FilesystemStorage) scanKeys(ctx context.Context, bucketPath, prefix string, limit int) ([]string, error) {
+	var keys []string
+	err := fs.walkKeys(bucketPath, prefix, func(key string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if limit > 0 && len(keys) >= limit {
+			return ErrScanLimit
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// walkKeys calls fn with the key of every object under bucketPath that starts
+// with prefix, skipping metadata sidecars, internal directories and, with
+// noFollowLinks, symlinks. Keys are produced in directory walk order. An
+// error from fn stops the walk and is returned.
+func (fs *FilesystemStorage) walkKeys(bucketPath, prefix string, fn func(key string) error) error {
+	return filepath.WalkDir(bucketPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip internal staging directories entirely
+		if d.IsDir() && isInternalDir(d.Name()) {
+			return filepath.SkipDir
+		}
+
+		// Skip directories, metadata sidecars and direct-write part files
+		if d.IsDir() || strings.HasSuffix(path, ".metadata.json") || strings.HasSuffix(path, directWriteSuffix) {
+			return nil
+		}
+		if fs.noFollowLinks && d.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		// Get relative path from bucket
+		relPath, err := filepath.Rel(bucketPath, path)
 		if err != nil {
 			return err
 		}
 
 		// Convert to S3 key format (use forward slashes)
-		key := filepath.ToSlash(relPath)
+		key, ok := fs.relToKey(filepath.ToSlash(relPath))
+		if !ok {
+			return nil
+		}
 
 		// Apply prefix filter
 		if prefix != "" && !strings.HasPrefix(key, prefix) {
 			return nil
 		}
 
-		scanCount++
-		if scanCount > MaxScanLimit {
-			return fmt.Errorf("bucket exceeds scan limit of %d objects; listing aborted", MaxScanLimit)
-		}
-
-		keys = append(keys, key)
-		return nil
+		return fn(key)
 	})
+}
+
+// WalkObjects calls fn for every object in bucket whose key starts with
+// prefix, without collecting them into a slice or applying MaxScanLimit. It is
+// meant for internal jobs that must visit every object. Objects are visited
+// in sorted key order when the key index is enabled and in directory walk
+// order otherwise. Objects deleted during the walk are skipped. An error from
+// fn stops the walk and is returned.
+func (fs *FilesystemStorage) WalkObjects(bucket, prefix string, fn func(ObjectInfo) error) error {
+	if err := fs.validateBucketPath(bucket); err != nil {
+		return err
+	}
+	if !fs.BucketExists(bucket) {
+		return fmt.Errorf("bucket does not exist")
+	}
 
+	visit := func(key string) error {
+		obj, ok := fs.objectInfo(bucket, key)
+		if !ok {
+			return nil
+		}
+		return fn(obj)
+	}
+
+	idx, err := fs.bucketIndex(bucket)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if idx != nil {
+		for _, key := range idx.page(prefix, "", "", 0) {
+			if err := visit(key); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
+	return fs.walkKeys(filepath.Join(fs.dataDir, bucket), prefix, visit)
+}
 
-	// Sort keys lexicographically (S3 compliance)
-	sort.Strings(keys)
+// ListDirectory lists the objects and common prefixes directly under prefix,
+// treating "/" as the delimiter. Only the single directory the prefix points
+// at is read, so browsing one "folder" costs O(entries in folder) rather than
+// O(entries in subtree). Sub-directories are returned as common prefixes
+// (ending in "/") only if they contain at least one object.
+func (fs *FilesystemStorage) ListDirectory(bucket, prefix string) ([]ObjectInfo, []string, error) {
+	if err := fs.validateBucketPath(bucket); err != nil {
+		return nil, nil, err
+	}
+	if !fs.BucketExists(bucket) {
+		return nil, nil, fmt.Errorf("bucket does not exist")
+	}
 
-	// Apply maxKeys pagination
-	if maxKeys > 0 && len(keys) > maxKeys {
-		keys = keys[:maxKeys]
+	// Split the prefix into the directory to read and the name filter
+	// applied to its entries: "photos/ca" → dir "photos/", filter "ca".
+	dirPart, namePrefix := "", prefix
+	if idx := strings.LastIndex(prefix, "/"); idx >= 0 {
+		dirPart, namePrefix = prefix[:idx+1], prefix[idx+1:]
+	}
+	if dirPart != "" {
+		if err := fs.validateObjectPath(bucket, strings.TrimSuffix(dirPart, "/")); err != nil {
+			// No object can live under a prefix that escapes the bucket.
+			return nil, nil, nil
+		}
 	}
+	dirPath := filepath.Join(fs.dataDir, bucket, filepath.FromSlash(fs.keyToRel(dirPart)))
 
-	// Fetch metadata only for the keys in the current page
-	objects := make([]ObjectInfo, 0, len(keys))
-	for _, key := range keys {
-		objectPath := fs.objectPath(bucket, key)
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		// A prefix without a matching directory simply has no objects.
+		return nil, nil, nil
+	}
+	if len(entries) > MaxScanLimit {
+		return nil, nil, fmt.Errorf("directory has %d entries: %w", len(entries), ErrScanLimit)
+	}
 
-		info, err := os.Stat(objectPath)
-		if err != nil {
-			// File was deleted between walk and stat, skip it
+	var objects []ObjectInfo
+	var prefixes []string
+	for _, entry := range entries {
+		if entry.IsDir() && isInternalDir(entry.Name()) {
+			continue
+		}
+		if !entry.IsDir() && (strings.HasSuffix(entry.Name(), ".metadata.json") || strings.HasSuffix(entry.Name(), directWriteSuffix)) {
+			continue
+		}
+		name, ok := fs.relToKey(entry.Name())
+		if !ok || !strings.HasPrefix(name, namePrefix) {
 			continue
 		}
 
-		etag := ""
-		if meta, loadErr := fs.loadMetadata(bucket, key); loadErr == nil {
-			etag = meta.ETag
+		if entry.IsDir() {
+			if has, err := fs.dirHasObjects(filepath.Join(dirPath, entry.Name())); err == nil && has {
+				prefixes = append(prefixes, dirPart+name+"/")
+			}
+			continue
 		}
-		if etag == "" {
-			etag = fs.generatePseudoETag(info)
+
+		if obj, ok := fs.objectInfo(bucket, dirPart+name); ok {
+			objects = append(objects, obj)
 		}
+	}
 
-		objects = append(objects, ObjectInfo{
-			Key:          key,
-			Size:         info.Size(),
-			LastModified: info.ModTime(),
-			ETag:         etag,
-		})
+	return objects, prefixes, nil
+}
+
+// objectInfo stats a single object for a listing entry. It reports false if
+// the object disappeared between the directory scan and the stat.
+func (fs *FilesystemStorage) objectInfo(bucket, key string) (ObjectInfo, bool) {
+	if fs.refusesLink(bucket, key) {
+		return ObjectInfo{}, false
+	}
+	info, err := os.Stat(fs.objectPath(bucket, key))
+	if err != nil {
+		return ObjectInfo{}, false
 	}
 
-	return objects, nil
+	etag := ""
+	if meta, loadErr := fs.loadMetadata(bucket, key); loadErr == nil {
+		etag = meta.ETag
+	}
+	if etag == "" {
+		etag = fs.generatePseudoETag(info)
+	}
+
+	return ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		ETag:         etag,
+	}, true
 }
 
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -375,90 +1231,139 @@ func (fs *FilesystemStorage) PutObject(bucket, key string, reader io.Reader, inp
 	objectPath := fs.objectPath(bucket, key)
 	bucketPath := filepath.Join(fs.dataDir, bucket)
 
-	// Stage temp files in a dedicated hidden directory to avoid races
-	// with DeleteObject empty-directory cleanup.
-	stagingDir := filepath.Join(bucketPath, tmpStagingDir)
-	if err := os.MkdirAll(stagingDir, 0755); err != nil {
-		return nil, err
+	// A missing bucket is created here, before the body streams, rather
+	// than by the directory creation below: a bucket that DeleteBucket or
+	// RenameBucket removes mid-write then fails the write instead of coming
+	// back as a new, empty directory.
+	if !fs.BucketExists(bucket) {
+		if err := fs.CreateBucket(bucket); err != nil {
+			return nil, err
+		}
 	}
 
-	// Write to temp file OUTSIDE the stripe lock — network I/O must not
-	// hold a mutex because clients may be slow or large uploads take time.
-	tempFile, err := os.CreateTemp(stagingDir, ".put-*")
-	if err != nil {
-		return nil, err
+	// With direct writes the body goes next to the object and mu is held
+	// from here on; see SetDirectWrite.
+	var mu *sync.Mutex
+	var tempFile *os.File
+	var stagingDir string
+	var err error
+	if fs.directWrite && !fs.dedup {
+		mu, tempFile, err = fs.createDirectPart(bucket, objectPath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Stage temp files in a dedicated hidden directory to avoid races
+		// with DeleteObject empty-directory cleanup.
+		stagingDir = filepath.Join(bucketPath, tmpStagingDir)
+		if err := fs.mkdirInBucket(bucket, stagingDir); err != nil {
+			return nil, err
+		}
+
+		// Write to temp file OUTSIDE the stripe lock — network I/O must not
+		// hold a mutex because clients may be slow or large uploads take time.
+		tempFile, err = os.CreateTemp(stagingDir, ".put-*")
+		if err != nil {
+			return nil, err
+		}
 	}
 	tempPath := tempFile.Name()
 
+	// abort rolls back a write that failed before the lock below: it removes
+	// the temp file and, for a direct write, the directories created for it.
+	abort := func() {
+		os.Remove(tempPath)
+		if mu != nil {
+			fs.removeEmptyParents(bucket, objectPath)
+			mu.Unlock()
+		}
+	}
+
 	// Stream data and calculate MD5 (+ optional SHA256)
-	md5Hash := md5.New()
+	md5Hash := getHasher(&md5Pool)
+	defer md5Pool.Put(md5Hash)
 	writers := []io.Writer{tempFile, md5Hash}
 
-	var sha256Hasher io.Writer
-	var sha256Sum func() []byte
+	var sha256Hasher hash.Hash
 	var expectedSHA string
-	if input != nil && input.ExpectedSHA256 != "" {
+	var checksum *ChecksumInput
+	if input != nil {
 		expectedSHA = input.ExpectedSHA256
-		h := sha256.New()
-		sha256Hasher = h
-		sha256Sum = func() []byte { return h.Sum(nil) }
-		writers = append(writers, h)
+		checksum = input.Checksum
+	}
+	if (expectedSHA != "" && expectedSHA != emptySHA256) || fs.dedup {
+		sha256Hasher = getHasher(&sha256Pool)
+		defer sha256Pool.Put(sha256Hasher)
+		writers = append(writers, sha256Hasher)
+	}
+	checksumHasher := checksum.newHash()
+	if checksumHasher != nil {
+		writers = append(writers, checksumHasher)
 	}
 
 	multiWriter := io.MultiWriter(writers...)
-	size, err := io.Copy(multiWriter, reader)
+	size, err := copyPooled(multiWriter, reader)
 	if err != nil {
 		tempFile.Close()
-		os.Remove(tempPath)
+		abort()
 		return nil, err
 	}
 
 	if fs.enableFsync {
 		if err := tempFile.Sync(); err != nil {
 			tempFile.Close()
-			os.Remove(tempPath)
+			abort()
 			return nil, err
 		}
 	}
 	if err := tempFile.Close(); err != nil {
-		os.Remove(tempPath)
+		abort()
 		return nil, err
 	}
 
 	// Verify SHA256 BEFORE committing — never overwrite valid data with
 	// mismatched content.
+	var computedSHA string
 	if sha256Hasher != nil {
-		computed := hex.EncodeToString(sha256Sum())
-		if computed != expectedSHA {
-			os.Remove(tempPath)
+		computedSHA = hex.EncodeToString(sha256Hasher.Sum(nil))
+		if expectedSHA != "" && computedSHA != expectedSHA {
+			abort()
 			return nil, ErrBadDigest
 		}
+	} else if expectedSHA == emptySHA256 && size != 0 {
+		abort()
+		return nil, ErrBadDigest
+	}
+	md5Sum := md5Hash.Sum(nil)
+	if input != nil && input.ExpectedMD5 != nil && !bytes.Equal(md5Sum, input.ExpectedMD5) {
+		abort()
+		return nil, ErrBadContentMD5
+	}
+	var checksumAlgorithm, checksumValue string
+	if checksumHasher != nil {
+		if checksumValue, err = checksum.verify(checksumHasher); err != nil {
+			abort()
+			return nil, err
+		}
+		checksumAlgorithm = checksum.Algorithm
 	}
 
-	// Lock only for the directory creation + atomic rename.
-	mu := fs.stripe(objectPath)
-	mu.Lock()
-	dir := filepath.Dir(objectPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		mu.Unlock()
-		os.Remove(tempPath)
-		return nil, err
-	}
-	if err := os.Rename(tempPath, objectPath); err != nil {
-		mu.Unlock()
-		os.Remove(tempPath)
-		return nil, err
-	}
-	if fs.enableFsync {
-		syncParentDir(objectPath)
+	// With dedup the object becomes a link to the content's blob.
+	var blob string
+	if fs.dedup {
+		linkPath, err := fs.storeBlob(stagingDir, tempPath, computedSHA)
+		if err != nil {
+			os.Remove(tempPath)
+			return nil, err
+		}
+		tempPath, blob = linkPath, computedSHA
 	}
-	mu.Unlock()
 
 	// Build metadata from input
-	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(md5Hash.Sum(nil)))
+	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(md5Sum))
 	contentType := "application/octet-stream"
 	var contentEncoding, contentDisposition, cacheControl string
-	var customMeta map[string]string
+	var customMeta, tags map[string]string
 
 	if input != nil {
 		if input.ContentType != "" {
@@ -468,6 +1373,7 @@ func (fs *FilesystemStorage) PutObject(bucket, key string, reader io.Reader, inp
 		contentDisposition = input.ContentDisposition
 		cacheControl = input.CacheControl
 		customMeta = input.CustomMetadata
+		tags = input.Tags
 	}
 
 	metadata := &ObjectMetadata{
@@ -479,9 +1385,54 @@ func (fs *FilesystemStorage) PutObject(bucket, key string, reader io.Reader, inp
 		ContentDisposition: contentDisposition,
 		CacheControl:       cacheControl,
 		CustomMetadata:     customMeta,
+		Tags:               tags,
+		ChecksumAlgorithm:  checksumAlgorithm,
+		Checksum:           checksumValue,
+		Blob:               blob,
+	}
+
+	// Lock only for the directory creation + atomic rename.
+	if mu == nil {
+		mu = fs.lockStripe("PutObject", objectPath)
+	}
+	if err := fs.checkMutable(bucket, key); err != nil {
+		abort()
+		fs.releaseBlob(blob)
+		return nil, err
+	}
+	replaced := fs.blobOf(bucket, key)
+	dir := filepath.Dir(objectPath)
+	if err := fs.mkdirInBucket(bucket, dir); err != nil {
+		mu.Unlock()
+		os.Remove(tempPath)
+		fs.releaseBlob(blob)
+		return nil, err
+	}
+	commit := os.Rename
+	if input != nil && input.IfNoneMatch {
+		commit = createExclusive
+	}
+	if err := commit(tempPath, objectPath); err != nil {
+		mu.Unlock()
+		os.Remove(tempPath)
+		fs.releaseBlob(blob)
+		return nil, err
+	}
+	if fs.enableFsync {
+		syncParentDir(objectPath)
 	}
+	if blob != "" {
+		// Renaming a link over another link to the same blob is a no-op
+		// that leaves the staged link behind.
+		os.Remove(tempPath)
+	}
+	fs.indexAdd(bucket, key)
+	fs.forgetMissing(bucket, key)
+	fs.saveBlobMetadata(bucket, key, metadata)
+	mu.Unlock()
+	fs.releaseBlob(replaced)
 
-	if fs.enableMetadata {
+	if fs.enableMetadata && !fs.dedup {
 		if err := fs.saveMetadata(bucket, key, metadata); err != nil {
 			// Non-fatal: object is saved, metadata is best-effort
 			return metadata, nil
@@ -491,14 +1442,98 @@ func (fs *FilesystemStorage) PutObject(bucket, key string, reader io.Reader, inp
 	return metadata, nil
 }
 
+// createDirectPart locks the stripe of objectPath and opens the object's
+// direct-write part file, replacing any left by an interrupted write. The
+// caller holds the returned lock until the part is renamed or removed.
+func (fs *FilesystemStorage) createDirectPart(bucket, objectPath string) (*sync.Mutex, *os.File, error) {
+	mu := fs.lockStripe("PutObject", objectPath)
+	if err := fs.mkdirInBucket(bucket, filepath.Dir(objectPath)); err != nil {
+		mu.Unlock()
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(objectPath+directWriteSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		mu.Unlock()
+		return nil, nil, err
+	}
+	return mu, f, nil
+}
+
+// RemoveDirectWriteParts deletes the direct-write part files that writes cut
+// short by a crash left next to their objects, and returns how many it
+// removed. Each file is removed under its object's stripe lock, so a
+// direct-write PutObject in progress keeps its part.
+func (fs *FilesystemStorage) RemoveDirectWriteParts() (int, error) {
+	var removed int
+	err := filepath.WalkDir(fs.dataDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if path == fs.dataDir {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if isInternalDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), directWriteSuffix) {
+			return nil
+		}
+		mu := fs.stripe(strings.TrimSuffix(path, directWriteSuffix))
+		mu.Lock()
+		err = os.Remove(path)
+		mu.Unlock()
+		if err == nil {
+			removed++
+		} else if !os.IsNotExist(err) {
+			log.Printf("WARNING: failed to remove direct-write part %s: %v", path, err)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// createExclusive moves the staged file at tempPath to path only if nothing is
+// there yet, and returns ErrPreconditionFailed otherwise. A hard link fails
+// atomically when path exists, like O_CREATE|O_EXCL, so this holds even
+// against writers outside geckos3. Where links are not available it falls
+// back to a check and a rename, which the caller's stripe lock makes atomic
+// against geckos3's own writers.
+func createExclusive(tempPath, path string) error {
+	err := os.Link(tempPath, path)
+	if err == nil {
+		os.Remove(tempPath)
+		return nil
+	}
+	if os.IsExist(err) {
+		return ErrPreconditionFailed
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return ErrPreconditionFailed
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
 func (fs *FilesystemStorage) GetObject(bucket, key string) (io.ReadCloser, *ObjectMetadata, error) {
 	if err := fs.validateObjectPath(bucket, key); err != nil {
 		return nil, nil, err
 	}
 	objectPath := fs.objectPath(bucket, key)
+	if fs.refusesLink(bucket, key) {
+		return nil, nil, &os.PathError{Op: "open", Path: objectPath, Err: os.ErrNotExist}
+	}
+	missing, gen := fs.knownMissing(bucket, key)
+	if missing {
+		return nil, nil, &os.PathError{Op: "open", Path: objectPath, Err: os.ErrNotExist}
+	}
 
 	file, err := os.Open(objectPath)
 	if err != nil {
+		fs.rememberMissing(bucket, key, gen, err)
 		return nil, nil, err
 	}
 
@@ -525,9 +1560,17 @@ func (fs *FilesystemStorage) HeadObject(bucket, key string) (*ObjectMetadata, er
 		return nil, err
 	}
 	objectPath := fs.objectPath(bucket, key)
+	if fs.refusesLink(bucket, key) {
+		return nil, &os.PathError{Op: "stat", Path: objectPath, Err: os.ErrNotExist}
+	}
+	missing, gen := fs.knownMissing(bucket, key)
+	if missing {
+		return nil, &os.PathError{Op: "stat", Path: objectPath, Err: os.ErrNotExist}
+	}
 
 	info, err := os.Stat(objectPath)
 	if err != nil {
+		fs.rememberMissing(bucket, key, gen, err)
 		return nil, err
 	}
 
@@ -538,25 +1581,112 @@ func (fs *FilesystemStorage) HeadObject(bucket, key string) (*ObjectMetadata, er
 			LastModified: info.ModTime(),
 			ETag:         fs.generatePseudoETag(info),
 		}
+	} else if metadata.Size != info.Size() {
+		metadata.Stale = true
+		metadata.FileSize = info.Size()
 	}
 
 	return metadata, nil
 }
 
-func (fs *FilesystemStorage) DeleteObject(bucket, key string) error {
+// PutObjectTagging replaces the tag set of the object at key, keeping its
+// content, ETag and Last-Modified. An empty set removes its tags. Tags live in
+// the metadata sidecar, so this fails with ErrMetadataDisabled without one.
+func (fs *FilesystemStorage) PutObjectTagging(bucket, key string, tags map[string]string) error {
 	if err := fs.validateObjectPath(bucket, key); err != nil {
 		return err
 	}
+	if !fs.enableMetadata {
+		return ErrMetadataDisabled
+	}
+	mu := fs.stripe(fs.objectPath(bucket, key))
+	mu.Lock()
+	defer mu.Unlock()
+	metadata, err := fs.HeadObject(bucket, key)
+	if err != nil {
+		return err
+	}
+	metadata.Tags = nonEmpty(tags)
+	return fs.saveMetadata(bucket, key, metadata)
+}
+
+// GetObjectTagging returns the tag set of the object at key, or nil if it has
+// none.
+func (fs *FilesystemStorage) GetObjectTagging(bucket, key string) (map[string]string, error) {
+	metadata, err := fs.HeadObject(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.Tags, nil
+}
+
+// DeleteObjectTagging removes every tag from the object at key.
+func (fs *FilesystemStorage) DeleteObjectTagging(bucket, key string) error {
+	return fs.PutObjectTagging(bucket, key, nil)
+}
+
+func (fs *FilesystemStorage) DeleteObject(bucket, key string) (*DeleteObjectResult, error) {
+	return fs.deleteObject(bucket, key, "")
+}
+
+// DeleteObjectIfMatch deletes key only if its current ETag is etag (quoted or
+// not; "*" matches any ETag). It returns ErrPreconditionFailed on a mismatch
+// and a not-exist error if the key is missing. The check and the removal
+// happen under the key's stripe lock, so a concurrent geckos3 write cannot
+// slip in between them.
+func (fs *FilesystemStorage) DeleteObjectIfMatch(bucket, key, etag string) (*DeleteObjectResult, error) {
+	return fs.deleteObject(bucket, key, etag)
+}
+
+func (fs *FilesystemStorage) deleteObject(bucket, key, ifMatch string) (*DeleteObjectResult, error) {
+	if err := fs.validateObjectPath(bucket, key); err != nil {
+		return nil, err
+	}
 	objectPath := fs.objectPath(bucket, key)
 	metadataPath := fs.metadataPath(bucket, key)
 
+	mu := fs.stripe(objectPath)
+	mu.Lock()
+	if err := fs.checkMutable(bucket, key); err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	if ifMatch != "" {
+		current, err := fs.HeadObject(bucket, key)
+		if err != nil {
+			mu.Unlock()
+			return nil, err
+		}
+		if ifMatch != "*" && strings.Trim(ifMatch, `"`) != strings.Trim(current.ETag, `"`) {
+			mu.Unlock()
+			return nil, ErrPreconditionFailed
+		}
+	}
+	blob := fs.blobOf(bucket, key)
 	if err := os.Remove(objectPath); err != nil && !os.IsNotExist(err) {
-		return err
+		mu.Unlock()
+		return nil, err
+	}
+	fs.indexRemove(bucket, key)
+	if fs.dedup {
+		// As in saveBlobMetadata: a later write's sidecar must not be
+		// removed in its place.
+		os.Remove(metadataPath)
 	}
+	mu.Unlock()
+
+	if !fs.dedup {
+		os.Remove(metadataPath)
+	}
+	fs.releaseBlob(blob)
 
-	os.Remove(metadataPath)
+	fs.removeEmptyParents(bucket, objectPath)
+	return &DeleteObjectResult{}, nil
+}
 
-	// Clean up empty parent directories up to the bucket root
+// removeEmptyParents removes the now-empty directories above objectPath, up to
+// the bucket root.
+func (fs *FilesystemStorage) removeEmptyParents(bucket, objectPath string) {
 	bucketPath := filepath.Join(fs.dataDir, bucket)
 	dir := filepath.Dir(objectPath)
 	for dir != bucketPath && dir != "." {
@@ -567,11 +1697,92 @@ func (fs *FilesystemStorage) DeleteObject(bucket, key string) error {
 		os.Remove(dir)
 		dir = filepath.Dir(dir)
 	}
+}
 
-	return nil
+// RenameObject moves srcKey to dstKey within bucket with os.Rename, so the
+// data is never copied and readers see either the old or the new key, never a
+// partial object. The metadata sidecar moves with it; a destination sidecar
+// left over from an overwritten object is removed. Unless overwrite is set,
+// an existing destination fails with ErrObjectExists. A missing source
+// returns an error satisfying os.IsNotExist.
+func (fs *FilesystemStorage) RenameObject(bucket, srcKey, dstKey string, overwrite bool) (*ObjectMetadata, error) {
+	if err := fs.validateObjectPath(bucket, srcKey); err != nil {
+		return nil, err
+	}
+	if err := fs.validateObjectPath(bucket, dstKey); err != nil {
+		return nil, err
+	}
+	srcPath := fs.objectPath(bucket, srcKey)
+	dstPath := fs.objectPath(bucket, dstKey)
+	if srcPath == dstPath {
+		return fs.HeadObject(bucket, srcKey)
+	}
+	srcMeta := fs.metadataPath(bucket, srcKey)
+	dstMeta := fs.metadataPath(bucket, dstKey)
+
+	unlock := fs.lockPair(srcPath, dstPath)
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	if info.IsDir() {
+		unlock()
+		return nil, &os.PathError{Op: "rename", Path: srcPath, Err: os.ErrNotExist}
+	}
+	if _, err := os.Stat(dstPath); err == nil && !overwrite {
+		unlock()
+		return nil, ErrObjectExists
+	}
+	if err := fs.checkMutable(bucket, srcKey); err != nil {
+		unlock()
+		return nil, err
+	}
+	if err := fs.checkMutable(bucket, dstKey); err != nil {
+		unlock()
+		return nil, err
+	}
+	replaced := fs.blobOf(bucket, dstKey)
+	if err := fs.mkdirInBucket(bucket, filepath.Dir(dstPath)); err != nil {
+		unlock()
+		return nil, err
+	}
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		unlock()
+		return nil, err
+	}
+	if replaced != "" {
+		// With both keys linked to the same blob the rename was a no-op.
+		os.Remove(srcPath)
+	}
+	if err := os.Rename(srcMeta, dstMeta); err != nil {
+		if !os.IsNotExist(err) {
+			// Put the data back so the object keeps its metadata.
+			os.Rename(dstPath, srcPath)
+			unlock()
+			return nil, err
+		}
+		os.Remove(dstMeta)
+	}
+	if fs.enableFsync {
+		syncParentDir(dstPath)
+		syncParentDir(srcPath)
+	}
+	fs.indexRemove(bucket, srcKey)
+	fs.indexAdd(bucket, dstKey)
+	fs.forgetMissing(bucket, dstKey)
+	unlock()
+	fs.releaseBlob(replaced)
+
+	fs.removeEmptyParents(bucket, srcPath)
+	return fs.HeadObject(bucket, dstKey)
 }
 
-func (fs *FilesystemStorage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, overrideMeta *PutObjectInput) (*ObjectMetadata, error) {
+// CopyObject copies srcKey to dstKey. A nil overrideMeta keeps the source's
+// metadata (COPY directive); otherwise it replaces it. Likewise a nil
+// overrideTags keeps the source's tags, while a non-nil map, even an empty
+// one, replaces them.
+func (fs *FilesystemStorage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, overrideMeta *PutObjectInput, overrideTags map[string]string) (*ObjectMetadata, error) {
 	if err := fs.validateObjectPath(srcBucket, srcKey); err != nil {
 		return nil, err
 	}
@@ -579,14 +1790,49 @@ func (fs *FilesystemStorage) CopyObject(srcBucket, srcKey, dstBucket, dstKey str
 		return nil, err
 	}
 
+	// The content of a copy is always identical to the source, for both the
+	// COPY and REPLACE directives. When the source ETag is stored, clone the
+	// data file server-side and carry the ETag over instead of re-hashing.
+	// Multipart ETags ("<md5>-N") are carried over too, unless
+	// copyRehashMPU asks for a single-part ETag, in which case the clone
+	// hashes the data as it copies.
+	if stored, err := fs.loadMetadata(srcBucket, srcKey); err == nil && stored.ETag != "" {
+		meta := *stored
+		if overrideMeta != nil {
+			meta = ObjectMetadata{
+				ETag:               stored.ETag,
+				ContentType:        overrideMeta.ContentType,
+				ContentEncoding:    overrideMeta.ContentEncoding,
+				ContentDisposition: overrideMeta.ContentDisposition,
+				CacheControl:       overrideMeta.CacheControl,
+				CustomMetadata:     overrideMeta.CustomMetadata,
+				Tags:               stored.Tags,
+			}
+		}
+		if overrideTags != nil {
+			meta.Tags = nonEmpty(overrideTags)
+		}
+		if fs.copyRehashMPU && isMultipartETag(meta.ETag) {
+			meta.ETag = ""
+		}
+		return fs.cloneObject(srcBucket, srcKey, dstBucket, dstKey, &meta)
+	}
+
 	reader, srcMeta, err := fs.GetObject(srcBucket, srcKey)
 	if err != nil {
 		return nil, fmt.Errorf("source object not found")
 	}
 	defer reader.Close()
 
-	// If overrideMeta is provided (REPLACE directive), use it instead of source metadata.
+	tags := srcMeta.Tags
+	if overrideTags != nil {
+		tags = nonEmpty(overrideTags)
+	}
+
+	// If overrideMeta is provided (REPLACE directive), use it instead of source
+	// metadata. Tags follow their own directive.
 	if overrideMeta != nil {
+		overrideMeta.Tags = tags
 		if overrideMeta.ContentType == "" {
 			overrideMeta.ContentType = "application/octet-stream"
 		}
@@ -600,108 +1846,504 @@ func (fs *FilesystemStorage) CopyObject(srcBucket, srcKey, dstBucket, dstKey str
 		ContentDisposition: srcMeta.ContentDisposition,
 		CacheControl:       srcMeta.CacheControl,
 		CustomMetadata:     srcMeta.CustomMetadata,
+		Tags:               tags,
+	}
+	if input.ContentType == "" {
+		input.ContentType = "application/octet-stream"
+	}
+	return fs.PutObject(dstBucket, dstKey, reader, input)
+}
+
+// nonEmpty returns m, or nil if m is empty, so empty maps are omitted from
+// stored metadata.
+func nonEmpty(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// cloneObject copies the source data file to the destination and writes meta
+// (with a fresh LastModified) as the destination metadata. If meta.ETag is set
+// the data is not hashed: file-to-file io.Copy lets the kernel do the copy
+// (copy_file_range, which reflinks on XFS/Btrfs) and transparently falls back
+// to a userspace copy across filesystems or where the syscall is unsupported.
+// An empty meta.ETag is filled with the MD5 of the copied data.
+func (fs *FilesystemStorage) cloneObject(srcBucket, srcKey, dstBucket, dstKey string, meta *ObjectMetadata) (*ObjectMetadata, error) {
+	if fs.refusesLink(srcBucket, srcKey) {
+		return nil, fmt.Errorf("source object not found")
+	}
+	src, err := os.Open(fs.objectPath(srcBucket, srcKey))
+	if err != nil {
+		return nil, fmt.Errorf("source object not found")
+	}
+	defer src.Close()
+
+	stagingDir := filepath.Join(fs.dataDir, dstBucket, tmpStagingDir)
+	if err := fs.mkdirInBucket(dstBucket, stagingDir); err != nil {
+		return nil, err
+	}
+
+	// A copy of a deduplicated object is one more link to its blob. The
+	// content is identical, so the stored ETag still holds.
+	if meta.Blob != "" && fs.dedup && meta.ETag != "" {
+		info, err := src.Stat()
+		if err != nil {
+			return nil, err
+		}
+		linkPath, err := fs.linkBlob(stagingDir, meta.Blob)
+		if err == nil {
+			return fs.commitClone(dstBucket, dstKey, linkPath, info.Size(), nil, meta)
+		}
+		// The blob is gone (e.g. removed by hand): fall back to a copy.
+	}
+	meta.Blob = ""
+
+	tempFile, err := os.CreateTemp(stagingDir, ".copy-*")
+	if err != nil {
+		return nil, err
+	}
+	tempPath := tempFile.Name()
+
+	var size int64
+	var md5Hash hash.Hash
+	if meta.ETag == "" {
+		md5Hash = getHasher(&md5Pool)
+		defer md5Pool.Put(md5Hash)
+		size, err = copyPooled(io.MultiWriter(tempFile, md5Hash), src)
+	} else {
+		size, err = io.Copy(tempFile, src)
+	}
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return nil, err
+	}
+	if fs.enableFsync {
+		if err := tempFile.Sync(); err != nil {
+			tempFile.Close()
+			os.Remove(tempPath)
+			return nil, err
+		}
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+	return fs.commitClone(dstBucket, dstKey, tempPath, size, md5Hash, meta)
+}
+
+// commitClone moves the staged copy at tempPath into place as dstKey and
+// writes its metadata. A non-nil md5Hash holds the digest of the copied data,
+// which becomes the ETag.
+func (fs *FilesystemStorage) commitClone(dstBucket, dstKey, tempPath string, size int64, md5Hash hash.Hash, meta *ObjectMetadata) (*ObjectMetadata, error) {
+	objectPath := fs.objectPath(dstBucket, dstKey)
+
+	metadata := *meta
+	metadata.Size = size
+	metadata.LastModified = time.Now().UTC()
+	if md5Hash != nil {
+		metadata.ETag = fmt.Sprintf("\"%s\"", hex.EncodeToString(md5Hash.Sum(nil)))
+	}
+	if metadata.ContentType == "" {
+		metadata.ContentType = "application/octet-stream"
+	}
+
+	// Lock only for the directory creation + atomic rename.
+	mu := fs.stripe(objectPath)
+	mu.Lock()
+	if err := fs.checkMutable(dstBucket, dstKey); err != nil {
+		mu.Unlock()
+		os.Remove(tempPath)
+		fs.releaseBlob(meta.Blob)
+		return nil, err
+	}
+	replaced := fs.blobOf(dstBucket, dstKey)
+	if err := fs.mkdirInBucket(dstBucket, filepath.Dir(objectPath)); err != nil {
+		mu.Unlock()
+		os.Remove(tempPath)
+		fs.releaseBlob(meta.Blob)
+		return nil, err
+	}
+	if err := os.Rename(tempPath, objectPath); err != nil {
+		mu.Unlock()
+		os.Remove(tempPath)
+		fs.releaseBlob(meta.Blob)
+		return nil, err
+	}
+	if fs.enableFsync {
+		syncParentDir(objectPath)
+	}
+	if meta.Blob != "" {
+		// See PutObject: the rename may have left the staged link behind.
+		os.Remove(tempPath)
+	}
+	fs.indexAdd(dstBucket, dstKey)
+	fs.forgetMissing(dstBucket, dstKey)
+	fs.saveBlobMetadata(dstBucket, dstKey, &metadata)
+	mu.Unlock()
+	fs.releaseBlob(replaced)
+
+	if fs.enableMetadata && !fs.dedup {
+		// Non-fatal: object is saved, metadata is best-effort
+		fs.saveMetadata(dstBucket, dstKey, &metadata)
+	}
+
+	return &metadata, nil
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Multipart Upload Operations
+// ═══════════════════════════════════════════════════════════════════════════════
+
+// CreateMultipartUpload generates a unique upload ID and creates a staging directory.
+func (fs *FilesystemStorage) CreateMultipartUpload(bucket, key, contentType string) (string, error) {
+	if err := fs.validateObjectPath(bucket, key); err != nil {
+		return "", err
+	}
+	if !fs.BucketExists(bucket) {
+		return "", fmt.Errorf("bucket does not exist")
+	}
+
+	uploadID := generateUploadID()
+	stagingDir := fs.multipartStagingPath(bucket, uploadID)
+
+	if err := fs.mkdirInBucket(bucket, stagingDir); err != nil {
+		return "", fmt.Errorf("failed to create multipart staging: %w", err)
+	}
+
+	// Persist the target key and content type in a manifest
+	manifest := map[string]string{
+		"key":         key,
+		"contentType": contentType,
+		"initiated":   time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if fs.packedParts {
+		manifest["layout"] = "packed"
+	}
+	data, _ := json.Marshal(manifest)
+	if err := os.WriteFile(filepath.Join(stagingDir, "manifest.json"), data, 0644); err != nil {
+		os.RemoveAll(stagingDir)
+		return "", err
+	}
+
+	// A packed upload is recognized by its index file, so it is created
+	// up front together with the empty parts file.
+	if fs.packedParts {
+		if err := os.WriteFile(filepath.Join(stagingDir, packedPartsFile), nil, 0644); err != nil {
+			os.RemoveAll(stagingDir)
+			return "", err
+		}
+		if err := savePackedIndex(stagingDir, map[int]packedPart{}); err != nil {
+			os.RemoveAll(stagingDir)
+			return "", err
+		}
+	}
+
+	return uploadID, nil
+}
+
+// packedPartsFile and packedIndexFile make up the staging of a packed
+// multipart upload: the data of every part appended to one file, and a JSON
+// index of where each part lives in it.
+const (
+	packedPartsFile = "parts.bin"
+	packedIndexFile = "parts.json"
+)
+
+// packedPart locates one uploaded part inside packedPartsFile.
+type packedPart struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	MD5    string `json:"md5"` // hex digest of the part's data
+}
+
+// isPackedUpload reports whether the upload staged in stagingDir uses the
+// packed layout.
+func isPackedUpload(stagingDir string) bool {
+	_, err := os.Stat(filepath.Join(stagingDir, packedIndexFile))
+	return err == nil
+}
+
+func loadPackedIndex(stagingDir string) (map[int]packedPart, error) {
+	data, err := os.ReadFile(filepath.Join(stagingDir, packedIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[int]packedPart)
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func savePackedIndex(stagingDir string, index map[int]packedPart) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(stagingDir, ".index-tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(stagingDir, packedIndexFile))
+}
+
+// appendPackedPart appends the part staged at tempPath to the upload's parts
+// file and records it in the index, replacing any earlier upload of the same
+// part number. The bytes of a replaced part stay in the file as dead space
+// until the upload completes or is aborted. If the process dies between the
+// append and the index update, the appended bytes are likewise unreferenced.
+func (fs *FilesystemStorage) appendPackedPart(stagingDir string, partNumber int, tempPath string, digest []byte) error {
+	defer os.Remove(tempPath)
+
+	packPath := filepath.Join(stagingDir, packedPartsFile)
+	mu := fs.stripe(packPath)
+	mu.Lock()
+	defer mu.Unlock()
+
+	index, err := loadPackedIndex(stagingDir)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(tempPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	pack, err := os.OpenFile(packPath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
 	}
-	if input.ContentType == "" {
-		input.ContentType = "application/octet-stream"
+	offset, err := pack.Seek(0, io.SeekEnd)
+	if err != nil {
+		pack.Close()
+		return err
 	}
-	return fs.PutObject(dstBucket, dstKey, reader, input)
-}
-
-// ═══════════════════════════════════════════════════════════════════════════════
-// Multipart Upload Operations
-// ═══════════════════════════════════════════════════════════════════════════════
-
-// CreateMultipartUpload generates a unique upload ID and creates a staging directory.
-func (fs *FilesystemStorage) CreateMultipartUpload(bucket, key, contentType string) (string, error) {
-	if err := fs.validateObjectPath(bucket, key); err != nil {
-		return "", err
+	// File-to-file, so the kernel can use copy_file_range.
+	n, err := io.Copy(pack, src)
+	if err == nil && fs.enableFsync {
+		err = pack.Sync()
 	}
-	if !fs.BucketExists(bucket) {
-		return "", fmt.Errorf("bucket does not exist")
+	if err != nil {
+		pack.Truncate(offset)
+		pack.Close()
+		return err
+	}
+	if err := pack.Close(); err != nil {
+		return err
 	}
 
-	uploadID := generateUploadID()
-	stagingDir := fs.multipartStagingPath(bucket, uploadID)
+	index[partNumber] = packedPart{Offset: offset, Size: n, MD5: hex.EncodeToString(digest)}
+	return savePackedIndex(stagingDir, index)
+}
 
-	if err := os.MkdirAll(stagingDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create multipart staging: %w", err)
+// concatPackedParts copies the listed parts of a packed upload, in order, to
+// dst and returns their concatenated raw MD5 digests and total size.
+func concatPackedParts(stagingDir string, parts []CompletedPart, dst *os.File) ([]byte, int64, error) {
+	index, err := loadPackedIndex(stagingDir)
+	if err != nil {
+		return nil, 0, err
 	}
-
-	// Persist the target key and content type in a manifest
-	manifest := map[string]string{
-		"key":         key,
-		"contentType": contentType,
+	pack, err := os.Open(filepath.Join(stagingDir, packedPartsFile))
+	if err != nil {
+		return nil, 0, err
 	}
-	data, _ := json.Marshal(manifest)
-	if err := os.WriteFile(filepath.Join(stagingDir, "manifest.json"), data, 0644); err != nil {
-		os.RemoveAll(stagingDir)
-		return "", err
+	defer pack.Close()
+
+	partDigests := make([]byte, 0, len(parts)*md5.Size)
+	var totalSize int64
+	for i, part := range parts {
+		p, ok := index[part.PartNumber]
+		digest, decodeErr := hex.DecodeString(p.MD5)
+		if !ok || decodeErr != nil || len(digest) != md5.Size || !partETagMatches(part.ETag, digest) {
+			return nil, 0, fmt.Errorf("%w: part %d, ETag %s", ErrInvalidPart, part.PartNumber, part.ETag)
+		}
+		if i < len(parts)-1 && p.Size < minPartSize {
+			return nil, 0, fmt.Errorf("%w: part %d is %d bytes", ErrEntityTooSmall, part.PartNumber, p.Size)
+		}
+		if _, err := pack.Seek(p.Offset, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		// A LimitedReader over an *os.File still allows copy_file_range.
+		n, err := io.Copy(dst, io.LimitReader(pack, p.Size))
+		if err == nil && n != p.Size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to copy part %d: %w", part.PartNumber, err)
+		}
+		partDigests = append(partDigests, digest...)
+		totalSize += n
 	}
+	return partDigests, totalSize, nil
+}
 
-	return uploadID, nil
+// partETagMatches reports whether etag, as listed by the client in
+// CompleteMultipartUpload, is the ETag of a part with MD5 digest. Quotes are
+// optional, as S3 accepts the ETag with or without them.
+func partETagMatches(etag string, digest []byte) bool {
+	return strings.EqualFold(strings.Trim(etag, `"`), hex.EncodeToString(digest))
+}
+
+// checkPartOrder returns ErrInvalidPartOrder unless the part numbers listed
+// for CompleteMultipartUpload are strictly ascending.
+func checkPartOrder(parts []CompletedPart) error {
+	for i := 1; i < len(parts); i++ {
+		if parts[i].PartNumber <= parts[i-1].PartNumber {
+			return fmt.Errorf("%w: part %d follows part %d", ErrInvalidPartOrder, parts[i].PartNumber, parts[i-1].PartNumber)
+		}
+	}
+	return nil
 }
 
 // UploadPart saves a single part to the staging directory and returns its ETag.
 func (fs *FilesystemStorage) UploadPart(bucket, key, uploadID string, partNumber int, reader io.Reader, expectedSHA256 string) (string, error) {
+	etag, _, err := fs.UploadPartWithChecksum(bucket, key, uploadID, partNumber, reader, expectedSHA256, nil)
+	return etag, err
+}
+
+// UploadPartWithChecksum is UploadPart that also computes, and verifies if
+// checksum.Expected is set, an x-amz-checksum digest of the part. It returns
+// the digest in base64, or "" if checksum is nil. Part checksums are not
+// stored.
+func (fs *FilesystemStorage) UploadPartWithChecksum(bucket, key, uploadID string, partNumber int, reader io.Reader, expectedSHA256 string, checksum *ChecksumInput) (string, string, error) {
 	stagingDir := fs.multipartStagingPath(bucket, uploadID)
 	if _, err := os.Stat(stagingDir); os.IsNotExist(err) {
-		return "", fmt.Errorf("upload ID not found")
+		return "", "", fmt.Errorf("upload ID not found")
 	}
 
 	partPath := filepath.Join(stagingDir, fmt.Sprintf("part-%05d.tmp", partNumber))
 
 	tempFile, err := os.CreateTemp(stagingDir, ".part-tmp-*")
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	tempPath := tempFile.Name()
 
-	md5Hash := md5.New()
+	md5Hash := getHasher(&md5Pool)
+	defer md5Pool.Put(md5Hash)
 	writers := []io.Writer{tempFile, md5Hash}
 
-	var sha256Sum func() []byte
+	var sha256Hasher hash.Hash
 	if expectedSHA256 != "" {
-		h := sha256.New()
-		sha256Sum = func() []byte { return h.Sum(nil) }
-		writers = append(writers, h)
+		sha256Hasher = getHasher(&sha256Pool)
+		defer sha256Pool.Put(sha256Hasher)
+		writers = append(writers, sha256Hasher)
+	}
+	checksumHasher := checksum.newHash()
+	if checksumHasher != nil {
+		writers = append(writers, checksumHasher)
 	}
 
 	multiWriter := io.MultiWriter(writers...)
 
-	if _, err := io.Copy(multiWriter, reader); err != nil {
+	if _, err := copyPooled(multiWriter, reader); err != nil {
 		tempFile.Close()
 		os.Remove(tempPath)
-		return "", err
+		return "", "", err
 	}
 
 	if fs.enableFsync {
 		if err := tempFile.Sync(); err != nil {
 			tempFile.Close()
 			os.Remove(tempPath)
-			return "", err
+			return "", "", err
 		}
 	}
 	if err := tempFile.Close(); err != nil {
 		os.Remove(tempPath)
-		return "", err
+		return "", "", err
 	}
 
 	// Verify SHA256 before committing the part.
-	if sha256Sum != nil {
-		computed := hex.EncodeToString(sha256Sum())
+	if sha256Hasher != nil {
+		computed := hex.EncodeToString(sha256Hasher.Sum(nil))
 		if computed != expectedSHA256 {
 			os.Remove(tempPath)
-			return "", ErrBadDigest
+			return "", "", ErrBadDigest
+		}
+	}
+	var checksumValue string
+	if checksumHasher != nil {
+		var err error
+		if checksumValue, err = checksum.verify(checksumHasher); err != nil {
+			os.Remove(tempPath)
+			return "", "", err
+		}
+	}
+
+	digest := md5Hash.Sum(nil)
+	if isPackedUpload(stagingDir) {
+		if err := fs.appendPackedPart(stagingDir, partNumber, tempPath, digest); err != nil {
+			return "", "", err
 		}
+		return fmt.Sprintf("\"%s\"", hex.EncodeToString(digest)), checksumValue, nil
+	}
+
+	// Record the part's raw MD5 next to it so CompleteMultipartUpload can
+	// build the composite ETag without re-reading the data. The digest file
+	// is staged and both renames happen under the stripe lock, so a part and
+	// its digest always come from the same upload.
+	digestTemp, err := os.CreateTemp(stagingDir, ".md5-tmp-*")
+	if err != nil {
+		os.Remove(tempPath)
+		return "", "", err
+	}
+	digestTempPath := digestTemp.Name()
+	_, err = digestTemp.Write(digest)
+	if closeErr := digestTemp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		os.Remove(digestTempPath)
+		return "", "", err
 	}
 
+	mu := fs.stripe(partPath)
+	mu.Lock()
+	defer mu.Unlock()
 	if err := os.Rename(tempPath, partPath); err != nil {
 		os.Remove(tempPath)
-		return "", err
+		os.Remove(digestTempPath)
+		return "", "", err
 	}
+	if err := os.Rename(digestTempPath, partMD5Path(partPath)); err != nil {
+		os.Remove(digestTempPath)
+		return "", "", err
+	}
+
+	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(digest))
+	return etag, checksumValue, nil
+}
+
+// partMD5Path returns the path of the file holding a staged part's raw MD5.
+func partMD5Path(partPath string) string {
+	return strings.TrimSuffix(partPath, ".tmp") + ".md5"
+}
+
+// isMultipartETag reports whether etag has the "-N" part-count suffix of a
+// multipart upload ETag.
+func isMultipartETag(etag string) bool {
+	return strings.Contains(strings.Trim(etag, "\""), "-")
+}
 
-	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(md5Hash.Sum(nil)))
-	return etag, nil
+// multipartETag builds the S3 multipart ETag from the concatenated binary MD5
+// digests of the parts: hex(MD5(digests)) + "-" + number of parts.
+func multipartETag(partDigests []byte, numParts int) string {
+	sum := md5.Sum(partDigests)
+	return fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(sum[:]), numParts)
 }
 
 // CompleteMultipartUpload concatenates parts in order, writes the final object, and cleans up.
@@ -714,13 +2356,16 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(bucket, key, uploadID strin
 	if _, err := os.Stat(stagingDir); os.IsNotExist(err) {
 		return nil, fmt.Errorf("upload ID not found")
 	}
+	if err := checkPartOrder(parts); err != nil {
+		return nil, err
+	}
 
 	objectPath := fs.objectPath(bucket, key)
 	bucketPath := filepath.Join(fs.dataDir, bucket)
 
 	// Stage temp file in the dedicated hidden directory.
 	tmpDir := filepath.Join(bucketPath, tmpStagingDir)
-	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+	if err := fs.mkdirInBucket(bucket, tmpDir); err != nil {
 		return nil, err
 	}
 
@@ -732,26 +2377,66 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(bucket, key, uploadID strin
 	}
 	tempPath := tempFile.Name()
 
-	hash := md5.New()
-	multiWriter := io.MultiWriter(tempFile, hash)
+	// The S3 multipart ETag is the MD5 of the concatenated binary MD5s of
+	// each part. Parts uploaded without a digest record are hashed while
+	// concatenating.
+	md5Hash := getHasher(&md5Pool)
+	defer md5Pool.Put(md5Hash)
+	multiWriter := io.MultiWriter(tempFile, md5Hash)
+	partDigests := make([]byte, 0, len(parts)*md5.Size)
 	var totalSize int64
 
-	for _, part := range parts {
-		partPath := filepath.Join(stagingDir, fmt.Sprintf("part-%05d.tmp", part.PartNumber))
-		partFile, err := os.Open(partPath)
+	bufp := concatBufPool.Get().(*[]byte)
+	defer concatBufPool.Put(bufp)
+
+	if isPackedUpload(stagingDir) {
+		partDigests, totalSize, err = concatPackedParts(stagingDir, parts, tempFile)
 		if err != nil {
 			tempFile.Close()
 			os.Remove(tempPath)
-			return nil, fmt.Errorf("part %d not found", part.PartNumber)
+			return nil, err
 		}
-		n, err := io.Copy(multiWriter, partFile)
-		partFile.Close()
-		if err != nil {
-			tempFile.Close()
-			os.Remove(tempPath)
-			return nil, fmt.Errorf("failed to copy part %d: %w", part.PartNumber, err)
+	} else {
+		for i, part := range parts {
+			partPath := filepath.Join(stagingDir, fmt.Sprintf("part-%05d.tmp", part.PartNumber))
+			partFile, err := os.Open(partPath)
+			if err != nil {
+				tempFile.Close()
+				os.Remove(tempPath)
+				return nil, fmt.Errorf("%w: part %d, ETag %s", ErrInvalidPart, part.PartNumber, part.ETag)
+			}
+			// Every part but the last must meet the S3 minimum.
+			if info, err := partFile.Stat(); err == nil && i < len(parts)-1 && info.Size() < minPartSize {
+				partFile.Close()
+				tempFile.Close()
+				os.Remove(tempPath)
+				return nil, fmt.Errorf("%w: part %d is %d bytes", ErrEntityTooSmall, part.PartNumber, info.Size())
+			}
+			// With a recorded digest the part is copied file-to-file, which lets
+			// the kernel use copy_file_range; otherwise hash it on the way.
+			var n int64
+			digest, digestErr := os.ReadFile(partMD5Path(partPath))
+			if digestErr == nil && len(digest) == md5.Size {
+				n, err = io.Copy(tempFile, partFile)
+			} else {
+				md5Hash.Reset()
+				n, err = io.CopyBuffer(multiWriter, readerOnly{partFile}, *bufp)
+				digest = md5Hash.Sum(nil)
+			}
+			partFile.Close()
+			if err != nil {
+				tempFile.Close()
+				os.Remove(tempPath)
+				return nil, fmt.Errorf("failed to copy part %d: %w", part.PartNumber, err)
+			}
+			if !partETagMatches(part.ETag, digest) {
+				tempFile.Close()
+				os.Remove(tempPath)
+				return nil, fmt.Errorf("%w: part %d, ETag %s", ErrInvalidPart, part.PartNumber, part.ETag)
+			}
+			partDigests = append(partDigests, digest...)
+			totalSize += n
 		}
-		totalSize += n
 	}
 
 	if fs.enableFsync {
@@ -766,11 +2451,31 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(bucket, key, uploadID strin
 		return nil, err
 	}
 
+	etag := multipartETag(partDigests, len(parts))
+
+	// Read manifest for content type
+	contentType := "application/octet-stream"
+	if ct := readUploadManifest(stagingDir)["contentType"]; ct != "" {
+		contentType = ct
+	}
+
+	metadata := &ObjectMetadata{
+		Size:         totalSize,
+		LastModified: time.Now().UTC(),
+		ETag:         etag,
+		ContentType:  contentType,
+	}
+
 	// Lock only for directory creation + atomic rename.
-	mu := fs.stripe(objectPath)
-	mu.Lock()
+	mu := fs.lockStripe("CompleteMultipartUpload", objectPath)
+	if err := fs.checkMutable(bucket, key); err != nil {
+		mu.Unlock()
+		os.Remove(tempPath)
+		return nil, err
+	}
+	replaced := fs.blobOf(bucket, key)
 	dir := filepath.Dir(objectPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := fs.mkdirInBucket(bucket, dir); err != nil {
 		mu.Unlock()
 		os.Remove(tempPath)
 		return nil, err
@@ -783,30 +2488,13 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(bucket, key, uploadID strin
 	if fs.enableFsync {
 		syncParentDir(objectPath)
 	}
+	fs.indexAdd(bucket, key)
+	fs.forgetMissing(bucket, key)
+	fs.saveBlobMetadata(bucket, key, metadata)
 	mu.Unlock()
+	fs.releaseBlob(replaced)
 
-	// Build S3-style multipart ETag: MD5-of-data + "-N"
-	etag := fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(hash.Sum(nil)), len(parts))
-
-	// Read manifest for content type
-	contentType := "application/octet-stream"
-	if manifestData, err := os.ReadFile(filepath.Join(stagingDir, "manifest.json")); err == nil {
-		var manifest map[string]string
-		if json.Unmarshal(manifestData, &manifest) == nil {
-			if ct := manifest["contentType"]; ct != "" {
-				contentType = ct
-			}
-		}
-	}
-
-	metadata := &ObjectMetadata{
-		Size:         totalSize,
-		LastModified: time.Now().UTC(),
-		ETag:         etag,
-		ContentType:  contentType,
-	}
-
-	if fs.enableMetadata {
+	if fs.enableMetadata && !fs.dedup {
 		fs.saveMetadata(bucket, key, metadata)
 	}
 	os.RemoveAll(stagingDir)
@@ -823,12 +2511,222 @@ func (fs *FilesystemStorage) AbortMultipartUpload(bucket, key, uploadID string)
 	return os.RemoveAll(stagingDir)
 }
 
+// AbortMultipartUploadsForKey aborts every in-progress upload in bucket whose
+// manifest targets key and returns their upload IDs in sorted order. On error
+// the uploads aborted so far are still returned.
+func (fs *FilesystemStorage) AbortMultipartUploadsForKey(bucket, key string) ([]string, error) {
+	if err := fs.validateObjectPath(bucket, key); err != nil {
+		return nil, err
+	}
+	if !fs.BucketExists(bucket) {
+		return nil, fmt.Errorf("bucket does not exist")
+	}
+
+	mpDir := filepath.Join(fs.dataDir, bucket, multipartStagingDir)
+	entries, err := os.ReadDir(mpDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var aborted []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		stagingDir := filepath.Join(mpDir, e.Name())
+		if readUploadManifest(stagingDir)["key"] != key {
+			continue
+		}
+		if err := os.RemoveAll(stagingDir); err != nil {
+			return aborted, err
+		}
+		aborted = append(aborted, e.Name())
+	}
+	return aborted, nil
+}
+
+// MultipartUploadInfo describes an in-progress multipart upload.
+type MultipartUploadInfo struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// ListMultipartUploads returns the in-progress uploads in bucket whose key
+// starts with prefix, sorted by key and then upload ID, and whether more
+// remain after the first maxUploads. Listing resumes after keyMarker; with
+// uploadIDMarker as well, it resumes after that upload of keyMarker instead.
+// Staging directories without a readable manifest are skipped.
+func (fs *FilesystemStorage) ListMultipartUploads(bucket, prefix, keyMarker, uploadIDMarker string, maxUploads int) ([]MultipartUploadInfo, bool, error) {
+	if !fs.BucketExists(bucket) {
+		return nil, false, fmt.Errorf("bucket does not exist")
+	}
+
+	mpDir := filepath.Join(fs.dataDir, bucket, multipartStagingDir)
+	entries, err := os.ReadDir(mpDir)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var uploads []MultipartUploadInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		stagingDir := filepath.Join(mpDir, e.Name())
+		manifest := readUploadManifest(stagingDir)
+		key, ok := manifest["key"]
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if keyMarker != "" && (key < keyMarker || key == keyMarker && (uploadIDMarker == "" || e.Name() <= uploadIDMarker)) {
+			continue
+		}
+		initiated, err := time.Parse(time.RFC3339Nano, manifest["initiated"])
+		if err != nil {
+			// Uploads created before the manifest recorded it.
+			if info, err := os.Stat(filepath.Join(stagingDir, "manifest.json")); err == nil {
+				initiated = info.ModTime().UTC()
+			}
+		}
+		uploads = append(uploads, MultipartUploadInfo{Key: key, UploadID: e.Name(), Initiated: initiated})
+	}
+
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].UploadID < uploads[j].UploadID
+	})
+	if len(uploads) > maxUploads {
+		return uploads[:maxUploads], true, nil
+	}
+	return uploads, false, nil
+}
+
+// PartInfo describes an uploaded part of an in-progress multipart upload.
+type PartInfo struct {
+	PartNumber   int
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// ListParts returns the parts uploaded so far to uploadID with part numbers
+// above partNumberMarker, in part number order, and whether more remain after
+// the first maxParts. An upload that does not exist, or targets another key,
+// returns an "upload ID not found" error. The parts of a packed upload all
+// report the time the upload's part index was last written.
+func (fs *FilesystemStorage) ListParts(bucket, key, uploadID string, partNumberMarker, maxParts int) ([]PartInfo, bool, error) {
+	if err := fs.validateObjectPath(bucket, key); err != nil {
+		return nil, false, err
+	}
+	stagingDir := fs.multipartStagingPath(bucket, uploadID)
+	if _, err := os.Stat(stagingDir); err != nil {
+		return nil, false, fmt.Errorf("upload ID not found")
+	}
+	if manifest := readUploadManifest(stagingDir); manifest != nil && manifest["key"] != key {
+		return nil, false, fmt.Errorf("upload ID not found")
+	}
+
+	var parts []PartInfo
+	if isPackedUpload(stagingDir) {
+		index, err := loadPackedIndex(stagingDir)
+		if err != nil {
+			return nil, false, err
+		}
+		var modTime time.Time
+		if info, err := os.Stat(filepath.Join(stagingDir, packedIndexFile)); err == nil {
+			modTime = info.ModTime().UTC()
+		}
+		for n, part := range index {
+			if n > partNumberMarker {
+				parts = append(parts, PartInfo{PartNumber: n, Size: part.Size, ETag: fmt.Sprintf("\"%s\"", part.MD5), LastModified: modTime})
+			}
+		}
+	} else {
+		entries, err := os.ReadDir(stagingDir)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, e := range entries {
+			var n int
+			if _, err := fmt.Sscanf(e.Name(), "part-%05d.tmp", &n); err != nil || n <= partNumberMarker {
+				continue
+			}
+			if part, ok := fs.stagedPartInfo(filepath.Join(stagingDir, e.Name())); ok {
+				part.PartNumber = n
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	if len(parts) > maxParts {
+		return parts[:maxParts], true, nil
+	}
+	return parts, false, nil
+}
+
+// stagedPartInfo reads the size, time and ETag of a part staged as its own
+// file, under the part's stripe lock so the data and digest files come from
+// the same UploadPart. A part without a digest record is hashed.
+func (fs *FilesystemStorage) stagedPartInfo(partPath string) (PartInfo, bool) {
+	mu := fs.stripe(partPath)
+	mu.Lock()
+	defer mu.Unlock()
+
+	info, err := os.Stat(partPath)
+	if err != nil {
+		return PartInfo{}, false
+	}
+	digest, err := os.ReadFile(partMD5Path(partPath))
+	if err != nil || len(digest) != md5.Size {
+		f, err := os.Open(partPath)
+		if err != nil {
+			return PartInfo{}, false
+		}
+		h := md5.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return PartInfo{}, false
+		}
+		digest = h.Sum(nil)
+	}
+	return PartInfo{
+		Size:         info.Size(),
+		ETag:         fmt.Sprintf("\"%s\"", hex.EncodeToString(digest)),
+		LastModified: info.ModTime().UTC(),
+	}, true
+}
+
+// readUploadManifest returns the manifest written by CreateMultipartUpload,
+// or nil if it is missing or unreadable.
+func readUploadManifest(stagingDir string) map[string]string {
+	data, err := os.ReadFile(filepath.Join(stagingDir, "manifest.json"))
+	if err != nil {
+		return nil
+	}
+	var manifest map[string]string
+	if json.Unmarshal(data, &manifest) != nil {
+		return nil
+	}
+	return manifest
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Helper Functions
 // ═══════════════════════════════════════════════════════════════════════════════
 
 func (fs *FilesystemStorage) objectPath(bucket, key string) string {
-	return filepath.Join(fs.dataDir, bucket, filepath.FromSlash(key))
+	return filepath.Join(fs.dataDir, bucket, filepath.FromSlash(fs.keyToRel(key)))
 }
 
 func (fs *FilesystemStorage) metadataPath(bucket, key string) string {
@@ -866,13 +2764,27 @@ func (fs *FilesystemStorage) saveMetadata(bucket, key string, metadata *ObjectMe
 	return os.Rename(tmpPath, path)
 }
 
+// maxMetadataFileSize caps how much of a sidecar loadMetadata reads. Real
+// sidecars are a few KB; anything larger is damaged and is ignored rather
+// than read into memory.
+const maxMetadataFileSize = 1 << 20
+
 func (fs *FilesystemStorage) loadMetadata(bucket, key string) (*ObjectMetadata, error) {
 	path := fs.metadataPath(bucket, key)
 
-	data, err := os.ReadFile(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxMetadataFileSize+1))
 	if err != nil {
 		return nil, err
 	}
+	if len(data) > maxMetadataFileSize {
+		log.Printf("WARNING: Ignoring metadata sidecar %s: larger than %d bytes", path, maxMetadataFileSize)
+		return nil, fmt.Errorf("metadata sidecar %s exceeds %d bytes", path, maxMetadataFileSize)
+	}
 
 	var metadata ObjectMetadata
 	if err := json.Unmarshal(data, &metadata); err != nil {
@@ -882,6 +2794,39 @@ func (fs *FilesystemStorage) loadMetadata(bucket, key string) (*ObjectMetadata,
 	return &metadata, nil
 }
 
+// immutableUntilKey is the custom metadata name (x-amz-meta-immutable-until)
+// of the geckos3 immutability extension.
+const immutableUntilKey = "immutable-until"
+
+// parseImmutableUntil parses an immutable-until value, an RFC 3339 timestamp.
+func parseImmutableUntil(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("x-amz-meta-immutable-until must be an RFC 3339 timestamp, got %q", value)
+	}
+	return t, nil
+}
+
+// checkMutable returns ErrObjectImmutable if the stored object at key has an
+// immutable-until time in the future. Callers hold the key's stripe lock, so
+// the answer stays true until they unlock. Objects without a sidecar, or with
+// an unparsable time, are mutable.
+func (fs *FilesystemStorage) checkMutable(bucket, key string) error {
+	metadata, err := fs.loadMetadata(bucket, key)
+	if err != nil {
+		return nil
+	}
+	value, ok := metadata.CustomMetadata[immutableUntilKey]
+	if !ok {
+		return nil
+	}
+	until, err := parseImmutableUntil(value)
+	if err != nil || !time.Now().Before(until) {
+		return nil
+	}
+	return fmt.Errorf("%w until %s", ErrObjectImmutable, until.UTC().Format(time.RFC3339))
+}
+
 // generateUploadID creates a random hex ID for multipart uploads.
 func generateUploadID() string {
 	b := make([]byte, 16)
@@ -889,6 +2834,38 @@ func generateUploadID() string {
 	return hex.EncodeToString(b)
 }
 
+// SyncAll fsyncs every file and directory under the data directory, so a
+// snapshot taken afterwards sees all completed writes even when -fsync is off.
+// Directories are synced best-effort, as some platforms cannot open them for
+// syncing.
+func (fs *FilesystemStorage) SyncAll() error {
+	return filepath.WalkDir(fs.dataDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			// Entries removed by a concurrent delete are not an error.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		syncErr := f.Sync()
+		f.Close()
+		if syncErr != nil && !d.IsDir() {
+			return fmt.Errorf("sync %s: %w", path, syncErr)
+		}
+		return nil
+	})
+}
+
 // syncParentDir opens the parent directory of path, calls Sync to flush the
 // directory entry to durable storage, then closes it. Errors are intentionally
 // ignored because some filesystems (e.g. Windows, certain FUSE mounts) do not
@@ -902,3 +2879,9 @@ func syncParentDir(path string) {
 	d.Sync()
 	d.Close()
 }
+
+// AvailableSpace returns the free space on the filesystem holding the data
+// directory.
+func (fs *FilesystemStorage) AvailableSpace() (uint64, bool) {
+	return availableBytes(fs.dataDir)
+}
@@ -2,14 +2,26 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -76,6 +88,64 @@ func TestDeleteEmptyBucket(t *testing.T) {
 	}
 }
 
+func TestRenameBucket(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	s.CreateBucket("old-name")
+	s.PutObject("old-name", "dir/a.txt", strings.NewReader("a"), &PutObjectInput{ContentType: "text/plain"})
+	s.CreateBucket("taken")
+
+	if err := s.RenameBucket("old-name", "taken"); !errors.Is(err, ErrBucketExists) {
+		t.Fatalf("rename onto existing bucket: %v", err)
+	}
+	if err := s.RenameBucket("old-name", "new-name"); err != nil {
+		t.Fatal(err)
+	}
+	if s.BucketExists("old-name") || !s.BucketExists("new-name") {
+		t.Fatal("bucket directory not renamed")
+	}
+	meta, err := s.HeadObject("new-name", "dir/a.txt")
+	if err != nil || meta.ContentType != "text/plain" {
+		t.Errorf("object after rename: %+v, %v", meta, err)
+	}
+	if err := s.RenameBucket("old-name", "other"); err == nil {
+		t.Error("expected error renaming a missing bucket")
+	}
+}
+
+func TestRenameBucketDuringPut(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		s, cleanup := setupTestStorage(t)
+		s.SetDirectWrite(direct)
+		s.CreateBucket("old-name")
+
+		// A PUT whose body is still streaming when the bucket moves must
+		// fail rather than recreate the old bucket.
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.PutObject("old-name", "dir/a.txt", pr, nil)
+			done <- err
+		}()
+		pw.Write([]byte("partial"))
+		if err := s.RenameBucket("old-name", "new-name"); err != nil {
+			t.Fatalf("direct=%v: rename: %v", direct, err)
+		}
+		pw.Close()
+		if err := <-done; err == nil {
+			t.Errorf("direct=%v: PUT into a renamed bucket succeeded", direct)
+		}
+		if s.BucketExists("old-name") {
+			t.Errorf("direct=%v: PUT recreated the old bucket", direct)
+		}
+		if objects, err := s.ListObjects("new-name", "", 1000); err != nil || len(objects) != 0 {
+			t.Errorf("direct=%v: objects in renamed bucket: %v %v", direct, objects, err)
+		}
+		cleanup()
+	}
+}
+
 func TestListBuckets(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
@@ -322,7 +392,7 @@ func TestDeleteObject(t *testing.T) {
 	s.CreateBucket("b")
 
 	s.PutObject("b", "del.txt", strings.NewReader("gone"), nil)
-	if err := s.DeleteObject("b", "del.txt"); err != nil {
+	if _, err := s.DeleteObject("b", "del.txt"); err != nil {
 		t.Fatal(err)
 	}
 	_, _, err := s.GetObject("b", "del.txt")
@@ -367,11 +437,603 @@ func TestDeleteNonExistentObjectSilent(t *testing.T) {
 	s.CreateBucket("b")
 
 	// S3 returns 204 for deleting non-existent keys
-	if err := s.DeleteObject("b", "nope.txt"); err != nil {
+	if _, err := s.DeleteObject("b", "nope.txt"); err != nil {
 		t.Fatalf("deleting non-existent object should not error: %v", err)
 	}
 }
 
+func TestPutObjectIfNoneMatch(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	create := &PutObjectInput{IfNoneMatch: true}
+
+	if _, err := s.PutObject("b", "lock", strings.NewReader("first"), create); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := s.PutObject("b", "lock", strings.NewReader("second"), create); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("create over an existing key: %v", err)
+	}
+	reader, _, err := s.GetObject("b", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "first" {
+		t.Errorf("content = %q", data)
+	}
+	if staged, _ := os.ReadDir(filepath.Join(s.dataDir, "b", tmpStagingDir)); len(staged) != 0 {
+		t.Errorf("staging dir holds %d files", len(staged))
+	}
+
+	// Of many concurrent creates, exactly one wins.
+	var wg sync.WaitGroup
+	var won atomic.Int32
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.PutObject("b", "race", strings.NewReader(fmt.Sprint(i)), create)
+			if err == nil {
+				won.Add(1)
+			} else if !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("racing create: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if won.Load() != 1 {
+		t.Errorf("%d creates succeeded, want 1", won.Load())
+	}
+}
+
+func TestPutObjectDirectWrite(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.SetDirectWrite(true)
+	s.CreateBucket("b")
+	read := func(key string) string {
+		t.Helper()
+		reader, _, err := s.GetObject("b", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		data, _ := io.ReadAll(reader)
+		return string(data)
+	}
+
+	if _, err := s.PutObject("b", "dir/a", strings.NewReader("v1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := read("dir/a"); got != "v1" {
+		t.Errorf("content = %q", got)
+	}
+	partPath := s.objectPath("b", "dir/a") + directWriteSuffix
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Errorf("part file left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.dataDir, "b", tmpStagingDir)); !os.IsNotExist(err) {
+		t.Errorf("staging dir used: %v", err)
+	}
+
+	// A failed write keeps the old object and removes what it created.
+	bad := &PutObjectInput{ExpectedSHA256: strings.Repeat("0", 64)}
+	if _, err := s.PutObject("b", "dir/a", strings.NewReader("v2"), bad); !errors.Is(err, ErrBadDigest) {
+		t.Fatalf("bad digest: %v", err)
+	}
+	if got := read("dir/a"); got != "v1" {
+		t.Errorf("content after failed write = %q", got)
+	}
+	if _, err := s.PutObject("b", "new/x", io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(io.ErrUnexpectedEOF)), nil); err == nil {
+		t.Fatal("write with a failing body succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(s.dataDir, "b", "new")); !os.IsNotExist(err) {
+		t.Errorf("directory of failed write left behind: %v", err)
+	}
+
+	// While a body streams, the part file exists but is never listed, and
+	// the key's lock is held.
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.PutObject("b", "dir/a", pr, nil)
+		done <- err
+	}()
+	pw.Write([]byte("v3"))
+	if _, err := os.Stat(partPath); err != nil {
+		t.Fatalf("part file while streaming: %v", err)
+	}
+	objects, err := s.ListObjects("b", "", 1000)
+	if err != nil || len(objects) != 1 || objects[0].Key != "dir/a" {
+		t.Errorf("listing while streaming: %v %v", objects, err)
+	}
+	if objects, _, err := s.ListDirectory("b", "dir/"); err != nil || len(objects) != 1 || objects[0].Key != "dir/a" {
+		t.Errorf("directory listing while streaming: %v %v", objects, err)
+	}
+	if mu := s.stripe(s.objectPath("b", "dir/a")); mu.TryLock() {
+		mu.Unlock()
+		t.Error("stripe lock not held while streaming")
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := read("dir/a"); got != "v3" {
+		t.Errorf("content = %q", got)
+	}
+
+	// Keys that would collide with a part file or a sidecar are refused.
+	for _, key := range []string{"dir/a" + directWriteSuffix, "dir/a.metadata.json"} {
+		if _, err := s.PutObject("b", key, strings.NewReader("x"), nil); !errors.Is(err, ErrInvalidKeyName) {
+			t.Errorf("PutObject(%q) = %v, want ErrInvalidKeyName", key, err)
+		}
+	}
+	if got := read("dir/a"); got != "v3" {
+		t.Errorf("content after reserved-key writes = %q", got)
+	}
+
+	// A part file left by a crash is swept, the object it belonged to kept.
+	os.WriteFile(partPath, []byte("partial"), 0600)
+	if n, err := s.RemoveDirectWriteParts(); err != nil || n != 1 {
+		t.Errorf("RemoveDirectWriteParts = %d, %v; want 1", n, err)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Errorf("leftover part file: %v", err)
+	}
+	if got := read("dir/a"); got != "v3" {
+		t.Errorf("content after sweep = %q", got)
+	}
+}
+
+func TestDeleteObjectIfMatch(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	meta, _ := s.PutObject("b", "cond.txt", strings.NewReader("v1"), nil)
+
+	if _, err := s.DeleteObjectIfMatch("b", "cond.txt", `"0123456789abcdef0123456789abcdef"`); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("mismatched ETag: %v", err)
+	}
+	if _, err := s.HeadObject("b", "cond.txt"); err != nil {
+		t.Fatalf("object removed despite mismatch: %v", err)
+	}
+	// Unquoted ETags match too.
+	if _, err := s.DeleteObjectIfMatch("b", "cond.txt", strings.Trim(meta.ETag, `"`)); err != nil {
+		t.Fatalf("matching ETag: %v", err)
+	}
+	if _, err := s.HeadObject("b", "cond.txt"); !os.IsNotExist(err) {
+		t.Errorf("object still there after conditional delete: %v", err)
+	}
+	if _, err := s.DeleteObjectIfMatch("b", "cond.txt", "*"); !os.IsNotExist(err) {
+		t.Errorf("conditional delete of missing key: %v", err)
+	}
+}
+
+func TestImmutableUntil(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	s.PutObject("b", "locked.log", strings.NewReader("v1"), &PutObjectInput{CustomMetadata: map[string]string{immutableUntilKey: future}})
+	s.PutObject("b", "expired.log", strings.NewReader("v1"), &PutObjectInput{CustomMetadata: map[string]string{immutableUntilKey: past}})
+	s.PutObject("b", "src.log", strings.NewReader("src"), nil)
+
+	writes := map[string]func() error{
+		"PutObject": func() error {
+			_, err := s.PutObject("b", "locked.log", strings.NewReader("v2"), nil)
+			return err
+		},
+		"DeleteObject": func() error {
+			_, err := s.DeleteObject("b", "locked.log")
+			return err
+		},
+		"CopyObject": func() error {
+			_, err := s.CopyObject("b", "src.log", "b", "locked.log", nil, nil)
+			return err
+		},
+		"RenameObject from": func() error {
+			_, err := s.RenameObject("b", "locked.log", "moved.log", false)
+			return err
+		},
+		"RenameObject onto": func() error {
+			_, err := s.RenameObject("b", "src.log", "locked.log", true)
+			return err
+		},
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrObjectImmutable) {
+			t.Errorf("%s of an immutable object: %v", name, err)
+		}
+	}
+	rc, _, err := s.GetObject("b", "locked.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "v1" {
+		t.Errorf("immutable object changed to %q", data)
+	}
+
+	if _, err := s.PutObject("b", "expired.log", strings.NewReader("v2"), nil); err != nil {
+		t.Errorf("overwrite after the immutability time: %v", err)
+	}
+	if _, err := s.DeleteObject("b", "expired.log"); err != nil {
+		t.Errorf("delete after the immutability time: %v", err)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	if !hardLinksSupported {
+		t.Skip("dedup needs hard link counts")
+	}
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	if err := s.SetDedup(true); err != nil {
+		t.Fatal(err)
+	}
+	s.CreateBucket("b1")
+	s.CreateBucket("b2")
+
+	same := "backup payload"
+	sum := sha256.Sum256([]byte(same))
+	blobPath := s.blobPath(hex.EncodeToString(sum[:]))
+	links := func() uint64 {
+		t.Helper()
+		n, ok := hardLinkCount(blobPath)
+		if !ok {
+			return 0
+		}
+		return n
+	}
+
+	s.PutObject("b1", "a", strings.NewReader(same), nil)
+	s.PutObject("b2", "b", strings.NewReader(same), nil)
+	if _, err := s.CopyObject("b1", "a", "b1", "c", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := links(); n != 4 {
+		t.Fatalf("blob has %d links, want 4 (blob + 3 keys)", n)
+	}
+	rc, meta, err := s.GetObject("b2", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != same || meta.ETag != fmt.Sprintf("\"%x\"", md5.Sum([]byte(same))) {
+		t.Errorf("GET deduplicated object: %q %s", data, meta.ETag)
+	}
+	if buckets, _ := s.ListBuckets(); len(buckets) != 2 {
+		t.Errorf("ListBuckets: %v", buckets)
+	}
+
+	// Overwriting, deleting and renaming onto keys drops their links; the
+	// last one removes the blob.
+	s.PutObject("b1", "a", strings.NewReader("different"), nil)
+	s.DeleteObject("b2", "b")
+	if n := links(); n != 2 {
+		t.Errorf("after overwrite and delete: %d links, want 2", n)
+	}
+	s.PutObject("b1", "d", strings.NewReader("other"), nil)
+	if _, err := s.RenameObject("b1", "d", "c", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(blobPath); !os.IsNotExist(err) {
+		t.Errorf("blob still stored after its last key was replaced: %v", err)
+	}
+
+	// Blobs orphaned behind geckos3's back are swept.
+	s.PutObject("b1", "e", strings.NewReader(same), nil)
+	os.Remove(s.objectPath("b1", "e"))
+	if removed, err := s.SweepBlobs(); err != nil || removed != 1 {
+		t.Errorf("SweepBlobs: %d, %v", removed, err)
+	}
+	if _, err := os.Stat(blobPath); !os.IsNotExist(err) {
+		t.Errorf("orphaned blob not swept: %v", err)
+	}
+}
+
+// TestDedupConcurrentRefcount races puts, copies, renames, overwrites and
+// deletes of identical content and checks that the blob outlives every key
+// linking to it, and no longer.
+func TestDedupConcurrentRefcount(t *testing.T) {
+	if !hardLinksSupported {
+		t.Skip("dedup needs hard link counts")
+	}
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.SetDedup(true)
+	s.CreateBucket("b")
+
+	same := strings.Repeat("shared backup block ", 512)
+	sum := sha256.Sum256([]byte(same))
+	blobPath := s.blobPath(hex.EncodeToString(sum[:]))
+	keys := []string{"k0", "k1", "k2", "k3", "k4", "k5"}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := keys[(w*7+i)%len(keys)]
+				switch (w + i) % 6 {
+				case 0, 1:
+					s.PutObject("b", key, strings.NewReader(same), nil)
+				case 2:
+					s.DeleteObject("b", key)
+				case 3:
+					s.PutObject("b", key, strings.NewReader("unique "+key), nil)
+				case 4:
+					s.CopyObject("b", keys[(w+i)%len(keys)], "b", key, nil, nil)
+				case 5:
+					s.RenameObject("b", keys[(w+i)%len(keys)], key, true)
+				}
+				// A key that exists always reads back whole.
+				if rc, _, err := s.GetObject("b", key); err == nil {
+					data, err := io.ReadAll(rc)
+					rc.Close()
+					if err != nil || (string(data) != same && !strings.HasPrefix(string(data), "unique ")) {
+						t.Errorf("GET %s during the race: %d bytes, %v", key, len(data), err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	check := func(when string) {
+		t.Helper()
+		var linked uint64
+		for _, key := range keys {
+			rc, _, err := s.GetObject("b", key)
+			if err != nil {
+				continue
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) == same {
+				linked++
+			}
+		}
+		n, ok := hardLinkCount(blobPath)
+		switch {
+		case linked == 0 && ok:
+			t.Errorf("%s: blob kept with %d links but no key has its content", when, n)
+		case linked > 0 && (!ok || n != linked+1):
+			t.Errorf("%s: %d keys hold the content but the blob has %d links", when, linked, n)
+		}
+	}
+	check("after the race")
+
+	for _, key := range keys {
+		s.PutObject("b", key, strings.NewReader(same), nil)
+	}
+	check("after re-linking every key")
+	for _, key := range keys {
+		s.DeleteObject("b", key)
+	}
+	check("after deleting every key")
+}
+
+func TestMigratingStorage(t *testing.T) {
+	old := NewFilesystemStorage(t.TempDir())
+	old.CreateBucket("b")
+	old.PutBucketTagging("b", map[string]string{"team": "ops"})
+	old.PutObject("b", "a.txt", strings.NewReader("old a"), &PutObjectInput{ContentType: "text/plain"})
+	old.PutObject("b", "dir/x.txt", strings.NewReader("old x"), nil)
+	old.PutObject("b", "gone.txt", strings.NewReader("old gone"), nil)
+	old.PutObject("b", "move.txt", strings.NewReader("old move"), nil)
+	uploadID, _ := old.CreateMultipartUpload("b", "mpu.bin", "")
+	etag, _ := old.UploadPart("b", "mpu.bin", uploadID, 1, strings.NewReader("multipart"), "")
+	mpu, err := old.CompleteMultipartUpload("b", "mpu.bin", uploadID, []CompletedPart{{1, etag}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := NewFilesystemStorage(t.TempDir())
+	m, err := NewMigratingStorage(primary, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags, _ := primary.GetBucketTagging("b"); tags["team"] != "ops" {
+		t.Errorf("bucket tagging not carried over: %v", tags)
+	}
+
+	read := func(s Storage, key string) string {
+		t.Helper()
+		rc, _, err := s.GetObject("b", key)
+		if err != nil {
+			return "<" + err.Error() + ">"
+		}
+		defer rc.Close()
+		data, _ := io.ReadAll(rc)
+		return string(data)
+	}
+
+	// Reads fall through, and the object is then copied in the background.
+	if got := read(m, "a.txt"); got != "old a" {
+		t.Errorf("fall-through GET: %q", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for read(primary, "a.txt") != "old a" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, err := primary.HeadObject("b", "a.txt"); err != nil || got.ContentType != "text/plain" {
+		t.Errorf("lazy migration: %+v %v", got, err)
+	}
+
+	// Create-if-absent sees keys that are only in the old directory.
+	if _, err := m.PutObject("b", "dir/x.txt", strings.NewReader("new x"), &PutObjectInput{IfNoneMatch: true}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("IfNoneMatch over an unmigrated key: %v", err)
+	}
+
+	// Writes go to the primary and shadow the old copy.
+	m.PutObject("b", "dir/x.txt", strings.NewReader("new x"), nil)
+	m.PutObject("b", "new.txt", strings.NewReader("new"), nil)
+	if got := read(m, "dir/x.txt"); got != "new x" {
+		t.Errorf("overwritten key: %q", got)
+	}
+	if got := read(old, "dir/x.txt"); got != "old x" {
+		t.Errorf("old directory written to: %q", got)
+	}
+
+	// Deletes and renames remove the old copy so it cannot show through.
+	if _, err := m.DeleteObject("b", "gone.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.HeadObject("b", "gone.txt"); !os.IsNotExist(err) {
+		t.Errorf("deleted key still visible: %v", err)
+	}
+	if _, err := m.RenameObject("b", "move.txt", "moved.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(m, "moved.txt"); got != "old move" {
+		t.Errorf("renamed key: %q", got)
+	}
+	if _, err := m.HeadObject("b", "move.txt"); !os.IsNotExist(err) {
+		t.Errorf("rename source still visible: %v", err)
+	}
+
+	var keys []string
+	objects, err := m.ListObjects("b", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	want := []string{"a.txt", "dir/x.txt", "moved.txt", "mpu.bin", "new.txt"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("merged listing: %v, want %v", keys, want)
+	}
+	if objects, _ := m.ListObjects("b", "", 2); len(objects) != 2 || objects[1].Key != "dir/x.txt" {
+		t.Errorf("merged listing with maxKeys 2: %v", objects)
+	}
+
+	// The proactive pass copies the rest, keeping validators.
+	migrated, failed, err := m.MigrateAll(context.Background())
+	if err != nil || failed != 0 || migrated != 1 {
+		t.Errorf("MigrateAll: %d copied, %d failed, %v", migrated, failed, err)
+	}
+	got, err := primary.HeadObject("b", "mpu.bin")
+	if err != nil || got.ETag != mpu.ETag || !got.LastModified.Equal(mpu.LastModified) {
+		t.Errorf("migrated multipart object: %+v %v, want ETag %s", got, err, mpu.ETag)
+	}
+	if got := read(primary, "dir/x.txt"); got != "new x" {
+		t.Errorf("migration overwrote a newer write: %q", got)
+	}
+
+	if err := m.RenameBucket("b", "c"); !errors.Is(err, ErrMigrationInProgress) {
+		t.Errorf("RenameBucket: %v", err)
+	}
+}
+
+func TestCachingStorage(t *testing.T) {
+	origin := NewFilesystemStorage(t.TempDir())
+	origin.CreateBucket("b")
+	c, err := NewCachingStorage(origin, t.TempDir(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(key string) string {
+		t.Helper()
+		rc, _, err := c.GetObject("b", key)
+		if err != nil {
+			return "<missing>"
+		}
+		defer rc.Close()
+		if _, ok := rc.(io.ReadSeeker); !ok {
+			t.Errorf("GET %s: reader is not seekable", key)
+		}
+		data, _ := io.ReadAll(rc)
+		return string(data)
+	}
+	cached := func(key string) bool {
+		_, ok := c.lookup(cacheKey{"b", key})
+		return ok
+	}
+
+	// PutObject writes through to the origin and the cache.
+	hits, misses := cacheHits.Value(), cacheMisses.Value()
+	c.PutObject("b", "put.txt", strings.NewReader("written through"), &PutObjectInput{ContentType: "text/plain"})
+	if got := read("put.txt"); got != "written through" || cacheHits.Value() != hits+1 {
+		t.Errorf("GET after PUT: %q, %d hits", got, cacheHits.Value()-hits)
+	}
+	if meta, err := c.HeadObject("b", "put.txt"); err != nil || meta.ContentType != "text/plain" {
+		t.Errorf("cached HEAD: %+v %v", meta, err)
+	}
+	c.PutObjectTagging("b", "put.txt", map[string]string{"k": "v"})
+	if meta, err := c.HeadObject("b", "put.txt"); err != nil || meta.Tags["k"] != "v" {
+		t.Errorf("HEAD after tagging: %+v %v", meta, err)
+	}
+
+	// A miss fills the cache from the origin.
+	misses = cacheMisses.Value()
+	origin.PutObject("b", "origin.txt", strings.NewReader("from origin"), nil)
+	if got := read("origin.txt"); got != "from origin" || cacheMisses.Value() != misses+1 {
+		t.Errorf("first GET: %q, %d misses", got, cacheMisses.Value()-misses)
+	}
+	origin.PutObject("b", "origin.txt", strings.NewReader("changed behind the cache"), nil)
+	if got := read("origin.txt"); got != "from origin" {
+		t.Errorf("second GET should be a hit: %q", got)
+	}
+
+	// Writes and deletes through the wrapper invalidate.
+	c.PutObject("b", "origin.txt", strings.NewReader("rewritten"), nil)
+	if got := read("origin.txt"); got != "rewritten" {
+		t.Errorf("after overwrite: %q", got)
+	}
+	c.CopyObject("b", "put.txt", "b", "origin.txt", nil, nil)
+	if got := read("origin.txt"); got != "written through" {
+		t.Errorf("after copy onto the key: %q", got)
+	}
+	c.RenameObject("b", "origin.txt", "renamed.txt", true)
+	if got := read("origin.txt"); got != "<missing>" {
+		t.Errorf("rename source still served: %q", got)
+	}
+	c.DeleteObject("b", "put.txt")
+	if got := read("put.txt"); got != "<missing>" {
+		t.Errorf("deleted key still served: %q", got)
+	}
+
+	// Objects over a quarter of the budget pass through uncached.
+	big := strings.Repeat("x", 300)
+	origin.PutObject("b", "big.bin", strings.NewReader(big), nil)
+	if got := read("big.bin"); got != big || cached("big.bin") {
+		t.Errorf("big object: %d bytes, cached=%v", len(got), cached("big.bin"))
+	}
+
+	// The least recently used object is evicted first.
+	c.DeleteObject("b", "renamed.txt")
+	for i := 0; i < 5; i++ {
+		origin.PutObject("b", fmt.Sprintf("obj%d", i), strings.NewReader(strings.Repeat("y", 200)), nil)
+		read(fmt.Sprintf("obj%d", i))
+	}
+	read("obj0")
+	origin.PutObject("b", "obj5", strings.NewReader(strings.Repeat("y", 200)), nil)
+	read("obj5")
+	if !cached("obj0") || cached("obj1") || !cached("obj5") {
+		t.Errorf("eviction: obj0=%v obj1=%v obj5=%v", cached("obj0"), cached("obj1"), cached("obj5"))
+	}
+	if c.used > c.budget {
+		t.Errorf("cache holds %d bytes, budget %d", c.used, c.budget)
+	}
+
+	// A fill overtaken by a write is not cached.
+	fill := c.startFill(cacheKey{"b", "race.txt"})
+	c.invalidateKey("b", "race.txt")
+	if c.finishFill(fill, &ObjectMetadata{}, filepath.Join(c.dir, "stale"), 1, false) {
+		t.Error("stale fill was cached")
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Copy Object
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -384,7 +1046,7 @@ func TestCopyObjectSameBucket(t *testing.T) {
 	body := "copy-me"
 	s.PutObject("b", "orig.txt", strings.NewReader(body), &PutObjectInput{ContentType: "text/plain"})
 
-	meta, err := s.CopyObject("b", "orig.txt", "b", "copied.txt", nil)
+	meta, err := s.CopyObject("b", "orig.txt", "b", "copied.txt", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -407,7 +1069,7 @@ func TestCopyObjectCrossBucket(t *testing.T) {
 	s.CreateBucket("dst")
 
 	s.PutObject("src", "file.txt", strings.NewReader("cross-bucket"), &PutObjectInput{ContentType: "application/json"})
-	meta, err := s.CopyObject("src", "file.txt", "dst", "file.txt", nil)
+	meta, err := s.CopyObject("src", "file.txt", "dst", "file.txt", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -428,7 +1090,7 @@ func TestCopyObjectSourceNotFound(t *testing.T) {
 	defer cleanup()
 	s.CreateBucket("b")
 
-	_, err := s.CopyObject("b", "nope.txt", "b", "dest.txt", nil)
+	_, err := s.CopyObject("b", "nope.txt", "b", "dest.txt", nil, nil)
 	if err == nil {
 		t.Fatal("copy from missing source should fail")
 	}
@@ -440,7 +1102,7 @@ func TestCopyObjectToNested(t *testing.T) {
 	s.CreateBucket("b")
 
 	s.PutObject("b", "flat.txt", strings.NewReader("nested-copy"), nil)
-	_, err := s.CopyObject("b", "flat.txt", "b", "deep/nested/copy.txt", nil)
+	_, err := s.CopyObject("b", "flat.txt", "b", "deep/nested/copy.txt", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -460,7 +1122,7 @@ func TestCopyObjectOverwritesExisting(t *testing.T) {
 	s.PutObject("b", "src.txt", strings.NewReader("source"), &PutObjectInput{ContentType: "text/plain"})
 	s.PutObject("b", "dst.txt", strings.NewReader("old-dest"), &PutObjectInput{ContentType: "text/html"})
 
-	_, err := s.CopyObject("b", "src.txt", "b", "dst.txt", nil)
+	_, err := s.CopyObject("b", "src.txt", "b", "dst.txt", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -472,26 +1134,108 @@ func TestCopyObjectOverwritesExisting(t *testing.T) {
 	}
 }
 
-// ═══════════════════════════════════════════════════════════════════════════════
-// List Objects – Advanced
-// ═══════════════════════════════════════════════════════════════════════════════
-
-func TestListObjectsEmpty(t *testing.T) {
+func TestRenameObject(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
 	s.CreateBucket("b")
 
-	objs, err := s.ListObjects("b", "", 0)
+	put, _ := s.PutObject("b", "dir/src.txt", strings.NewReader("source"),
+		&PutObjectInput{ContentType: "text/plain", CustomMetadata: map[string]string{"k": "v"}})
+
+	meta, err := s.RenameObject("b", "dir/src.txt", "moved/dst.txt", false)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(objs) != 0 {
-		t.Errorf("expected 0 objects, got %d", len(objs))
+	if meta.ETag != put.ETag || meta.ContentType != "text/plain" || meta.CustomMetadata["k"] != "v" {
+		t.Errorf("metadata not carried over: %+v", meta)
+	}
+	if _, err := s.HeadObject("b", "dir/src.txt"); !os.IsNotExist(err) {
+		t.Errorf("source still present: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.dataDir, "b", "dir")); !os.IsNotExist(err) {
+		t.Error("empty source directory not removed")
+	}
+	reader, _, err := s.GetObject("b", "moved/dst.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "source" {
+		t.Errorf("renamed content: %q", data)
 	}
-}
 
-func TestListObjectsWithPrefix(t *testing.T) {
-	s, cleanup := setupTestStorage(t)
+	// An existing destination is only replaced on request.
+	s.PutObject("b", "other.txt", strings.NewReader("other"), nil)
+	if _, err := s.RenameObject("b", "other.txt", "moved/dst.txt", false); !errors.Is(err, ErrObjectExists) {
+		t.Fatalf("expected ErrObjectExists, got %v", err)
+	}
+	meta, err = s.RenameObject("b", "other.txt", "moved/dst.txt", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != 5 || meta.CustomMetadata["k"] != "" {
+		t.Errorf("overwrite kept the old object's metadata: %+v", meta)
+	}
+
+	if _, err := s.RenameObject("b", "missing.txt", "x.txt", false); !os.IsNotExist(err) {
+		t.Errorf("missing source: %v", err)
+	}
+}
+
+// Concurrent renames in opposite directions must not deadlock on the stripe
+// locks.
+func TestRenameObjectOppositeDirections(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	s.PutObject("b", "a", strings.NewReader("a"), nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				s.RenameObject("b", "a", "z", true)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				s.RenameObject("b", "z", "a", true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, errA := s.HeadObject("b", "a")
+	_, errZ := s.HeadObject("b", "z")
+	if (errA == nil) == (errZ == nil) {
+		t.Errorf("expected exactly one of a, z to exist: %v, %v", errA, errZ)
+	}
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// List Objects – Advanced
+// ═══════════════════════════════════════════════════════════════════════════════
+
+func TestListObjectsEmpty(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	objs, err := s.ListObjects("b", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 0 {
+		t.Errorf("expected 0 objects, got %d", len(objs))
+	}
+}
+
+func TestListObjectsWithPrefix(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
 	defer cleanup()
 	s.CreateBucket("b")
 
@@ -510,6 +1254,50 @@ func TestListObjectsWithPrefix(t *testing.T) {
 	}
 }
 
+func TestWalkObjects(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	s.PutObject("b", "logs/app.log", strings.NewReader("aa"), nil)
+	s.PutObject("b", "logs/deep/err.log", strings.NewReader("b"), nil)
+	s.PutObject("b", "data/file.csv", strings.NewReader("c"), nil)
+	if _, err := s.CreateMultipartUpload("b", "logs/pending", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]int64{}
+	err := s.WalkObjects("b", "logs/", func(obj ObjectInfo) error {
+		if obj.ETag == "" {
+			t.Errorf("%s: empty ETag", obj.Key)
+		}
+		got[obj.Key] = obj.Size
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"logs/app.log": 2, "logs/deep/err.log": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %v, want %v", got, want)
+	}
+
+	// An error from the callback stops the walk.
+	stop := errors.New("stop")
+	calls := 0
+	err = s.WalkObjects("b", "", func(ObjectInfo) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("got err %v after %d calls, want stop after 1", err, calls)
+	}
+
+	if err := s.WalkObjects("missing", "", func(ObjectInfo) error { return nil }); err == nil {
+		t.Error("expected error for missing bucket")
+	}
+}
+
 func TestListObjectsMaxKeys(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
@@ -586,6 +1374,102 @@ func TestListObjectsUnlimited(t *testing.T) {
 	}
 }
 
+func TestListDirectoryRoot(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	s.PutObject("b", "root.txt", strings.NewReader("r"), nil)
+	s.PutObject("b", "logs/app.log", strings.NewReader("a"), nil)
+	s.PutObject("b", "logs/2024/deep.log", strings.NewReader("d"), nil)
+	s.PutObject("b", "data/file.csv", strings.NewReader("c"), nil)
+	os.MkdirAll(filepath.Join(s.dataDir, "b", "empty", "nested"), 0755)
+
+	objs, prefixes, err := s.ListDirectory("b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Key != "root.txt" {
+		t.Errorf("objects: %+v", objs)
+	}
+	if strings.Join(prefixes, ",") != "data/,logs/" {
+		t.Errorf("prefixes: %v", prefixes)
+	}
+}
+
+func TestListDirectoryNestedPrefix(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	s.PutObject("b", "photos/cat.jpg", strings.NewReader("1"), nil)
+	s.PutObject("b", "photos/car.jpg", strings.NewReader("2"), nil)
+	s.PutObject("b", "photos/cats/tom.jpg", strings.NewReader("3"), nil)
+	s.PutObject("b", "photos/dog.jpg", strings.NewReader("4"), nil)
+
+	objs, prefixes, err := s.ListDirectory("b", "photos/ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, o := range objs {
+		keys = append(keys, o.Key)
+		if o.ETag == "" {
+			t.Errorf("missing ETag for %s", o.Key)
+		}
+	}
+	if strings.Join(keys, ",") != "photos/car.jpg,photos/cat.jpg" {
+		t.Errorf("objects: %v", keys)
+	}
+	if len(prefixes) != 1 || prefixes[0] != "photos/cats/" {
+		t.Errorf("prefixes: %v", prefixes)
+	}
+}
+
+func TestListDirectorySkipsInternalEntries(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	s.PutObject("b", "a.txt", strings.NewReader("a"), nil)
+	uploadID, _ := s.CreateMultipartUpload("b", "big.bin", "")
+	s.UploadPart("b", "big.bin", uploadID, 1, strings.NewReader("part"), "")
+
+	objs, prefixes, err := s.ListDirectory("b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Key != "a.txt" {
+		t.Errorf("objects: %+v", objs)
+	}
+	if len(prefixes) != 0 {
+		t.Errorf("staging dirs leaked as prefixes: %v", prefixes)
+	}
+}
+
+func TestListDirectoryMissingPrefix(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	objs, prefixes, err := s.ListDirectory("b", "nope/")
+	if err != nil {
+		t.Fatalf("missing prefix should not error: %v", err)
+	}
+	if len(objs) != 0 || len(prefixes) != 0 {
+		t.Errorf("expected empty listing, got %v %v", objs, prefixes)
+	}
+}
+
+func TestListDirectoryNonExistentBucket(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	if _, _, err := s.ListDirectory("ghost", ""); err == nil {
+		t.Fatal("should error for non-existent bucket")
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Path Traversal Security
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -690,7 +1574,7 @@ func TestPathTraversalDeleteObject(t *testing.T) {
 	defer cleanup()
 	s.CreateBucket("b")
 
-	err := s.DeleteObject("b", "../../../etc/passwd")
+	_, err := s.DeleteObject("b", "../../../etc/passwd")
 	if err == nil {
 		t.Fatal("should reject path traversal in DeleteObject")
 	}
@@ -713,17 +1597,184 @@ func TestPathTraversalCopyObject(t *testing.T) {
 	s.CreateBucket("b")
 	s.PutObject("b", "legit.txt", strings.NewReader("ok"), nil)
 
-	_, err := s.CopyObject("b", "legit.txt", "b", "../../escape.txt", nil)
+	_, err := s.CopyObject("b", "legit.txt", "b", "../../escape.txt", nil, nil)
 	if err == nil {
 		t.Fatal("should reject path traversal in CopyObject destination")
 	}
 
-	_, err = s.CopyObject("b", "../../passwd", "b", "dest.txt", nil)
+	_, err = s.CopyObject("b", "../../passwd", "b", "dest.txt", nil, nil)
 	if err == nil {
 		t.Fatal("should reject path traversal in CopyObject source")
 	}
 }
 
+func TestSymlinkedDataDir(t *testing.T) {
+	base := t.TempDir()
+	target := filepath.Join(base, "real")
+	other := filepath.Join(base, "other")
+	link := filepath.Join(base, "link")
+	os.Mkdir(target, 0755)
+	os.Mkdir(other, 0755)
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	s := NewFilesystemStorage(link)
+	want, _ := filepath.EvalSymlinks(target)
+	if s.dataDir != want {
+		t.Fatalf("dataDir = %q, want resolved %q", s.dataDir, want)
+	}
+
+	if err := s.CreateBucket("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutObject("b", "k.txt", strings.NewReader("data"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "b", "k.txt")); err != nil {
+		t.Fatalf("object not stored under the symlink target: %v", err)
+	}
+	for _, key := range []string{"../../other/x", "../../link/b/k.txt"} {
+		if _, err := s.PutObject("b", key, strings.NewReader("evil"), nil); err == nil {
+			t.Errorf("should reject traversal key %q", key)
+		}
+	}
+	if err := s.CreateBucket("../other"); err == nil {
+		t.Error("should reject traversal bucket name")
+	}
+
+	// Re-pointing the symlink does not move the root.
+	os.Remove(link)
+	if err := os.Symlink(other, link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.HeadObject("b", "k.txt"); err != nil {
+		t.Errorf("object lost after symlink swap: %v", err)
+	}
+	s.PutObject("b", "after.txt", strings.NewReader("x"), nil)
+	if entries, _ := os.ReadDir(other); len(entries) != 0 {
+		t.Errorf("writes followed the swapped symlink: %v", entries)
+	}
+}
+
+func TestNoFollowSymlinks(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	s.PutObject("b", "plain.txt", strings.NewReader("ok"), nil)
+
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	os.WriteFile(secret, []byte("secret"), 0644)
+	bucketDir := filepath.Join(s.dataDir, "b")
+	if err := os.Symlink(secret, filepath.Join(bucketDir, "link.txt")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	os.Symlink(outside, filepath.Join(bucketDir, "linkdir"))
+
+	// Without the option the links are followed.
+	if _, err := s.HeadObject("b", "link.txt"); err != nil {
+		t.Fatalf("default mode: %v", err)
+	}
+
+	s.SetNoFollowSymlinks(true)
+	for _, key := range []string{"link.txt", "linkdir/secret.txt"} {
+		if _, _, err := s.GetObject("b", key); !os.IsNotExist(err) {
+			t.Errorf("GetObject(%q) = %v, want not-exist", key, err)
+		}
+		if _, err := s.HeadObject("b", key); !os.IsNotExist(err) {
+			t.Errorf("HeadObject(%q) = %v, want not-exist", key, err)
+		}
+		if _, err := s.CopyObject("b", key, "b", "copy.txt", nil, nil); err == nil {
+			t.Errorf("CopyObject from %q succeeded", key)
+		}
+	}
+
+	objs, err := s.ListObjects("b", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Key != "plain.txt" {
+		t.Errorf("ListObjects = %v, want only plain.txt", objs)
+	}
+	objs, _, err = s.ListDirectory("b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Key != "plain.txt" {
+		t.Errorf("ListDirectory = %v, want only plain.txt", objs)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	s.SetNegativeCacheTTL(time.Hour)
+
+	if _, err := s.HeadObject("b", "favicon.ico"); !os.IsNotExist(err) {
+		t.Fatalf("HeadObject: %v", err)
+	}
+
+	// A file added behind the server's back stays hidden until expiry...
+	os.WriteFile(filepath.Join(s.dataDir, "b", "favicon.ico"), []byte("x"), 0644)
+	if _, _, err := s.GetObject("b", "favicon.ico"); !os.IsNotExist(err) {
+		t.Fatalf("expected cached miss, got %v", err)
+	}
+
+	// ...but a write through the storage is visible immediately.
+	s.PutObject("b", "favicon.ico", strings.NewReader("icon"), nil)
+	if _, err := s.HeadObject("b", "favicon.ico"); err != nil {
+		t.Fatalf("after PutObject: %v", err)
+	}
+
+	// Copies, multipart completes and renames invalidate their destination.
+	s.HeadObject("b", "copy.ico")
+	s.CopyObject("b", "favicon.ico", "b", "copy.ico", nil, nil)
+	s.HeadObject("b", "mpu.ico")
+	putMultipartObject(t, s, "b", "mpu.ico", "part")
+	s.HeadObject("b", "renamed.ico")
+	s.RenameObject("b", "copy.ico", "renamed.ico", false)
+	for _, key := range []string{"mpu.ico", "renamed.ico"} {
+		if _, err := s.HeadObject("b", key); err != nil {
+			t.Errorf("%s after write: %v", key, err)
+		}
+	}
+
+	// Entries expire.
+	s.SetNegativeCacheTTL(10 * time.Millisecond)
+	s.HeadObject("b", "late.txt")
+	os.WriteFile(filepath.Join(s.dataDir, "b", "late.txt"), []byte("x"), 0644)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := s.HeadObject("b", "late.txt"); err != nil {
+		t.Errorf("entry did not expire: %v", err)
+	}
+}
+
+func TestNegativeCacheStaleAddAndBound(t *testing.T) {
+	c := newNegativeCache(time.Hour)
+
+	// A miss observed before a concurrent write must not be cached after it.
+	gen := c.generation()
+	c.forget("b", "k")
+	c.add("b", "k", gen)
+	if c.missing("b", "k") {
+		t.Error("stale miss was cached")
+	}
+
+	for i := 0; i < negativeCacheMaxEntries+10; i++ {
+		c.add("b", fmt.Sprintf("k%d", i), c.generation())
+	}
+	if len(c.entries) > negativeCacheMaxEntries {
+		t.Errorf("cache grew to %d entries", len(c.entries))
+	}
+
+	c.forgetBucket("b")
+	if len(c.entries) != 0 {
+		t.Errorf("forgetBucket left %d entries", len(c.entries))
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // ETag Correctness
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -744,6 +1795,43 @@ func TestETagIsQuotedMD5(t *testing.T) {
 	}
 }
 
+func TestPooledHashersResetBetweenWrites(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 64)
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := strings.Repeat(string(rune('a'+i%26)), 1000+i)
+			sum := sha256.Sum256([]byte(content))
+			meta, err := s.PutObject("b", "k"+string(rune('A'+i%26))+string(rune('0'+i/26)), strings.NewReader(content),
+				&PutObjectInput{ExpectedSHA256: hex.EncodeToString(sum[:])})
+			if err != nil {
+				errs <- err.Error()
+				return
+			}
+			want := "\"" + hex.EncodeToString(md5Sum([]byte(content))) + "\""
+			if meta.ETag != want {
+				errs <- "ETag " + meta.ETag + " != " + want
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+}
+
+func md5Sum(b []byte) []byte {
+	sum := md5.Sum(b)
+	return sum[:]
+}
+
 func TestETagConsistentAcrossOperations(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
@@ -995,6 +2083,37 @@ func TestMetadataSurvivesOverwrite(t *testing.T) {
 	}
 }
 
+func TestOversizedMetadataSidecarIgnored(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	s.PutObject("b", "big.txt", strings.NewReader("data"), &PutObjectInput{ContentType: "text/plain"})
+
+	// Valid JSON padded past the cap: it would parse if read whole.
+	sidecar := `{"etag":"\"bogus\"","contentType":"x/huge"}` + strings.Repeat(" ", maxMetadataFileSize)
+	if err := os.WriteFile(s.metadataPath("b", "big.txt"), []byte(sidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	meta, err := s.HeadObject("b", "big.txt")
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	if meta.ETag == `"bogus"` || meta.ContentType == "x/huge" {
+		t.Errorf("oversized sidecar was used: %+v", meta)
+	}
+	if meta.Size != 4 || meta.ETag == "" {
+		t.Errorf("fallback metadata = %+v", meta)
+	}
+	if !strings.Contains(logs.String(), "Ignoring metadata sidecar") {
+		t.Errorf("no warning logged: %q", logs.String())
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Object Path Mapping
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -1026,6 +2145,7 @@ func TestObjectPathMapping(t *testing.T) {
 // ═══════════════════════════════════════════════════════════════════════════════
 
 func TestMultipartUploadBasic(t *testing.T) {
+	allowSmallParts(t)
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
 	s.CreateBucket("b")
@@ -1088,36 +2208,124 @@ func TestMultipartUploadBasic(t *testing.T) {
 	}
 }
 
-func TestMultipartUploadBucketNotExist(t *testing.T) {
+func TestMultipartETagMatchesS3(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
+	s.CreateBucket("b")
 
-	_, err := s.CreateMultipartUpload("ghost", "file.txt", "text/plain")
-	if err == nil {
-		t.Fatal("should fail for non-existent bucket")
+	uploadID, _ := s.CreateMultipartUpload("b", "big.bin", "")
+	part1 := strings.Repeat("a", 5*1024*1024)
+	part2 := strings.Repeat("b", 1024)
+	etag1, _ := s.UploadPart("b", "big.bin", uploadID, 1, strings.NewReader(part1), "")
+	etag2, _ := s.UploadPart("b", "big.bin", uploadID, 2, strings.NewReader(part2), "")
+
+	meta, err := s.CompleteMultipartUpload("b", "big.bin", uploadID, []CompletedPart{
+		{PartNumber: 1, ETag: etag1},
+		{PartNumber: 2, ETag: etag2},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
 	}
-}
 
-func TestMultipartUploadInvalidUploadID(t *testing.T) {
-	s, cleanup := setupTestStorage(t)
-	defer cleanup()
-	s.CreateBucket("b")
+	// Value returned by AWS S3 for the same two parts.
+	const want = `"16329fb6004d64a4fbc5bbb983fa0528-2"`
+	if meta.ETag != want {
+		t.Errorf("multipart ETag: got %s, want %s", meta.ETag, want)
+	}
+}
 
-	_, err := s.UploadPart("b", "file.txt", "invalid-upload-id", 1, strings.NewReader("data"), "")
-	if err == nil {
-		t.Fatal("UploadPart should fail with invalid uploadID")
+func TestMultipartETagFromPartDigests(t *testing.T) {
+	d1 := md5Sum([]byte("Hello, "))
+	d2 := md5Sum([]byte("World!"))
+	want := "\"" + hex.EncodeToString(md5Sum(append(append([]byte{}, d1...), d2...))) + "-2\""
+	if got := multipartETag(append(append([]byte{}, d1...), d2...), 2); got != want {
+		t.Errorf("multipartETag: got %s, want %s", got, want)
 	}
 }
 
-func TestMultipartUploadAbort(t *testing.T) {
+func TestMultipartPartDigestRecorded(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
 	s.CreateBucket("b")
 
-	uploadID, _ := s.CreateMultipartUpload("b", "abort.txt", "text/plain")
-	s.UploadPart("b", "abort.txt", uploadID, 1, strings.NewReader("data"), "")
+	uploadID, _ := s.CreateMultipartUpload("b", "file.txt", "")
+	etag, err := s.UploadPart("b", "file.txt", uploadID, 1, strings.NewReader("Hello, "), "")
+	if err != nil {
+		t.Fatalf("UploadPart: %v", err)
+	}
 
-	if err := s.AbortMultipartUpload("b", "abort.txt", uploadID); err != nil {
+	partPath := filepath.Join(s.multipartStagingPath("b", uploadID), "part-00001.tmp")
+	digest, err := os.ReadFile(partMD5Path(partPath))
+	if err != nil {
+		t.Fatalf("part digest not recorded: %v", err)
+	}
+	if got := "\"" + hex.EncodeToString(digest) + "\""; got != etag {
+		t.Errorf("recorded digest %s does not match part ETag %s", got, etag)
+	}
+
+	if err := s.AbortMultipartUpload("b", "file.txt", uploadID); err != nil {
+		t.Fatalf("AbortMultipartUpload: %v", err)
+	}
+	if _, err := os.Stat(partMD5Path(partPath)); !os.IsNotExist(err) {
+		t.Errorf("part digest should be removed on abort: %v", err)
+	}
+}
+
+func TestMultipartCompleteWithoutPartDigest(t *testing.T) {
+	allowSmallParts(t)
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	// Parts staged before digests were recorded are hashed during completion.
+	uploadID, _ := s.CreateMultipartUpload("b", "file.txt", "")
+	etag1, _ := s.UploadPart("b", "file.txt", uploadID, 1, strings.NewReader("Hello, "), "")
+	etag2, _ := s.UploadPart("b", "file.txt", uploadID, 2, strings.NewReader("World!"), "")
+	os.Remove(partMD5Path(filepath.Join(s.multipartStagingPath("b", uploadID), "part-00001.tmp")))
+
+	meta, err := s.CompleteMultipartUpload("b", "file.txt", uploadID, []CompletedPart{
+		{PartNumber: 1, ETag: etag1},
+		{PartNumber: 2, ETag: etag2},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+	digests := append(md5Sum([]byte("Hello, ")), md5Sum([]byte("World!"))...)
+	if want := multipartETag(digests, 2); meta.ETag != want {
+		t.Errorf("ETag: got %s, want %s", meta.ETag, want)
+	}
+}
+
+func TestMultipartUploadBucketNotExist(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	_, err := s.CreateMultipartUpload("ghost", "file.txt", "text/plain")
+	if err == nil {
+		t.Fatal("should fail for non-existent bucket")
+	}
+}
+
+func TestMultipartUploadInvalidUploadID(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	_, err := s.UploadPart("b", "file.txt", "invalid-upload-id", 1, strings.NewReader("data"), "")
+	if err == nil {
+		t.Fatal("UploadPart should fail with invalid uploadID")
+	}
+}
+
+func TestMultipartUploadAbort(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	uploadID, _ := s.CreateMultipartUpload("b", "abort.txt", "text/plain")
+	s.UploadPart("b", "abort.txt", uploadID, 1, strings.NewReader("data"), "")
+
+	if err := s.AbortMultipartUpload("b", "abort.txt", uploadID); err != nil {
 		t.Fatalf("AbortMultipartUpload: %v", err)
 	}
 
@@ -1220,6 +2428,7 @@ func TestMultipartUploadSinglePart(t *testing.T) {
 }
 
 func TestMultipartUploadLargePartCount(t *testing.T) {
+	allowSmallParts(t)
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
 	s.CreateBucket("b")
@@ -1509,6 +2718,35 @@ func TestPutObjectExpectedSHA256Wrong(t *testing.T) {
 	}
 }
 
+// TestPutObjectEmptySHA256Constant locks in the handling of emptySHA256, the
+// SHA-256 of zero bytes. PutObject checks it by size rather than by hashing,
+// so it must still accept exactly the empty body and reject anything else.
+func TestPutObjectEmptySHA256Constant(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	if sum := sha256.Sum256(nil); hex.EncodeToString(sum[:]) != emptySHA256 {
+		t.Fatalf("emptySHA256 is not the SHA-256 of zero bytes")
+	}
+	empty := &PutObjectInput{ExpectedSHA256: emptySHA256}
+
+	meta, err := s.PutObject("b", "empty.txt", bytes.NewReader(nil), empty)
+	if err != nil {
+		t.Fatalf("empty body with the empty-SHA constant: %v", err)
+	}
+	if meta.Size != 0 {
+		t.Errorf("size = %d", meta.Size)
+	}
+
+	if _, err := s.PutObject("b", "empty.txt", strings.NewReader("not empty"), empty); err != ErrBadDigest {
+		t.Fatalf("non-empty body with the empty-SHA constant: %v", err)
+	}
+	if meta, err := s.HeadObject("b", "empty.txt"); err != nil || meta.Size != 0 {
+		t.Errorf("empty object replaced despite the bad digest: %+v %v", meta, err)
+	}
+}
+
 func TestPutObjectSHA256DoesNotOverwriteExisting(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
@@ -1535,6 +2773,32 @@ func TestPutObjectSHA256DoesNotOverwriteExisting(t *testing.T) {
 	}
 }
 
+func TestPutObjectExpectedMD5(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	sum := md5.Sum([]byte("original"))
+	meta, err := s.PutObject("b", "keep.txt", strings.NewReader("original"), &PutObjectInput{ExpectedMD5: sum[:]})
+	if err != nil {
+		t.Fatalf("matching MD5: %v", err)
+	}
+	if meta.ETag != `"`+hex.EncodeToString(sum[:])+`"` {
+		t.Errorf("ETag = %s", meta.ETag)
+	}
+
+	_, err = s.PutObject("b", "keep.txt", strings.NewReader("replacement"), &PutObjectInput{ExpectedMD5: sum[:]})
+	if !errors.Is(err, ErrBadContentMD5) || !errors.Is(err, ErrBadDigest) {
+		t.Fatalf("mismatched MD5: %v", err)
+	}
+	r, _, _ := s.GetObject("b", "keep.txt")
+	defer r.Close()
+	data, _ := io.ReadAll(r)
+	if string(data) != "original" {
+		t.Errorf("original content should survive a bad Content-MD5: got %q", data)
+	}
+}
+
 func TestPutObjectEmptySHA256FieldSkipsVerification(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
@@ -1719,6 +2983,69 @@ func TestDeleteBucketStillFailsWithRealObjects(t *testing.T) {
 	}
 }
 
+func TestDeleteBucketWithEmptySubdirectories(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	// Stale empty directories left behind by deletes must not block deletion
+	os.MkdirAll(filepath.Join(s.dataDir, "b", "logs", "2024", "01"), 0755)
+	os.MkdirAll(filepath.Join(s.dataDir, "b", "empty"), 0755)
+
+	if err := s.DeleteBucket("b"); err != nil {
+		t.Fatalf("DeleteBucket with only empty dirs should succeed: %v", err)
+	}
+	if s.BucketExists("b") {
+		t.Error("bucket should be gone after delete")
+	}
+}
+
+func TestDeleteBucketFailsWithDeeplyNestedObject(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	os.MkdirAll(filepath.Join(s.dataDir, "b", "empty"), 0755)
+	s.PutObject("b", "logs/2024/01/app.log", strings.NewReader("line"), nil)
+
+	if err := s.DeleteBucket("b"); err == nil {
+		t.Fatal("DeleteBucket should fail when a nested object exists")
+	}
+}
+
+func TestDeleteBucketIgnoresNestedArtifactsAndSidecars(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	nested := filepath.Join(s.dataDir, "b", "photos")
+	os.MkdirAll(nested, 0755)
+	os.WriteFile(filepath.Join(nested, ".DS_Store"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(nested, "Thumbs.db"), []byte("x"), 0644)
+	// Orphaned sidecar whose object is already gone
+	os.WriteFile(filepath.Join(nested, "cat.jpg.metadata.json"), []byte("{}"), 0644)
+
+	if err := s.DeleteBucket("b"); err != nil {
+		t.Fatalf("DeleteBucket with nested artifacts should succeed: %v", err)
+	}
+}
+
+func TestDeleteBucketIgnoresFilesInStagingDirs(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	uploadDir := filepath.Join(s.dataDir, "b", multipartStagingDir, "abc123")
+	os.MkdirAll(uploadDir, 0755)
+	os.WriteFile(filepath.Join(uploadDir, "part-00001.tmp"), []byte("part"), 0644)
+	os.MkdirAll(filepath.Join(s.dataDir, "b", tmpStagingDir), 0755)
+	os.WriteFile(filepath.Join(s.dataDir, "b", tmpStagingDir, ".put-123"), []byte("tmp"), 0644)
+
+	if err := s.DeleteBucket("b"); err != nil {
+		t.Fatalf("DeleteBucket with staged files should succeed: %v", err)
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Fix 1: I/O Outside Stripe Lock – Concurrent Stress
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -1781,6 +3108,45 @@ func TestConcurrentPutAndDeleteNoDeadlock(t *testing.T) {
 	// If we get here without deadlock or panic, the test passes
 }
 
+func TestStripeLockWaitWarning(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	s.SetLockWaitWarning(10 * time.Millisecond)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	before := stripeLockWait.Count()
+	if _, err := s.PutObject("b", "fast.txt", strings.NewReader("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := stripeLockWait.Count() - before; got != 1 {
+		t.Errorf("uncontended put recorded %d lock waits, want 1", got)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("uncontended put logged: %s", logs.String())
+	}
+
+	// Hold the key's stripe so the put has to wait for it.
+	mu := s.stripe(s.objectPath("b", "slow.txt"))
+	mu.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := s.PutObject("b", "slow.txt", strings.NewReader("x"), nil)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	mu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "PutObject waited") {
+		t.Errorf("contended put not logged: %q", logs.String())
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Benchmarks
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -1799,6 +3165,21 @@ func BenchmarkPutObject(b *testing.B) {
 	}
 }
 
+func BenchmarkPutObjectDirectWrite(b *testing.B) {
+	tempDir := b.TempDir()
+	storage := NewFilesystemStorage(tempDir)
+	storage.SetDirectWrite(true)
+	storage.CreateBucket("benchmark")
+
+	content := bytes.Repeat([]byte("a"), 1024) // 1KB
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := filepath.Join("test", string(rune(i%26+97)), "file.txt")
+		storage.PutObject("benchmark", key, bytes.NewReader(content), nil)
+	}
+}
+
 func BenchmarkGetObject(b *testing.B) {
 	tempDir := b.TempDir()
 	storage := NewFilesystemStorage(tempDir)
@@ -1815,6 +3196,81 @@ func BenchmarkGetObject(b *testing.B) {
 	}
 }
 
+// BenchmarkCompleteMultipartUpload completes a 1GB upload made of 16 x 64MB
+// parts, and a 64MB upload made of 1000 x 64KB parts, with per-file and
+// packed part staging. Only the CompleteMultipartUpload call is timed; the
+// staged-files metric is the number of inodes the upload held beforehand.
+func BenchmarkCompleteMultipartUpload(b *testing.B) {
+	for _, shape := range []struct {
+		name      string
+		partSize  int
+		partCount int
+	}{
+		{"16x64MB", 64 * 1024 * 1024, 16},
+		{"1000x64KB", 64 * 1024, 1000},
+	} {
+		for _, packed := range []bool{false, true} {
+			layout := "files"
+			if packed {
+				layout = "packed"
+			}
+			b.Run(shape.name+"/"+layout, func(b *testing.B) {
+				s := NewFilesystemStorage(b.TempDir())
+				s.SetPackedMultipart(packed)
+				s.CreateBucket("bench")
+				part := bytes.Repeat([]byte("m"), shape.partSize)
+
+				b.SetBytes(int64(shape.partSize * shape.partCount))
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					uploadID, _ := s.CreateMultipartUpload("bench", "big.bin", "")
+					parts := make([]CompletedPart, shape.partCount)
+					for n := 1; n <= shape.partCount; n++ {
+						etag, err := s.UploadPart("bench", "big.bin", uploadID, n, bytes.NewReader(part), "")
+						if err != nil {
+							b.Fatal(err)
+						}
+						parts[n-1] = CompletedPart{PartNumber: n, ETag: etag}
+					}
+					staged, _ := os.ReadDir(s.multipartStagingPath("bench", uploadID))
+					b.ReportMetric(float64(len(staged)), "staged-files")
+					b.StartTimer()
+
+					if _, err := s.CompleteMultipartUpload("bench", "big.bin", uploadID, parts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkCopyObject copies a 256MB object. "clone" uses the server-side
+// file copy that preserves the stored ETag; "stream" removes the source
+// sidecar first, forcing the read + re-hash fallback.
+func BenchmarkCopyObject(b *testing.B) {
+	payload := bytes.Repeat([]byte("c"), 256*1024*1024)
+
+	for _, mode := range []string{"clone", "stream"} {
+		b.Run(mode, func(b *testing.B) {
+			s := NewFilesystemStorage(b.TempDir())
+			s.CreateBucket("bench")
+			s.PutObject("bench", "src.bin", bytes.NewReader(payload), nil)
+			if mode == "stream" {
+				os.Remove(s.metadataPath("bench", "src.bin"))
+			}
+
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.CopyObject("bench", "src.bin", "bench", "dst.bin", nil, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 // ═══════════════════════════════════════════════════════════════════════════════
 // Helpers
 // ═══════════════════════════════════════════════════════════════════════════════
@@ -1869,139 +3325,663 @@ func TestUploadPartSHA256Mismatch(t *testing.T) {
 	}
 }
 
-func TestUploadPartSHA256EmptySkipsCheck(t *testing.T) {
+func TestUploadPartSHA256EmptySkipsCheck(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	uploadID, _ := s.CreateMultipartUpload("b", "sha.txt", "text/plain")
+
+	// Empty expectedSHA256 should skip verification
+	etag, err := s.UploadPart("b", "sha.txt", uploadID, 1, bytes.NewReader([]byte("data")), "")
+	if err != nil {
+		t.Fatalf("UploadPart with empty SHA256: %v", err)
+	}
+	if etag == "" {
+		t.Fatal("etag should not be empty")
+	}
+}
+
+func TestPutObjectChecksum(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	body := []byte("checksummed content")
+	crc := crc32.NewIEEE()
+	crc.Write(body)
+	crc32Sum := base64.StdEncoding.EncodeToString(crc.Sum(nil))
+	crcC := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	crcC.Write(body)
+	crc32cSum := base64.StdEncoding.EncodeToString(crcC.Sum(nil))
+	sha1Sum := sha1.Sum(body)
+	sha256Sum := sha256.Sum256(body)
+	want := map[string]string{
+		"CRC32":  crc32Sum,
+		"CRC32C": crc32cSum,
+		"SHA1":   base64.StdEncoding.EncodeToString(sha1Sum[:]),
+		"SHA256": base64.StdEncoding.EncodeToString(sha256Sum[:]),
+	}
+
+	for algorithm, sum := range want {
+		// Computed without an expected value, verified with one.
+		for _, expected := range []string{"", sum} {
+			input := &PutObjectInput{Checksum: &ChecksumInput{Algorithm: algorithm, Expected: expected}}
+			if _, err := s.PutObject("b", "k", bytes.NewReader(body), input); err != nil {
+				t.Fatalf("%s: %v", algorithm, err)
+			}
+			meta, err := s.HeadObject("b", "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.ChecksumAlgorithm != algorithm || meta.Checksum != sum {
+				t.Errorf("%s: stored %s %q, want %q", algorithm, meta.ChecksumAlgorithm, meta.Checksum, sum)
+			}
+		}
+	}
+
+	bad := &PutObjectInput{Checksum: &ChecksumInput{Algorithm: "CRC32", Expected: crc32cSum}}
+	if _, err := s.PutObject("b", "k", strings.NewReader("replacement"), bad); !errors.Is(err, ErrBadChecksum) || !errors.Is(err, ErrBadDigest) {
+		t.Fatalf("mismatched checksum: %v", err)
+	}
+	r, _, _ := s.GetObject("b", "k")
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(data, body) {
+		t.Errorf("content after a bad checksum = %q", data)
+	}
+
+	uploadID, _ := s.CreateMultipartUpload("b", "mp", "")
+	_, got, err := s.UploadPartWithChecksum("b", "mp", uploadID, 1, bytes.NewReader(body), "", &ChecksumInput{Algorithm: "CRC32", Expected: crc32Sum})
+	if err != nil || got != crc32Sum {
+		t.Errorf("part checksum: %q %v", got, err)
+	}
+	_, _, err = s.UploadPartWithChecksum("b", "mp", uploadID, 2, strings.NewReader("other"), "", &ChecksumInput{Algorithm: "CRC32", Expected: crc32Sum})
+	if !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("mismatched part checksum: %v", err)
+	}
+	if parts, _, _ := s.ListParts("b", "mp", uploadID, 0, 1000); len(parts) != 1 {
+		t.Errorf("%d parts staged, want 1", len(parts))
+	}
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Fix 2: Multipart Upload Garbage Collection
+// ═══════════════════════════════════════════════════════════════════════════════
+
+func TestCleanAbandonedUploads(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	// Create a multipart upload and stage a part
+	uploadID, _ := s.CreateMultipartUpload("b", "abandoned.txt", "text/plain")
+	s.UploadPart("b", "abandoned.txt", uploadID, 1, strings.NewReader("data"), "")
+
+	stagingDir := s.multipartStagingPath("b", uploadID)
+
+	// Backdate the staging directory to 25 hours ago
+	old := time.Now().Add(-25 * time.Hour)
+	os.Chtimes(stagingDir, old, old)
+
+	// Run GC with 24h max age
+	cleanAbandonedUploads(s.dataDir, 24*time.Hour, false)
+
+	// Staging dir should be gone
+	if _, err := os.Stat(stagingDir); !os.IsNotExist(err) {
+		t.Fatal("abandoned staging dir should have been removed")
+	}
+}
+
+func TestCleanAbandonedUploadsKeepsRecent(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	// Create a recent multipart upload
+	uploadID, _ := s.CreateMultipartUpload("b", "recent.txt", "text/plain")
+	s.UploadPart("b", "recent.txt", uploadID, 1, strings.NewReader("data"), "")
+
+	stagingDir := s.multipartStagingPath("b", uploadID)
+
+	// Run GC — this upload is fresh, should NOT be removed
+	cleanAbandonedUploads(s.dataDir, 24*time.Hour, false)
+
+	if _, err := os.Stat(stagingDir); os.IsNotExist(err) {
+		t.Fatal("recent staging dir should NOT have been removed")
+	}
+}
+
+func TestCleanAbandonedUploadsDryRun(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	uploadID, _ := s.CreateMultipartUpload("b", "abandoned.txt", "text/plain")
+	s.UploadPart("b", "abandoned.txt", uploadID, 1, strings.NewReader("data"), "")
+	stagingDir := s.multipartStagingPath("b", uploadID)
+	old := time.Now().Add(-25 * time.Hour)
+	os.Chtimes(stagingDir, old, old)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	result := cleanAbandonedUploads(s.dataDir, 24*time.Hour, true)
+	if _, err := os.Stat(stagingDir); err != nil {
+		t.Fatalf("dry run removed the staging dir: %v", err)
+	}
+	if result.Uploads != 1 || result.Bytes < 4 {
+		t.Errorf("dry run result = %+v", result)
+	}
+	if !strings.Contains(logs.String(), "would remove upload "+uploadID+" in bucket b") {
+		t.Errorf("dry run did not log the candidate:\n%s", logs.String())
+	}
+
+	// The real run reclaims the same upload and counts it in the metrics;
+	// the dry run did not.
+	uploads, freed := gcUploadsReclaimed.Value(), gcBytesReclaimed.Value()
+	if result = cleanAbandonedUploads(s.dataDir, 24*time.Hour, false); result.Uploads != 1 {
+		t.Errorf("real run result = %+v", result)
+	}
+	if got := gcUploadsReclaimed.Value() - uploads; got != 1 {
+		t.Errorf("uploads reclaimed metric grew by %d", got)
+	}
+	if got := gcBytesReclaimed.Value() - freed; got != result.Bytes {
+		t.Errorf("bytes reclaimed metric grew by %d, want %d", got, result.Bytes)
+	}
+	if time.Since(time.Unix(gcLastRun.Value(), 0)) > time.Minute {
+		t.Errorf("last run timestamp %d not updated", gcLastRun.Value())
+	}
+	if _, err := os.Stat(stagingDir); !os.IsNotExist(err) {
+		t.Fatal("abandoned staging dir should have been removed")
+	}
+}
+
+func TestCleanAbandonedUploadsNoBuckets(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	// No buckets — should not panic or error
+	cleanAbandonedUploads(s.dataDir, 24*time.Hour, false)
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Fix 3: Directory Fsync on Rename (syncParentDir)
+// ═══════════════════════════════════════════════════════════════════════════════
+
+func TestSyncParentDirDoesNotPanic(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	// PutObject internally calls syncParentDir — just verify no panic
+	_, err := s.PutObject("b", "sync-test.txt", strings.NewReader("data"), nil)
+	if err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+
+	// CompleteMultipartUpload also calls syncParentDir
+	uploadID, _ := s.CreateMultipartUpload("b", "sync-multi.txt", "text/plain")
+	etag, _ := s.UploadPart("b", "sync-multi.txt", uploadID, 1, strings.NewReader("data"), "")
+	_, err = s.CompleteMultipartUpload("b", "sync-multi.txt", uploadID, []CompletedPart{
+		{PartNumber: 1, ETag: etag},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+}
+
+func TestSyncParentDirNonExistentPath(t *testing.T) {
+	// Should not panic on invalid path
+	syncParentDir("/nonexistent/path/file.txt")
+}
+
+// ═══════════════════════════════════════════════════════════════════════════════
+// Fix 5: CopyObject Metadata Directive
+// ═══════════════════════════════════════════════════════════════════════════════
+
+func TestCopyObjectDefaultCopiesMetadata(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	s.PutObject("b", "src.txt", strings.NewReader("data"), &PutObjectInput{
+		ContentType:    "text/html",
+		CacheControl:   "max-age=3600",
+		CustomMetadata: map[string]string{"author": "alice"},
+	})
+
+	// COPY directive (nil override) should preserve source metadata
+	meta, err := s.CopyObject("b", "src.txt", "b", "dst.txt", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ContentType != "text/html" {
+		t.Errorf("content type: %q, want text/html", meta.ContentType)
+	}
+	if meta.CacheControl != "max-age=3600" {
+		t.Errorf("cache-control: %q", meta.CacheControl)
+	}
+
+	dstMeta, _ := s.HeadObject("b", "dst.txt")
+	if dstMeta.CustomMetadata["author"] != "alice" {
+		t.Errorf("custom metadata missing: %v", dstMeta.CustomMetadata)
+	}
+}
+
+func TestCopyObjectClonePreservesSourceETag(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	s.CreateBucket("other")
+
+	srcMeta, _ := s.PutObject("b", "src.txt", strings.NewReader("clone me"), &PutObjectInput{
+		ContentType:    "text/plain",
+		CustomMetadata: map[string]string{"k": "v"},
+	})
+
+	meta, err := s.CopyObject("b", "src.txt", "other", "nested/dst.txt", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ETag != srcMeta.ETag {
+		t.Errorf("ETag: %q, want source %q", meta.ETag, srcMeta.ETag)
+	}
+	if meta.Size != srcMeta.Size {
+		t.Errorf("Size: %d, want %d", meta.Size, srcMeta.Size)
+	}
+	if meta.LastModified.Before(srcMeta.LastModified) {
+		t.Error("copy should get a fresh LastModified")
+	}
+
+	reader, dstMeta, err := s.GetObject("other", "nested/dst.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "clone me" {
+		t.Errorf("content: %q", data)
+	}
+	if dstMeta.ETag != srcMeta.ETag || dstMeta.CustomMetadata["k"] != "v" {
+		t.Errorf("stored metadata: %+v", dstMeta)
+	}
+}
+
+func TestCopyObjectReplaceKeepsSourceETag(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	srcMeta, _ := s.PutObject("b", "src.txt", strings.NewReader("same bytes"), &PutObjectInput{
+		ContentType:    "text/plain",
+		CustomMetadata: map[string]string{"old": "1"},
+	})
+
+	meta, err := s.CopyObject("b", "src.txt", "b", "dst.txt", &PutObjectInput{
+		ContentType:    "text/markdown",
+		CustomMetadata: map[string]string{"new": "2"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ETag != srcMeta.ETag {
+		t.Errorf("REPLACE changes metadata only; ETag %q, want %q", meta.ETag, srcMeta.ETag)
+	}
+
+	dstMeta, _ := s.HeadObject("b", "dst.txt")
+	if dstMeta.ContentType != "text/markdown" {
+		t.Errorf("ContentType: %q", dstMeta.ContentType)
+	}
+	if dstMeta.CustomMetadata["new"] != "2" || dstMeta.CustomMetadata["old"] != "" {
+		t.Errorf("CustomMetadata: %v", dstMeta.CustomMetadata)
+	}
+}
+
+// allowSmallParts lifts the minimum part size for the rest of the test, so
+// multipart tests can complete uploads of a few bytes per part.
+func allowSmallParts(t *testing.T) {
+	saved := minPartSize
+	minPartSize = 0
+	t.Cleanup(func() { minPartSize = saved })
+}
+
+// putMultipartObject uploads parts as a multipart object and returns its ETag.
+func putMultipartObject(t *testing.T, s *FilesystemStorage, bucket, key string, parts ...string) string {
+	t.Helper()
+	allowSmallParts(t)
+	uploadID, err := s.CreateMultipartUpload(bucket, key, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed := make([]CompletedPart, len(parts))
+	for i, p := range parts {
+		etag, err := s.UploadPart(bucket, key, uploadID, i+1, strings.NewReader(p), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		completed[i] = CompletedPart{PartNumber: i + 1, ETag: etag}
+	}
+	meta, err := s.CompleteMultipartUpload(bucket, key, uploadID, completed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta.ETag
+}
+
+func TestPackedMultipartUpload(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	wantETag := putMultipartObject(t, s, "b", "files.txt", "alpha ", "beta ", "gamma")
+
+	s.SetPackedMultipart(true)
+	uploadID, err := s.CreateMultipartUpload("b", "packed.txt", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Out of order, with part 2 re-uploaded at a different size.
+	etags := make(map[int]string)
+	for _, p := range []struct {
+		n    int
+		data string
+	}{{3, "gamma"}, {2, "stale part two"}, {1, "alpha "}, {2, "beta "}} {
+		etag, err := s.UploadPart("b", "packed.txt", uploadID, p.n, strings.NewReader(p.data), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		etags[p.n] = etag
+	}
+	if want := fmt.Sprintf("\"%x\"", md5.Sum([]byte("beta "))); etags[2] != want {
+		t.Errorf("part ETag = %s, want %s", etags[2], want)
+	}
+	if _, err := s.UploadPart("b", "packed.txt", uploadID, 4, strings.NewReader("x"), strings.Repeat("0", 64)); !errors.Is(err, ErrBadDigest) {
+		t.Errorf("bad SHA256: %v", err)
+	}
+
+	// Toggling the option does not change an existing upload's layout.
+	s.SetPackedMultipart(false)
+	staged, _ := os.ReadDir(s.multipartStagingPath("b", uploadID))
+	var names []string
+	for _, e := range staged {
+		names = append(names, e.Name())
+	}
+	if want := []string{"manifest.json", "parts.bin", "parts.json"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("staging dir holds %v, want %v", names, want)
+	}
+
+	meta, err := s.CompleteMultipartUpload("b", "packed.txt", uploadID, []CompletedPart{
+		{PartNumber: 1, ETag: etags[1]}, {PartNumber: 2, ETag: etags[2]}, {PartNumber: 3, ETag: etags[3]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ETag != wantETag || meta.Size != int64(len("alpha beta gamma")) {
+		t.Errorf("completed ETag %s size %d, want %s", meta.ETag, meta.Size, wantETag)
+	}
+	reader, _, err := s.GetObject("b", "packed.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "alpha beta gamma" {
+		t.Errorf("content = %q", data)
+	}
+
+	// Completing with a part that was never uploaded is ErrInvalidPart.
+	s.SetPackedMultipart(true)
+	uploadID, _ = s.CreateMultipartUpload("b", "missing.txt", "")
+	s.UploadPart("b", "missing.txt", uploadID, 1, strings.NewReader("x"), "")
+	if _, err := s.CompleteMultipartUpload("b", "missing.txt", uploadID, []CompletedPart{{PartNumber: 1}, {PartNumber: 2}}); !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("missing part: %v", err)
+	}
+}
+
+func TestMultipartMinimumPartSize(t *testing.T) {
+	saved := minPartSize
+	minPartSize = 4
+	defer func() { minPartSize = saved }()
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	for _, packed := range []bool{false, true} {
+		s.SetPackedMultipart(packed)
+		upload := func(parts ...string) (string, []CompletedPart) {
+			uploadID, err := s.CreateMultipartUpload("b", "obj", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			completed := make([]CompletedPart, len(parts))
+			for i, p := range parts {
+				etag, err := s.UploadPart("b", "obj", uploadID, i+1, strings.NewReader(p), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				completed[i] = CompletedPart{PartNumber: i + 1, ETag: etag}
+			}
+			return uploadID, completed
+		}
+
+		// A short part before the last is rejected and the upload kept.
+		uploadID, completed := upload("abcd", "ef", "g")
+		if _, err := s.CompleteMultipartUpload("b", "obj", uploadID, completed); !errors.Is(err, ErrEntityTooSmall) {
+			t.Errorf("packed=%v: short middle part: %v", packed, err)
+		}
+		// Without the third part the short one is last, which is allowed.
+		meta, err := s.CompleteMultipartUpload("b", "obj", uploadID, completed[:2])
+		if err != nil {
+			t.Fatalf("packed=%v: short last part: %v", packed, err)
+		}
+		if meta.Size != 6 {
+			t.Errorf("packed=%v: size = %d, want 6", packed, meta.Size)
+		}
+
+		// A single part may be any size, including empty.
+		uploadID, completed = upload("")
+		if _, err := s.CompleteMultipartUpload("b", "obj", uploadID, completed); err != nil {
+			t.Errorf("packed=%v: single empty part: %v", packed, err)
+		}
+	}
+}
+
+func TestMultipartCompleteValidatesParts(t *testing.T) {
+	allowSmallParts(t)
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	for _, packed := range []bool{false, true} {
+		s.SetPackedMultipart(packed)
+		uploadID, err := s.CreateMultipartUpload("b", "obj", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var completed []CompletedPart
+		for i, p := range []string{"one", "two"} {
+			etag, err := s.UploadPart("b", "obj", uploadID, i+1, strings.NewReader(p), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			completed = append(completed, CompletedPart{PartNumber: i + 1, ETag: etag})
+		}
+		wrong := fmt.Sprintf("\"%x\"", md5.Sum([]byte("three")))
+
+		for _, tc := range []struct {
+			name  string
+			parts []CompletedPart
+			want  error
+		}{
+			{"wrong ETag", []CompletedPart{completed[0], {PartNumber: 2, ETag: wrong}}, ErrInvalidPart},
+			{"missing ETag", []CompletedPart{completed[0], {PartNumber: 2}}, ErrInvalidPart},
+			{"out of order", []CompletedPart{completed[1], completed[0]}, ErrInvalidPartOrder},
+			{"duplicate", []CompletedPart{completed[0], completed[0]}, ErrInvalidPartOrder},
+		} {
+			if _, err := s.CompleteMultipartUpload("b", "obj", uploadID, tc.parts); !errors.Is(err, tc.want) {
+				t.Errorf("packed=%v: %s: got %v, want %v", packed, tc.name, err, tc.want)
+			}
+		}
+
+		// The rejected attempts leave the upload intact; unquoted and
+		// upper-case ETags are accepted.
+		completed[1].ETag = strings.ToUpper(strings.Trim(completed[1].ETag, `"`))
+		if _, err := s.CompleteMultipartUpload("b", "obj", uploadID, completed); err != nil {
+			t.Fatalf("packed=%v: complete: %v", packed, err)
+		}
+		reader, _, err := s.GetObject("b", "obj")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != "onetwo" {
+			t.Errorf("packed=%v: content = %q", packed, data)
+		}
+	}
+}
+
+func TestListPartsPacked(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
+	s.SetPackedMultipart(true)
 	s.CreateBucket("b")
 
-	uploadID, _ := s.CreateMultipartUpload("b", "sha.txt", "text/plain")
+	uploadID, _ := s.CreateMultipartUpload("b", "packed.bin", "")
+	etag2, _ := s.UploadPart("b", "packed.bin", uploadID, 2, strings.NewReader("second"), "")
+	etag1, _ := s.UploadPart("b", "packed.bin", uploadID, 1, strings.NewReader("first!!"), "")
 
-	// Empty expectedSHA256 should skip verification
-	etag, err := s.UploadPart("b", "sha.txt", uploadID, 1, bytes.NewReader([]byte("data")), "")
-	if err != nil {
-		t.Fatalf("UploadPart with empty SHA256: %v", err)
+	parts, truncated, err := s.ListParts("b", "packed.bin", uploadID, 0, 1000)
+	if err != nil || truncated {
+		t.Fatalf("ListParts: %v truncated=%v", err, truncated)
 	}
-	if etag == "" {
-		t.Fatal("etag should not be empty")
+	want := []PartInfo{{PartNumber: 1, Size: 7, ETag: etag1}, {PartNumber: 2, Size: 6, ETag: etag2}}
+	for i := range parts {
+		parts[i].LastModified = time.Time{}
+	}
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("parts = %+v, want %+v", parts, want)
 	}
-}
 
-// ═══════════════════════════════════════════════════════════════════════════════
-// Fix 2: Multipart Upload Garbage Collection
-// ═══════════════════════════════════════════════════════════════════════════════
+	if parts, truncated, _ := s.ListParts("b", "packed.bin", uploadID, 1, 1000); len(parts) != 1 || parts[0].PartNumber != 2 || truncated {
+		t.Errorf("after marker 1: %+v truncated=%v", parts, truncated)
+	}
+}
 
-func TestCleanAbandonedUploads(t *testing.T) {
+func TestCopyObjectMultipartKeepsPartCountETag(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
 	s.CreateBucket("b")
+	srcETag := putMultipartObject(t, s, "b", "mp.txt", "part one ", "part two ", "part three")
+	if !strings.HasSuffix(srcETag, "-3\"") {
+		t.Fatalf("source ETag: %s", srcETag)
+	}
 
-	// Create a multipart upload and stage a part
-	uploadID, _ := s.CreateMultipartUpload("b", "abandoned.txt", "text/plain")
-	s.UploadPart("b", "abandoned.txt", uploadID, 1, strings.NewReader("data"), "")
-
-	stagingDir := s.multipartStagingPath("b", uploadID)
-
-	// Backdate the staging directory to 25 hours ago
-	old := time.Now().Add(-25 * time.Hour)
-	os.Chtimes(stagingDir, old, old)
-
-	// Run GC with 24h max age
-	cleanAbandonedUploads(s.dataDir, 24*time.Hour)
-
-	// Staging dir should be gone
-	if _, err := os.Stat(stagingDir); !os.IsNotExist(err) {
-		t.Fatal("abandoned staging dir should have been removed")
+	for _, override := range []*PutObjectInput{nil, {ContentType: "text/markdown"}} {
+		meta, err := s.CopyObject("b", "mp.txt", "b", "copy.txt", override, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.ETag != srcETag {
+			t.Errorf("override=%v: ETag %s, want source %s", override != nil, meta.ETag, srcETag)
+		}
+		head, _ := s.HeadObject("b", "copy.txt")
+		if head.ETag != srcETag {
+			t.Errorf("override=%v: stored ETag %s, want %s", override != nil, head.ETag, srcETag)
+		}
 	}
 }
 
-func TestCleanAbandonedUploadsKeepsRecent(t *testing.T) {
+func TestCopyObjectMultipartRecomputeETag(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
+	s.SetCopyRecomputesMultipartETag(true)
 	s.CreateBucket("b")
+	putMultipartObject(t, s, "b", "mp.txt", "part one ", "part two")
+	single, _ := s.PutObject("b", "single.txt", strings.NewReader("plain"), nil)
 
-	// Create a recent multipart upload
-	uploadID, _ := s.CreateMultipartUpload("b", "recent.txt", "text/plain")
-	s.UploadPart("b", "recent.txt", uploadID, 1, strings.NewReader("data"), "")
-
-	stagingDir := s.multipartStagingPath("b", uploadID)
-
-	// Run GC — this upload is fresh, should NOT be removed
-	cleanAbandonedUploads(s.dataDir, 24*time.Hour)
-
-	if _, err := os.Stat(stagingDir); os.IsNotExist(err) {
-		t.Fatal("recent staging dir should NOT have been removed")
+	want := "\"" + hex.EncodeToString(md5Sum([]byte("part one part two"))) + "\""
+	for _, override := range []*PutObjectInput{nil, {ContentType: "text/markdown"}} {
+		meta, err := s.CopyObject("b", "mp.txt", "b", "copy.txt", override, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.ETag != want {
+			t.Errorf("override=%v: ETag %s, want single-part %s", override != nil, meta.ETag, want)
+		}
+		head, _ := s.HeadObject("b", "copy.txt")
+		if head.ETag != want || head.Size != 17 {
+			t.Errorf("override=%v: stored %s size %d", override != nil, head.ETag, head.Size)
+		}
 	}
-}
-
-func TestCleanAbandonedUploadsNoBuckets(t *testing.T) {
-	s, cleanup := setupTestStorage(t)
-	defer cleanup()
 
-	// No buckets — should not panic or error
-	cleanAbandonedUploads(s.dataDir, 24*time.Hour)
+	// Single-part sources are unaffected.
+	meta, err := s.CopyObject("b", "single.txt", "b", "single-copy.txt", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ETag != single.ETag {
+		t.Errorf("single-part copy ETag %s, want %s", meta.ETag, single.ETag)
+	}
 }
 
-// ═══════════════════════════════════════════════════════════════════════════════
-// Fix 3: Directory Fsync on Rename (syncParentDir)
-// ═══════════════════════════════════════════════════════════════════════════════
-
-func TestSyncParentDirDoesNotPanic(t *testing.T) {
+func TestCopyObjectReplaceInPlace(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
 	s.CreateBucket("b")
 
-	// PutObject internally calls syncParentDir — just verify no panic
-	_, err := s.PutObject("b", "sync-test.txt", strings.NewReader("data"), nil)
+	srcMeta, _ := s.PutObject("b", "obj.txt", strings.NewReader("in place"), &PutObjectInput{ContentType: "text/plain"})
+
+	meta, err := s.CopyObject("b", "obj.txt", "b", "obj.txt", &PutObjectInput{ContentType: "text/csv"}, nil)
 	if err != nil {
-		t.Fatalf("PutObject: %v", err)
+		t.Fatal(err)
+	}
+	if meta.ETag != srcMeta.ETag {
+		t.Errorf("ETag changed: %q -> %q", srcMeta.ETag, meta.ETag)
 	}
 
-	// CompleteMultipartUpload also calls syncParentDir
-	uploadID, _ := s.CreateMultipartUpload("b", "sync-multi.txt", "text/plain")
-	etag, _ := s.UploadPart("b", "sync-multi.txt", uploadID, 1, strings.NewReader("data"), "")
-	_, err = s.CompleteMultipartUpload("b", "sync-multi.txt", uploadID, []CompletedPart{
-		{PartNumber: 1, ETag: etag},
-	})
+	reader, got, err := s.GetObject("b", "obj.txt")
 	if err != nil {
-		t.Fatalf("CompleteMultipartUpload: %v", err)
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "in place" || got.ContentType != "text/csv" {
+		t.Errorf("after in-place copy: %q %q", data, got.ContentType)
 	}
 }
 
-func TestSyncParentDirNonExistentPath(t *testing.T) {
-	// Should not panic on invalid path
-	syncParentDir("/nonexistent/path/file.txt")
-}
-
-// ═══════════════════════════════════════════════════════════════════════════════
-// Fix 5: CopyObject Metadata Directive
-// ═══════════════════════════════════════════════════════════════════════════════
-
-func TestCopyObjectDefaultCopiesMetadata(t *testing.T) {
+func TestCopyObjectWithoutSidecarRecomputesETag(t *testing.T) {
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
 	s.CreateBucket("b")
 
-	s.PutObject("b", "src.txt", strings.NewReader("data"), &PutObjectInput{
-		ContentType:    "text/html",
-		CacheControl:   "max-age=3600",
-		CustomMetadata: map[string]string{"author": "alice"},
-	})
+	srcMeta, _ := s.PutObject("b", "src.txt", strings.NewReader("no sidecar"), nil)
+	os.Remove(s.metadataPath("b", "src.txt"))
 
-	// COPY directive (nil override) should preserve source metadata
-	meta, err := s.CopyObject("b", "src.txt", "b", "dst.txt", nil)
+	meta, err := s.CopyObject("b", "src.txt", "b", "dst.txt", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if meta.ContentType != "text/html" {
-		t.Errorf("content type: %q, want text/html", meta.ContentType)
-	}
-	if meta.CacheControl != "max-age=3600" {
-		t.Errorf("cache-control: %q", meta.CacheControl)
+	// Without a stored ETag the copy falls back to streaming and hashes the
+	// content, yielding the real MD5 rather than a pseudo-ETag.
+	if meta.ETag != srcMeta.ETag {
+		t.Errorf("ETag: %q, want MD5 %q", meta.ETag, srcMeta.ETag)
 	}
+}
 
-	dstMeta, _ := s.HeadObject("b", "dst.txt")
-	if dstMeta.CustomMetadata["author"] != "alice" {
-		t.Errorf("custom metadata missing: %v", dstMeta.CustomMetadata)
+func TestCopyObjectCloneNoTempLeftovers(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	s.PutObject("b", "src.txt", strings.NewReader("data"), nil)
+	s.CopyObject("b", "src.txt", "b", "dst.txt", nil, nil)
+
+	entries, _ := os.ReadDir(filepath.Join(s.dataDir, "b", tmpStagingDir))
+	if len(entries) != 0 {
+		t.Errorf("temp files left behind: %d", len(entries))
 	}
 }
 
@@ -2022,7 +4002,7 @@ func TestCopyObjectReplaceMetadata(t *testing.T) {
 		CacheControl:   "no-cache",
 		CustomMetadata: map[string]string{"version": "2"},
 	}
-	meta, err := s.CopyObject("b", "src.txt", "b", "dst.txt", overrideMeta)
+	meta, err := s.CopyObject("b", "src.txt", "b", "dst.txt", overrideMeta, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2053,7 +4033,7 @@ func TestCopyObjectReplaceEmptyContentType(t *testing.T) {
 
 	// REPLACE with empty ContentType should default to application/octet-stream
 	overrideMeta := &PutObjectInput{}
-	meta, _ := s.CopyObject("b", "src.txt", "b", "dst.txt", overrideMeta)
+	meta, _ := s.CopyObject("b", "src.txt", "b", "dst.txt", overrideMeta, nil)
 	if meta.ContentType != "application/octet-stream" {
 		t.Errorf("content type: %q, want application/octet-stream", meta.ContentType)
 	}
@@ -2368,6 +4348,7 @@ func TestFsyncEnabledUploadPart(t *testing.T) {
 }
 
 func TestFsyncEnabledCompleteMultipart(t *testing.T) {
+	allowSmallParts(t)
 	s, cleanup := setupTestStorage(t)
 	defer cleanup()
 	s.SetFsync(true)
@@ -2461,3 +4442,300 @@ func TestFsyncAndMetadataCombinations(t *testing.T) {
 		})
 	}
 }
+
+func TestBucketACL(t *testing.T) {
+	s := NewFilesystemStorage(t.TempDir())
+	if err := s.CreateBucket("acls"); err != nil {
+		t.Fatal(err)
+	}
+	if acl, err := s.GetBucketACL("acls"); err != nil || acl != "private" {
+		t.Fatalf("default ACL = %q, %v", acl, err)
+	}
+	if err := s.PutBucketACL("acls", "public-read"); err != nil {
+		t.Fatal(err)
+	}
+	if acl, _ := s.GetBucketACL("acls"); acl != "public-read" {
+		t.Errorf("ACL = %q", acl)
+	}
+
+	// The stored ACL is not an object and does not keep the bucket alive.
+	objects, err := s.ListObjects("acls", "", 1000)
+	if err != nil || len(objects) != 0 {
+		t.Errorf("listing = %v, %v", objects, err)
+	}
+	if err := s.RenameBucket("acls", "acls2"); err != nil {
+		t.Fatal(err)
+	}
+	if acl, _ := s.GetBucketACL("acls2"); acl != "public-read" {
+		t.Errorf("ACL after rename = %q", acl)
+	}
+	if err := s.DeleteBucket("acls2"); err != nil {
+		t.Errorf("DeleteBucket: %v", err)
+	}
+	if err := s.PutBucketACL("acls2", "private"); err == nil {
+		t.Error("PutBucketACL on a missing bucket succeeded")
+	}
+}
+
+func TestBucketTagging(t *testing.T) {
+	s := NewFilesystemStorage(t.TempDir())
+	if err := s.CreateBucket("tagged"); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := s.GetBucketTagging("tagged"); err != nil || tags != nil {
+		t.Fatalf("untagged bucket = %v, %v", tags, err)
+	}
+	want := map[string]string{"team": "storage", "cost-center": "42"}
+	if err := s.PutBucketTagging("tagged", want); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := s.GetBucketTagging("tagged"); err != nil || !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, %v", tags, err)
+	}
+	if objects, err := s.ListObjects("tagged", "", 1000); err != nil || len(objects) != 0 {
+		t.Errorf("listing = %v, %v", objects, err)
+	}
+
+	if err := s.PutBucketTagging("tagged", nil); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := s.GetBucketTagging("tagged"); err != nil || tags != nil {
+		t.Errorf("tags after delete = %v, %v", tags, err)
+	}
+	if err := s.PutBucketTagging("tagged", nil); err != nil {
+		t.Errorf("deleting absent tags: %v", err)
+	}
+	if err := s.PutBucketTagging("missing", want); err == nil {
+		t.Error("PutBucketTagging on a missing bucket succeeded")
+	}
+}
+
+func TestObjectTagging(t *testing.T) {
+	s := NewFilesystemStorage(t.TempDir())
+	s.CreateBucket("b")
+	put, err := s.PutObject("b", "obj", strings.NewReader("data"), &PutObjectInput{Tags: map[string]string{"old": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"team": "storage", "env": "dev"}
+	if err := s.PutObjectTagging("b", "obj", want); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := s.GetObjectTagging("b", "obj"); err != nil || !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, %v", tags, err)
+	}
+	// The object itself is unchanged.
+	meta, err := s.HeadObject("b", "obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ETag != put.ETag || !meta.LastModified.Equal(put.LastModified) {
+		t.Errorf("tagging changed ETag %s/%s or Last-Modified %v/%v", meta.ETag, put.ETag, meta.LastModified, put.LastModified)
+	}
+
+	if err := s.DeleteObjectTagging("b", "obj"); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := s.GetObjectTagging("b", "obj"); err != nil || tags != nil {
+		t.Errorf("tags after delete = %v, %v", tags, err)
+	}
+
+	if err := s.PutObjectTagging("b", "missing", want); !os.IsNotExist(err) {
+		t.Errorf("PutObjectTagging on a missing key: %v", err)
+	}
+	if _, err := s.GetObjectTagging("b", "missing"); !os.IsNotExist(err) {
+		t.Errorf("GetObjectTagging on a missing key: %v", err)
+	}
+
+	s.SetMetadataEnabled(false)
+	if err := s.PutObjectTagging("b", "obj", want); !errors.Is(err, ErrMetadataDisabled) {
+		t.Errorf("PutObjectTagging without sidecars: %v", err)
+	}
+}
+
+func TestLayoutVersion(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFilesystemStorage(dir)
+	s.CreateBucket("b")
+
+	// A directory from before the version file is format 1.
+	if v, err := s.LayoutVersion(); err != nil || v != 1 {
+		t.Fatalf("unversioned directory: %d, %v", v, err)
+	}
+	if err := s.UpgradeLayout(); err != nil {
+		t.Fatal(err)
+	}
+	versionPath := filepath.Join(dir, layoutVersionFile)
+	if data, err := os.ReadFile(versionPath); err != nil || string(data) != fmt.Sprintf("%d\n", layoutVersion) {
+		t.Errorf("version file = %q, %v", data, err)
+	}
+	if buckets, err := s.ListBuckets(); err != nil || len(buckets) != 1 {
+		t.Errorf("buckets = %v, %v", buckets, err)
+	}
+
+	// A newer format is refused and left alone.
+	newer := fmt.Sprintf("%d\n", layoutVersion+1)
+	os.WriteFile(versionPath, []byte(newer), 0644)
+	if err := s.UpgradeLayout(); !errors.Is(err, ErrLayoutTooNew) {
+		t.Errorf("newer format: %v", err)
+	}
+	if err := s.CheckLayout(); !errors.Is(err, ErrLayoutTooNew) {
+		t.Errorf("CheckLayout on newer format: %v", err)
+	}
+	if data, _ := os.ReadFile(versionPath); string(data) != newer {
+		t.Errorf("version file rewritten to %q", data)
+	}
+
+	os.WriteFile(versionPath, []byte("garbage"), 0644)
+	if err := s.UpgradeLayout(); err == nil || errors.Is(err, ErrLayoutTooNew) {
+		t.Errorf("corrupt version file: %v", err)
+	}
+}
+
+func TestCaseInsensitiveProbe(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	insensitive, err := s.CaseInsensitive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Compare with what the filesystem actually does with two keys.
+	s.CreateBucket("b")
+	s.PutObject("b", "File.txt", strings.NewReader("upper"), nil)
+	s.PutObject("b", "file.txt", strings.NewReader("lower"), nil)
+	reader, _, err := s.GetObject("b", "File.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if collided := string(data) == "lower"; collided != insensitive {
+		t.Errorf("CaseInsensitive() = %v, but keys collided = %v", insensitive, collided)
+	}
+
+	entries, _ := os.ReadDir(s.dataDir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".geckos3-case-probe") {
+			t.Errorf("probe file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestBase32KeyEncoding(t *testing.T) {
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	if err := s.SetKeyEncoding("rot13"); err == nil {
+		t.Error("unknown encoding accepted")
+	}
+	if err := s.SetKeyEncoding(keyEncodingBase32); err != nil {
+		t.Fatal(err)
+	}
+	s.CreateBucket("b")
+
+	keys := []string{"File.txt", "file.txt", "dir/CON", "dir/nul.", "dir/sub/x?y*z", "x/../y"}
+	for _, key := range keys {
+		if _, err := s.PutObject("b", key, strings.NewReader(key), nil); err != nil {
+			t.Fatalf("PutObject(%q): %v", key, err)
+		}
+	}
+	// A file dropped into the bucket by hand is not a valid encoding.
+	os.WriteFile(filepath.Join(s.dataDir, "b", "README"), []byte("x"), 0644)
+
+	for _, key := range keys {
+		reader, _, err := s.GetObject("b", key)
+		if err != nil {
+			t.Fatalf("GetObject(%q): %v", key, err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != key {
+			t.Errorf("GetObject(%q) = %q", key, data)
+		}
+	}
+
+	objects, err := s.ListObjects("b", "", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, obj := range objects {
+		listed = append(listed, obj.Key)
+	}
+	want := append([]string(nil), keys...)
+	sort.Strings(want)
+	if !reflect.DeepEqual(listed, want) {
+		t.Errorf("ListObjects = %q, want %q", listed, want)
+	}
+
+	objs, prefixes, err := s.ListDirectory("b", "dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, obj := range objs {
+		names = append(names, obj.Key)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"dir/CON", "dir/nul."}) || !reflect.DeepEqual(prefixes, []string{"dir/sub/"}) {
+		t.Errorf("ListDirectory(dir/) = %q, %q", names, prefixes)
+	}
+
+	// On disk every name is lowercase base32, so nothing can collide.
+	entries, _ := os.ReadDir(filepath.Join(s.dataDir, "b"))
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".metadata.json")
+		if _, ok := decodeSegment(name); !ok && name != "README" && !isInternalDir(name) {
+			t.Errorf("unencoded name on disk: %s", e.Name())
+		}
+	}
+
+	for _, key := range keys {
+		if _, err := s.DeleteObject("b", key); err != nil {
+			t.Errorf("DeleteObject(%q): %v", key, err)
+		}
+	}
+	os.Remove(filepath.Join(s.dataDir, "b", "README"))
+	if err := s.DeleteBucket("b"); err != nil {
+		t.Errorf("DeleteBucket after deleting everything: %v", err)
+	}
+}
+
+func TestWindowsNameProblem(t *testing.T) {
+	for seg, bad := range map[string]bool{
+		"file.txt": false, "cone.txt": false, "con.tents": true, "CONSOLE": false, "a b": false, ".hidden": false,
+		"a:b": true, "what?": true, "x*": true, "<tag>": true, "pipe|": true, `quote"`: true, `back\slash`: true,
+		"trailing.": true, "trailing ": true, "tab\tname": true,
+		"CON": true, "con": true, "nul.txt": true, "Com1.log": true, "LPT9": true,
+	} {
+		if got := windowsNameProblem(seg) != ""; got != bad {
+			t.Errorf("windowsNameProblem(%q) = %q, want problem: %v", seg, windowsNameProblem(seg), bad)
+		}
+	}
+}
+
+func TestWindowsForbiddenKeys(t *testing.T) {
+	saved := checkWindowsNames
+	checkWindowsNames = true
+	defer func() { checkWindowsNames = saved }()
+
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+
+	for _, key := range []string{"dir/a:b", "NUL", "dir./x"} {
+		if _, err := s.PutObject("b", key, strings.NewReader("x"), nil); !errors.Is(err, ErrInvalidKeyName) {
+			t.Errorf("raw PutObject(%q) = %v, want ErrInvalidKeyName", key, err)
+		}
+		if _, err := s.CreateMultipartUpload("b", key, ""); !errors.Is(err, ErrInvalidKeyName) {
+			t.Errorf("raw CreateMultipartUpload(%q) = %v, want ErrInvalidKeyName", key, err)
+		}
+	}
+
+	// Encoded keys are always valid file names.
+	s.SetKeyEncoding(keyEncodingBase32)
+	if _, err := s.PutObject("b", "dir/a:b", strings.NewReader("x"), nil); err != nil {
+		t.Errorf("encoded PutObject: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestWindowsForbiddenKeysNative checks, on a real Windows filesystem, that
+// raw keys Windows cannot store fail cleanly and that base32 keys store them.
+func TestWindowsForbiddenKeysNative(t *testing.T) {
+	keys := []string{"a:b", "what?", "x*y", "<tag>", "pipe|", "trailing.", "trailing ", "dir/CON", "aux.txt"}
+
+	s, cleanup := setupTestStorage(t)
+	defer cleanup()
+	s.CreateBucket("b")
+	for _, key := range keys {
+		if _, err := s.PutObject("b", key, strings.NewReader(key), nil); !errors.Is(err, ErrInvalidKeyName) {
+			t.Errorf("raw PutObject(%q) = %v, want ErrInvalidKeyName", key, err)
+		}
+	}
+
+	if err := s.SetKeyEncoding(keyEncodingBase32); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if _, err := s.PutObject("b", key, strings.NewReader(key), nil); err != nil {
+			t.Fatalf("encoded PutObject(%q): %v", key, err)
+		}
+	}
+	for _, key := range keys {
+		reader, _, err := s.GetObject("b", key)
+		if err != nil {
+			t.Fatalf("encoded GetObject(%q): %v", key, err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != key {
+			t.Errorf("encoded GetObject(%q) = %q", key, data)
+		}
+	}
+}